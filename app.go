@@ -40,6 +40,7 @@ import (
 	"os/signal"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -47,6 +48,7 @@ import (
 	log "github.com/cihub/seelog"
 	"github.com/kardianos/service"
 	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/credential"
 	"infini.sh/framework/core/daemon"
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/errors"
@@ -56,6 +58,7 @@ import (
 	"infini.sh/framework/core/logging/logger"
 	"infini.sh/framework/core/module"
 	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/plugin"
 	"infini.sh/framework/core/stats"
 	"infini.sh/framework/core/util"
 	"infini.sh/framework/lib/bytebufferpool"
@@ -73,6 +76,7 @@ type App struct {
 	pidFile        string
 	configFile     string
 	logLevel       string
+	validateConfig bool
 
 	setup func()
 	start func()
@@ -180,6 +184,7 @@ func (app *App) initWithFlags() {
 	flag.IntVar(&app.numCPU, "cpu", -1, "the number of CPUs to use")
 	flag.IntVar(&app.maxMEM, "mem", -1, "the max size of Memory to use, soft limit in megabyte")
 	flag.StringVar(&app.svcFlag, "service", "", "service management, options: install,uninstall,start,stop")
+	flag.BoolVar(&app.validateConfig, "validate-config", false, "validate the config files and exit, non-zero exit code on errors")
 
 	if debugFlagInitFunc != nil {
 		debugFlagInitFunc()
@@ -213,7 +218,7 @@ func (app *App) initWithFlags() {
 	app.environment.SetConfigFile(app.configFile)
 
 	err := app.environment.InitPaths(app.configFile)
-	if err != nil {
+	if err != nil && !app.validateConfig {
 		panic(err)
 	}
 
@@ -240,6 +245,59 @@ func (app *App) initWithFlags() {
 	})
 }
 
+// validateConfigFiles checks the main config file and the files in the config dir,
+// prints the errors with the line numbers, returns the exit code
+func (app *App) validateConfigFiles() int {
+	//processors of the out-of-process plugins are registered from their manifests
+	if err := app.registerValidationProcessors(); err != nil {
+		fmt.Printf("failed to load the manifests of plugins: %v\n", err)
+	}
+
+	root := env.GetDefaultSystemConfig()
+	errs := config.ValidateFile(app.configFile, &root)
+
+	configDir := app.environment.GetConfigDir()
+	if configDir != "" && util.FileExists(configDir) {
+		root := env.GetDefaultSystemConfig()
+		errs = append(errs, config.ValidatePath(configDir, &root)...)
+	}
+
+	for _, err := range errs {
+		fmt.Println(err.Error())
+	}
+	if refs := credential.UnverifiedReferences(); len(refs) > 0 {
+		fmt.Printf("credential references are resolved at runtime, not verified: %v\n", strings.Join(refs, ", "))
+	}
+	if len(errs) > 0 {
+		fmt.Printf("config validation failed, %v error(s) found\n", len(errs))
+		return 1
+	}
+	fmt.Println("config validation passed")
+	return 0
+}
+
+// registerValidationProcessors registers the processors of the plugins enabled in
+// the main config file, the plugins are not started
+func (app *App) registerValidationProcessors() error {
+	pluginsCfg := plugin.ExternalPluginsConfig{Path: app.environment.GetPluginDir()}
+	if util.FileExists(app.configFile) {
+		cfg, err := config.LoadFile(app.configFile)
+		if err != nil {
+			//reported by the validation of the file
+			return nil
+		}
+		if cfg.HasField("external_plugins") {
+			if _, err := env.ParseConfigSection(cfg, "external_plugins", &pluginsCfg); err != nil {
+				return err
+			}
+		}
+	}
+	if !pluginsCfg.Enabled {
+		return nil
+	}
+	return plugin.RegisterValidationProcessors(pluginsCfg)
+}
+
 func (app *App) initEnvironment(customFunc func()) {
 	ksResolver, err := keystore.GetVariableResolver()
 	if err != nil {
@@ -247,6 +305,22 @@ func (app *App) initEnvironment(customFunc func()) {
 	}
 
 	config.RegisterOption("keystore", ksResolver)
+
+	//credentials are loaded from the store at runtime, not verified on validation
+	getCredResolver := credential.GetVariableResolver
+	if app.validateConfig {
+		getCredResolver = credential.GetValidationResolver
+	}
+	credResolver, err := getCredResolver()
+	if err != nil {
+		panic(err)
+	}
+	config.RegisterOption("credential", credResolver)
+
+	if app.validateConfig {
+		os.Exit(app.validateConfigFiles())
+	}
+
 	task.RunWithContext("keystore_changes_notify", func(ctx context.Context) error {
 		_, err = keystore.GetOrInitKeystore()
 		if err != nil {
@@ -259,6 +333,21 @@ func (app *App) initEnvironment(customFunc func()) {
 	global.RegisterShutdownCallback(keystore.CloseWatch)
 	app.environment.Init()
 
+	//external secret providers, resolve the keys missing in the local keystore
+	ksCfg := keystore.ProvidersConfig{ReloadOnChange: true}
+	if exists, err := env.ParseConfig("keystore", &ksCfg); exists && err != nil {
+		panic(err)
+	}
+	if err := keystore.InitProviders(ksCfg); err != nil {
+		panic(err)
+	}
+	if len(ksCfg.Providers) > 0 {
+		task.RunWithContext("keystore_providers_refresh", func(ctx context.Context) error {
+			keystore.RefreshProviders(ctx, util.GetDurationOrDefault(ksCfg.RefreshInterval, time.Minute))
+			return nil
+		}, context.Background())
+	}
+
 	//allow use yml to configure the log level
 	if app.logLevel != "" {
 		app.environment.SystemConfig.LoggingConfig.LogLevel = app.logLevel
@@ -359,6 +448,38 @@ func (app *App) Setup(setup func(), start func(), stop func()) (allowContinue bo
 	return true
 }
 
+// runShutdownCallbacks executes the callbacks in order, and stops waiting when
+// the deadline is reached, the callbacks not finished are reported
+func (app *App) runShutdownCallbacks(callbacks []func(), timeout time.Duration) {
+	finished := make(chan int, len(callbacks))
+	go func() {
+		for i, v := range callbacks {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						log.Error("error on executing shutdown callback: ", i, ", ", r)
+					}
+					finished <- i
+				}()
+				log.Trace("executing callback: ", i)
+				v()
+				log.Trace("executed callback: ", i)
+			}()
+		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for i := 0; i < len(callbacks); i++ {
+		select {
+		case <-finished:
+		case <-timer.C:
+			log.Warnf("shutdown callbacks timed out after %v, %v of %v callbacks not finished", timeout, len(callbacks)-i, len(callbacks))
+			return
+		}
+	}
+}
+
 func (app *App) Shutdown() {
 	//cleanup
 	if !app.environment.SystemConfig.SkipInstanceDetect {
@@ -367,11 +488,7 @@ func (app *App) Shutdown() {
 
 	callbacks := global.GetShutdownCallback()
 	if callbacks != nil && len(callbacks) > 0 {
-		for i, v := range callbacks {
-			log.Trace("executing callback: ", i)
-			v()
-			log.Trace("executed callback: ", i)
-		}
+		app.runShutdownCallbacks(callbacks, app.environment.SystemConfig.Shutdown.GetCallbackTimeout())
 	}
 
 	if r := recover(); r != nil {
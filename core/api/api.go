@@ -28,6 +28,7 @@
 package api
 
 import (
+	ctx "context"
 	"crypto/rsa"
 	"crypto/x509"
 	"fmt"
@@ -270,14 +271,16 @@ func StartAPI() {
 			}()
 
 			err = srv.ServeTLS(l, "", "")
-			if err != nil {
+			if err != nil && err != http.ErrServerClosed {
 				log.Error(err)
 				panic(err)
 			}
 		}()
+		apiServer = srv
 
 	} else {
 		log.Trace("starting insecure API server")
+		srv := &http.Server{Handler: RecoveryHandler()(c.Handler(context.ClearHandler(router)))}
 		go func() {
 			defer func() {
 				if !global.Env().IsDebug {
@@ -296,12 +299,13 @@ func StartAPI() {
 				}
 			}()
 
-			err := http.Serve(l, RecoveryHandler()(c.Handler(context.ClearHandler(router))))
-			if err != nil {
+			err := srv.Serve(l)
+			if err != nil && err != http.ErrServerClosed {
 				log.Error(err)
 				panic(err)
 			}
 		}()
+		apiServer = srv
 	}
 
 	err = util.WaitServerUp(listenAddress, 30*time.Second)
@@ -313,3 +317,19 @@ func StartAPI() {
 
 }
 
+var apiServer *http.Server
+
+// StopAPI stops accepting new requests, and waits for the in-flight requests
+// to finish within the timeout
+func StopAPI(timeout time.Duration) error {
+	if apiServer == nil {
+		return nil
+	}
+	ctx1, cancel := ctx.WithTimeout(ctx.Background(), timeout)
+	defer cancel()
+	err := apiServer.Shutdown(ctx1)
+	apiServer = nil
+	log.Debug("api server stopped")
+	return err
+}
+
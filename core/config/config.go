@@ -425,7 +425,10 @@ func NewConfigWithTemplate(v ConfigTemplate) (*Config, error) {
 		return nil, err
 	}
 
-	configStr := NestedRenderingTemplate(string(tempBytes), v.Variable)
+	configStr, err := RenderTemplate(string(tempBytes), v.Variable, filepath.Dir(cfgFile))
+	if err != nil {
+		return nil, errors.Errorf("failed to render template %v: %v", cfgFile, err)
+	}
 
 	//log.Error(configStr)
 
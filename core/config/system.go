@@ -28,6 +28,7 @@ import (
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/util"
 	"strings"
+	"time"
 )
 
 // ClusterConfig stores cluster settings
@@ -173,6 +174,32 @@ type SystemConfig struct {
 	Plugins []*Config `config:"plugins"`
 
 	HTTPClientConfig HTTPClientConfig `config:"http_client"`
+
+	Shutdown ShutdownConfig `config:"shutdown"`
+}
+
+// ShutdownConfig controls the deadline of each shutdown phase
+type ShutdownConfig struct {
+	IngressTimeout  string `config:"ingress_timeout"`  //stop accepting api and ingest traffic
+	DrainTimeout    string `config:"drain_timeout"`    //drain pipelines, flush buffers and commit offsets
+	StopTimeout     string `config:"stop_timeout"`     //stop the rest modules, storage modules are the last
+	CallbackTimeout string `config:"callback_timeout"` //run the registered shutdown callbacks
+}
+
+func (cfg ShutdownConfig) GetIngressTimeout() time.Duration {
+	return util.GetDurationOrDefault(cfg.IngressTimeout, 10*time.Second)
+}
+
+func (cfg ShutdownConfig) GetDrainTimeout() time.Duration {
+	return util.GetDurationOrDefault(cfg.DrainTimeout, 60*time.Second)
+}
+
+func (cfg ShutdownConfig) GetStopTimeout() time.Duration {
+	return util.GetDurationOrDefault(cfg.StopTimeout, 30*time.Second)
+}
+
+func (cfg ShutdownConfig) GetCallbackTimeout() time.Duration {
+	return util.GetDurationOrDefault(cfg.CallbackTimeout, 10*time.Second)
 }
 
 type CookieConfig struct {
@@ -216,6 +243,19 @@ type ConfigsConfig struct {
 	AlwaysRegisterAfterRestart bool     `config:"always_register_after_restart"`
 	AllowGeneratedMetricsTasks bool     `config:"allow_generated_metrics_tasks"`
 	IgnoredPath                []string `config:"ignored_path"`
+
+	Signature BundleSignatureConfig `config:"signature"` //verify the config bundles from manager
+	Rollback  BundleRollbackConfig  `config:"rollback"`  //rollback to the last known-good configs
+}
+
+type BundleSignatureConfig struct {
+	Required    bool     `config:"required"`     //reject the unsigned bundles
+	TrustedKeys []string `config:"trusted_keys"` //ed25519 public keys, PEM or base64 encoded
+}
+
+type BundleRollbackConfig struct {
+	Enabled          bool   `config:"enabled"`
+	HealthCheckDelay string `config:"health_check_delay"` //wait for modules to reload before checking health
 }
 
 type ResourceLimit struct {
@@ -263,6 +303,7 @@ type S3Config struct {
 	AccessKey          string `config:"access_key" json:"access_key,omitempty"`
 	AccessSecret       string `config:"access_secret" json:"access_secret,omitempty"`
 	Token              string `config:"token" json:"token,omitempty"`
+	CredentialID       string `config:"credential_id" json:"credential_id,omitempty"` //credential of type aws_access_key, replaces the keys above
 	SSL                bool   `config:"ssl" json:"ssl,omitempty"`
 	SkipInsecureVerify bool   `config:"skip_insecure_verify" json:"skip_insecure_verify,omitempty"`
 }
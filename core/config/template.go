@@ -0,0 +1,477 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+)
+
+// The template directives, extends the `$[[variable]]` substitution:
+//
+//	$[[include "partial.yml"]]            include a file, relative to the template, with the indentation of the tag,
+//	                                      the file must be within the base dir of the template
+//	$[[if VAR]] ... $[[else]] ... $[[end]] conditionals, also `not VAR`, `VAR == "value"` and `VAR != "value"`
+//	$[[for item in LIST]] ... $[[end]]     loops over a list or a map, `loop.index`, `loop.first`, `loop.last`, `loop.key` are available
+//	$[[VAR | default "value"]]             default value if the variable is missing or empty
+//
+// the directive tag alone in a line doesn't leave blank line in the output.
+
+const maxIncludeDepth = 10
+
+const (
+	nodeText = iota
+	nodeVariable
+	nodeInclude
+	nodeIf
+	nodeFor
+)
+
+type templateNode struct {
+	kind int
+	text string
+	//the condition of if, the list of for, the path of include, the name of the variable
+	expr string
+	//the loop variable, or the default value
+	arg      string
+	indent   string
+	alone    bool
+	children []*templateNode
+	elses    []*templateNode
+}
+
+type templateToken struct {
+	tag    bool
+	text   string
+	indent string
+	alone  bool
+}
+
+// RenderTemplate renders the template with the variables, the files are included relative to baseDir,
+// absolute paths and paths outside of baseDir are rejected
+func RenderTemplate(content string, variables util.MapStr, baseDir string) (string, error) {
+	rootDir, err := filepath.Abs(baseDir)
+	if err != nil {
+		return "", err
+	}
+	return renderTemplate(content, variables, rootDir, rootDir, 0)
+}
+
+// RenderTemplateFile renders the template file with the variables
+func RenderTemplateFile(path string, variables util.MapStr) (string, error) {
+	content, err := util.FileGetContent(path)
+	if err != nil {
+		return "", err
+	}
+	return RenderTemplate(string(content), variables, filepath.Dir(path))
+}
+
+func renderTemplate(content string, variables util.MapStr, rootDir, baseDir string, depth int) (string, error) {
+	if depth > maxIncludeDepth {
+		return "", errors.Errorf("template includes exceed the max depth of %v", maxIncludeDepth)
+	}
+	if variables == nil {
+		variables = util.MapStr{}
+	}
+	tokens := tokenizeTemplate(content)
+	nodes, rest, err := parseTemplateNodes(tokens, false)
+	if err != nil {
+		return "", err
+	}
+	if len(rest) > 0 {
+		return "", errors.Errorf("unexpected template tag: $[[%v]]", rest[0].text)
+	}
+	buffer := strings.Builder{}
+	if err := renderNodes(&buffer, nodes, variables, rootDir, baseDir, depth); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// includePath resolves the include path relative to baseDir, the file must be within rootDir
+func includePath(rootDir, baseDir, name string) (string, error) {
+	if name == "" || filepath.IsAbs(name) {
+		return "", errors.Errorf("invalid include path: %v, must be relative to the template", name)
+	}
+	path := filepath.Clean(filepath.Join(baseDir, name))
+	rel, err := filepath.Rel(rootDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("invalid include path: %v, outside of path: %v", name, rootDir)
+	}
+	return path, nil
+}
+
+func directiveName(tag string) string {
+	fields := strings.Fields(tag)
+	if len(fields) == 0 {
+		return ""
+	}
+	switch fields[0] {
+	case "include", "if", "for":
+		if len(fields) > 1 {
+			return fields[0]
+		}
+	case "else", "end":
+		if len(fields) == 1 {
+			return fields[0]
+		}
+	}
+	if i := strings.Index(tag, "|"); i > 0 && strings.HasPrefix(strings.TrimSpace(tag[i+1:]), "default") {
+		return "default"
+	}
+	return ""
+}
+
+// tokenizeTemplate splits the content to texts and directive tags, the tags of
+// variables are left in the texts, the directive tags alone in the line are trimmed
+func tokenizeTemplate(content string) []templateToken {
+	tokens := []templateToken{}
+	text := strings.Builder{}
+	i := 0
+	for i < len(content) {
+		start := strings.Index(content[i:], "$[[")
+		if start < 0 {
+			text.WriteString(content[i:])
+			break
+		}
+		start += i
+		end := matchTagEnd(content, start)
+		if end < 0 {
+			text.WriteString(content[i:])
+			break
+		}
+		inner := content[start+3 : end]
+		name := directiveName(inner)
+		if name == "" {
+			text.WriteString(content[i : end+2])
+			i = end + 2
+			continue
+		}
+		text.WriteString(content[i:start])
+		i = end + 2
+
+		prefix := text.String()
+		lineStart := strings.LastIndex(prefix, "\n") + 1
+		indent := prefix[lineStart:]
+		aloneInLine := strings.TrimLeft(indent, " \t") == ""
+		if name != "default" && name != "include" && aloneInLine {
+			rest := content[i:]
+			trimmed := strings.TrimLeft(rest, " \t")
+			if trimmed == "" || strings.HasPrefix(trimmed, "\n") || strings.HasPrefix(trimmed, "\r\n") {
+				//remove the whole line of the tag
+				prefix = prefix[:lineStart]
+				i += len(rest) - len(trimmed)
+				if strings.HasPrefix(trimmed, "\r\n") {
+					i += 2
+				} else if strings.HasPrefix(trimmed, "\n") {
+					i++
+				}
+			}
+		}
+		if !aloneInLine {
+			indent = ""
+		}
+		if prefix != "" {
+			tokens = append(tokens, templateToken{text: prefix})
+		}
+		text.Reset()
+		tokens = append(tokens, templateToken{tag: true, text: strings.TrimSpace(inner), indent: indent, alone: aloneInLine})
+	}
+	if text.Len() > 0 {
+		tokens = append(tokens, templateToken{text: text.String()})
+	}
+	return tokens
+}
+
+// matchTagEnd returns the position of the `]]` closes the tag starts at start, nested tags are skipped
+func matchTagEnd(content string, start int) int {
+	depth := 0
+	for i := start; i < len(content)-1; i++ {
+		if strings.HasPrefix(content[i:], "$[[") {
+			depth++
+			i += 2
+			continue
+		}
+		if content[i] == ']' && content[i+1] == ']' {
+			depth--
+			if depth == 0 {
+				return i
+			}
+			i++
+		}
+	}
+	return -1
+}
+
+// parseTemplateNodes parses the tokens until `else` or `end` if nested, returns the remaining tokens
+func parseTemplateNodes(tokens []templateToken, nested bool) ([]*templateNode, []templateToken, error) {
+	nodes := []*templateNode{}
+	for len(tokens) > 0 {
+		token := tokens[0]
+		if !token.tag {
+			nodes = append(nodes, &templateNode{kind: nodeText, text: token.text})
+			tokens = tokens[1:]
+			continue
+		}
+
+		name := directiveName(token.text)
+		switch name {
+		case "else", "end":
+			if !nested {
+				return nil, nil, errors.Errorf("unexpected template tag: $[[%v]]", token.text)
+			}
+			return nodes, tokens, nil
+		case "default":
+			i := strings.Index(token.text, "|")
+			filter := strings.TrimSpace(strings.TrimSpace(token.text[i+1:])[len("default"):])
+			nodes = append(nodes, &templateNode{kind: nodeVariable, expr: strings.TrimSpace(token.text[:i]), arg: unquote(filter)})
+			tokens = tokens[1:]
+		case "include":
+			path := unquote(strings.TrimSpace(token.text[len("include"):]))
+			nodes = append(nodes, &templateNode{kind: nodeInclude, expr: path, indent: token.indent, alone: token.alone})
+			tokens = tokens[1:]
+		case "if", "for":
+			node := &templateNode{kind: nodeIf, expr: strings.TrimSpace(token.text[len(name):])}
+			if name == "for" {
+				parts := strings.Fields(node.expr)
+				if len(parts) != 3 || parts[1] != "in" {
+					return nil, nil, errors.Errorf("invalid template tag: $[[%v]], should be `for item in LIST`", token.text)
+				}
+				node.kind = nodeFor
+				node.arg = parts[0]
+				node.expr = parts[2]
+			}
+			children, rest, err := parseTemplateNodes(tokens[1:], true)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(rest) == 0 {
+				return nil, nil, errors.Errorf("missing $[[end]] for template tag: $[[%v]]", token.text)
+			}
+			node.children = children
+			if directiveName(rest[0].text) == "else" {
+				elses, rest2, err := parseTemplateNodes(rest[1:], true)
+				if err != nil {
+					return nil, nil, err
+				}
+				if len(rest2) == 0 || directiveName(rest2[0].text) != "end" {
+					return nil, nil, errors.Errorf("missing $[[end]] for template tag: $[[%v]]", token.text)
+				}
+				node.elses = elses
+				rest = rest2
+			}
+			nodes = append(nodes, node)
+			tokens = rest[1:]
+		}
+	}
+	if nested {
+		return nil, nil, errors.New("missing $[[end]] in template")
+	}
+	return nodes, nil, nil
+}
+
+func unquote(str string) string {
+	if len(str) >= 2 && (str[0] == '"' && str[len(str)-1] == '"' || str[0] == '\'' && str[len(str)-1] == '\'') {
+		return str[1 : len(str)-1]
+	}
+	return str
+}
+
+func renderNodes(buffer *strings.Builder, nodes []*templateNode, variables util.MapStr, rootDir, baseDir string, depth int) error {
+	for _, node := range nodes {
+		switch node.kind {
+		case nodeText:
+			buffer.WriteString(NestedRenderingTemplate(node.text, variables))
+		case nodeVariable:
+			v, ok := GetVariable(variables, node.expr)
+			if !ok || v == "" {
+				v = NestedRenderingTemplate(node.arg, variables)
+			}
+			buffer.WriteString(v)
+		case nodeInclude:
+			path, err := includePath(rootDir, baseDir, NestedRenderingTemplate(node.expr, variables))
+			if err != nil {
+				return err
+			}
+			content, err := util.FileGetContent(path)
+			if err != nil {
+				return errors.Errorf("failed to include template %v: %v", path, err)
+			}
+			str, err := renderTemplate(string(content), variables, rootDir, filepath.Dir(path), depth+1)
+			if err != nil {
+				return errors.Errorf("failed to render template %v: %v", path, err)
+			}
+			if node.alone {
+				//keep the indentation of the tag for the included lines
+				str = strings.TrimRight(str, "\n")
+				str = strings.Replace(str, "\n", "\n"+node.indent, -1)
+			}
+			buffer.WriteString(str)
+		case nodeIf:
+			ok, err := evalTemplateCondition(node.expr, variables)
+			if err != nil {
+				return err
+			}
+			branch := node.children
+			if !ok {
+				branch = node.elses
+			}
+			if err := renderNodes(buffer, branch, variables, rootDir, baseDir, depth); err != nil {
+				return err
+			}
+		case nodeFor:
+			items, keys := templateLoopItems(variables, node.expr)
+			if len(items) == 0 {
+				if err := renderNodes(buffer, node.elses, variables, rootDir, baseDir, depth); err != nil {
+					return err
+				}
+				continue
+			}
+			for i, item := range items {
+				scope := util.MapStr{}
+				for k, v := range variables {
+					scope[k] = v
+				}
+				scope[node.arg] = item
+				loop := util.MapStr{"index": i, "first": i == 0, "last": i == len(items)-1}
+				if keys != nil {
+					loop["key"] = keys[i]
+				}
+				scope["loop"] = loop
+				if err := renderNodes(buffer, node.children, scope, rootDir, baseDir, depth); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func lookupTemplateValue(variables util.MapStr, key string) (interface{}, bool) {
+	v, err := variables.GetValue(key)
+	if err != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// templateLoopItems returns the items of a list, or the values of a map with the sorted keys
+func templateLoopItems(variables util.MapStr, key string) ([]interface{}, []string) {
+	v, ok := lookupTemplateValue(variables, key)
+	if !ok || v == nil {
+		return nil, nil
+	}
+	switch obj := v.(type) {
+	case []interface{}:
+		return obj, nil
+	case []string:
+		items := make([]interface{}, 0, len(obj))
+		for _, x := range obj {
+			items = append(items, x)
+		}
+		return items, nil
+	case map[string]interface{}:
+		return mapLoopItems(obj)
+	case util.MapStr:
+		return mapLoopItems(obj)
+	default:
+		return []interface{}{v}, nil
+	}
+}
+
+func mapLoopItems(obj map[string]interface{}) ([]interface{}, []string) {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := make([]interface{}, 0, len(obj))
+	for _, k := range keys {
+		items = append(items, obj[k])
+	}
+	return items, keys
+}
+
+func isTemplateValueTrue(v interface{}) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	case string:
+		x = strings.TrimSpace(x)
+		return x != "" && x != "false" && x != "0"
+	case []interface{}:
+		return len(x) > 0
+	case map[string]interface{}:
+		return len(x) > 0
+	case util.MapStr:
+		return len(x) > 0
+	}
+	return util.ToString(v) != "0"
+}
+
+// evalTemplateCondition evaluates `VAR`, `not VAR`, `VAR == value` and `VAR != value`,
+// the value is a quoted string or a variable
+func evalTemplateCondition(expr string, variables util.MapStr) (bool, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "not ") {
+		ok, err := evalTemplateCondition(expr[4:], variables)
+		return !ok, err
+	}
+	for _, op := range []string{"==", "!="} {
+		i := strings.Index(expr, op)
+		if i < 0 {
+			continue
+		}
+		left := templateOperand(strings.TrimSpace(expr[:i]), variables)
+		right := templateOperand(strings.TrimSpace(expr[i+2:]), variables)
+		if op == "==" {
+			return left == right, nil
+		}
+		return left != right, nil
+	}
+	if strings.ContainsAny(expr, " \t") {
+		return false, errors.Errorf("invalid template condition: %v", expr)
+	}
+	v, ok := lookupTemplateValue(variables, expr)
+	return ok && isTemplateValueTrue(v), nil
+}
+
+func templateOperand(str string, variables util.MapStr) string {
+	if len(str) >= 2 && (str[0] == '"' || str[0] == '\'') {
+		return unquote(str)
+	}
+	v, ok := lookupTemplateValue(variables, str)
+	if !ok {
+		//literal value, eg: numbers
+		return str
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,141 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/util"
+)
+
+func TestRenderTemplateVariables(t *testing.T) {
+	vars := util.MapStr{"CLUSTER_ID": "123", "prefix_123_password": "345"}
+	str, err := RenderTemplate("password: $[[prefix_$[[CLUSTER_ID]]_password]]", vars, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "password: 345", str)
+
+	str, err = RenderTemplate("port: $[[PORT | default \"9200\"]], id: $[[CLUSTER_ID|default 1]], $[[missing]]", vars, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "port: 9200, id: 123, $[[missing]]", str)
+}
+
+func TestRenderTemplateConditionsAndLoops(t *testing.T) {
+	temp := `pipeline:
+$[[for tenant in tenants]]
+  - name: $[[tenant.name]]_$[[loop.index]]
+    $[[if tenant.bulk]]
+    processor: bulk
+    $[[else]]
+    processor: echo
+    $[[end]]
+$[[end]]
+$[[if mode == "debug"]]
+debug: true
+$[[end]]
+$[[if not mode]]
+never: true
+$[[end]]
+`
+	vars := util.MapStr{
+		"mode": "debug",
+		"tenants": []interface{}{
+			map[string]interface{}{"name": "a", "bulk": true},
+			map[string]interface{}{"name": "b"},
+		},
+	}
+	str, err := RenderTemplate(temp, vars, "")
+	assert.NoError(t, err)
+	assert.Equal(t, `pipeline:
+  - name: a_0
+    processor: bulk
+  - name: b_1
+    processor: echo
+debug: true
+`, str)
+
+	str, err = RenderTemplate("$[[for k in labels]]$[[loop.key]]=$[[k]]$[[if not loop.last]],$[[end]]$[[end]]", util.MapStr{"labels": map[string]interface{}{"y": 2, "x": 1}}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "x=1,y=2", str)
+}
+
+func TestRenderTemplateInclude(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "partials"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "partials", "output.yml"), []byte("elasticsearch: $[[cluster]]\nmax_size: 10\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "main.yml"), []byte("processor:\n  - bulk:\n      $[[include \"partials/output.yml\"]]\n"), 0644))
+
+	str, err := RenderTemplateFile(filepath.Join(dir, "main.yml"), util.MapStr{"cluster": "prod"})
+	assert.NoError(t, err)
+	assert.Equal(t, "processor:\n  - bulk:\n      elasticsearch: prod\n      max_size: 10\n", str)
+
+	//recursive include
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "loop.yml"), []byte("$[[include \"loop.yml\"]]"), 0644))
+	_, err = RenderTemplateFile(filepath.Join(dir, "loop.yml"), nil)
+	assert.Error(t, err)
+
+	//nested include relative to the included file, still within the base dir
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "partials", "nested.yml"), []byte("$[[include \"../common.yml\"]]"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "common.yml"), []byte("common: true"), 0644))
+	str, err = RenderTemplate("$[[include \"partials/nested.yml\"]]", nil, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "common: true", str)
+}
+
+func TestRenderTemplateIncludeOutsideBaseDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "config")
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "partials"), 0755))
+	secret := filepath.Join(root, "secret.yml")
+	assert.NoError(t, os.WriteFile(secret, []byte("password: changeme"), 0644))
+
+	_, err := RenderTemplate("$[[include \"../secret.yml\"]]", nil, dir)
+	assert.Error(t, err)
+	_, err = RenderTemplate("$[[include \"partials/../../secret.yml\"]]", nil, dir)
+	assert.Error(t, err)
+	_, err = RenderTemplate("$[[include \""+secret+"\"]]", nil, dir)
+	assert.Error(t, err)
+
+	//escape from an included file
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "partials", "escape.yml"), []byte("$[[include \"../../secret.yml\"]]"), 0644))
+	_, err = RenderTemplate("$[[include \"partials/escape.yml\"]]", nil, dir)
+	assert.Error(t, err)
+
+	//sibling folder with the same prefix
+	assert.NoError(t, os.MkdirAll(dir+"2", 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir+"2", "a.yml"), []byte("a: 1"), 0644))
+	_, err = RenderTemplate("$[[include \"../config2/a.yml\"]]", nil, dir)
+	assert.Error(t, err)
+}
+
+func TestRenderTemplateErrors(t *testing.T) {
+	_, err := RenderTemplate("$[[if a]]x", nil, "")
+	assert.Error(t, err)
+	_, err = RenderTemplate("x$[[end]]", nil, "")
+	assert.Error(t, err)
+	_, err = RenderTemplate("$[[for a b]]x$[[end]]", nil, "")
+	assert.Error(t, err)
+}
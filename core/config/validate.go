@@ -0,0 +1,391 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/go-ucfg"
+)
+
+// ValidationError is an error found in the config file, Line is 0 if the
+// location was not found
+type ValidationError struct {
+	File    string
+	Line    int
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	location := e.File
+	if e.Line > 0 {
+		location = fmt.Sprintf("%s:%d", e.File, e.Line)
+	}
+	if e.Path != "" {
+		return fmt.Sprintf("%s: [%s] %s", location, e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", location, e.Message)
+}
+
+// SchemaValidator checks the config section beyond the unknown fields, eg: creates
+// the processors to verify the parameters
+type SchemaValidator func(cfg *Config) error
+
+type configSchema struct {
+	newValue   func() interface{}
+	validators []SchemaValidator
+}
+
+var (
+	schemas     = map[string]configSchema{}
+	schemasLock sync.RWMutex
+)
+
+// RegisterSchema registers the struct of the top-level config section, used to
+// check the unknown fields in the section, newValue returns a pointer to a new
+// struct or slice of struct
+func RegisterSchema(key string, newValue func() interface{}, validators ...SchemaValidator) {
+	schemasLock.Lock()
+	defer schemasLock.Unlock()
+	schemas[key] = configSchema{newValue: newValue, validators: validators}
+}
+
+func getSchema(key string) (configSchema, bool) {
+	schemasLock.RLock()
+	defer schemasLock.RUnlock()
+	v, ok := schemas[key]
+	return v, ok
+}
+
+// ValidateFile loads the file with templates and variables applied, and checks
+// the top-level sections against root (a pointer to the struct of the known top-level
+// fields, eg: SystemConfig) and the registered schemas, sections not known are skipped
+func ValidateFile(path string, root interface{}) []ValidationError {
+	content, err := util.FileGetContent(path)
+	if err != nil {
+		return []ValidationError{{File: path, Message: err.Error()}}
+	}
+	newError := func(p string, msg string) ValidationError {
+		return ValidationError{File: path, Line: LocateLine(string(content), p), Path: p, Message: msg}
+	}
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		return []ValidationError{{File: path, Line: parseYAMLErrorLine(err.Error()), Message: err.Error()}}
+	}
+
+	errs := []ValidationError{}
+	if root != nil {
+		if err := cfg.Unpack(root); err != nil {
+			errs = append(errs, newError("", err.Error()))
+		}
+	}
+
+	rootFields := map[string]reflect.Type{}
+	if root != nil {
+		rootFields = structFields(reflect.TypeOf(root))
+	}
+
+	for _, key := range cfg.GetFields() {
+		child, err := cfg.Child(key, -1)
+		if err != nil {
+			//primitive values
+			continue
+		}
+
+		schema, ok := getSchema(key)
+		if ok {
+			v := schema.newValue()
+			if err := child.Unpack(v); err != nil {
+				errs = append(errs, newError(key, err.Error()))
+				continue
+			}
+			for _, p := range CheckUnknownFields(child, reflect.TypeOf(v), key) {
+				errs = append(errs, newError(p, "unknown field"))
+			}
+			for _, validator := range schema.validators {
+				if err := validator(child); err != nil {
+					errs = append(errs, newError(key, err.Error()))
+				}
+			}
+			continue
+		}
+
+		if t, ok := rootFields[key]; ok {
+			for _, p := range CheckUnknownFields(child, t, key) {
+				errs = append(errs, newError(p, "unknown field"))
+			}
+		}
+	}
+	return errs
+}
+
+// ValidatePath validates the yaml files in the folder, the registered path filters are applied
+func ValidatePath(folder string, root interface{}) []ValidationError {
+	errs := []ValidationError{}
+	filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if info == nil || info.IsDir() {
+			return nil
+		}
+		if !util.SuffixStr(path, ".yml") && !util.SuffixStr(path, ".yaml") {
+			return nil
+		}
+		for _, filter := range pathFilters {
+			if filter(path) {
+				return nil
+			}
+		}
+		errs = append(errs, ValidateFile(path, root)...)
+		return nil
+	})
+	return errs
+}
+
+func parseYAMLErrorLine(msg string) int {
+	i := strings.Index(msg, "line ")
+	if i < 0 {
+		return 0
+	}
+	end := i + 5
+	for end < len(msg) && msg[end] >= '0' && msg[end] <= '9' {
+		end++
+	}
+	line, _ := strconv.Atoi(msg[i+5 : end])
+	return line
+}
+
+var (
+	configType     = reflect.TypeOf(Config{})
+	ucfgConfigType = reflect.TypeOf(ucfg.Config{})
+	anyType        = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// CheckUnknownFields returns the paths of the fields in cfg which are not
+// declared by the `config` tags of the type t
+func CheckUnknownFields(cfg *Config, t reflect.Type, path string) []string {
+	var raw interface{}
+	if cfg.IsArray() {
+		arr := []interface{}{}
+		if err := cfg.Unpack(&arr); err != nil {
+			return nil
+		}
+		raw = arr
+	} else {
+		obj := map[string]interface{}{}
+		if err := cfg.Unpack(&obj); err != nil {
+			return nil
+		}
+		raw = obj
+	}
+	result := []string{}
+	checkUnknownFields(raw, t, path, &result)
+	sort.Strings(result)
+	return result
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func checkUnknownFields(v interface{}, t reflect.Type, path string, result *[]string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == configType || t == ucfgConfigType {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields := structFields(t)
+		if _, ok := fields[""]; ok {
+			//inline map accepts any field
+			return
+		}
+		for k, value := range obj {
+			ft, ok := fields[k]
+			if !ok {
+				*result = append(*result, joinPath(path, k))
+				continue
+			}
+			checkUnknownFields(value, ft, joinPath(path, k), result)
+		}
+	case reflect.Map:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for k, value := range obj {
+			checkUnknownFields(value, t.Elem(), joinPath(path, k), result)
+		}
+	case reflect.Slice, reflect.Array:
+		arr, ok := v.([]interface{})
+		if !ok {
+			//single value is accepted as a list with one element
+			checkUnknownFields(v, t.Elem(), path, result)
+			return
+		}
+		for i, value := range arr {
+			checkUnknownFields(value, t.Elem(), joinPath(path, strconv.Itoa(i)), result)
+		}
+	}
+}
+
+// structFields returns the fields of the struct by the name in `config` tag, the
+// inline map is returned with empty name
+func structFields(t reflect.Type) map[string]reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	fields := map[string]reflect.Type{}
+	if t.Kind() != reflect.Struct {
+		return fields
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("config")
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+		if name == "-" {
+			continue
+		}
+		inline := strings.Contains(opts, "inline") || (f.Anonymous && name == "")
+		if inline {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Map {
+				fields[""] = ft.Elem()
+				continue
+			}
+			for k, v := range structFields(ft) {
+				fields[k] = v
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			//unexported
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		if i := strings.Index(name, "."); i > 0 {
+			//nested path, only the first segment is checked
+			fields[name[:i]] = anyType
+			continue
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// LocateLine finds the line of the path in the yaml content, the path is
+// separated by dot, numbers are the index of the list items, the line of the
+// deepest located element is returned, 0 if nothing was found
+func LocateLine(content string, path string) int {
+	if path == "" {
+		return 0
+	}
+	lines := strings.Split(content, "\n")
+	found := 0
+	start, parentIndent := 0, -1
+
+	for _, seg := range strings.Split(path, ".") {
+		idx, err := strconv.Atoi(seg)
+		matched := false
+		count := -1
+		itemIndent := -1
+		for i := start; i < len(lines); i++ {
+			trimmed := strings.TrimLeft(lines[i], " ")
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				continue
+			}
+			indent := len(lines[i]) - len(trimmed)
+			if i > start && indent <= parentIndent {
+				break
+			}
+			if err == nil {
+				//list item
+				if !strings.HasPrefix(trimmed, "- ") && trimmed != "-" {
+					continue
+				}
+				if itemIndent < 0 {
+					itemIndent = indent
+				}
+				if indent != itemIndent {
+					continue
+				}
+				count++
+				if count == idx {
+					start, parentIndent, matched = i, indent, true
+					//the fields of the item starts after `- `
+					if len(trimmed) > 2 {
+						lines[i] = strings.Repeat(" ", indent+2) + trimmed[2:]
+						parentIndent = indent + 1
+					} else {
+						start = i + 1
+					}
+					break
+				}
+				continue
+			}
+			if strings.HasPrefix(trimmed, "- ") {
+				trimmed = trimmed[2:]
+				indent += 2
+			}
+			if strings.HasPrefix(trimmed, seg+":") || strings.HasPrefix(trimmed, "\""+seg+"\":") {
+				start, parentIndent, matched = i+1, indent, true
+				found = i + 1
+				break
+			}
+		}
+		if !matched {
+			break
+		}
+		if err == nil {
+			found = start + 1
+		}
+	}
+	return found
+}
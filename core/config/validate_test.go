@@ -0,0 +1,107 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testOutput struct {
+	Hosts   []string          `config:"hosts"`
+	Timeout string            `config:"timeout"`
+	Headers map[string]string `config:"headers"`
+}
+
+type testPipeline struct {
+	Name       string    `config:"name"`
+	Processors []*Config `config:"processor"`
+	Output     *testOutput
+}
+
+type testRoot struct {
+	API struct {
+		Enabled bool `config:"enabled"`
+		Network struct {
+			Binding string `config:"binding"`
+		} `config:"network"`
+	} `config:"api"`
+}
+
+const testValidateYAML = `
+api:
+  enabled: true
+  network:
+    binding: 0.0.0.0:2900
+    bindng: 1
+test_pipeline:
+  - name: first
+    processor:
+      - echo:
+          anything: true
+  - name: second
+    output:
+      hosts: ["localhost"]
+      timout: 1s
+      headers:
+        x: y
+unknown_section:
+  foo: bar
+`
+
+func TestValidateFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.yml")
+	assert.NoError(t, os.WriteFile(file, []byte(testValidateYAML), 0644))
+
+	RegisterSchema("test_pipeline", func() interface{} {
+		return &[]testPipeline{}
+	}, func(cfg *Config) error {
+		return errors.New("validator called")
+	})
+
+	errs := ValidateFile(file, &testRoot{})
+	assert.Equal(t, 3, len(errs))
+	byPath := map[string]ValidationError{}
+	for _, e := range errs {
+		byPath[e.Path] = e
+	}
+
+	assert.Equal(t, 6, byPath["api.network.bindng"].Line)
+	assert.Equal(t, 15, byPath["test_pipeline.1.output.timout"].Line)
+	assert.Equal(t, "validator called", byPath["test_pipeline"].Message)
+	assert.Equal(t, file+":15: [test_pipeline.1.output.timout] unknown field", byPath["test_pipeline.1.output.timout"].Error())
+}
+
+func TestLocateLine(t *testing.T) {
+	assert.Equal(t, 2, LocateLine(testValidateYAML, "api"))
+	assert.Equal(t, 12, LocateLine(testValidateYAML, "test_pipeline.1"))
+	assert.Equal(t, 13, LocateLine(testValidateYAML, "test_pipeline.1.output"))
+	assert.Equal(t, 17, LocateLine(testValidateYAML, "test_pipeline.1.output.headers.x"))
+	assert.Equal(t, 7, LocateLine(testValidateYAML, "test_pipeline.not_exists"))
+	assert.Equal(t, 0, LocateLine(testValidateYAML, "not_exists"))
+}
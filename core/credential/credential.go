@@ -39,13 +39,19 @@ type Credential struct {
 	Type     string `json:"type" elastic_mapping:"type:{type:keyword}"`
 	Tags []string `json:"tags" elastic_mapping:"category:{type:keyword,copy_to:search_text}"`
 	Payload map[string]interface{} `json:"payload" elastic_mapping:"payload:{type:object,enabled:false}"`
-	Encrypt struct{
-		Type string `json:"type"`
-		Params map[string]interface{} `json:"params"`
-	} `json:"encrypt" elastic_mapping:"encrypt:{type:object,enabled:false}"`
+	Encrypt Encryption `json:"encrypt" elastic_mapping:"encrypt:{type:object,enabled:false}"`
 	SearchText string `json:"search_text,omitempty" elastic_mapping:"search_text:{type:text,index_prefixes:{},index_phrases:true, analyzer:suggest_text_search }"`
 	secret []byte
 	Invalid bool `json:"invalid" elastic_mapping:"invalid:{type:boolean}"`
+
+	//version increases on every change, the previous versions are kept in history
+	Version int                 `json:"version,omitempty" elastic_mapping:"version:{type:integer}"`
+	History []CredentialVersion `json:"history,omitempty" elastic_mapping:"history:{type:object,enabled:false}"`
+}
+
+type Encryption struct {
+	Type   string                 `json:"type"`
+	Params map[string]interface{} `json:"params"`
 }
 
 func (cred *Credential) SetSecret(secret []byte) {
@@ -59,43 +65,95 @@ func (cred *Credential) Validate() error {
 	if cred.Type == "" {
 		return fmt.Errorf("credential type must not be empty")
 	}
+	if _, err := getCredentialType(cred.Type); err != nil {
+		return err
+	}
 	if _, ok := cred.Payload[cred.Type]; !ok {
 		return fmt.Errorf("credential payload with type [%s] must not be empty", cred.Type)
 	}
 	return nil
 }
 
-func (cred *Credential) Encode() error{
-	switch cred.Type {
-	case BasicAuth:
-		return encodeBasicAuth(cred)
-	default:
-		return fmt.Errorf("unkonow credential type [%s]", cred.Type)
-	}
+// Encode encrypts the secret fields of the payload
+func (cred *Credential) Encode() error {
+	return encodePayload(cred)
 }
+
+// DecodePayload returns the plaintext fields of the payload
+func (cred *Credential) DecodePayload() (map[string]string, error) {
+	return decodePayload(cred)
+}
+
 func (cred *Credential) DecodeBasicAuth() (*model.BasicAuth, error) {
-	var dv interface{}
 	dv, err := cred.Decode()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	if auth, ok := dv.(model.BasicAuth); ok {
-		return &auth,nil
+		return &auth, nil
+	}
+	return nil, fmt.Errorf("credential type [%s] is not %s", cred.Type, BasicAuth)
+}
+
+func (cred *Credential) DecodeAPIKey() (*APIKeyAuth, error) {
+	dv, err := cred.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if auth, ok := dv.(APIKeyAuth); ok {
+		return &auth, nil
+	}
+	return nil, fmt.Errorf("credential type [%s] is not %s", cred.Type, APIKey)
+}
+
+func (cred *Credential) DecodeBearerToken() (*BearerTokenAuth, error) {
+	dv, err := cred.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if auth, ok := dv.(BearerTokenAuth); ok {
+		return &auth, nil
+	}
+	return nil, fmt.Errorf("credential type [%s] is not %s", cred.Type, BearerToken)
+}
+
+func (cred *Credential) DecodeTLSClientCert() (*TLSClientCertAuth, error) {
+	dv, err := cred.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if auth, ok := dv.(TLSClientCertAuth); ok {
+		return &auth, nil
+	}
+	return nil, fmt.Errorf("credential type [%s] is not %s", cred.Type, TLSClientCert)
+}
+
+func (cred *Credential) DecodeAWSAccessKey() (*AWSAccessKeyAuth, error) {
+	dv, err := cred.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if auth, ok := dv.(AWSAccessKeyAuth); ok {
+		return &auth, nil
 	}
-	return nil, fmt.Errorf("unkonow credential type [%s]", cred.Type)
+	return nil, fmt.Errorf("credential type [%s] is not %s", cred.Type, AWSAccessKey)
 }
 
+// Decode returns the decrypted credential, the value type depends on the credential type,
+// eg: model.BasicAuth for basic_auth, APIKeyAuth for api_key
 func (cred *Credential) Decode() (interface{}, error) {
-	switch cred.Type {
-	case BasicAuth:
-		return decodeBasicAuth(cred)
-	default:
-		return nil, fmt.Errorf("unkonow credential type [%s]", cred.Type)
+	t, err := getCredentialType(cred.Type)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := decodePayload(cred)
+	if err != nil {
+		return nil, err
 	}
+	return t.decode(fields), nil
 }
 
 const (
 	BasicAuth string = "basic_auth"
 )
-
@@ -0,0 +1,281 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package credential
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/keystore"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/go-ucfg"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "credential")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("KEYSTORE_PATH", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newTestCredential(t string, params map[string]interface{}) *Credential {
+	cred := &Credential{Name: "test", Type: t, Payload: map[string]interface{}{t: params}}
+	cred.ID = "test"
+	return cred
+}
+
+func TestEncodeAndDecode(t *testing.T) {
+	cred := newTestCredential(AWSAccessKey, map[string]interface{}{
+		"access_key_id":     "AKID",
+		"secret_access_key": "secret",
+		"region":            "us-east-1",
+	})
+	assert.Nil(t, cred.Validate())
+	assert.Nil(t, cred.Encode())
+	params := cred.Payload[AWSAccessKey].(map[string]interface{})
+	assert.NotEqual(t, "secret", params["secret_access_key"])
+	assert.Equal(t, "AKID", params["access_key_id"])
+
+	auth, err := cred.DecodeAWSAccessKey()
+	assert.Nil(t, err)
+	assert.Equal(t, "AKID", auth.AccessKeyID)
+	assert.Equal(t, "secret", auth.SecretAccessKey.Get())
+	assert.Equal(t, "", auth.SessionToken.Get())
+	assert.Equal(t, "us-east-1", auth.Region)
+
+	_, err = cred.DecodeBasicAuth()
+	assert.NotNil(t, err)
+
+	cred = newTestCredential(APIKey, map[string]interface{}{"id": "key_id"})
+	assert.NotNil(t, cred.Encode())
+	cred = newTestCredential("unknown", map[string]interface{}{})
+	assert.NotNil(t, cred.Validate())
+}
+
+func TestDecodeLegacyBasicAuth(t *testing.T) {
+	secret, err := GetOrInitSecret()
+	assert.Nil(t, err)
+	encoded, salt, err := util.AesGcmEncrypt([]byte("changeme"), secret)
+	assert.Nil(t, err)
+
+	cred := newTestCredential(BasicAuth, map[string]interface{}{"username": "elastic", "password": string(encoded)})
+	cred.Encrypt.Type = "AES"
+	cred.Encrypt.Params = map[string]interface{}{"salt": string(salt)}
+	auth, err := cred.DecodeBasicAuth()
+	assert.Nil(t, err)
+	assert.Equal(t, "elastic", auth.Username)
+	assert.Equal(t, "changeme", auth.Password.Get())
+}
+
+func TestReEncryptAfterRotation(t *testing.T) {
+	cred := newTestCredential(BearerToken, map[string]interface{}{"token": "v1"})
+	assert.Nil(t, cred.Encode())
+	cred.Version = 1
+	cred.History = append(cred.History, cred.snapshot())
+	cred.Payload = map[string]interface{}{BearerToken: map[string]interface{}{"token": "v2"}}
+	assert.Nil(t, cred.Encode())
+	cred.Version = 2
+
+	oldVersion, err := GetSecretVersion()
+	assert.Nil(t, err)
+	version, err := newSecretVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, oldVersion+1, version)
+	assert.True(t, needReEncrypt(cred, version))
+
+	//still readable with the previous key
+	auth, err := cred.DecodeBearerToken()
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", auth.Token.Get())
+
+	assert.Nil(t, ReEncrypt(cred))
+	assert.False(t, needReEncrypt(cred, version))
+	assert.Equal(t, version, getKeyVersion(cred.Encrypt.Params))
+
+	auth, err = cred.DecodeBearerToken()
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", auth.Token.Get())
+
+	old, err := cred.GetVersion(1)
+	assert.Nil(t, err)
+	auth, err = old.DecodeBearerToken()
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", auth.Token.Get())
+}
+
+func TestResolveReference(t *testing.T) {
+	cred := newTestCredential(BasicAuth, map[string]interface{}{"username": "elastic", "password": "changeme"})
+	assert.Nil(t, cred.Encode())
+
+	loader := Loader
+	defer func() { Loader = loader }()
+	Loader = func(id string) (*Credential, error) {
+		if id == "es.prod" {
+			return cred, nil
+		}
+		panic("orm is not ready")
+	}
+
+	v, err := ResolveReference("es.prod.password")
+	assert.Nil(t, err)
+	assert.Equal(t, "changeme", v)
+
+	_, err = ResolveReference("es.prod.token")
+	assert.NotNil(t, err)
+	_, err = ResolveReference("other.password")
+	assert.NotNil(t, err)
+	_, err = ResolveReference("password")
+	assert.NotNil(t, err)
+}
+
+func TestValidationResolver(t *testing.T) {
+	loader := Loader
+	defer func() { Loader = loader }()
+	Loader = func(id string) (*Credential, error) {
+		panic("credentials are not loaded on validation")
+	}
+
+	resolver, err := GetValidationResolver()
+	assert.Nil(t, err)
+	cfg, err := ucfg.NewFrom(map[string]interface{}{"password": "$[[credential.es_prod.password]]", "invalid": "$[[credential.password]]"}, ucfg.VarExp)
+	assert.Nil(t, err)
+
+	v, err := cfg.String("password", -1, resolver)
+	assert.Nil(t, err)
+	assert.Equal(t, "<credential.es_prod.password>", v)
+	_, err = cfg.String("invalid", -1, resolver)
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"es_prod.password"}, UnverifiedReferences())
+}
+
+func TestResolveReferenceFromCache(t *testing.T) {
+	cred := newTestCredential(BasicAuth, map[string]interface{}{"username": "elastic", "password": "secret"})
+	cred.ID = "es.cached"
+	assert.Nil(t, cred.Encode())
+
+	loader := Loader
+	defer func() { Loader = loader }()
+	loads := 0
+	Loader = func(id string) (*Credential, error) {
+		loads++
+		return cred, nil
+	}
+
+	//cached after resolved
+	for i := 0; i < 2; i++ {
+		v, err := ResolveReference("es.cached.password")
+		assert.Nil(t, err)
+		assert.Equal(t, "secret", v)
+	}
+	assert.Equal(t, 1, loads)
+
+	//the last resolved value is used while the store is not available
+	TriggerChangeEvent(cred)
+	Loader = func(id string) (*Credential, error) {
+		return nil, errStoreNotReady
+	}
+	v, err := ResolveReference("es.cached.password")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", v)
+	_, err = ResolveReference("es.cached.username")
+	assert.NotNil(t, err)
+	//never persisted to the keystore
+	ks, err := keystore.GetValue("credential_ref.es.cached.password")
+	assert.Nil(t, ks)
+
+	//deleted credential is not resolved any more
+	Loader = func(id string) (*Credential, error) {
+		return nil, fmt.Errorf("credential [%s] was %w", id, errNotFound)
+	}
+	_, err = ResolveReference("es.cached.password")
+	assert.True(t, errors.Is(err, errNotFound))
+	Loader = func(id string) (*Credential, error) {
+		return nil, errStoreNotReady
+	}
+	_, err = ResolveReference("es.cached.password")
+	assert.NotNil(t, err)
+}
+
+// memoryORM moves the saved credentials to the end, like sorting by the update time
+type memoryORM struct {
+	orm.ORM
+	creds []*Credential
+}
+
+func (m *memoryORM) Save(ctx *orm.Context, o interface{}) error {
+	cred := o.(*Credential)
+	for i, v := range m.creds {
+		if v.ID == cred.ID {
+			m.creds = append(m.creds[:i], m.creds[i+1:]...)
+			break
+		}
+	}
+	m.creds = append(m.creds, cred)
+	return nil
+}
+
+func (m *memoryORM) Search(o interface{}, q *orm.Query) (error, orm.Result) {
+	result := orm.Result{Total: int64(len(m.creds))}
+	for i := q.From; i < len(m.creds) && i < q.From+q.Size; i++ {
+		result.Result = append(result.Result, util.MapStr{
+			"id":      m.creds[i].ID,
+			"type":    m.creds[i].Type,
+			"payload": m.creds[i].Payload,
+			"encrypt": m.creds[i].Encrypt,
+		})
+	}
+	return nil, result
+}
+
+func TestReEncryptAll(t *testing.T) {
+	store := &memoryORM{}
+	for i := 0; i < 150; i++ {
+		cred := newTestCredential(BearerToken, map[string]interface{}{"token": fmt.Sprintf("token-%d", i)})
+		cred.ID = fmt.Sprintf("cred-%d", i)
+		assert.Nil(t, cred.Encode())
+		store.creds = append(store.creds, cred)
+	}
+	orm.Register("credential_test", store)
+
+	version, err := newSecretVersion()
+	assert.Nil(t, err)
+	count, err := ReEncryptAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 150, count)
+	for _, cred := range store.creds {
+		assert.False(t, needReEncrypt(cred, version), cred.ID)
+	}
+}
@@ -30,35 +30,99 @@ package credential
 import (
 	"fmt"
 	"infini.sh/framework/core/keystore"
-	"infini.sh/framework/core/model"
 	"infini.sh/framework/core/util"
-	"infini.sh/framework/lib/go-ucfg"
 	keystore2 "infini.sh/framework/lib/keystore"
+	"strconv"
 )
 
 const SecretKey = "credential_secret"
 
+// secretVersionKey stores the version of the current master key, the first
+// version is stored under SecretKey, the rotated ones are suffixed with version
+const secretVersionKey = "credential_secret_version"
+
+func secretKeyName(version int) string {
+	if version <= 1 {
+		return SecretKey
+	}
+	return fmt.Sprintf("%s_v%d", SecretKey, version)
+}
+
+// GetOrInitSecret returns the current master key, the key will be generated
+// if not exists
 func GetOrInitSecret() ([]byte, error) {
+	_, secret, err := getCurrentSecret()
+	return secret, err
+}
+
+// GetSecretVersion returns the version of the current master key
+func GetSecretVersion() (int, error) {
+	ks, err := keystore.GetOrInitKeystore()
+	if err != nil {
+		return 0, err
+	}
+	secStr, err := ks.Retrieve(secretVersionKey)
+	if err == keystore2.ErrKeyDoesntExists {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := secStr.Get()
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(string(v))
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid credential secret version [%s]", string(v))
+	}
+	return version, nil
+}
+
+// GetSecret returns the master key of the version
+func GetSecret(version int) ([]byte, error) {
 	ks, err := keystore.GetOrInitKeystore()
 	if err != nil {
 		return nil, err
 	}
+	secStr, err := ks.Retrieve(secretKeyName(version))
+	if err != nil {
+		return nil, fmt.Errorf("credential secret of version [%v] error: %w", version, err)
+	}
+	return secStr.Get()
+}
+
+func getCurrentSecret() (int, []byte, error) {
+	version, err := GetSecretVersion()
+	if err != nil {
+		return 0, nil, err
+	}
+	if version > 1 {
+		secret, err := GetSecret(version)
+		return version, secret, err
+	}
+
+	ks, err := keystore.GetOrInitKeystore()
+	if err != nil {
+		return 0, nil, err
+	}
 	secStr, err := ks.Retrieve(SecretKey)
 	if err == keystore2.ErrKeyDoesntExists {
 		secBytes, err := util.RandomBytes(32)
 		if err != nil {
-			return nil, fmt.Errorf("generate credential secret error: %w", err)
+			return 0, nil, fmt.Errorf("generate credential secret error: %w", err)
 		}
 		err = InitSecret(ks, secBytes)
 		if err != nil {
-			return nil, err
+			return 0, nil, err
 		}
-		return secBytes, nil
+		return 1, secBytes, nil
 	}
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
-	return secStr.Get()
+	secret, err := secStr.Get()
+	return 1, secret, err
 }
 
 func InitSecret(ks keystore2.Keystore, secret []byte) error {
@@ -66,7 +130,7 @@ func InitSecret(ks keystore2.Keystore, secret []byte) error {
 	if ks == nil {
 		ks, err = keystore.GetOrInitKeystore()
 		if err != nil {
-			return  err
+			return err
 		}
 	}
 	ksw, err := keystore2.AsWritableKeystore(ks)
@@ -85,76 +149,129 @@ func InitSecret(ks keystore2.Keystore, secret []byte) error {
 	return nil
 }
 
-func encodeBasicAuth(cred *Credential) error {
-	var (
-		params map[string]interface{}
-		ok bool
-		pwd string
-	)
-	if params, ok = cred.Payload[cred.Type].(map[string]interface{}); !ok {
-		return fmt.Errorf("wrong credential parameters for type [%s], expect a map", cred.Type)
+// encodePayload encrypts the secret fields of the payload with the current master key,
+// each field is encrypted with its own salt
+func encodePayload(cred *Credential) error {
+	t, err := getCredentialType(cred.Type)
+	if err != nil {
+		return err
 	}
-	if pwd, ok = params["password"].(string); !ok {
-		return fmt.Errorf("wrong credential parameters password for type [%s], expect a string", cred.Type)
+	params, ok := cred.Payload[cred.Type].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("wrong credential parameters for type [%s], expect a map", cred.Type)
 	}
-	if pwd == "" {
-		return fmt.Errorf("credential parameters password can not be empty")
+	for _, field := range t.required {
+		v, ok := params[field].(string)
+		if !ok {
+			return fmt.Errorf("wrong credential parameters %s for type [%s], expect a string", field, cred.Type)
+		}
+		if v == "" {
+			return fmt.Errorf("credential parameters %s can not be empty", field)
+		}
 	}
-	secret, err := GetOrInitSecret()
+
+	version, secret, err := getCurrentSecret()
 	if err != nil {
 		return err
 	}
-	encodeBytes, salt, err := util.AesGcmEncrypt([]byte(pwd), secret)
-	if err != nil {
-		return fmt.Errorf("encrypt password error: %w", err)
+	salts := map[string]interface{}{}
+	for _, field := range t.secretFields {
+		v, ok := params[field].(string)
+		if !ok || v == "" {
+			continue
+		}
+		encodeBytes, salt, err := util.AesGcmEncrypt([]byte(v), secret)
+		if err != nil {
+			return fmt.Errorf("encrypt %s error: %w", field, err)
+		}
+		params[field] = string(encodeBytes)
+		salts[field] = string(salt)
 	}
 	cred.Encrypt.Type = "AES"
 	cred.Encrypt.Params = map[string]interface{}{
-		"salt": string(salt),
+		"salts":       salts,
+		"key_version": version,
+	}
+	//keep compatible with the layout of basic auth before
+	if salt, ok := salts["password"]; ok {
+		cred.Encrypt.Params["salt"] = salt
 	}
-	params["password"] = string(encodeBytes)
 	cred.Payload[cred.Type] = params
 	return nil
 }
 
-func decodeBasicAuth(cred *Credential) (basicAuth model.BasicAuth, err error) {
-	var (
-		params map[string]interface{}
-		ok bool
-		pwd string
-		salt string
-	)
-	if params, ok = cred.Payload[cred.Type].(map[string]interface{}); !ok {
-		err = fmt.Errorf("wrong credential parameters for type [%s], expect a map", cred.Type)
-		return
-	}
-	if pwd, ok = params["password"].(string); !ok {
-		err = fmt.Errorf("wrong credential parameters password for type [%s], expect a string", cred.Type)
-		return
-	}
-	if pwd == "" {
-		err = fmt.Errorf("credential parameters password can not be empty")
-		return
-	}
-	if salt, ok = cred.Encrypt.Params["salt"].(string); !ok {
-		err = fmt.Errorf("credential encrypt parameters salt can not be empty")
-		return
+func getKeyVersion(params map[string]interface{}) int {
+	switch v := params["key_version"].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 1
+}
+
+func getFieldSalt(params map[string]interface{}, field string) string {
+	if salts, ok := params["salts"].(map[string]interface{}); ok {
+		if salt, ok := salts[field].(string); ok {
+			return salt
+		}
 	}
+	if field == "password" {
+		if salt, ok := params["salt"].(string); ok {
+			return salt
+		}
+	}
+	return ""
+}
+
+// decodePayload returns the payload fields with the secret fields decrypted
+func decodePayload(cred *Credential) (map[string]string, error) {
+	t, err := getCredentialType(cred.Type)
+	if err != nil {
+		return nil, err
+	}
+	params, ok := cred.Payload[cred.Type].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("wrong credential parameters for type [%s], expect a map", cred.Type)
+	}
+	fields := map[string]string{}
+	for k, v := range params {
+		if str, ok := v.(string); ok {
+			fields[k] = str
+		}
+	}
+
 	var secret = cred.secret
-	if secret == nil {
-		secret, err = GetOrInitSecret()
+	for _, field := range t.secretFields {
+		v := fields[field]
+		if v == "" {
+			continue
+		}
+		salt := getFieldSalt(cred.Encrypt.Params, field)
+		if salt == "" {
+			return nil, fmt.Errorf("credential encrypt parameters salt can not be empty")
+		}
+		if secret == nil {
+			secret, err = GetSecret(getKeyVersion(cred.Encrypt.Params))
+			if err != nil {
+				return nil, err
+			}
+		}
+		plaintext, err := util.AesGcmDecrypt([]byte(v), secret, []byte(salt))
 		if err != nil {
-			return basicAuth, err
+			return nil, err
 		}
+		fields[field] = string(plaintext)
 	}
 
-	plaintext, err := util.AesGcmDecrypt([]byte(pwd), secret, []byte(salt))
-	if err != nil {
-		return basicAuth, err
+	for _, field := range t.required {
+		if fields[field] == "" {
+			return nil, fmt.Errorf("credential parameters %s can not be empty", field)
+		}
 	}
-	basicAuth.Username = params["username"].(string)
-	basicAuth.Password = ucfg.SecretString(plaintext)
-	return
+	return fields, nil
 }
 
 type ChangeEvent func(credentials *Credential)
@@ -0,0 +1,161 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package credential
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/orm"
+)
+
+// MaxHistorySize is the max number of previous versions kept in a credential
+var MaxHistorySize = 10
+
+// CredentialVersion is a previous version of the credential, the payload is kept encrypted
+type CredentialVersion struct {
+	Version int                    `json:"version"`
+	Type    string                 `json:"type"`
+	Payload map[string]interface{} `json:"payload"`
+	Encrypt Encryption             `json:"encrypt"`
+	Updated *time.Time             `json:"updated,omitempty"`
+}
+
+func (cred *Credential) snapshot() CredentialVersion {
+	return CredentialVersion{
+		Version: cred.Version,
+		Type:    cred.Type,
+		Payload: cred.Payload,
+		Encrypt: cred.Encrypt,
+		Updated: cred.Updated,
+	}
+}
+
+// GetVersion returns the credential of the version, which can be decoded as usual
+func (cred *Credential) GetVersion(version int) (*Credential, error) {
+	if version == cred.Version {
+		return cred, nil
+	}
+	for _, v := range cred.History {
+		if v.Version == version {
+			old := &Credential{
+				Name:    cred.Name,
+				Type:    v.Type,
+				Tags:    cred.Tags,
+				Payload: v.Payload,
+				Encrypt: v.Encrypt,
+				Version: v.Version,
+				secret:  cred.secret,
+			}
+			old.ID = cred.ID
+			old.Updated = v.Updated
+			return old, nil
+		}
+	}
+	return nil, fmt.Errorf("version [%v] of credential [%s] was not found", version, cred.ID)
+}
+
+// Save encrypts the plaintext payload and stores the credential, the stored
+// version is moved to the history, the change event is triggered after saved
+func Save(ctx *orm.Context, cred *Credential) error {
+	if err := cred.Validate(); err != nil {
+		return err
+	}
+
+	cred.Version = 1
+	cred.History = nil
+	if cred.ID != "" {
+		old := &Credential{}
+		old.ID = cred.ID
+		exists, err := orm.Get(old)
+		if exists {
+			cred.Created = old.Created
+			cred.Version = old.Version + 1
+			cred.History = append(old.History, old.snapshot())
+			if len(cred.History) > MaxHistorySize {
+				cred.History = cred.History[len(cred.History)-MaxHistorySize:]
+			}
+		} else if err != nil {
+			log.Debugf("credential [%s] was not found, %v", cred.ID, err)
+		}
+	}
+
+	if err := cred.Encode(); err != nil {
+		return err
+	}
+
+	var err error
+	if cred.ID == "" {
+		err = orm.Create(ctx, cred)
+	} else {
+		err = orm.Save(ctx, cred)
+	}
+	if err != nil {
+		return err
+	}
+	TriggerChangeEvent(cred)
+	return nil
+}
+
+// Rollback restores the credential to the version, as a new version
+func Rollback(ctx *orm.Context, id string, version int) (*Credential, error) {
+	cred := &Credential{}
+	cred.ID = id
+	exists, err := orm.Get(cred)
+	if !exists {
+		if err == nil {
+			err = fmt.Errorf("credential [%s] was not found", id)
+		}
+		return nil, err
+	}
+
+	old, err := cred.GetVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := old.DecodePayload()
+	if err != nil {
+		return nil, err
+	}
+	cred.Type = old.Type
+	cred.Payload = map[string]interface{}{cred.Type: toPayloadParams(fields)}
+	cred.Encrypt = Encryption{}
+	if err := Save(ctx, cred); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+func toPayloadParams(fields map[string]string) map[string]interface{} {
+	params := map[string]interface{}{}
+	for k, v := range fields {
+		params[k] = v
+	}
+	return params
+}
@@ -0,0 +1,225 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package credential
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/lib/go-ucfg"
+	"infini.sh/framework/lib/go-ucfg/parse"
+)
+
+var (
+	errStoreNotReady = errors.New("credential store is not ready")
+	errNotFound      = errors.New("not found")
+)
+
+// Loader loads the credential by id, loads from orm by default
+var Loader = func(id string) (*Credential, error) {
+	if !orm.Registered() {
+		return nil, errStoreNotReady
+	}
+	cred := &Credential{}
+	cred.ID = id
+	exists, err := orm.Get(cred)
+	if !exists {
+		if err == nil {
+			err = fmt.Errorf("credential [%s] was %w", id, errNotFound)
+		}
+		return nil, err
+	}
+	return cred, nil
+}
+
+// resolved references are cached for a while, the expired values are only kept
+// in memory to be used while the credential store is unavailable, they are
+// never persisted, so nothing outlives the rotation of the master key
+const resolvedTTL = time.Minute
+
+type resolvedValue struct {
+	value    string
+	expireAt time.Time
+}
+
+var (
+	resolved     = map[string]resolvedValue{}
+	resolvedLock sync.RWMutex
+)
+
+func init() {
+	RegisterChangeEvent(func(cred *Credential) {
+		if cred != nil {
+			invalidateResolved(cred.ID)
+		}
+	})
+}
+
+// invalidateResolved expires the cached references of the credential, so they
+// are loaded again from the store
+func invalidateResolved(id string) {
+	resolvedLock.Lock()
+	defer resolvedLock.Unlock()
+	for ref, v := range resolved {
+		if strings.HasPrefix(ref, id+".") {
+			v.expireAt = time.Time{}
+			resolved[ref] = v
+		}
+	}
+}
+
+// getResolved returns the cached value, fresh is false if the value was expired
+func getResolved(ref string) (value string, fresh bool, ok bool) {
+	resolvedLock.RLock()
+	defer resolvedLock.RUnlock()
+	v, ok := resolved[ref]
+	if !ok {
+		return "", false, false
+	}
+	return v.value, time.Now().Before(v.expireAt), true
+}
+
+// ResolveReference resolves the reference in the form of `<id>.<field>`,
+// eg: `es_prod.password`, the field is one of the payload fields, the last
+// resolved value is used if the credential store is not available
+func ResolveReference(ref string) (string, error) {
+	i := strings.LastIndex(ref, ".")
+	if i <= 0 || i == len(ref)-1 {
+		return "", fmt.Errorf("invalid credential reference [%s], expect <id>.<field>", ref)
+	}
+	if v, fresh, _ := getResolved(ref); fresh {
+		return v, nil
+	}
+
+	v, err := resolveFromStore(ref[:i], ref[i+1:])
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			resolvedLock.Lock()
+			delete(resolved, ref)
+			resolvedLock.Unlock()
+			return "", err
+		}
+		if v, _, ok := getResolved(ref); ok {
+			log.Debugf("credential store is not available, use the last resolved value of [%s], %v", ref, err)
+			return v, nil
+		}
+		return "", err
+	}
+
+	resolvedLock.Lock()
+	resolved[ref] = resolvedValue{value: v, expireAt: time.Now().Add(resolvedTTL)}
+	resolvedLock.Unlock()
+	return v, nil
+}
+
+func resolveFromStore(id, field string) (string, error) {
+	cred, err := loadCredential(id)
+	if err != nil {
+		return "", err
+	}
+	fields, err := cred.DecodePayload()
+	if err != nil {
+		return "", err
+	}
+	v, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("field [%s] was %w in credential [%s]", field, errNotFound, id)
+	}
+	return v, nil
+}
+
+// loadCredential turns the panic into error
+func loadCredential(id string) (cred *Credential, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to load credential [%s]: %v", id, r)
+		}
+	}()
+	return Loader(id)
+}
+
+// GetVariableResolver resolves `$[[credential.<id>.<field>]]` in the config, so any
+// config field can refer to a stored credential instead of a literal, eg:
+//
+//	basic_auth:
+//	  username: $[[credential.es_prod.username]]
+//	  password: $[[credential.es_prod.password]]
+func GetVariableResolver() (ucfg.Option, error) {
+	return ucfg.Resolve(func(keyName string) (string, parse.Config, error) {
+		if strings.HasPrefix(keyName, "credential.") {
+			v, err := ResolveReference(keyName[11:])
+			if err != nil {
+				return "", parse.NoopConfig, err
+			}
+			return v, parse.NoopConfig, nil
+		}
+		return "", parse.NoopConfig, ucfg.ErrMissing
+	}), nil
+}
+
+var (
+	unverified     = map[string]bool{}
+	unverifiedLock sync.Mutex
+)
+
+// GetValidationResolver resolves `$[[credential.<id>.<field>]]` with a placeholder,
+// the credentials are loaded from the store at runtime, so they are not verified
+// while validating the config, see UnverifiedReferences
+func GetValidationResolver() (ucfg.Option, error) {
+	return ucfg.Resolve(func(keyName string) (string, parse.Config, error) {
+		if strings.HasPrefix(keyName, "credential.") {
+			ref := keyName[11:]
+			if i := strings.LastIndex(ref, "."); i <= 0 || i == len(ref)-1 {
+				return "", parse.NoopConfig, fmt.Errorf("invalid credential reference [%s], expect <id>.<field>", ref)
+			}
+			unverifiedLock.Lock()
+			unverified[ref] = true
+			unverifiedLock.Unlock()
+			return "<credential." + ref + ">", parse.NoopConfig, nil
+		}
+		return "", parse.NoopConfig, ucfg.ErrMissing
+	}), nil
+}
+
+// UnverifiedReferences returns the sorted references resolved by the validation resolver
+func UnverifiedReferences() []string {
+	unverifiedLock.Lock()
+	defer unverifiedLock.Unlock()
+	refs := make([]string, 0, len(unverified))
+	for k := range unverified {
+		refs = append(refs, k)
+	}
+	sort.Strings(refs)
+	return refs
+}
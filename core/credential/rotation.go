@@ -0,0 +1,178 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package credential
+
+import (
+	"fmt"
+	"strconv"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/keystore"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+// RotateSecret generates a new master key and re-encrypts all the stored
+// credentials with it, the previous keys are kept in the keystore, so the
+// credentials failed to re-encrypt are still readable, returns the new key
+// version and the number of re-encrypted credentials
+func RotateSecret(ctx *orm.Context) (int, int, error) {
+	version, err := newSecretVersion()
+	if err != nil {
+		return 0, 0, err
+	}
+	log.Infof("credential secret rotated to version [%v]", version)
+
+	count, err := ReEncryptAll(ctx)
+	return version, count, err
+}
+
+func newSecretVersion() (int, error) {
+	//make sure the first version exists
+	if _, err := GetOrInitSecret(); err != nil {
+		return 0, err
+	}
+	version, err := GetSecretVersion()
+	if err != nil {
+		return 0, err
+	}
+	version++
+
+	secret, err := util.RandomBytes(32)
+	if err != nil {
+		return 0, fmt.Errorf("generate credential secret error: %w", err)
+	}
+	ksw, err := keystore.GetWriteableKeystore()
+	if err != nil {
+		return 0, err
+	}
+	if err = ksw.Store(secretKeyName(version), secret); err != nil {
+		return 0, fmt.Errorf("store credential secret error: %w", err)
+	}
+	if err = ksw.Store(secretVersionKey, []byte(strconv.Itoa(version))); err != nil {
+		return 0, fmt.Errorf("store credential secret version error: %w", err)
+	}
+	if err = ksw.Save(); err != nil {
+		return 0, fmt.Errorf("save credential secret error: %w", err)
+	}
+	return version, nil
+}
+
+// ReEncrypt decrypts the credential and its history with the keys they were
+// encrypted with, and encrypts them again with the current master key
+func ReEncrypt(cred *Credential) error {
+	if err := reEncrypt(cred); err != nil {
+		return err
+	}
+	for i := range cred.History {
+		old, err := cred.GetVersion(cred.History[i].Version)
+		if err != nil {
+			return err
+		}
+		if err := reEncrypt(old); err != nil {
+			return fmt.Errorf("version [%v]: %w", old.Version, err)
+		}
+		cred.History[i].Payload = old.Payload
+		cred.History[i].Encrypt = old.Encrypt
+	}
+	return nil
+}
+
+func reEncrypt(cred *Credential) error {
+	fields, err := decodePayload(cred)
+	if err != nil {
+		return err
+	}
+	cred.secret = nil
+	cred.Payload = map[string]interface{}{cred.Type: toPayloadParams(fields)}
+	return encodePayload(cred)
+}
+
+// ReEncryptAll re-encrypts the stored credentials which are not encrypted with
+// the current master key
+func ReEncryptAll(ctx *orm.Context) (int, error) {
+	version, err := GetSecretVersion()
+	if err != nil {
+		return 0, err
+	}
+
+	//load all the credentials first, the saved ones may shift the pages
+	var creds []*Credential
+	q := &orm.Query{Size: 100}
+	for {
+		err, result := orm.Search(&Credential{}, q)
+		if err != nil {
+			return 0, err
+		}
+		for _, v := range result.Result {
+			cred := &Credential{}
+			if err := util.FromJSONBytes(util.MustToJSONBytes(v), cred); err != nil {
+				return 0, err
+			}
+			if needReEncrypt(cred, version) {
+				creds = append(creds, cred)
+			}
+		}
+		if len(result.Result) < q.Size {
+			break
+		}
+		q.From += q.Size
+	}
+
+	count := 0
+	var failed []string
+	for _, cred := range creds {
+		if err := ReEncrypt(cred); err != nil {
+			log.Errorf("failed to re-encrypt credential [%s], %v", cred.ID, err)
+			failed = append(failed, cred.ID)
+			continue
+		}
+		if err := orm.Save(ctx, cred); err != nil {
+			return count, err
+		}
+		TriggerChangeEvent(cred)
+		count++
+	}
+
+	if len(failed) > 0 {
+		return count, fmt.Errorf("failed to re-encrypt credentials: %v", failed)
+	}
+	return count, nil
+}
+
+func needReEncrypt(cred *Credential, version int) bool {
+	if cred.Encrypt.Type != "" && getKeyVersion(cred.Encrypt.Params) != version {
+		return true
+	}
+	for _, v := range cred.History {
+		if v.Encrypt.Type != "" && getKeyVersion(v.Encrypt.Params) != version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package credential
+
+import (
+	"fmt"
+
+	"infini.sh/framework/core/model"
+	"infini.sh/framework/lib/go-ucfg"
+)
+
+const (
+	APIKey        string = "api_key"
+	BearerToken   string = "bearer_token"
+	TLSClientCert string = "tls_client_cert"
+	AWSAccessKey  string = "aws_access_key"
+)
+
+// credentialType describes the fields of the payload, the secret fields are
+// encrypted before stored
+type credentialType struct {
+	required     []string
+	secretFields []string
+	decode       func(fields map[string]string) interface{}
+}
+
+var credentialTypes = map[string]credentialType{
+	BasicAuth: {
+		required:     []string{"password"},
+		secretFields: []string{"password"},
+		decode: func(fields map[string]string) interface{} {
+			return model.BasicAuth{Username: fields["username"], Password: ucfg.SecretString(fields["password"])}
+		},
+	},
+	APIKey: {
+		required:     []string{"id", "key"},
+		secretFields: []string{"key"},
+		decode: func(fields map[string]string) interface{} {
+			return APIKeyAuth{ID: fields["id"], Key: ucfg.SecretString(fields["key"])}
+		},
+	},
+	BearerToken: {
+		required:     []string{"token"},
+		secretFields: []string{"token"},
+		decode: func(fields map[string]string) interface{} {
+			return BearerTokenAuth{Token: ucfg.SecretString(fields["token"])}
+		},
+	},
+	TLSClientCert: {
+		required:     []string{"cert", "key"},
+		secretFields: []string{"key"},
+		decode: func(fields map[string]string) interface{} {
+			return TLSClientCertAuth{Cert: fields["cert"], Key: ucfg.SecretString(fields["key"]), CA: fields["ca"]}
+		},
+	},
+	AWSAccessKey: {
+		required:     []string{"access_key_id", "secret_access_key"},
+		secretFields: []string{"secret_access_key", "session_token"},
+		decode: func(fields map[string]string) interface{} {
+			return AWSAccessKeyAuth{
+				AccessKeyID:     fields["access_key_id"],
+				SecretAccessKey: ucfg.SecretString(fields["secret_access_key"]),
+				SessionToken:    ucfg.SecretString(fields["session_token"]),
+				Region:          fields["region"],
+			}
+		},
+	},
+}
+
+// GetSupportedTypes returns the supported credential types
+func GetSupportedTypes() []string {
+	return []string{BasicAuth, APIKey, BearerToken, TLSClientCert, AWSAccessKey}
+}
+
+func getCredentialType(t string) (credentialType, error) {
+	v, ok := credentialTypes[t]
+	if !ok {
+		return v, fmt.Errorf("unkonow credential type [%s]", t)
+	}
+	return v, nil
+}
+
+// APIKeyAuth is the decoded credential of type api_key
+type APIKeyAuth struct {
+	ID  string            `json:"id,omitempty"`
+	Key ucfg.SecretString `json:"key,omitempty"`
+}
+
+// BearerTokenAuth is the decoded credential of type bearer_token
+type BearerTokenAuth struct {
+	Token ucfg.SecretString `json:"token,omitempty"`
+}
+
+// TLSClientCertAuth is the decoded credential of type tls_client_cert, cert,
+// key and ca are PEM encoded
+type TLSClientCertAuth struct {
+	Cert string            `json:"cert,omitempty"`
+	Key  ucfg.SecretString `json:"key,omitempty"`
+	CA   string            `json:"ca,omitempty"`
+}
+
+// AWSAccessKeyAuth is the decoded credential of type aws_access_key
+type AWSAccessKeyAuth struct {
+	AccessKeyID     string            `json:"access_key_id,omitempty"`
+	SecretAccessKey ucfg.SecretString `json:"secret_access_key,omitempty"`
+	SessionToken    ucfg.SecretString `json:"session_token,omitempty"`
+	Region          string            `json:"region,omitempty"`
+}
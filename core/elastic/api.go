@@ -68,6 +68,10 @@ type API interface {
 
 	GetNodesStats(nodeID, host string, level string) *NodesStats
 
+	// GetNodesThreadPoolStats returns the stats of the thread pools of all the nodes,
+	// filtered to the pools, eg: write
+	GetNodesThreadPoolStats(pools ...string) *NodesStats
+
 	GetIndicesStats() *IndicesStats
 
 	GetVersion() Version
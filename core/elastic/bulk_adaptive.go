@@ -0,0 +1,446 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+package elastic
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+// AdaptiveBulkConfig enables the adaptive controller, which adjusts the batch
+// size and the in-flight bulk requests per cluster, based on the feedback of
+// the cluster, like the latency, rejections and the write thread pool queue
+type AdaptiveBulkConfig struct {
+	Enabled bool `config:"enabled"`
+
+	MinBulkSizeInKb  int `config:"min_batch_size_in_kb"`
+	MaxBulkSizeInKb  int `config:"max_batch_size_in_kb"`
+	MinBulkDocsCount int `config:"min_batch_size_in_docs"`
+	MaxBulkDocsCount int `config:"max_batch_size_in_docs"`
+
+	MinConcurrency int `config:"min_concurrency"`
+	MaxConcurrency int `config:"max_concurrency"`
+
+	TargetLatency     string  `config:"target_latency"`
+	MaxRejectedRatio  float64 `config:"max_rejected_ratio"`
+	MaxWriteQueueSize int     `config:"max_write_queue_size"`
+
+	AdjustInterval    string `config:"adjust_interval"`
+	NodeStatsInterval string `config:"node_stats_interval"`
+}
+
+func (cfg *AdaptiveBulkConfig) init() {
+	if cfg.MinBulkSizeInKb <= 0 {
+		cfg.MinBulkSizeInKb = 512
+	}
+	if cfg.MaxBulkSizeInKb <= 0 {
+		cfg.MaxBulkSizeInKb = 50 * 1024
+	}
+	if cfg.MaxBulkSizeInKb < cfg.MinBulkSizeInKb {
+		cfg.MaxBulkSizeInKb = cfg.MinBulkSizeInKb
+	}
+	if cfg.MinBulkDocsCount <= 0 {
+		cfg.MinBulkDocsCount = 100
+	}
+	if cfg.MaxBulkDocsCount <= 0 {
+		cfg.MaxBulkDocsCount = 20000
+	}
+	if cfg.MaxBulkDocsCount < cfg.MinBulkDocsCount {
+		cfg.MaxBulkDocsCount = cfg.MinBulkDocsCount
+	}
+	if cfg.MinConcurrency <= 0 {
+		cfg.MinConcurrency = 1
+	}
+	if cfg.MaxConcurrency <= 0 {
+		cfg.MaxConcurrency = 10
+	}
+	if cfg.MaxConcurrency < cfg.MinConcurrency {
+		cfg.MaxConcurrency = cfg.MinConcurrency
+	}
+	if cfg.MaxRejectedRatio <= 0 {
+		cfg.MaxRejectedRatio = 0.01
+	}
+	if cfg.MaxWriteQueueSize <= 0 {
+		cfg.MaxWriteQueueSize = 200
+	}
+}
+
+// bulkFeedback collects the bulk results between two adjustments
+type bulkFeedback struct {
+	requests     int
+	docs         int
+	rejectedDocs int
+	latency      time.Duration
+}
+
+// BulkController adjusts batch size and concurrency for one cluster
+type BulkController struct {
+	clusterID string
+	config    AdaptiveBulkConfig
+
+	targetLatency     time.Duration
+	adjustInterval    time.Duration
+	nodeStatsInterval time.Duration
+
+	lock         sync.Mutex
+	bulkSize     int
+	bulkDocs     int
+	concurrency  int
+	inFlight     int
+	notify       chan struct{}
+	feedback     bulkFeedback
+	lastAdjust   time.Time
+	avgLatency   time.Duration
+	rejectedRate float64
+
+	//feedback from node stats
+	fetchNodesStats   func() *NodesStats
+	refreshing        int32
+	lastNodeStats     time.Time
+	writeQueue        int
+	nodeRejected      int
+	nodeRejectedTotal map[string]int
+}
+
+// bulkLatency sums the round trips of one bulk request, excluding the delays
+// between the retries, shared by the sub requests of the shard routing
+type bulkLatency struct {
+	total int64
+	count int64
+}
+
+type bulkLatencyKey struct{}
+
+func withBulkLatency(ctx context.Context, latency *bulkLatency) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, bulkLatencyKey{}, latency)
+}
+
+// recordBulkLatency adds the round trip to the latency of the context if any
+func recordBulkLatency(ctx context.Context, d time.Duration) {
+	if ctx == nil {
+		return
+	}
+	if latency, ok := ctx.Value(bulkLatencyKey{}).(*bulkLatency); ok {
+		atomic.AddInt64(&latency.total, int64(d))
+		atomic.AddInt64(&latency.count, 1)
+	}
+}
+
+// average returns the average round trip, 0 if nothing was sent
+func (latency *bulkLatency) average() time.Duration {
+	count := atomic.LoadInt64(&latency.count)
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&latency.total) / count)
+}
+
+var bulkControllers = sync.Map{}
+var bulkControllerStatsOnce = sync.Once{}
+
+// GetBulkController returns the shared adaptive controller of the cluster, the
+// in-flight requests are limited per cluster, so the config of the first bulk
+// processor is used, the different configs of the others are ignored
+func GetBulkController(clusterID string, cfg AdaptiveBulkConfig, initSize, initDocs int) *BulkController {
+	v, ok := bulkControllers.Load(clusterID)
+	if ok {
+		controller := v.(*BulkController)
+		controller.checkConfig(cfg)
+		return controller
+	}
+
+	controller := NewBulkController(clusterID, cfg, initSize, initDocs)
+	controller.fetchNodesStats = func() *NodesStats {
+		//bulk before v6.3
+		return GetClient(clusterID).GetNodesThreadPoolStats("write", "bulk")
+	}
+	v, loaded := bulkControllers.LoadOrStore(clusterID, controller)
+	controller = v.(*BulkController)
+	if loaded {
+		controller.checkConfig(cfg)
+	}
+
+	bulkControllerStatsOnce.Do(func() {
+		stats.RegisterStats("bulk_adaptive", func() interface{} {
+			result := util.MapStr{}
+			bulkControllers.Range(func(key, value interface{}) bool {
+				result[key.(string)] = value.(*BulkController).Stats()
+				return true
+			})
+			return result
+		})
+	})
+	return controller
+}
+
+func NewBulkController(clusterID string, cfg AdaptiveBulkConfig, initSize, initDocs int) *BulkController {
+	cfg.init()
+	controller := &BulkController{
+		clusterID:         clusterID,
+		config:            cfg,
+		targetLatency:     util.GetDurationOrDefault(cfg.TargetLatency, 2*time.Second),
+		adjustInterval:    util.GetDurationOrDefault(cfg.AdjustInterval, 5*time.Second),
+		nodeStatsInterval: util.GetDurationOrDefault(cfg.NodeStatsInterval, 10*time.Second),
+		concurrency:       cfg.MaxConcurrency,
+		notify:            make(chan struct{}, 1),
+		lastAdjust:        time.Now(),
+		nodeRejectedTotal: map[string]int{},
+	}
+	controller.bulkSize = clampInt(initSize, cfg.MinBulkSizeInKb*1024, cfg.MaxBulkSizeInKb*1024)
+	controller.bulkDocs = clampInt(initDocs, cfg.MinBulkDocsCount, cfg.MaxBulkDocsCount)
+	return controller
+}
+
+// checkConfig warns if the adaptive config differs from the shared controller
+func (c *BulkController) checkConfig(cfg AdaptiveBulkConfig) {
+	cfg.init()
+	if cfg != c.config {
+		log.Warnf("adaptive bulk of cluster [%v] is shared by all the bulk processors, ignore the different config: %+v, using: %+v", c.clusterID, cfg, c.config)
+	}
+}
+
+func (c *BulkController) GetBulkSizeInBytes() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.bulkSize
+}
+
+func (c *BulkController) GetBulkMaxDocsCount() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.bulkDocs
+}
+
+func (c *BulkController) GetConcurrency() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.concurrency
+}
+
+// Acquire blocks until a new bulk request is allowed, return false if the context is done
+func (c *BulkController) Acquire(ctx context.Context) bool {
+	for {
+		c.lock.Lock()
+		if c.inFlight < c.concurrency {
+			c.inFlight++
+			c.lock.Unlock()
+			return true
+		}
+		c.lock.Unlock()
+
+		var done <-chan struct{}
+		if ctx != nil {
+			done = ctx.Done()
+		}
+		select {
+		case <-done:
+			return false
+		case <-c.notify:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (c *BulkController) Release() {
+	c.lock.Lock()
+	if c.inFlight > 0 {
+		c.inFlight--
+	}
+	c.lock.Unlock()
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Observe records the result of one bulk request, the latency is ignored if
+// no request was sent to the cluster
+func (c *BulkController) Observe(latency time.Duration, statsRet map[int]int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if latency > 0 {
+		c.feedback.requests++
+		c.feedback.latency += latency
+	}
+	for code, count := range statsRet {
+		c.feedback.docs += count
+		if code == 429 {
+			c.feedback.rejectedDocs += count
+		}
+	}
+
+	now := time.Now()
+	if now.Sub(c.lastAdjust) >= c.adjustInterval {
+		c.adjust()
+		c.lastAdjust = now
+	}
+
+	if c.fetchNodesStats != nil && now.Sub(c.lastNodeStats) >= c.nodeStatsInterval && atomic.CompareAndSwapInt32(&c.refreshing, 0, 1) {
+		c.lastNodeStats = now
+		go c.refreshNodeStats()
+	}
+}
+
+// adjust follows the AIMD way, decrease quickly on pressure and increase slowly
+func (c *BulkController) adjust() {
+	feedback := c.feedback
+	c.feedback = bulkFeedback{}
+
+	if feedback.requests == 0 {
+		return
+	}
+
+	c.avgLatency = feedback.latency / time.Duration(feedback.requests)
+	c.rejectedRate = 0
+	if feedback.docs > 0 {
+		c.rejectedRate = float64(feedback.rejectedDocs) / float64(feedback.docs)
+	}
+
+	sizeStep := (c.config.MaxBulkSizeInKb - c.config.MinBulkSizeInKb) * 1024 / 10
+	docsStep := (c.config.MaxBulkDocsCount - c.config.MinBulkDocsCount) / 10
+
+	oldSize, oldDocs, oldConcurrency := c.bulkSize, c.bulkDocs, c.concurrency
+	switch {
+	case c.rejectedRate > c.config.MaxRejectedRatio || c.nodeRejected > 0 || c.writeQueue > c.config.MaxWriteQueueSize:
+		c.bulkSize /= 2
+		c.bulkDocs /= 2
+		c.concurrency /= 2
+	case c.avgLatency > c.targetLatency:
+		c.bulkSize = c.bulkSize * 3 / 4
+		c.bulkDocs = c.bulkDocs * 3 / 4
+		if c.avgLatency > 2*c.targetLatency {
+			c.concurrency--
+		}
+	case c.avgLatency < c.targetLatency/2:
+		if c.bulkSize < c.config.MaxBulkSizeInKb*1024 || c.bulkDocs < c.config.MaxBulkDocsCount {
+			c.bulkSize += sizeStep
+			c.bulkDocs += docsStep
+		} else {
+			c.concurrency++
+		}
+	}
+	c.nodeRejected = 0
+
+	c.bulkSize = clampInt(c.bulkSize, c.config.MinBulkSizeInKb*1024, c.config.MaxBulkSizeInKb*1024)
+	c.bulkDocs = clampInt(c.bulkDocs, c.config.MinBulkDocsCount, c.config.MaxBulkDocsCount)
+	c.concurrency = clampInt(c.concurrency, c.config.MinConcurrency, c.config.MaxConcurrency)
+
+	if oldSize != c.bulkSize || oldDocs != c.bulkDocs || oldConcurrency != c.concurrency {
+		log.Debugf("adaptive bulk for [%v], latency: %v, rejected: %.4f, write_queue: %v, batch: %v/%v docs -> %v/%v docs, concurrency: %v -> %v",
+			c.clusterID, c.avgLatency, c.rejectedRate, c.writeQueue, util.ByteSize(uint64(oldSize)), oldDocs, util.ByteSize(uint64(c.bulkSize)), c.bulkDocs, oldConcurrency, c.concurrency)
+	}
+}
+
+func (c *BulkController) refreshNodeStats() {
+	defer atomic.StoreInt32(&c.refreshing, 0)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Debugf("failed to fetch node stats for [%v], %v", c.clusterID, r)
+		}
+	}()
+
+	nodesStats := c.fetchNodesStats()
+	if nodesStats == nil || nodesStats.ErrorObject != nil {
+		return
+	}
+	c.updateNodeStats(nodesStats)
+}
+
+// updateNodeStats collects the max queue size and the new rejections of the write thread pool
+func (c *BulkController) updateNodeStats(nodesStats *NodesStats) {
+	maxQueue := 0
+	rejected := 0
+	totals := map[string]int{}
+	for nodeID, v := range nodesStats.Nodes {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		m := util.MapStr(obj)
+		pool, err := m.GetValue("thread_pool.write")
+		if err != nil {
+			//before v6.3
+			pool, err = m.GetValue("thread_pool.bulk")
+			if err != nil {
+				continue
+			}
+		}
+		poolObj, ok := pool.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if queue := getIntValue(poolObj["queue"]); queue > maxQueue {
+			maxQueue = queue
+		}
+		total := getIntValue(poolObj["rejected"])
+		totals[nodeID] = total
+
+		c.lock.Lock()
+		previous, ok := c.nodeRejectedTotal[nodeID]
+		c.lock.Unlock()
+		if ok && total > previous {
+			rejected += total - previous
+		}
+	}
+
+	c.lock.Lock()
+	c.writeQueue = maxQueue
+	c.nodeRejected += rejected
+	c.nodeRejectedTotal = totals
+	c.lock.Unlock()
+}
+
+func (c *BulkController) Stats() util.MapStr {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return util.MapStr{
+		"batch_size_in_bytes": c.bulkSize,
+		"batch_size_in_docs":  c.bulkDocs,
+		"concurrency":         c.concurrency,
+		"in_flight":           c.inFlight,
+		"avg_latency_in_ms":   c.avgLatency.Milliseconds(),
+		"rejected_ratio":      c.rejectedRate,
+		"write_queue":         c.writeQueue,
+	}
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,154 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+package elastic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBulkController() *BulkController {
+	return NewBulkController("test", AdaptiveBulkConfig{
+		MinBulkSizeInKb:  1024,
+		MaxBulkSizeInKb:  11 * 1024,
+		MinBulkDocsCount: 100,
+		MaxBulkDocsCount: 1100,
+		MinConcurrency:   1,
+		MaxConcurrency:   4,
+		TargetLatency:    "1s",
+		AdjustInterval:   "1h",
+	}, 10*1024*1024, 1000)
+}
+
+func TestBulkControllerIncrease(t *testing.T) {
+	c := newTestBulkController()
+	assert.Equal(t, 10*1024*1024, c.GetBulkSizeInBytes())
+	assert.Equal(t, 1000, c.GetBulkMaxDocsCount())
+	assert.Equal(t, 4, c.GetConcurrency())
+
+	c.Observe(100*time.Millisecond, map[int]int{200: 1000})
+	c.adjust()
+	assert.Equal(t, 11*1024*1024, c.GetBulkSizeInBytes())
+	assert.Equal(t, 1100, c.GetBulkMaxDocsCount())
+
+	//no feedback, nothing changed
+	c.adjust()
+	assert.Equal(t, 11*1024*1024, c.GetBulkSizeInBytes())
+}
+
+func TestBulkControllerBackoff(t *testing.T) {
+	c := newTestBulkController()
+
+	c.Observe(100*time.Millisecond, map[int]int{200: 900, 429: 100})
+	c.adjust()
+	assert.Equal(t, 5*1024*1024, c.GetBulkSizeInBytes())
+	assert.Equal(t, 500, c.GetBulkMaxDocsCount())
+	assert.Equal(t, 2, c.GetConcurrency())
+
+	//slow responses
+	c.Observe(3*time.Second, map[int]int{200: 500})
+	c.adjust()
+	assert.Equal(t, 5*1024*1024*3/4, c.GetBulkSizeInBytes())
+	assert.Equal(t, 375, c.GetBulkMaxDocsCount())
+	assert.Equal(t, 1, c.GetConcurrency())
+
+	//bounded by the min values
+	for i := 0; i < 10; i++ {
+		c.Observe(100*time.Millisecond, map[int]int{429: 100})
+		c.adjust()
+	}
+	assert.Equal(t, 1024*1024, c.GetBulkSizeInBytes())
+	assert.Equal(t, 100, c.GetBulkMaxDocsCount())
+	assert.Equal(t, 1, c.GetConcurrency())
+}
+
+func TestBulkControllerNodeStats(t *testing.T) {
+	c := newTestBulkController()
+	nodeStats := func(queue, rejected float64) *NodesStats {
+		return &NodesStats{Nodes: map[string]interface{}{
+			"n1": map[string]interface{}{"thread_pool": map[string]interface{}{
+				"write": map[string]interface{}{"queue": queue, "rejected": rejected},
+			}},
+			"n2": map[string]interface{}{"thread_pool": map[string]interface{}{
+				"bulk": map[string]interface{}{"queue": float64(0), "rejected": float64(0)},
+			}},
+		}}
+	}
+
+	c.updateNodeStats(nodeStats(10, 5))
+	c.Observe(100*time.Millisecond, map[int]int{200: 100})
+	c.adjust()
+	assert.Equal(t, 11*1024*1024, c.GetBulkSizeInBytes())
+
+	//new rejections on the write thread pool
+	c.updateNodeStats(nodeStats(10, 8))
+	c.Observe(100*time.Millisecond, map[int]int{200: 100})
+	c.adjust()
+	assert.Equal(t, 11*1024*1024/2, c.GetBulkSizeInBytes())
+	assert.Equal(t, 10, c.Stats()["write_queue"])
+}
+
+func TestBulkControllerConcurrency(t *testing.T) {
+	c := newTestBulkController()
+	c.concurrency = 1
+
+	assert.True(t, c.Acquire(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.False(t, c.Acquire(ctx))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.Release()
+	}()
+	assert.True(t, c.Acquire(context.Background()))
+	assert.Equal(t, 1, c.Stats()["in_flight"])
+}
+
+func TestBulkLatency(t *testing.T) {
+	latency := &bulkLatency{}
+	ctx := withBulkLatency(nil, latency)
+	assert.Equal(t, time.Duration(0), latency.average())
+	recordBulkLatency(ctx, 100*time.Millisecond)
+	recordBulkLatency(ctx, 300*time.Millisecond)
+	recordBulkLatency(context.Background(), time.Hour)
+	assert.Equal(t, 200*time.Millisecond, latency.average())
+
+	//nothing sent, only the docs are counted
+	c := newTestBulkController()
+	c.Observe(0, map[int]int{429: 100})
+	assert.Equal(t, 0, c.feedback.requests)
+	assert.Equal(t, 100, c.feedback.rejectedDocs)
+}
+
+func TestGetBulkControllerShared(t *testing.T) {
+	cfg := AdaptiveBulkConfig{Enabled: true, MaxConcurrency: 2}
+	c := GetBulkController("shared_test", cfg, 1024*1024, 1000)
+	cfg.MaxConcurrency = 8
+	assert.True(t, c == GetBulkController("shared_test", cfg, 1024*1024, 1000))
+	assert.Equal(t, 2, c.GetConcurrency())
+}
@@ -34,6 +34,9 @@ type BulkBuffer struct {
 	bytesBuffer     *bytebufferpool.ByteBuffer
 	MessageIDs []string
 	Reason []string
+
+	//the start of each message in bytes, used to split the messages by documents
+	messageOffsets []int
 }
 
 type BulkBufferPool struct {
@@ -135,6 +138,7 @@ func SafetyAddNewlineBetweenData(buffer *bytebufferpool.ByteBuffer,data []byte){
 func (receiver *BulkBuffer) Add(id string, data []byte) {
 	if data != nil && len(data) > 0 && len(id) != 0 {
 		receiver.MessageIDs = append(receiver.MessageIDs, id)
+		receiver.messageOffsets = append(receiver.messageOffsets, receiver.bytesBuffer.Len())
 		SafetyAddNewlineBetweenData(receiver.bytesBuffer, data)
 	}
 }
@@ -154,6 +158,11 @@ func (receiver *BulkBuffer) GetMessageBytes() []byte {
 func (receiver *BulkBuffer) WriteMessageID(id string) {
 	if len(id) != 0 {
 		receiver.MessageIDs = append(receiver.MessageIDs, id)
+		offset := 0
+		if receiver.bytesBuffer != nil {
+			offset = receiver.bytesBuffer.Len()
+		}
+		receiver.messageOffsets = append(receiver.messageOffsets, offset)
 	}else{
 		log.Error("invalid message id: ",id)
 		panic("invalid message id")
@@ -176,5 +185,6 @@ func (receiver *BulkBuffer) ResetData() {
 		receiver.bytesBuffer.Reset()
 	}
 	receiver.MessageIDs = receiver.MessageIDs[:0]
+	receiver.messageOffsets = receiver.messageOffsets[:0]
 	receiver.Reason = receiver.Reason[:0]
 }
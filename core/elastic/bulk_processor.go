@@ -255,6 +255,10 @@ type BulkProcessorConfig struct {
 	BulkResponseParseConfig BulkResponseParseConfig `config:"response_handle"`
 
 	RemoveDuplicatedNewlines bool `config:"remove_duplicated_newlines"`
+
+	ShardRouting ShardRoutingConfig `config:"shard_routing"`
+
+	Adaptive AdaptiveBulkConfig `config:"adaptive"`
 }
 
 type BulkResponseParseConfig struct {
@@ -390,6 +394,8 @@ type BulkProcessor struct {
 	Config         BulkProcessorConfig
 	BulkBufferPool *BulkBufferPool
 	HttpPool       *fasthttp.RequestResponsePool
+
+	controller *BulkController
 }
 
 func NewBulkProcessor(tag,esClusterID string,cfg BulkProcessorConfig)BulkProcessor  {
@@ -401,13 +407,53 @@ func NewBulkProcessor(tag,esClusterID string,cfg BulkProcessorConfig)BulkProcess
 	if bulkProcessor.Config.DeadletterRequestsQueue == "" {
 		bulkProcessor.Config.DeadletterRequestsQueue = fmt.Sprintf("%v-bulk-dead_letter-items", esClusterID)
 	}
+	if cfg.Adaptive.Enabled {
+		bulkProcessor.controller = GetBulkController(esClusterID, cfg.Adaptive, cfg.GetBulkSizeInBytes(), cfg.BulkMaxDocsCount)
+	}
 
 	return bulkProcessor
 }
 
+// GetBulkSizeInBytes returns the batch size, which is adjusted by the cluster feedback in adaptive mode
+func (joint *BulkProcessor) GetBulkSizeInBytes() int {
+	if joint.controller != nil {
+		return joint.controller.GetBulkSizeInBytes()
+	}
+	return joint.Config.GetBulkSizeInBytes()
+}
+
+// GetBulkMaxDocsCount returns the max docs of a batch, which is adjusted by the cluster feedback in adaptive mode
+func (joint *BulkProcessor) GetBulkMaxDocsCount() int {
+	if joint.controller != nil {
+		return joint.controller.GetBulkMaxDocsCount()
+	}
+	return joint.Config.BulkMaxDocsCount
+}
+
 
 // bulkResult is valid only if max_reject_retry_times == 0
 func (joint *BulkProcessor) Bulk(ctx context.Context, tag string, metadata *ElasticsearchMetadata, host string, buffer *BulkBuffer) (continueNext bool, statsRet map[int]int, bulkResult *BulkResult, err error) {
+	if joint.controller != nil {
+		//limit the in-flight requests of this cluster
+		if !joint.controller.Acquire(ctx) {
+			return false, make(map[int]int), nil, errors.New("bulk request was canceled")
+		}
+		//only the round trips are measured, not the delays of the retries
+		latency := &bulkLatency{}
+		ctx = withBulkLatency(ctx, latency)
+		defer func() {
+			joint.controller.Observe(latency.average(), statsRet)
+			joint.controller.Release()
+		}()
+	}
+
+	if joint.Config.ShardRouting.Enabled && buffer != nil && buffer.GetMessageSize() > 0 {
+		return joint.bulkWithShardRouting(ctx, tag, metadata, host, buffer)
+	}
+	return joint.doBulk(ctx, tag, metadata, host, buffer)
+}
+
+func (joint *BulkProcessor) doBulk(ctx context.Context, tag string, metadata *ElasticsearchMetadata, host string, buffer *BulkBuffer) (continueNext bool, statsRet map[int]int, bulkResult *BulkResult, err error) {
 
 	statsRet = make(map[int]int)
 
@@ -421,6 +467,12 @@ func (joint *BulkProcessor) Bulk(ctx context.Context, tag string, metadata *Elas
 		panic("invalid host")
 	}
 
+	//fail fast, the slot is reserved on each attempt
+	breaker := GetHostCircuitBreaker(host)
+	if breaker != nil && !breaker.Ready() {
+		return false, statsRet, nil, errors.Errorf("circuit breaker of host [%v] is open", host)
+	}
+
 	httpClient := metadata.GetHttpClient(host)
 
 	var url string
@@ -510,7 +562,21 @@ DO:
 
 	req.SetURI(clonedURI)
 	//execute
-	err = httpClient.DoTimeout(req, resp, time.Duration(joint.Config.RequestTimeoutInSecond)*time.Second)
+	requestStart := time.Now()
+	if breaker != nil {
+		allowed := breaker.Execute(func() bool {
+			err = httpClient.DoTimeout(req, resp, time.Duration(joint.Config.RequestTimeoutInSecond)*time.Second)
+			return err == nil && resp.StatusCode() < 500
+		})
+		if allowed {
+			recordBulkLatency(ctx, time.Since(requestStart))
+		} else {
+			err = errors.Errorf("circuit breaker of host [%v] is open", host)
+		}
+	} else {
+		err = httpClient.DoTimeout(req, resp, time.Duration(joint.Config.RequestTimeoutInSecond)*time.Second)
+		recordBulkLatency(ctx, time.Since(requestStart))
+	}
 	//restore schema
 	clonedURI.SetScheme(orignalSchema)
 	req.SetURI(clonedURI)
@@ -0,0 +1,525 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+package elastic
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+// ShardRoutingConfig controls how bulk requests are split by the node which
+// holds the primary shard of each document, requests are then sent to that
+// node directly, skip one extra hop of the coordinating node.
+type ShardRoutingConfig struct {
+	Enabled bool `config:"enabled"`
+
+	//groups with less docs than this will be merged and sent to the default host
+	MinDocsPerNode int `config:"min_docs_per_node"`
+
+	//max number of the routed requests sent at the same time, default 4
+	MaxConcurrentRequests int `config:"max_concurrent_requests"`
+}
+
+// indexRouting holds the resolved primary shards of a concrete index
+type indexRouting struct {
+	valid            bool
+	numberOfShards   int
+	routingNumShards int
+	primaries        map[int]string //shard id => http host
+}
+
+type shardRouter struct {
+	metadata  *ElasticsearchMetadata
+	version   int
+	available func(host string) bool
+	indices   map[string]*indexRouting
+}
+
+func newShardRouter(metadata *ElasticsearchMetadata, version int) *shardRouter {
+	return &shardRouter{
+		metadata:  metadata,
+		version:   version,
+		available: IsHostAvailable,
+		indices:   map[string]*indexRouting{},
+	}
+}
+
+// resolveIndex returns the routing of the index or alias, routing is only
+// valid when the cluster state is known and the index is not partitioned
+func (router *shardRouter) resolveIndex(index string) *indexRouting {
+	if v, ok := router.indices[index]; ok {
+		return v
+	}
+
+	routing := &indexRouting{}
+	router.indices[index] = routing
+
+	state := router.metadata.ClusterState
+	if index == "" || state == nil || state.RoutingTable == nil {
+		return routing
+	}
+
+	table, err := router.metadata.GetIndexRoutingTable(index)
+	if err != nil || len(table) == 0 {
+		if global.Env().IsDebug {
+			log.Tracef("routing table for index [%v] was not found, %v", index, err)
+		}
+		return routing
+	}
+
+	var concreteIndex string
+	routing.numberOfShards = len(table)
+	routing.primaries = map[int]string{}
+	for k, shards := range table {
+		shardID, err := util.ToInt(k)
+		if err != nil || shardID < 0 || shardID >= routing.numberOfShards {
+			return routing
+		}
+		for _, shard := range shards {
+			if !shard.Primary {
+				continue
+			}
+			concreteIndex = shard.Index
+			//primary shard is moving around, let the cluster decide
+			if shard.State != "STARTED" || shard.RelocatingNode != nil {
+				break
+			}
+			host := router.getNodeHost(shard.Node)
+			if host != "" {
+				routing.primaries[shardID] = host
+			}
+			break
+		}
+	}
+
+	if concreteIndex == "" {
+		concreteIndex = index
+	}
+
+	if state.Metadata != nil && state.Metadata.Indices != nil {
+		if obj, ok := state.Metadata.Indices[concreteIndex].(map[string]interface{}); ok {
+			m := util.MapStr(obj)
+			if v, err := m.GetValue("routing_num_shards"); err == nil {
+				routing.routingNumShards = getIntValue(v)
+			}
+			if v, err := m.GetValue("settings.index.routing_partition_size"); err == nil && getIntValue(v) > 1 {
+				//partitioned index need both id and routing to calculate the shard
+				return routing
+			}
+		}
+	}
+
+	routing.valid = true
+	return routing
+}
+
+// getIntValue parse int from the cluster state, settings are returned as string
+func getIntValue(v interface{}) int {
+	if str, ok := v.(string); ok {
+		n, err := util.ToInt(str)
+		if err != nil {
+			return 0
+		}
+		return n
+	}
+	n, err := util.ExtractInt(v)
+	if err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+func (router *shardRouter) getNodeHost(nodeID string) string {
+	if nodeID == "" || router.metadata.Nodes == nil {
+		return ""
+	}
+	node, ok := (*router.metadata.Nodes)[nodeID]
+	if !ok || node.Http.PublishAddress == "" {
+		return ""
+	}
+	host := node.GetHttpPublishHost()
+	if !router.available(host) {
+		return ""
+	}
+	return host
+}
+
+// route returns the host of the primary shard for this document, empty if unknown
+func (router *shardRouter) route(index, id, routing string) string {
+	key := routing
+	if key == "" {
+		key = id
+	}
+	if key == "" {
+		//auto generated id, any shard is fine
+		return ""
+	}
+
+	indexRouting := router.resolveIndex(index)
+	if !indexRouting.valid {
+		return ""
+	}
+
+	shardID := GetShardIDWithRoutingOffset(router.version, []byte(key), indexRouting.numberOfShards, indexRouting.routingNumShards, 1)
+	return indexRouting.primaries[shardID]
+}
+
+// routedBulkRequest is a slice of the original bulk request
+type routedBulkRequest struct {
+	host   string
+	docs   int
+	failed bool
+	buffer *BulkBuffer
+}
+
+// routedDoc is a document of the original bulk request
+type routedDoc struct {
+	host string
+	//the index of the message id in the original request
+	message int
+	//the bytes of the meta and payload lines in the original request
+	start, end int
+	request    *routedBulkRequest
+}
+
+// default number of the routed requests sent at the same time
+const defaultMaxConcurrentRoutedRequests = 4
+
+// assignMessages finds the message of each document, the message ids are
+// either written per document, or per message of multiple documents, in which
+// case the message is located by the bytes offset
+func assignMessages(buffer *BulkBuffer, docs []routedDoc) error {
+	ids := buffer.MessageIDs
+	switch {
+	case len(ids) == len(docs):
+		for i := range docs {
+			docs[i].message = i
+		}
+	case len(ids) > 0 && len(buffer.messageOffsets) == len(ids):
+		j := 0
+		for i := range docs {
+			for j+1 < len(ids) && buffer.messageOffsets[j+1] <= docs[i].start {
+				j++
+			}
+			docs[i].message = j
+		}
+	default:
+		return errors.Errorf("%v message ids can't be mapped to %v documents", len(ids), len(docs))
+	}
+	return nil
+}
+
+// writeDocuments writes the matched documents to the buffer, with the ids of
+// the original messages they belong to
+func writeDocuments(target *BulkBuffer, data []byte, ids []string, docs []routedDoc, match func(doc *routedDoc) bool) {
+	last := -1
+	for i := range docs {
+		doc := &docs[i]
+		if !match(doc) {
+			continue
+		}
+		if doc.message != last {
+			target.WriteMessageID(ids[doc.message])
+			last = doc.message
+		}
+		target.WriteNewByteBufferLine("routed", data[doc.start:doc.end])
+	}
+}
+
+// splitByShardRouting splits the bulk request by the target node, documents
+// can't be routed are put into the last request with an empty host
+func (joint *BulkProcessor) splitByShardRouting(router *shardRouter, buffer *BulkBuffer) (requests []*routedBulkRequest, docs []routedDoc, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			for _, v := range requests {
+				joint.BulkBufferPool.ReturnBulkBuffer(v.buffer)
+			}
+			requests = nil
+			docs = nil
+			err = errors.Errorf("failed to split bulk requests: %v", r)
+		}
+	}()
+
+	buffer.SafetyEndWithNewline()
+	data := buffer.GetMessageBytes()
+
+	//track the position of each line, empty lines are skipped by the walker
+	cursor, lineStart := 0, 0
+	_, err = WalkBulkRequests(data, func(line []byte) bool {
+		for cursor < len(data) && data[cursor] == '\n' {
+			cursor++
+		}
+		lineStart = cursor
+		cursor += len(line) + 1
+		return false
+	}, func(metaBytes []byte, actionStr, index, typeName, id, routing string, offset int) error {
+		docs = append(docs, routedDoc{host: router.route(index, id, routing), start: lineStart, end: lineStart + len(metaBytes)})
+		return nil
+	}, func(payloadBytes []byte, actionStr, index, typeName, id, routing string) {
+		docs[len(docs)-1].end = lineStart + len(payloadBytes)
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+	if err := assignMessages(buffer, docs); err != nil {
+		panic(err)
+	}
+
+	//merge the small groups into the fallback one
+	minDocs := joint.Config.ShardRouting.MinDocsPerNode
+	if minDocs > 1 {
+		counts := map[string]int{}
+		for _, doc := range docs {
+			counts[doc.host]++
+		}
+		for i := range docs {
+			if docs[i].host != "" && counts[docs[i].host] < minDocs {
+				docs[i].host = ""
+			}
+		}
+	}
+
+	groups := map[string]*routedBulkRequest{}
+	for i := range docs {
+		v, ok := groups[docs[i].host]
+		if !ok {
+			v = &routedBulkRequest{host: docs[i].host, buffer: joint.BulkBufferPool.AcquireBulkBuffer()}
+			v.buffer.Queue = buffer.Queue
+			groups[v.host] = v
+			requests = append(requests, v)
+		}
+		v.docs++
+		docs[i].request = v
+	}
+	for _, v := range requests {
+		req := v
+		writeDocuments(req.buffer, data, buffer.MessageIDs, docs, func(doc *routedDoc) bool {
+			return doc.request == req
+		})
+	}
+
+	//keep the fallback request at last
+	for i, v := range requests {
+		if v.host == "" && i != len(requests)-1 {
+			requests = append(append(requests[:i:i], requests[i+1:]...), v)
+			break
+		}
+	}
+
+	return requests, docs, nil
+}
+
+// retainFailedDocuments removes the documents of the succeeded requests from
+// the buffer, so that only the failed documents will be sent again
+func (joint *BulkProcessor) retainFailedDocuments(buffer *BulkBuffer, docs []routedDoc) {
+	retained := joint.BulkBufferPool.AcquireBulkBuffer()
+	defer joint.BulkBufferPool.ReturnBulkBuffer(retained)
+
+	writeDocuments(retained, buffer.GetMessageBytes(), buffer.MessageIDs, docs, func(doc *routedDoc) bool {
+		return doc.request.failed
+	})
+
+	buffer.ResetData()
+	buffer.Write(retained.GetMessageBytes())
+	buffer.MessageIDs = append(buffer.MessageIDs, retained.MessageIDs...)
+	buffer.messageOffsets = append(buffer.messageOffsets, retained.messageOffsets...)
+}
+
+// bulkWithShardRouting sends documents to the node where the primary shard
+// lives, falls back to the default host when the routing is unknown or stale.
+// when some of the routed requests failed, the documents of the succeeded
+// requests are removed from the buffer before returning, so that they won't
+// be indexed twice by the caller's retry
+func (joint *BulkProcessor) bulkWithShardRouting(ctx context.Context, tag string, metadata *ElasticsearchMetadata, host string, buffer *BulkBuffer) (continueNext bool, statsRet map[int]int, bulkResult *BulkResult, err error) {
+
+	if metadata.ClusterState == nil || metadata.ClusterState.RoutingTable == nil {
+		stats.Increment("elasticsearch.bulk", "shard_routing.fallback")
+		return joint.doBulk(ctx, tag, metadata, host, buffer)
+	}
+
+	router := newShardRouter(metadata, metadata.GetMajorVersion())
+	requests, docs, err := joint.splitByShardRouting(router, buffer)
+	if err != nil {
+		log.Warnf("%v, fallback to default host, %v", tag, err)
+		stats.Increment("elasticsearch.bulk", "shard_routing.fallback")
+		return joint.doBulk(ctx, tag, metadata, host, buffer)
+	}
+	defer func() {
+		for _, v := range requests {
+			joint.BulkBufferPool.ReturnBulkBuffer(v.buffer)
+		}
+	}()
+
+	//nothing was routed
+	if len(requests) == 1 && requests[0].host == "" {
+		stats.Increment("elasticsearch.bulk", "shard_routing.fallback")
+		return joint.doBulk(ctx, tag, metadata, host, buffer)
+	}
+
+	if joint.Config.MaxRejectRetryTimes < 0 {
+		joint.Config.MaxRejectRetryTimes = 3
+	}
+
+	type routedResult struct {
+		continueNext bool
+		stats        map[int]int
+		result       *BulkResult
+		err          error
+	}
+
+	maxConcurrent := joint.Config.ShardRouting.MaxConcurrentRequests
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultMaxConcurrentRoutedRequests
+	}
+	limiter := make(chan struct{}, maxConcurrent)
+
+	results := make([]routedResult, len(requests))
+	wg := sync.WaitGroup{}
+	for i, v := range requests {
+		wg.Add(1)
+		limiter <- struct{}{}
+		go func(i int, req *routedBulkRequest) {
+			defer wg.Done()
+			defer func() {
+				<-limiter
+			}()
+			defer func() {
+				if r := recover(); r != nil {
+					results[i].err = errors.Errorf("bulk to [%v] failed: %v", req.host, r)
+				}
+			}()
+
+			if req.host == "" {
+				stats.IncrementBy("elasticsearch.bulk", "shard_routing.fallback_docs", int64(req.docs))
+				results[i].continueNext, results[i].stats, results[i].result, results[i].err = joint.doBulk(ctx, tag, metadata, host, req.buffer)
+				return
+			}
+
+			stats.IncrementBy("elasticsearch.bulk", "shard_routing.routed_docs", int64(req.docs))
+			r := &results[i]
+			r.continueNext, r.stats, r.result, r.err = joint.doBulk(ctx, tag, metadata, req.host, req.buffer)
+			if r.err != nil && r.result == nil {
+				//the node may be gone, the routing table is stale, try again with the default host
+				log.Debugf("%v, bulk to node [%v] failed, fallback to default host, %v", tag, req.host, r.err)
+				stats.IncrementBy("elasticsearch.bulk", "shard_routing.fallback_docs", int64(req.docs))
+				r.continueNext, r.stats, r.result, r.err = joint.doBulk(ctx, tag, metadata, host, req.buffer)
+			}
+		}(i, v)
+	}
+	wg.Wait()
+
+	continueNext = true
+	statsRet = make(map[int]int)
+	errs := []string{}
+	for i, r := range results {
+		if !r.continueNext {
+			continueNext = false
+			requests[i].failed = true
+		}
+		for k, v := range r.stats {
+			statsRet[k] = statsRet[k] + v
+		}
+		if r.result != nil {
+			if bulkResult == nil {
+				bulkResult = &BulkResult{}
+			}
+			bulkResult.Merge(r.result)
+		}
+		if r.err != nil {
+			errs = append(errs, r.err.Error())
+		}
+	}
+
+	if !continueNext {
+		joint.retainFailedDocuments(buffer, docs)
+		log.Debugf("%v, %v messages of the failed routed requests are kept for retry", tag, buffer.GetMessageCount())
+	}
+
+	if len(errs) > 0 {
+		err = errors.New(strings.Join(errs, "; "))
+	}
+
+	return continueNext, statsRet, bulkResult, err
+}
+
+// Merge appends the other bulk result into this one
+func (result *BulkResult) Merge(other *BulkResult) {
+	if other == nil {
+		return
+	}
+	result.Error = result.Error || other.Error
+	result.ErrorMsgs = append(result.ErrorMsgs, other.ErrorMsgs...)
+	result.Codes = append(result.Codes, other.Codes...)
+	result.Indices = append(result.Indices, other.Indices...)
+	result.Actions = append(result.Actions, other.Actions...)
+	result.Versions = append(result.Versions, other.Versions...)
+
+	result.Summary.Failure.Count += other.Summary.Failure.Count
+	result.Summary.Failure.Size += other.Summary.Failure.Size
+	result.Summary.Invalid.Count += other.Summary.Invalid.Count
+	result.Summary.Invalid.Size += other.Summary.Invalid.Size
+	result.Summary.Success.Count += other.Summary.Success.Count
+	result.Summary.Success.Size += other.Summary.Success.Size
+
+	result.Stats.Code = mergeCodeStats(result.Stats.Code, other.Stats.Code)
+	result.Stats.Indices = mergeNameStats(result.Stats.Indices, other.Stats.Indices)
+	result.Stats.Actions = mergeNameStats(result.Stats.Actions, other.Stats.Actions)
+
+	result.Detail.Failure.Documents = append(result.Detail.Failure.Documents, other.Detail.Failure.Documents...)
+	result.Detail.Failure.Reasons = append(result.Detail.Failure.Reasons, other.Detail.Failure.Reasons...)
+	result.Detail.Invalid.Documents = append(result.Detail.Invalid.Documents, other.Detail.Invalid.Documents...)
+	result.Detail.Invalid.Reasons = append(result.Detail.Invalid.Reasons, other.Detail.Invalid.Reasons...)
+}
+
+func mergeCodeStats(to, from map[int]int) map[int]int {
+	if len(from) == 0 {
+		return to
+	}
+	if to == nil {
+		to = make(map[int]int, len(from))
+	}
+	for k, v := range from {
+		to[k] += v
+	}
+	return to
+}
+
+func mergeNameStats(to, from map[string]int) map[string]int {
+	if len(from) == 0 {
+		return to
+	}
+	if to == nil {
+		to = make(map[string]int, len(from))
+	}
+	for k, v := range from {
+		to[k] += v
+	}
+	return to
+}
@@ -0,0 +1,227 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+package elastic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRoutingMetadata() *ElasticsearchMetadata {
+	nodes := map[string]NodesInfo{}
+	for id, host := range map[string]string{"n1": "127.0.0.1:9201", "n2": "127.0.0.1:9202"} {
+		node := NodesInfo{Name: id}
+		node.Http.PublishAddress = host
+		nodes[id] = node
+	}
+
+	state := &ClusterState{RoutingTable: &ClusterRoutingTable{Indices: map[string]struct {
+		Shards map[string][]IndexShardRouting `json:"shards"`
+	}{}}}
+	state.RoutingTable.Indices["test"] = struct {
+		Shards map[string][]IndexShardRouting `json:"shards"`
+	}{Shards: map[string][]IndexShardRouting{
+		"0": {{Index: "test", Shard: 0, Primary: true, State: "STARTED", Node: "n1"}, {Index: "test", Shard: 0, State: "STARTED", Node: "n2"}},
+		"1": {{Index: "test", Shard: 1, Primary: true, State: "STARTED", Node: "n2"}},
+	}}
+	state.RoutingTable.Indices["moving"] = struct {
+		Shards map[string][]IndexShardRouting `json:"shards"`
+	}{Shards: map[string][]IndexShardRouting{
+		"0": {{Index: "moving", Shard: 0, Primary: true, State: "RELOCATING", Node: "n1", RelocatingNode: "n2"}},
+	}}
+
+	cfg := &ElasticsearchConfig{Version: "7.10.0"}
+	cfg.ID = "test"
+	return &ElasticsearchMetadata{
+		Config:       cfg,
+		ClusterState: state,
+		Nodes:        &nodes,
+	}
+}
+
+func TestShardRouterRoute(t *testing.T) {
+	router := newShardRouter(newTestRoutingMetadata(), 7)
+	router.available = func(host string) bool { return true }
+
+	hosts := []string{"127.0.0.1:9201", "127.0.0.1:9202"}
+	for _, id := range []string{"1", "2", "3", "abc", "20210811_1_61de2c1369300ff4089b70422c65ad24"} {
+		assert.Equal(t, hosts[GetShardID(7, []byte(id), 2)], router.route("test", id, ""))
+	}
+	//routing takes precedence over id
+	assert.Equal(t, hosts[GetShardID(7, []byte("user1"), 2)], router.route("test", "1", "user1"))
+
+	assert.Equal(t, "", router.route("test", "", ""))
+	assert.Equal(t, "", router.route("not_exists", "1", ""))
+	assert.Equal(t, "", router.route("moving", "1", ""))
+
+	//unavailable node falls back
+	router = newShardRouter(newTestRoutingMetadata(), 7)
+	router.available = func(host string) bool { return host != "127.0.0.1:9202" }
+	assert.Equal(t, "", router.route("test", routeToShard(t, 1), ""))
+	assert.Equal(t, "127.0.0.1:9201", router.route("test", routeToShard(t, 0), ""))
+}
+
+// routeToShard finds a key which is routed to the shard
+func routeToShard(t *testing.T, shard int) string {
+	for _, v := range []string{"1", "2", "3", "4", "5", "6", "7", "8"} {
+		if GetShardID(7, []byte(v), 2) == shard {
+			return v
+		}
+	}
+	t.Fatal("no key found")
+	return ""
+}
+
+func TestSplitByShardRouting(t *testing.T) {
+	router := newShardRouter(newTestRoutingMetadata(), 7)
+	router.available = func(host string) bool { return true }
+	processor := NewBulkProcessor("routing_test", "test", DefaultBulkProcessorConfig)
+
+	shard0, shard1 := routeToShard(t, 0), routeToShard(t, 1)
+	docs := []string{
+		"{\"index\":{\"_index\":\"test\"}}\n{\"f\":1}\n",
+		"{\"index\":{\"_index\":\"test\",\"_id\":\"" + shard0 + "\"}}\n{\"f\":2}\n",
+		"{\"delete\":{\"_index\":\"test\",\"_id\":\"" + shard1 + "\"}}\n",
+		"{\"update\":{\"_index\":\"test\",\"_id\":\"" + shard1 + "\"}}\n{\"doc\":{\"f\":3}}\n",
+		"{\"index\":{\"_index\":\"not_exists\",\"_id\":\"" + shard0 + "\"}}\n{\"f\":4}\n",
+	}
+
+	//messages of multiple documents, as written by the bulk_indexing processor
+	buffer := processor.BulkBufferPool.AcquireBulkBuffer()
+	defer processor.BulkBufferPool.ReturnBulkBuffer(buffer)
+	buffer.WriteMessageID("m1")
+	buffer.WriteByteBuffer([]byte(docs[0] + docs[1]))
+	buffer.WriteMessageID("m2")
+	buffer.WriteByteBuffer([]byte(docs[2] + docs[3] + docs[4]))
+
+	requests, routedDocs, err := processor.splitByShardRouting(router, buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(routedDocs))
+	assert.Equal(t, 3, len(requests))
+
+	counts := map[string]int{}
+	ids := map[string][]string{}
+	lines := 0
+	for _, v := range requests {
+		counts[v.host] = v.docs
+		ids[v.host] = v.buffer.MessageIDs
+		lines += len(strings.Split(strings.TrimSpace(string(v.buffer.GetMessageBytes())), "\n"))
+	}
+	assert.Equal(t, 1, counts["127.0.0.1:9201"])
+	assert.Equal(t, 2, counts["127.0.0.1:9202"])
+	assert.Equal(t, 2, counts[""])
+	assert.Equal(t, 9, lines)
+	//the original message ids are carried
+	assert.Equal(t, []string{"m1"}, ids["127.0.0.1:9201"])
+	assert.Equal(t, []string{"m2"}, ids["127.0.0.1:9202"])
+	assert.Equal(t, []string{"m1", "m2"}, ids[""])
+	assert.Equal(t, docs[2]+docs[3], string(requests[1].buffer.GetMessageBytes())+"\n")
+	//unrouted docs are always sent at last
+	assert.Equal(t, "", requests[len(requests)-1].host)
+
+	for _, v := range requests {
+		processor.BulkBufferPool.ReturnBulkBuffer(v.buffer)
+	}
+
+	//small groups are merged into the fallback request
+	processor.Config.ShardRouting.MinDocsPerNode = 2
+	requests, _, err = processor.splitByShardRouting(router, buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(requests))
+	assert.Equal(t, "127.0.0.1:9202", requests[0].host)
+	assert.Equal(t, "", requests[1].host)
+	assert.Equal(t, 3, requests[1].docs)
+	assert.Equal(t, []string{"m1", "m2"}, requests[1].buffer.MessageIDs)
+	for _, v := range requests {
+		processor.BulkBufferPool.ReturnBulkBuffer(v.buffer)
+	}
+
+	//one message id per document
+	buffer.ResetData()
+	for i, doc := range docs {
+		buffer.WriteNewByteBufferLine("meta", []byte(doc))
+		buffer.WriteMessageID(strings.Repeat("d", i+1))
+	}
+	processor.Config.ShardRouting.MinDocsPerNode = 0
+	requests, _, err = processor.splitByShardRouting(router, buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"dd"}, requests[0].buffer.MessageIDs)
+	assert.Equal(t, []string{"ddd", "dddd"}, requests[1].buffer.MessageIDs)
+	assert.Equal(t, []string{"d", "ddddd"}, requests[2].buffer.MessageIDs)
+	for _, v := range requests {
+		processor.BulkBufferPool.ReturnBulkBuffer(v.buffer)
+	}
+
+	//message ids don't match the documents
+	buffer.ResetData()
+	buffer.WriteStringBuffer(docs[0] + docs[1])
+	_, _, err = processor.splitByShardRouting(router, buffer)
+	assert.Error(t, err)
+}
+
+func TestRetainFailedDocuments(t *testing.T) {
+	router := newShardRouter(newTestRoutingMetadata(), 7)
+	router.available = func(host string) bool { return true }
+	processor := NewBulkProcessor("routing_test", "test", DefaultBulkProcessorConfig)
+
+	shard0, shard1 := routeToShard(t, 0), routeToShard(t, 1)
+	doc0 := "{\"index\":{\"_index\":\"test\",\"_id\":\"" + shard0 + "\"}}\n{\"f\":1}\n"
+	doc1 := "{\"create\":{\"_index\":\"test\",\"_id\":\"" + shard1 + "\"}}\n{\"f\":2}\n"
+	doc2 := "{\"index\":{\"_index\":\"test\"}}\n{\"f\":3}\n"
+
+	buffer := processor.BulkBufferPool.AcquireBulkBuffer()
+	defer processor.BulkBufferPool.ReturnBulkBuffer(buffer)
+	buffer.WriteMessageID("m1")
+	buffer.WriteByteBuffer([]byte(doc0 + doc1))
+	buffer.WriteMessageID("m2")
+	buffer.WriteByteBuffer([]byte(doc2))
+
+	requests, docs, err := processor.splitByShardRouting(router, buffer)
+	assert.Nil(t, err)
+	defer func() {
+		for _, v := range requests {
+			processor.BulkBufferPool.ReturnBulkBuffer(v.buffer)
+		}
+	}()
+	assert.Equal(t, 3, len(requests))
+
+	//the request to shard 1 was rejected, others succeeded
+	for _, v := range requests {
+		v.failed = v.host == "127.0.0.1:9202"
+	}
+	processor.retainFailedDocuments(buffer, docs)
+	assert.Equal(t, []string{"m1"}, buffer.MessageIDs)
+	assert.Equal(t, doc1, string(buffer.GetMessageBytes())+"\n")
+
+	//retained buffer can be split again
+	for _, v := range requests {
+		processor.BulkBufferPool.ReturnBulkBuffer(v.buffer)
+	}
+	requests, docs, err = processor.splitByShardRouting(router, buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(requests))
+	assert.Equal(t, 1, len(docs))
+	assert.Equal(t, "127.0.0.1:9202", requests[0].host)
+}
@@ -0,0 +1,276 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+package elastic
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/util"
+)
+
+// CircuitBreakerConfig trips the breaker of a host when too many requests failed,
+// requests to an open host fail fast, after the open timeout, a few trial requests
+// are allowed (half-open) to check if the host is recovered
+type CircuitBreakerConfig struct {
+	Enabled              bool    `json:"enabled,omitempty" config:"enabled"`
+	ConsecutiveFailures  int     `json:"consecutive_failures,omitempty" config:"consecutive_failures"`
+	FailureRatio         float64 `json:"failure_ratio,omitempty" config:"failure_ratio"`
+	MinRequests          int     `json:"min_requests,omitempty" config:"min_requests"`
+	Window               string  `json:"window,omitempty" config:"window"`
+	OpenTimeout          string  `json:"open_timeout,omitempty" config:"open_timeout"`
+	HalfOpenRequests     int     `json:"half_open_requests,omitempty" config:"half_open_requests"`
+	SlowRequestThreshold string  `json:"slow_request_threshold,omitempty" config:"slow_request_threshold"`
+}
+
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+type CircuitBreaker struct {
+	host   string
+	config CircuitBreakerConfig
+
+	window      time.Duration
+	openTimeout time.Duration
+	slowRequest time.Duration
+
+	lock             sync.Mutex
+	state            CircuitState
+	openedAt         time.Time
+	windowStart      time.Time
+	requests         int
+	failures         int
+	consecutive      int
+	halfOpenInFlight int
+	halfOpenSuccess  int
+	rejected         int64
+	lastFailure      time.Time
+}
+
+func NewCircuitBreaker(host string, cfg CircuitBreakerConfig) *CircuitBreaker {
+	if cfg.ConsecutiveFailures <= 0 {
+		cfg.ConsecutiveFailures = 5
+	}
+	if cfg.FailureRatio <= 0 {
+		cfg.FailureRatio = 0.5
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 20
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = 1
+	}
+	return &CircuitBreaker{
+		host:        host,
+		config:      cfg,
+		window:      util.GetDurationOrDefault(cfg.Window, 10*time.Second),
+		openTimeout: util.GetDurationOrDefault(cfg.OpenTimeout, 30*time.Second),
+		slowRequest: util.GetDurationOrDefault(cfg.SlowRequestThreshold, 0),
+		state:       CircuitClosed,
+		windowStart: time.Now(),
+	}
+}
+
+// GetHostCircuitBreaker returns the breaker of the host, nil if the breaker is not enabled
+func GetHostCircuitBreaker(host string) *CircuitBreaker {
+	if host == "" {
+		return nil
+	}
+	node, ok := GetHostAvailableInfo(util.UnifyLocalAddress(host))
+	if !ok || node == nil {
+		return nil
+	}
+	return node.GetCircuitBreaker()
+}
+
+func (node *NodeAvailable) GetCircuitBreaker() *CircuitBreaker {
+	node.configLock.RLock()
+	breaker := node.breaker
+	node.configLock.RUnlock()
+	if breaker != nil || node.ClusterID == "" {
+		return breaker
+	}
+
+	cfg := GetConfigNoPanic(node.ClusterID)
+	if cfg == nil || cfg.CircuitBreaker == nil || !cfg.CircuitBreaker.Enabled {
+		return nil
+	}
+
+	node.configLock.Lock()
+	defer node.configLock.Unlock()
+	if node.breaker == nil {
+		node.breaker = NewCircuitBreaker(node.Host, *cfg.CircuitBreaker)
+	}
+	return node.breaker
+}
+
+// State returns the current state, an open breaker turns to half-open after the timeout
+func (breaker *CircuitBreaker) State() CircuitState {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	return breaker.currentState(time.Now())
+}
+
+func (breaker *CircuitBreaker) currentState(now time.Time) CircuitState {
+	if breaker.state == CircuitOpen && now.Sub(breaker.openedAt) >= breaker.openTimeout {
+		breaker.setState(CircuitHalfOpen, now)
+	}
+	return breaker.state
+}
+
+// Ready checks if the host is able to accept requests, without any reservation
+func (breaker *CircuitBreaker) Ready() bool {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	switch breaker.currentState(time.Now()) {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		return breaker.halfOpenInFlight < breaker.config.HalfOpenRequests
+	}
+	return true
+}
+
+// Allow checks and reserves a slot for a new request, the result must be reported by Record
+func (breaker *CircuitBreaker) Allow() bool {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	switch breaker.currentState(time.Now()) {
+	case CircuitOpen:
+		breaker.rejected++
+		return false
+	case CircuitHalfOpen:
+		if breaker.halfOpenInFlight >= breaker.config.HalfOpenRequests {
+			breaker.rejected++
+			return false
+		}
+		breaker.halfOpenInFlight++
+	}
+	return true
+}
+
+// Execute reserves a slot and runs the request, the result is always recorded,
+// even if the request panics, so the half-open slot is never leaked, returns
+// false if the request was rejected by the breaker
+func (breaker *CircuitBreaker) Execute(request func() bool) bool {
+	if !breaker.Allow() {
+		return false
+	}
+	success := false
+	start := time.Now()
+	defer func() {
+		breaker.Record(success, time.Since(start))
+	}()
+	success = request()
+	return true
+}
+
+// Record reports the result of a request, slow requests are treated as failures
+func (breaker *CircuitBreaker) Record(success bool, latency time.Duration) {
+	if success && breaker.slowRequest > 0 && latency > breaker.slowRequest {
+		success = false
+	}
+
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	now := time.Now()
+	switch breaker.currentState(now) {
+	case CircuitHalfOpen:
+		if breaker.halfOpenInFlight > 0 {
+			breaker.halfOpenInFlight--
+		}
+		if !success {
+			breaker.lastFailure = now
+			breaker.setState(CircuitOpen, now)
+			return
+		}
+		breaker.halfOpenSuccess++
+		if breaker.halfOpenSuccess >= breaker.config.HalfOpenRequests {
+			breaker.setState(CircuitClosed, now)
+		}
+	case CircuitClosed:
+		if now.Sub(breaker.windowStart) > breaker.window {
+			breaker.windowStart = now
+			breaker.requests = 0
+			breaker.failures = 0
+		}
+		breaker.requests++
+		if success {
+			breaker.consecutive = 0
+			return
+		}
+		breaker.failures++
+		breaker.consecutive++
+		breaker.lastFailure = now
+		if breaker.consecutive >= breaker.config.ConsecutiveFailures ||
+			(breaker.requests >= breaker.config.MinRequests && float64(breaker.failures)/float64(breaker.requests) >= breaker.config.FailureRatio) {
+			breaker.setState(CircuitOpen, now)
+		}
+	}
+}
+
+func (breaker *CircuitBreaker) setState(state CircuitState, now time.Time) {
+	if breaker.state == state {
+		return
+	}
+	log.Infof("circuit breaker of host [%v] changed from [%v] to [%v]", breaker.host, breaker.state, state)
+	breaker.state = state
+	switch state {
+	case CircuitOpen:
+		breaker.openedAt = now
+	case CircuitHalfOpen:
+		breaker.halfOpenInFlight = 0
+		breaker.halfOpenSuccess = 0
+	case CircuitClosed:
+		breaker.windowStart = now
+		breaker.requests = 0
+		breaker.failures = 0
+		breaker.consecutive = 0
+	}
+}
+
+func (breaker *CircuitBreaker) Stats() util.MapStr {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	result := util.MapStr{
+		"state":                breaker.currentState(time.Now()),
+		"requests":             breaker.requests,
+		"failures":             breaker.failures,
+		"consecutive_failures": breaker.consecutive,
+		"rejected":             breaker.rejected,
+	}
+	if !breaker.lastFailure.IsZero() {
+		result["last_failure"] = breaker.lastFailure
+	}
+	if breaker.state == CircuitOpen {
+		result["opened_at"] = breaker.openedAt
+	}
+	return result
+}
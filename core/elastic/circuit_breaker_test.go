@@ -0,0 +1,99 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+package elastic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreakerConsecutiveFailures(t *testing.T) {
+	breaker := NewCircuitBreaker("127.0.0.1:9200", CircuitBreakerConfig{ConsecutiveFailures: 3, OpenTimeout: "50ms", HalfOpenRequests: 1})
+
+	for i := 0; i < 2; i++ {
+		assert.True(t, breaker.Allow())
+		breaker.Record(false, time.Millisecond)
+	}
+	assert.Equal(t, CircuitClosed, breaker.State())
+	breaker.Record(true, time.Millisecond)
+	breaker.Record(false, time.Millisecond)
+	assert.Equal(t, CircuitClosed, breaker.State())
+
+	breaker.Record(false, time.Millisecond)
+	breaker.Record(false, time.Millisecond)
+	assert.Equal(t, CircuitOpen, breaker.State())
+	assert.False(t, breaker.Ready())
+	assert.False(t, breaker.Allow())
+
+	//half-open after timeout, only one trial request
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+	assert.True(t, breaker.Allow())
+	assert.False(t, breaker.Allow())
+
+	//trial failed, open again
+	breaker.Record(false, time.Millisecond)
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, breaker.Allow())
+	breaker.Record(true, time.Millisecond)
+	assert.Equal(t, CircuitClosed, breaker.State())
+	assert.Equal(t, int64(2), breaker.Stats()["rejected"])
+}
+
+func TestCircuitBreakerFailureRatio(t *testing.T) {
+	breaker := NewCircuitBreaker("127.0.0.1:9200", CircuitBreakerConfig{ConsecutiveFailures: 100, FailureRatio: 0.5, MinRequests: 10, SlowRequestThreshold: "100ms"})
+
+	for i := 0; i < 8; i++ {
+		breaker.Record(i%2 == 0, time.Millisecond)
+	}
+	assert.Equal(t, CircuitClosed, breaker.State())
+
+	//slow requests are failures
+	breaker.Record(true, time.Second)
+	assert.Equal(t, CircuitClosed, breaker.State())
+	breaker.Record(false, time.Millisecond)
+	assert.Equal(t, CircuitOpen, breaker.State())
+}
+
+func TestCircuitBreakerExecutePanic(t *testing.T) {
+	breaker := NewCircuitBreaker("127.0.0.1:9200", CircuitBreakerConfig{ConsecutiveFailures: 1, OpenTimeout: "50ms", HalfOpenRequests: 1})
+	assert.True(t, breaker.Execute(func() bool { return false }))
+	assert.Equal(t, CircuitOpen, breaker.State())
+	assert.False(t, breaker.Execute(func() bool { return true }))
+
+	//the half-open slot is released by the panicked request
+	time.Sleep(60 * time.Millisecond)
+	assert.Panics(t, func() {
+		breaker.Execute(func() bool { panic("request failed") })
+	})
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, breaker.Ready())
+	assert.True(t, breaker.Execute(func() bool { return true }))
+	assert.Equal(t, CircuitClosed, breaker.State())
+}
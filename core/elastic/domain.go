@@ -245,6 +245,7 @@ type NodeAvailable struct {
 	lastCheck   time.Time
 	lastSuccess time.Time
 	configLock  sync.RWMutex
+	breaker     *CircuitBreaker
 }
 
 type IndexInfo struct {
@@ -496,6 +497,9 @@ type ElasticsearchConfig struct {
 		MaxQpsPerNode        int  `json:"max_qps_per_node,omitempty" config:"max_qps_per_node" elastic_mapping:"max_qps_per_node:{type:keyword}"`
 	} `config:"traffic_control" json:"traffic_control,omitempty" elastic_mapping:"traffic_control:{type:object}"`
 
+	CircuitBreaker *CircuitBreakerConfig `config:"circuit_breaker" json:"circuit_breaker,omitempty" elastic_mapping:"circuit_breaker:{type:object}"`
+	HedgedRequest  *HedgedRequestConfig  `config:"hedged_request" json:"hedged_request,omitempty" elastic_mapping:"hedged_request:{type:object}"`
+
 	Discovery struct {
 		Enabled bool     `json:"enabled,omitempty" config:"enabled"`
 		Modules []string `json:"module,omitempty" config:"module"`
@@ -397,6 +397,10 @@ func IsHostAvailable(host string) bool {
 		if global.Env().IsDebug {
 			log.Trace("get host info: ", info)
 		}
+		//the breaker is open, treat it as unavailable and try other hosts
+		if breaker := info.GetCircuitBreaker(); breaker != nil && !breaker.Ready() {
+			return false
+		}
 		if time.Since(info.lastCheck) < 60*time.Second {
 			return info.IsAvailable()
 		}
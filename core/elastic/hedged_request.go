@@ -0,0 +1,236 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+package elastic
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+// HedgedRequestConfig sends a second read request to another node, when the
+// first one is slower than the latency percentile, the faster one wins
+type HedgedRequestConfig struct {
+	Enabled    bool    `json:"enabled,omitempty" config:"enabled"`
+	Percentile float64 `json:"percentile,omitempty" config:"percentile"`
+	MinSamples int     `json:"min_samples,omitempty" config:"min_samples"`
+	MinDelay   string  `json:"min_delay,omitempty" config:"min_delay"`
+	MaxDelay   string  `json:"max_delay,omitempty" config:"max_delay"`
+}
+
+const latencySamples = 1024
+
+type HedgedRequester struct {
+	clusterID  string
+	percentile float64
+	minSamples int
+	minDelay   time.Duration
+	maxDelay   time.Duration
+
+	lock      sync.Mutex
+	latencies []time.Duration
+	next      int
+	updated   int
+	delay     time.Duration
+}
+
+var hedgedRequesters = sync.Map{}
+
+// GetHedgedRequester returns the shared requester of the cluster, nil if hedged request is not enabled
+func GetHedgedRequester(clusterID string, cfg *HedgedRequestConfig) *HedgedRequester {
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+	v, ok := hedgedRequesters.Load(clusterID)
+	if ok {
+		return v.(*HedgedRequester)
+	}
+	v, _ = hedgedRequesters.LoadOrStore(clusterID, NewHedgedRequester(clusterID, *cfg))
+	return v.(*HedgedRequester)
+}
+
+func NewHedgedRequester(clusterID string, cfg HedgedRequestConfig) *HedgedRequester {
+	if cfg.Percentile <= 0 || cfg.Percentile >= 100 {
+		cfg.Percentile = 95
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = 100
+	}
+	requester := &HedgedRequester{
+		clusterID:  clusterID,
+		percentile: cfg.Percentile,
+		minSamples: cfg.MinSamples,
+		minDelay:   util.GetDurationOrDefault(cfg.MinDelay, 10*time.Millisecond),
+		maxDelay:   util.GetDurationOrDefault(cfg.MaxDelay, time.Second),
+		latencies:  make([]time.Duration, 0, latencySamples),
+	}
+	requester.delay = requester.maxDelay
+	return requester
+}
+
+func (h *HedgedRequester) record(latency time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if len(h.latencies) < latencySamples {
+		h.latencies = append(h.latencies, latency)
+	} else {
+		h.latencies[h.next] = latency
+	}
+	h.next = (h.next + 1) % latencySamples
+	h.updated++
+
+	//recalculate the percentile from time to time
+	if len(h.latencies) >= h.minSamples && h.updated >= 32 {
+		h.updated = 0
+		sorted := make([]time.Duration, len(h.latencies))
+		copy(sorted, h.latencies)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		i := int(float64(len(sorted)-1) * h.percentile / 100)
+		h.delay = sorted[i]
+		if h.delay < h.minDelay {
+			h.delay = h.minDelay
+		}
+		if h.delay > h.maxDelay {
+			h.delay = h.maxDelay
+		}
+	}
+}
+
+// Delay returns how long to wait before sending the hedged request
+func (h *HedgedRequester) Delay() time.Duration {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.delay
+}
+
+type hedgedResult struct {
+	host    string
+	result  *util.Result
+	err     error
+	latency time.Duration
+}
+
+func (r *hedgedResult) ok() bool {
+	return r.err == nil && r.result != nil && r.result.StatusCode < 500
+}
+
+// Do sends the request to the first host, and to the second host if there is no response
+// after the delay, the first successful response is returned and the other one is canceled
+func (h *HedgedRequester) Do(ctx context.Context, hosts []string, do func(ctx context.Context, host string) (*util.Result, error)) (*util.Result, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("no host to send request")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	results := make(chan *hedgedResult, len(hosts))
+	cancels := make([]context.CancelFunc, 0, len(hosts))
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+
+	send := func(host string) {
+		reqCtx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		go func() {
+			start := time.Now()
+			result, err := do(reqCtx, host)
+			results <- &hedgedResult{host: host, result: result, err: err, latency: time.Since(start)}
+		}()
+	}
+
+	send(hosts[0])
+	sent := 1
+
+	timer := time.NewTimer(h.Delay())
+	defer timer.Stop()
+
+	var last *hedgedResult
+	for received := 0; received < sent; {
+		select {
+		case r := <-results:
+			received++
+			if r.ok() {
+				h.record(r.latency)
+				if r.host != hosts[0] {
+					stats.Increment("elasticsearch."+h.clusterID+".hedged_request", "won")
+				}
+				return r.result, r.err
+			}
+			last = r
+			//failed fast, try the next host immediately
+			if sent < len(hosts) {
+				send(hosts[sent])
+				sent++
+			}
+		case <-timer.C:
+			if sent < len(hosts) {
+				stats.Increment("elasticsearch."+h.clusterID+".hedged_request", "sent")
+				send(hosts[sent])
+				sent++
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return last.result, last.err
+}
+
+func (h *HedgedRequester) Stats() util.MapStr {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return util.MapStr{
+		"delay_in_ms": h.delay.Milliseconds(),
+		"samples":     len(h.latencies),
+	}
+}
+
+// GetHedgedHosts returns the active host and another available host for hedged requests
+func (meta *ElasticsearchMetadata) GetHedgedHosts() []string {
+	primary := meta.GetActiveHost()
+	hosts := []string{primary}
+
+	candidates := append([]string{}, meta.GetSeedHosts()...)
+	if meta.Config.Discovery.Enabled && meta.Nodes != nil {
+		for _, v := range *meta.Nodes {
+			if v.Http.PublishAddress != "" {
+				candidates = append(candidates, v.GetHttpPublishHost())
+			}
+		}
+	}
+	for _, v := range candidates {
+		if v != "" && v != primary && IsHostAvailable(v) {
+			return append(hosts, v)
+		}
+	}
+	return hosts
+}
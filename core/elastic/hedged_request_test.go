@@ -0,0 +1,92 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+package elastic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+)
+
+func TestHedgedRequest(t *testing.T) {
+	h := NewHedgedRequester("test", HedgedRequestConfig{MaxDelay: "20ms"})
+
+	var canceled = make(chan string, 2)
+	do := func(latency map[string]time.Duration) func(ctx context.Context, host string) (*util.Result, error) {
+		return func(ctx context.Context, host string) (*util.Result, error) {
+			select {
+			case <-time.After(latency[host]):
+				return &util.Result{StatusCode: 200, Body: []byte(host)}, nil
+			case <-ctx.Done():
+				canceled <- host
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	//the first host is fast enough
+	result, err := h.Do(nil, []string{"a", "b"}, do(map[string]time.Duration{"a": time.Millisecond, "b": time.Millisecond}))
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(result.Body))
+
+	//the first host is slow, the hedged one wins
+	result, err = h.Do(context.Background(), []string{"a", "b"}, do(map[string]time.Duration{"a": time.Second, "b": time.Millisecond}))
+	assert.Nil(t, err)
+	assert.Equal(t, "b", string(result.Body))
+	select {
+	case host := <-canceled:
+		assert.Equal(t, "a", host)
+	case <-time.After(time.Second):
+		t.Fatal("slow request was not canceled")
+	}
+
+	//failed request falls over to the next host immediately
+	start := time.Now()
+	result, err = h.Do(context.Background(), []string{"a", "b"}, func(ctx context.Context, host string) (*util.Result, error) {
+		if host == "a" {
+			return nil, errors.New("connection refused")
+		}
+		return &util.Result{StatusCode: 200, Body: []byte(host)}, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "b", string(result.Body))
+	assert.True(t, time.Since(start) < 20*time.Millisecond)
+}
+
+func TestHedgedRequestDelay(t *testing.T) {
+	h := NewHedgedRequester("test", HedgedRequestConfig{Percentile: 90, MinSamples: 10, MinDelay: "1ms", MaxDelay: "1s"})
+	assert.Equal(t, time.Second, h.Delay())
+
+	for i := 0; i < 64; i++ {
+		latency := 10 * time.Millisecond
+		if i%10 == 0 {
+			latency = 500 * time.Millisecond
+		}
+		h.record(latency)
+	}
+	assert.Equal(t, 10*time.Millisecond, h.Delay())
+}
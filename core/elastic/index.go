@@ -177,6 +177,7 @@ type IndexDocument struct {
 	Routing   string                   `json:"_routing,omitempty"`
 	Source    map[string]interface{}   `json:"_source,omitempty"`
 	Highlight map[string][]interface{} `json:"highlight,omitempty"`
+	Sort      []interface{}            `json:"sort,omitempty"`
 }
 
 type BucketBase map[string]interface{}
@@ -420,6 +421,7 @@ type BoolQuery struct {
 	Must    []interface{} `json:"must,omitempty"`
 	MustNot []interface{} `json:"must_not,omitempty"`
 	Should  []interface{} `json:"should,omitempty"`
+	Filter  []interface{} `json:"filter,omitempty"`
 }
 
 // Query is the root query object
@@ -588,6 +588,35 @@ func easyjson7411bd3fDecodeInfiniShFrameworkCoreElastic2(in *jlexer.Lexer, out *
 				}
 				in.Delim('}')
 			}
+		case "sort":
+			if in.IsNull() {
+				in.Skip()
+				out.Sort = nil
+			} else {
+				in.Delim('[')
+				if out.Sort == nil {
+					if !in.IsDelim(']') {
+						out.Sort = make([]interface{}, 0, 4)
+					} else {
+						out.Sort = []interface{}{}
+					}
+				} else {
+					out.Sort = (out.Sort)[:0]
+				}
+				for !in.IsDelim(']') {
+					var v14 interface{}
+					if m, ok := v14.(easyjson.Unmarshaler); ok {
+						m.UnmarshalEasyJSON(in)
+					} else if m, ok := v14.(json.Unmarshaler); ok {
+						_ = m.UnmarshalJSON(in.Raw())
+					} else {
+						v14 = in.Interface()
+					}
+					out.Sort = append(out.Sort, v14)
+					in.WantComma()
+				}
+				in.Delim(']')
+			}
 		default:
 			in.SkipRecursive()
 		}
@@ -709,6 +738,31 @@ func easyjson7411bd3fEncodeInfiniShFrameworkCoreElastic2(out *jwriter.Writer, in
 			out.RawByte('}')
 		}
 	}
+	if len(in.Sort) != 0 {
+		const prefix string = ",\"sort\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		{
+			out.RawByte('[')
+			for v15, v16 := range in.Sort {
+				if v15 > 0 {
+					out.RawByte(',')
+				}
+				if m, ok := v16.(easyjson.Marshaler); ok {
+					m.MarshalEasyJSON(out)
+				} else if m, ok := v16.(json.Marshaler); ok {
+					out.Raw(m.MarshalJSON())
+				} else {
+					out.Raw(json.Marshal(v16))
+				}
+			}
+			out.RawByte(']')
+		}
+	}
 	out.RawByte('}')
 }
 func easyjson7411bd3fDecode2(in *jlexer.Lexer, out *struct {
@@ -337,6 +337,10 @@ func GetDefaultSystemConfig() config.SystemConfig {
 			PanicOnConfigError:         true,
 			MaxBackupFiles:             10,
 			ValidConfigsExtensions:     []string{".tpl", ".json", ".yml", ".yaml"},
+			Rollback: config.BundleRollbackConfig{
+				Enabled:          false,
+				HealthCheckDelay: "10s",
+			},
 		},
 		HTTPClientConfig: config.HTTPClientConfig{
 			ReadBufferSize:       100 * 1024,
@@ -517,6 +521,18 @@ func GetPluginConfig(name string) *config.Config {
 	return cfg
 }
 
+// UpdateConfigSection replaces the config section with the given key, which will
+// be picked up by the next ParseConfig, used to reload a single module
+func UpdateConfigSection(configKey string, cfg *config.Config) error {
+	refreshLock.Lock()
+	defer refreshLock.Unlock()
+
+	if configObject == nil {
+		configObject = config.NewConfig()
+	}
+	return configObject.SetChild(configKey, -1, cfg)
+}
+
 // LoadConfigContents reads contents from the given path, and renders template
 // variables if necessary.
 func LoadConfigContents(path string) (contents string, err error) {
@@ -534,7 +550,11 @@ func LoadConfigContents(path string) (contents string, err error) {
 	if err != nil {
 		return
 	}
-	contents = config.NestedRenderingTemplate(contents, variables)
+	rendered, err := config.RenderTemplate(contents, variables, filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	contents = rendered
 
 	return
 }
@@ -0,0 +1,79 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package event
+
+import (
+	"sync"
+
+	log "github.com/cihub/seelog"
+)
+
+// Listener receives the events in process, it must not modify or keep a
+// reference to the event after returning
+type Listener func(event *Event)
+
+var listeners = map[string]Listener{}
+var listenersLock = sync.RWMutex{}
+
+// RegisterListener adds a named listener to all the saved or dispatched
+// events, registering the same name again replaces the previous listener
+func RegisterListener(name string, listener Listener) {
+	listenersLock.Lock()
+	defer listenersLock.Unlock()
+	listeners[name] = listener
+}
+
+func UnregisterListener(name string) {
+	listenersLock.Lock()
+	defer listenersLock.Unlock()
+	delete(listeners, name)
+}
+
+// Dispatch hands the event over to the listeners only, without queueing it
+func Dispatch(event *Event) {
+	//listeners may register or unregister listeners, so don't hold the lock
+	listenersLock.RLock()
+	names := make([]string, 0, len(listeners))
+	items := make([]Listener, 0, len(listeners))
+	for name, listener := range listeners {
+		names = append(names, name)
+		items = append(items, listener)
+	}
+	listenersLock.RUnlock()
+
+	for i, listener := range items {
+		name := names[i]
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Errorf("event listener [%v] failed: %v", name, r)
+				}
+			}()
+			listener(event)
+		}()
+	}
+}
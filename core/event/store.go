@@ -49,6 +49,11 @@ func getMeta() *AgentMeta {
 	return meta
 }
 
+// GetMeta returns the registered agent metadata, or the defaults
+func GetMeta() *AgentMeta {
+	return getMeta()
+}
+
 func UpdateAgentID(agentID string) {
 	if meta != nil {
 		meta.AgentID = agentID
@@ -65,6 +70,7 @@ func SaveWithTimestamp(event *Event, time2 time.Time) error {
 	}
 
 	event.Timestamp = time2
+	Dispatch(event)
 
 	//check event specified queue name
 	if  event.QueueName!= "" {
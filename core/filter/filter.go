@@ -57,6 +57,12 @@ type Filter interface {
 	Close() error
 }
 
+// BucketDeleter is implemented by the filters able to drop a whole bucket,
+// eg: the buckets of an expired time window
+type BucketDeleter interface {
+	DeleteBucket(bucket string) error
+}
+
 var handler Filter
 
 func getHandler() Filter {
@@ -88,7 +94,32 @@ func CheckThenAdd(bucket string, key []byte) (bool, error) {
 
 var filters map[string]Filter
 
+// GetFilter returns the filter registered with the name, or the default one
+// when the name is empty
+func GetFilter(name string) (Filter, error) {
+	if name == "" {
+		if handler == nil {
+			return nil, errors.New("filter handler is not registered")
+		}
+		return handler, nil
+	}
+	h, ok := filters[name]
+	if !ok {
+		return nil, errors.Errorf("filter [%v] is not registered", name)
+	}
+	return h, nil
+}
+
+// Register registers the filter and makes it the default one
 func Register(name string, h Filter) {
+	RegisterNamed(name, h)
+
+	handler = h
+}
+
+// RegisterNamed registers the filter without replacing the default one, it is
+// only used when the name is set explicitly, eg: the filter of dedup processor
+func RegisterNamed(name string, h Filter) {
 	if filters == nil {
 		filters = map[string]Filter{}
 	}
@@ -99,8 +130,5 @@ func Register(name string, h Filter) {
 
 	filters[name] = h
 
-	handler = h
-
 	log.Debug("register filter: ", name)
-
 }
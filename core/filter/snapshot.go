@@ -0,0 +1,253 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package filter
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/util"
+)
+
+// SnapshotBucket is a bucket of the in-memory filters, persisted by the BucketStore
+type SnapshotBucket interface {
+	// Snapshot returns the state to persist, nil if not changed since the last snapshot
+	Snapshot() (interface{}, error)
+	// Restore loads the state from the snapshot, decode works as gob.Decoder.Decode
+	Restore(decode func(v interface{}) error) error
+	// MarkDirty is called if the snapshot failed to write, so it is persisted again
+	MarkDirty()
+}
+
+// BucketStore keeps the buckets of the in-memory filters, the changed buckets are
+// persisted to the snapshot files periodically and on close, and reloaded on first use
+type BucketStore struct {
+	name      string
+	dir       string
+	ext       string
+	interval  time.Duration
+	newBucket func(bucket string) SnapshotBucket
+
+	lock    sync.RWMutex
+	buckets map[string]*storedBucket
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+type storedBucket struct {
+	SnapshotBucket
+	name string
+	file string
+	//serializes the snapshots and the deletion of the bucket
+	lock    sync.Mutex
+	deleted bool
+}
+
+// NewBucketStore creates the store, the snapshots are saved to dir with the ext, eg: `.bloom`
+func NewBucketStore(name, dir, ext string, interval time.Duration, newBucket func(bucket string) SnapshotBucket) *BucketStore {
+	return &BucketStore{
+		name:      name,
+		dir:       dir,
+		ext:       ext,
+		interval:  interval,
+		newBucket: newBucket,
+		buckets:   map[string]*storedBucket{},
+	}
+}
+
+// Open starts the periodic snapshots
+func (store *BucketStore) Open() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.quit != nil {
+		return nil
+	}
+	if !util.FileExists(store.dir) {
+		if err := os.MkdirAll(store.dir, 0755); err != nil {
+			return err
+		}
+	}
+	store.quit = make(chan struct{})
+
+	quit := store.quit
+	store.wg.Add(1)
+	go func() {
+		defer store.wg.Done()
+		ticker := time.NewTicker(store.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				store.Snapshot()
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops the periodic snapshots and persists the changed buckets
+func (store *BucketStore) Close() error {
+	store.lock.Lock()
+	if store.quit == nil {
+		store.lock.Unlock()
+		return nil
+	}
+	close(store.quit)
+	store.quit = nil
+	store.lock.Unlock()
+
+	store.wg.Wait()
+	return store.Snapshot()
+}
+
+// Snapshot persists the buckets changed since the last snapshot
+func (store *BucketStore) Snapshot() error {
+	store.lock.RLock()
+	buckets := make([]*storedBucket, 0, len(store.buckets))
+	for _, b := range store.buckets {
+		buckets = append(buckets, b)
+	}
+	store.lock.RUnlock()
+
+	var lastErr error
+	for _, b := range buckets {
+		if err := b.persist(); err != nil {
+			log.Errorf("failed to persist %v [%v]: %v", store.name, b.name, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (store *BucketStore) file(bucket string) string {
+	return path.Join(store.dir, strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(bucket)+store.ext)
+}
+
+// Get returns the bucket, it is reloaded from the snapshot or created if not exists
+func (store *BucketStore) Get(bucket string) SnapshotBucket {
+	store.lock.RLock()
+	b, ok := store.buckets[bucket]
+	store.lock.RUnlock()
+	if ok {
+		return b.SnapshotBucket
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if b, ok = store.buckets[bucket]; ok {
+		return b.SnapshotBucket
+	}
+	b = &storedBucket{name: bucket, file: store.file(bucket)}
+	if util.FileExists(b.file) {
+		if err := b.load(store.newBucket(bucket)); err != nil {
+			log.Errorf("failed to reload %v [%v], start over: %v", store.name, bucket, err)
+		} else {
+			log.Debugf("%v [%v] reloaded from %v", store.name, bucket, b.file)
+		}
+	}
+	if b.SnapshotBucket == nil {
+		b.SnapshotBucket = store.newBucket(bucket)
+	}
+	store.buckets[bucket] = b
+	return b.SnapshotBucket
+}
+
+// Delete drops the bucket and its snapshot
+func (store *BucketStore) Delete(bucket string) error {
+	store.lock.Lock()
+	b, ok := store.buckets[bucket]
+	delete(store.buckets, bucket)
+	store.lock.Unlock()
+
+	if ok {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		b.deleted = true
+	}
+	file := store.file(bucket)
+	if util.FileExists(file) {
+		return os.Remove(file)
+	}
+	return nil
+}
+
+// Range calls f for each bucket in memory
+func (store *BucketStore) Range(f func(bucket string, b SnapshotBucket)) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	for name, b := range store.buckets {
+		f(name, b.SnapshotBucket)
+	}
+}
+
+func (b *storedBucket) load(bucket SnapshotBucket) error {
+	data, err := ioutil.ReadFile(b.file)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Restore(gob.NewDecoder(bytes.NewReader(data)).Decode); err != nil {
+		return err
+	}
+	b.SnapshotBucket = bucket
+	return nil
+}
+
+func (b *storedBucket) persist() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.deleted {
+		return nil
+	}
+
+	snapshot, err := b.Snapshot()
+	if err != nil || snapshot == nil {
+		return err
+	}
+	if err = b.write(snapshot); err != nil {
+		b.MarkDirty()
+	}
+	return err
+}
+
+func (b *storedBucket) write(snapshot interface{}) error {
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		return err
+	}
+	//write to a temp file first, never leave a truncated snapshot behind
+	tmp := b.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.file)
+}
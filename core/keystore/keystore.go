@@ -148,6 +148,15 @@ func GetVariableResolver() (ucfg.Option, error){
 			}
 			v, pc, err := keystore.ResolverWrap(ks)(keyName[9:])
 			if err == ucfg.ErrMissing {
+				if r := getProviderResolver(); r != nil {
+					v, ok, err := r.Get(keyName[9:])
+					if err != nil {
+						return "", parse.NoopConfig, err
+					}
+					if ok {
+						return v, parse.NoopConfig, nil
+					}
+				}
 				return "", parse.NoopConfig, nil
 			}
 			return v, pc, err
@@ -0,0 +1,247 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package keystore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/util"
+)
+
+// SecretProvider is an external source of secrets, the keys not found in the
+// local keystore are resolved from the providers in order
+type SecretProvider interface {
+	Name() string
+	// Get returns the secret of the key, and false if the key doesn't exist
+	Get(key string) (string, bool, error)
+}
+
+// ProviderConfig is the config of a secret provider, the fields used depend on the type
+type ProviderConfig struct {
+	Type string `config:"type"` //env_file, vault, k8s_secret
+	Name string `config:"name"`
+
+	//file of env_file, directory of k8s_secret, secret path of vault
+	Path string `config:"path"`
+
+	//vault only
+	Address   string `config:"address"`
+	Token     string `config:"token"`
+	Namespace string `config:"namespace"`
+	Mount     string `config:"mount"`
+	KVVersion int    `config:"kv_version"`
+	Timeout   string `config:"timeout"`
+}
+
+// ProvidersConfig is the config section `keystore`
+type ProvidersConfig struct {
+	Providers       []ProviderConfig `config:"providers"`
+	CacheTTL        string           `config:"cache_ttl"`
+	RefreshInterval string           `config:"refresh_interval"`
+	ReloadOnChange  bool             `config:"reload_on_change"`
+}
+
+type ProviderFactory func(cfg ProviderConfig) (SecretProvider, error)
+
+var providerFactories = map[string]ProviderFactory{}
+
+// RegisterProviderFactory registers the factory of the provider type
+func RegisterProviderFactory(providerType string, factory ProviderFactory) {
+	providerFactories[providerType] = factory
+}
+
+// NewProvider creates the provider by the type in config
+func NewProvider(cfg ProviderConfig) (SecretProvider, error) {
+	factory, ok := providerFactories[cfg.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown secret provider type [%s]", cfg.Type)
+	}
+	return factory(cfg)
+}
+
+type cachedSecret struct {
+	value    string
+	provider string
+	expireAt time.Time
+}
+
+// providerResolver resolves the keys from the providers, the values are cached
+// with ttl, and refreshed in background to detect the changes
+type providerResolver struct {
+	providers []SecretProvider
+	ttl       time.Duration
+	lock      sync.RWMutex
+	cache     map[string]*cachedSecret
+	onChange  func(keys []string)
+}
+
+func newProviderResolver(providers []SecretProvider, ttl time.Duration) *providerResolver {
+	return &providerResolver{providers: providers, ttl: ttl, cache: map[string]*cachedSecret{}}
+}
+
+func (r *providerResolver) fetch(key string) (*cachedSecret, error) {
+	for _, p := range r.providers {
+		v, ok, err := p.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("secret provider [%s] error: %w", p.Name(), err)
+		}
+		if ok {
+			return &cachedSecret{value: v, provider: p.Name(), expireAt: time.Now().Add(r.ttl)}, nil
+		}
+	}
+	return nil, nil
+}
+
+// Get returns the secret of the key, the cached value is used before expired
+func (r *providerResolver) Get(key string) (string, bool, error) {
+	r.lock.RLock()
+	v, ok := r.cache[key]
+	r.lock.RUnlock()
+	if ok && time.Now().Before(v.expireAt) {
+		return v.value, true, nil
+	}
+
+	secret, err := r.fetch(key)
+	if err != nil {
+		//keep using the stale value if the provider is temporarily unavailable
+		if ok {
+			log.Warnf("failed to refresh secret [%s], use the cached value, %v", key, err)
+			return v.value, true, nil
+		}
+		return "", false, err
+	}
+	if secret == nil {
+		return "", false, nil
+	}
+	r.lock.Lock()
+	r.cache[key] = secret
+	r.lock.Unlock()
+	return secret.value, true, nil
+}
+
+// Refresh fetches all the cached keys again, and returns the keys changed
+func (r *providerResolver) Refresh() []string {
+	r.lock.RLock()
+	keys := make([]string, 0, len(r.cache))
+	for k := range r.cache {
+		keys = append(keys, k)
+	}
+	r.lock.RUnlock()
+
+	changed := []string{}
+	for _, key := range keys {
+		secret, err := r.fetch(key)
+		if err != nil {
+			log.Warnf("failed to refresh secret [%s], %v", key, err)
+			continue
+		}
+		r.lock.Lock()
+		old := r.cache[key]
+		if secret == nil {
+			delete(r.cache, key)
+		} else {
+			r.cache[key] = secret
+		}
+		r.lock.Unlock()
+
+		if secret == nil || old == nil || old.value != secret.value {
+			changed = append(changed, key)
+		}
+	}
+
+	if len(changed) > 0 && r.onChange != nil {
+		r.onChange(changed)
+	}
+	return changed
+}
+
+var (
+	resolver     *providerResolver
+	resolverLock sync.RWMutex
+)
+
+func getProviderResolver() *providerResolver {
+	resolverLock.RLock()
+	defer resolverLock.RUnlock()
+	return resolver
+}
+
+// InitProviders creates the secret providers by the config, the providers are
+// used by GetVariableResolver after the local keystore, the config of the
+// providers can refer to the local keystore, eg: token: $[[keystore.VAULT_TOKEN]]
+func InitProviders(cfg ProvidersConfig) error {
+	providers := []SecretProvider{}
+	for _, v := range cfg.Providers {
+		p, err := NewProvider(v)
+		if err != nil {
+			return err
+		}
+		providers = append(providers, p)
+	}
+
+	resolverLock.Lock()
+	defer resolverLock.Unlock()
+	if len(providers) == 0 {
+		resolver = nil
+		return nil
+	}
+	resolver = newProviderResolver(providers, util.GetDurationOrDefault(cfg.CacheTTL, 5*time.Minute))
+	if cfg.ReloadOnChange {
+		resolver.onChange = func(keys []string) {
+			log.Infof("secrets %v changed, reload config", keys)
+			err := global.Env().RefreshConfig()
+			if err != nil {
+				log.Error(err)
+			}
+		}
+	}
+	log.Debugf("initialized [%v] secret providers", len(providers))
+	return nil
+}
+
+// RefreshProviders refreshes the secrets resolved from the providers periodically
+// until the context is done, config will be reloaded if any secret changed
+func RefreshProviders(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if r := getProviderResolver(); r != nil {
+				r.Refresh()
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package keystore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvFileProvider(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secrets.env")
+	assert.Nil(t, os.WriteFile(file, []byte("# comment\nexport ES_PASSWORD=\"hello world\"\nTOKEN='abc'\nINVALID\n"), 0600))
+
+	p, err := NewProvider(ProviderConfig{Type: "env_file", Path: file})
+	assert.Nil(t, err)
+	v, ok, err := p.Get("ES_PASSWORD")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "hello world", v)
+	v, _, _ = p.Get("TOKEN")
+	assert.Equal(t, "abc", v)
+	_, ok, _ = p.Get("INVALID")
+	assert.False(t, ok)
+}
+
+func TestK8sSecretProvider(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "password"), []byte("changeme\n"), 0600))
+
+	p, err := NewProvider(ProviderConfig{Type: "k8s_secret", Path: dir})
+	assert.Nil(t, err)
+	v, ok, err := p.Get("password")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "changeme", v)
+	_, ok, _ = p.Get("missing")
+	assert.False(t, ok)
+	_, ok, _ = p.Get("../password")
+	assert.False(t, ok)
+}
+
+// a stand-in of the vault dev server, serves the kv v2 secret at secret/app
+func newTestVault(secrets map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/app" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"data":{"password":"` + secrets["password"].(string) + `","port":9200},"metadata":{"version":1}}}`))
+	}))
+}
+
+func TestVaultProvider(t *testing.T) {
+	secrets := map[string]interface{}{"password": "s3cret"}
+	server := newTestVault(secrets)
+	defer server.Close()
+
+	p, err := NewProvider(ProviderConfig{Type: "vault", Address: server.URL, Token: "root", Path: "app"})
+	assert.Nil(t, err)
+	v, ok, err := p.Get("password")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "s3cret", v)
+	v, _, _ = p.Get("port")
+	assert.Equal(t, "9200", v)
+	_, ok, err = p.Get("missing")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	p, _ = NewProvider(ProviderConfig{Type: "vault", Address: server.URL, Token: "root", Path: "other"})
+	_, ok, err = p.Get("password")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	p, _ = NewProvider(ProviderConfig{Type: "vault", Address: server.URL, Token: "invalid", Path: "app"})
+	_, _, err = p.Get("password")
+	assert.NotNil(t, err)
+}
+
+func TestProviderResolverRefresh(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "token")
+	assert.Nil(t, os.WriteFile(file, []byte("v1"), 0600))
+	p, _ := NewProvider(ProviderConfig{Type: "k8s_secret", Path: dir})
+
+	r := newProviderResolver([]SecretProvider{p}, time.Hour)
+	changes := [][]string{}
+	r.onChange = func(keys []string) {
+		changes = append(changes, keys)
+	}
+	v, ok, err := r.Get("token")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "v1", v)
+
+	//cached before expired
+	assert.Nil(t, os.WriteFile(file, []byte("v2"), 0600))
+	v, _, _ = r.Get("token")
+	assert.Equal(t, "v1", v)
+
+	assert.Equal(t, []string{"token"}, r.Refresh())
+	assert.Equal(t, [][]string{{"token"}}, changes)
+	v, _, _ = r.Get("token")
+	assert.Equal(t, "v2", v)
+
+	assert.Equal(t, 0, len(r.Refresh()))
+	assert.Equal(t, 1, len(changes))
+}
@@ -0,0 +1,244 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package keystore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"infini.sh/framework/core/util"
+)
+
+func init() {
+	RegisterProviderFactory("env_file", NewEnvFileProvider)
+	RegisterProviderFactory("k8s_secret", NewK8sSecretProvider)
+	RegisterProviderFactory("vault", NewVaultProvider)
+}
+
+func providerName(cfg ProviderConfig) string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+	return cfg.Type
+}
+
+// EnvFileProvider reads the secrets from a file of `KEY=VALUE` lines, the file
+// is parsed again when it was modified
+type EnvFileProvider struct {
+	name    string
+	path    string
+	lock    sync.Mutex
+	modTime time.Time
+	values  map[string]string
+}
+
+func NewEnvFileProvider(cfg ProviderConfig) (SecretProvider, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("path of the env file must not be empty")
+	}
+	return &EnvFileProvider{name: providerName(cfg), path: cfg.Path}, nil
+}
+
+func (p *EnvFileProvider) Name() string {
+	return p.name
+}
+
+func (p *EnvFileProvider) Get(key string) (string, bool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return "", false, err
+	}
+	if p.values == nil || !info.ModTime().Equal(p.modTime) {
+		data, err := os.ReadFile(p.path)
+		if err != nil {
+			return "", false, err
+		}
+		p.values = parseEnvFile(data)
+		p.modTime = info.ModTime()
+	}
+	v, ok := p.values[key]
+	return v, ok, nil
+}
+
+func parseEnvFile(data []byte) map[string]string {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			continue
+		}
+		key := strings.TrimSpace(line[:i])
+		value := strings.TrimSpace(line[i+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	return values
+}
+
+// K8sSecretProvider reads the secrets from a mounted kubernetes secret directory,
+// each key is a file in the directory
+type K8sSecretProvider struct {
+	name string
+	dir  string
+}
+
+func NewK8sSecretProvider(cfg ProviderConfig) (SecretProvider, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("path of the secret directory must not be empty")
+	}
+	return &K8sSecretProvider{name: providerName(cfg), dir: cfg.Path}, nil
+}
+
+func (p *K8sSecretProvider) Name() string {
+	return p.name
+}
+
+func (p *K8sSecretProvider) Get(key string) (string, bool, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || strings.HasPrefix(key, "..") {
+		return "", false, nil
+	}
+	data, err := os.ReadFile(filepath.Join(p.dir, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// VaultProvider reads the secrets from the HashiCorp Vault KV secrets engine,
+// keys are the fields of the secret at the configured path
+type VaultProvider struct {
+	name      string
+	token     string
+	namespace string
+	url       string
+	kvVersion int
+	client    *http.Client
+}
+
+func NewVaultProvider(cfg ProviderConfig) (SecretProvider, error) {
+	if cfg.Address == "" || cfg.Path == "" {
+		return nil, fmt.Errorf("address and path of vault must not be empty")
+	}
+	p := &VaultProvider{
+		name:      providerName(cfg),
+		token:     cfg.Token,
+		namespace: cfg.Namespace,
+		kvVersion: cfg.KVVersion,
+		client:    &http.Client{Timeout: util.GetDurationOrDefault(cfg.Timeout, 10*time.Second)},
+	}
+	address := strings.TrimRight(cfg.Address, "/")
+	mount := strings.Trim(cfg.Mount, "/")
+	if mount == "" {
+		mount = "secret"
+	}
+	path := strings.Trim(cfg.Path, "/")
+	switch p.kvVersion {
+	case 0, 2:
+		p.kvVersion = 2
+		p.url = fmt.Sprintf("%s/v1/%s/data/%s", address, mount, path)
+	case 1:
+		p.url = fmt.Sprintf("%s/v1/%s/%s", address, mount, path)
+	default:
+		return nil, fmt.Errorf("invalid vault kv version [%v]", cfg.KVVersion)
+	}
+	return p, nil
+}
+
+func (p *VaultProvider) Name() string {
+	return p.name
+}
+
+func (p *VaultProvider) Get(key string) (string, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, p.url, nil)
+	if err != nil {
+		return "", false, err
+	}
+	if p.token != "" {
+		req.Header.Set("X-Vault-Token", p.token)
+	}
+	if p.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.namespace)
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", false, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("vault responded with status [%v]: %s", res.StatusCode, string(body))
+	}
+
+	var ret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return "", false, err
+	}
+	data := ret.Data
+	if p.kvVersion == 2 {
+		data, _ = ret.Data["data"].(map[string]interface{})
+	}
+	v, ok := data[key]
+	if !ok || v == nil {
+		return "", false, nil
+	}
+	if str, ok := v.(string); ok {
+		return str, true, nil
+	}
+	return fmt.Sprintf("%v", v), true, nil
+}
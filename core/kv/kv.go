@@ -62,6 +62,11 @@ func getKVHandler() KVStore {
 	return handler
 }
 
+// Registered returns true if a kv store handler has been registered
+func Registered() bool {
+	return handler != nil
+}
+
 func GetValue(bucket string, key []byte) ([]byte, error) {
 	return getKVHandler().GetValue(bucket, key)
 }
@@ -35,6 +35,38 @@ type Module interface {
 	Name() string
 }
 
+// DependentModule declares the modules which must be started before this one,
+// the dependencies are referred by name, stop order is the reverse
+type DependentModule interface {
+	Dependencies() []string
+}
+
+// HealthCheckModule reports the health of a running module
+type HealthCheckModule interface {
+	Health() error
+}
+
+// ShutdownPhase groups the modules which are stopped together on shutdown,
+// phases are stopped in order, each with its own deadline
+type ShutdownPhase int
+
+const (
+	// PhaseIngress stops accepting api and ingest traffic
+	PhaseIngress ShutdownPhase = iota
+	// PhaseProcessing drains the pipelines, flushes buffers and commits offsets
+	PhaseProcessing
+	// PhaseDefault is the phase of modules without preference
+	PhaseDefault
+	// PhaseStorage stops the queues and storage, should be the last
+	PhaseStorage
+)
+
+// ShutdownAwareModule declares the phase in which the module should be stopped,
+// modules not implementing it are stopped in PhaseDefault
+type ShutdownAwareModule interface {
+	ShutdownPhase() ShutdownPhase
+}
+
 ////implement template
 //type Module struct {
 //}
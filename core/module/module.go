@@ -40,15 +40,26 @@ limitations under the License.
 package module
 
 import (
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
 	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/env"
-	"sort"
+	"infini.sh/framework/core/errors"
 )
 
 type Modules struct {
-	system  []ModuleItem
-	user    []ModuleItem
+	system  []*ModuleItem
+	user    []*ModuleItem
 	configs map[string]interface{}
+
+	//modules in the start order
+	order []*ModuleItem
+	lock  sync.Mutex
 }
 
 func (receiver *Modules) Sort() {
@@ -74,27 +85,252 @@ func (receiver *Modules) Sort() {
 var m = &Modules{}
 
 func RegisterModuleWithPriority(mod Module, priority int) {
-	m.system = append(m.system, ModuleItem{Value: mod, Priority: priority})
+	m.system = append(m.system, &ModuleItem{Value: mod, Priority: priority, state: StateRegistered})
 }
 
 func RegisterSystemModule(mod Module) {
-	m.system = append(m.system, ModuleItem{Value: mod})
+	m.system = append(m.system, &ModuleItem{Value: mod, state: StateRegistered})
 	log.Trace("system:", mod.Name(), ",", m.system)
 }
 
 func RegisterUserPlugin(mod Module) {
-	m.user = append(m.user, ModuleItem{Value: mod})
+	m.user = append(m.user, &ModuleItem{Value: mod, plugin: true, state: StateRegistered})
 	log.Trace("user:", mod.Name(), ",", m.user)
 }
 
 func RegisterPluginWithPriority(mod Module, priority int) {
-	m.user = append(m.user, ModuleItem{Value: mod, Priority: priority})
+	m.user = append(m.user, &ModuleItem{Value: mod, Priority: priority, plugin: true, state: StateRegistered})
 	log.Trace("user:", mod.Name(), ",", m.user)
 }
 
+type ModuleState string
+
+const (
+	StateRegistered ModuleState = "registered"
+	StateDisabled   ModuleState = "disabled"
+	StateSetup      ModuleState = "setup"
+	StateRunning    ModuleState = "running"
+	StateStopped    ModuleState = "stopped"
+	StateFailed     ModuleState = "failed"
+)
+
 type ModuleItem struct {
 	Value    Module
 	Priority int
+
+	plugin     bool
+	state      ModuleState
+	err        error
+	lastChange time.Time
+}
+
+func (item *ModuleItem) name() string {
+	return strings.ToLower(item.Value.Name())
+}
+
+func (item *ModuleItem) kind() string {
+	if item.plugin {
+		return "plugin"
+	}
+	return "module"
+}
+
+func (item *ModuleItem) dependencies() []string {
+	if v, ok := item.Value.(DependentModule); ok {
+		deps := []string{}
+		for _, dep := range v.Dependencies() {
+			deps = append(deps, strings.ToLower(dep))
+		}
+		return deps
+	}
+	return nil
+}
+
+func (item *ModuleItem) enabled() bool {
+	var cfg *config.Config
+	if item.plugin {
+		cfg = env.GetPluginConfig(item.Value.Name())
+	} else {
+		cfg = env.GetModuleConfig(item.Value.Name())
+	}
+	log.Trace(item.kind(), ": ", item.Value.Name(), ", enabled: ", cfg.Enabled(true))
+	return cfg.Enabled(true)
+}
+
+// sectionEnabled checks the `enabled` of the config section named after the
+// module, which may be replaced on reload
+func (item *ModuleItem) sectionEnabled() bool {
+	cfg := struct {
+		Enabled bool `config:"enabled"`
+	}{true}
+	if ok, err := env.ParseConfig(item.Value.Name(), &cfg); !ok || err != nil {
+		return true
+	}
+	return cfg.Enabled
+}
+
+func (item *ModuleItem) setState(state ModuleState, err error) {
+	item.state = state
+	item.err = err
+	item.lastChange = time.Now()
+}
+
+// call runs the lifecycle function, and turns the panic into error
+func (item *ModuleItem) call(action string, f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("error on %v %v [%v]: %v, %s", action, item.kind(), item.Value.Name(), r, debug.Stack())
+			err = errors.Errorf("failed to %v %v [%v]: %v", action, item.kind(), item.Value.Name(), r)
+		}
+	}()
+	return f()
+}
+
+func (item *ModuleItem) setup() error {
+	log.Trace("start to setup ", item.kind(), ": ", item.Value.Name())
+	err := item.call("setup", func() error {
+		item.Value.Setup()
+		return nil
+	})
+	if err != nil {
+		item.setState(StateFailed, err)
+		return err
+	}
+	item.setState(StateSetup, nil)
+	log.Debug("setup ", item.kind(), ": ", item.Value.Name())
+	return nil
+}
+
+func (item *ModuleItem) start() error {
+	log.Trace("starting ", item.kind(), ": ", item.Value.Name())
+	err := item.call("start", item.Value.Start)
+	if err != nil {
+		item.setState(StateFailed, err)
+		return err
+	}
+	item.setState(StateRunning, nil)
+	log.Info("started ", item.kind(), ": ", item.Value.Name())
+	return nil
+}
+
+func (item *ModuleItem) stop() error {
+	log.Debug("stopping ", item.kind(), ": ", item.Value.Name())
+	err := item.call("stop", item.Value.Stop)
+	if err != nil {
+		item.setState(StateFailed, err)
+		return err
+	}
+	item.setState(StateStopped, nil)
+	log.Debug("stopped ", item.kind(), ": ", item.Value.Name())
+	return nil
+}
+
+// sortByDependencies returns the modules in the order of dependencies, modules
+// without dependency between each other keep the original priority order
+func sortByDependencies(items []*ModuleItem) ([]*ModuleItem, error) {
+	index := map[string]*ModuleItem{}
+	for _, item := range items {
+		index[item.name()] = item
+	}
+
+	placed := map[string]bool{}
+	result := make([]*ModuleItem, 0, len(items))
+	for len(result) < len(items) {
+		progress := false
+		for _, item := range items {
+			if placed[item.name()] {
+				continue
+			}
+			ready := true
+			for _, dep := range item.dependencies() {
+				if _, ok := index[dep]; ok && !placed[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				placed[item.name()] = true
+				result = append(result, item)
+				progress = true
+				//restart from the beginning to keep the priority order
+				break
+			}
+		}
+		if !progress {
+			return nil, errors.Errorf("circular module dependencies: %v", findCycle(items, index, placed))
+		}
+	}
+	return result, nil
+}
+
+func findCycle(items []*ModuleItem, index map[string]*ModuleItem, placed map[string]bool) string {
+	visiting := map[string]int{}
+	var path []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		if i, ok := visiting[name]; ok {
+			return append(path[i:], name)
+		}
+		visiting[name] = len(path)
+		path = append(path, name)
+		for _, dep := range index[name].dependencies() {
+			if _, ok := index[dep]; !ok || placed[dep] {
+				continue
+			}
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		delete(visiting, name)
+		return nil
+	}
+	for _, item := range items {
+		if !placed[item.name()] {
+			if cycle := visit(item.name()); cycle != nil {
+				return strings.Join(cycle, " -> ")
+			}
+		}
+	}
+	return ""
+}
+
+func (receiver *Modules) resolveOrder() ([]*ModuleItem, error) {
+	enabled := []*ModuleItem{}
+	names := map[string]bool{}
+	for _, v := range append(append([]*ModuleItem{}, receiver.system...), receiver.user...) {
+		if v.enabled() {
+			enabled = append(enabled, v)
+			names[v.name()] = true
+		} else {
+			v.setState(StateDisabled, nil)
+		}
+	}
+
+	for _, v := range enabled {
+		for _, dep := range v.dependencies() {
+			if names[dep] {
+				continue
+			}
+			if receiver.get(dep) != nil {
+				log.Warnf("%v [%v] depends on [%v], which is not enabled", v.kind(), v.Value.Name(), dep)
+			} else {
+				log.Debugf("%v [%v] depends on [%v], which is not registered", v.kind(), v.Value.Name(), dep)
+			}
+		}
+	}
+
+	return sortByDependencies(enabled)
+}
+
+func (receiver *Modules) get(name string) *ModuleItem {
+	name = strings.ToLower(name)
+	for _, v := range append(append([]*ModuleItem{}, receiver.system...), receiver.user...) {
+		if v.name() == name {
+			return v
+		}
+	}
+	return nil
 }
 
 func checkModuleEnabled(name string) bool {
@@ -108,107 +344,266 @@ func checkModuleEnabled(name string) bool {
 
 func Start() {
 
-	m.Sort()
-
-	log.Trace("start to setup system modules")
-
-	for _, v := range m.system {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 
-		cfg := env.GetModuleConfig(v.Value.Name())
+	m.Sort()
 
-		log.Trace("module: ", v.Value.Name(), ", enabled: ", cfg.Enabled(true))
+	order, err := m.resolveOrder()
+	if err != nil {
+		panic(err)
+	}
+	m.order = order
 
-		if cfg.Enabled(true) {
-			log.Trace("start to setup module: ", v.Value.Name())
-			v.Value.Setup()
-			log.Debug("setup module: ", v.Value.Name())
+	log.Trace("start to setup modules")
+	for _, v := range m.order {
+		if err := v.setup(); err != nil {
+			panic(err)
 		}
+	}
+	log.Debug("all modules and plugins setup finished")
 
+	log.Trace("start to start modules")
+	for _, v := range m.order {
+		if err := v.start(); err != nil {
+			panic(err)
+		}
 	}
-	log.Debug("all system module setup finished")
+	log.Debug("all modules and plugins are started")
 
-	log.Trace("start to setup user plugins")
-	for _, v := range m.user {
+	log.Info("all modules are started")
+}
 
-		cfg := env.GetPluginConfig(v.Value.Name())
+// Stop stops all the running modules, see Shutdown
+func Stop() {
+	Shutdown()
+}
 
-		log.Trace("plugin: ", v.Value.Name(), ", enabled: ", cfg.Enabled(true))
+// ModuleStatus is the runtime status of a module
+type ModuleStatus struct {
+	Name         string      `json:"name"`
+	Type         string      `json:"type"`
+	Priority     int         `json:"priority"`
+	Dependencies []string    `json:"dependencies,omitempty"`
+	State        ModuleState `json:"state"`
+	Error        string      `json:"error,omitempty"`
+	Health       string      `json:"health,omitempty"`
+	HealthError  string      `json:"health_error,omitempty"`
+	Since        time.Time   `json:"since,omitempty"`
+}
 
-		if cfg.Enabled(true) {
-			log.Trace("start to setup plugin: ", v.Value.Name())
-			v.Value.Setup()
-			log.Debug("setup plugin: ", v.Value.Name())
+func (item *ModuleItem) status() ModuleStatus {
+	status := ModuleStatus{
+		Name:         item.Value.Name(),
+		Type:         item.kind(),
+		Priority:     item.Priority,
+		Dependencies: item.dependencies(),
+		State:        item.state,
+		Since:        item.lastChange,
+	}
+	if item.err != nil {
+		status.Error = item.err.Error()
+	}
+	if checker, ok := item.Value.(HealthCheckModule); ok && item.state == StateRunning {
+		err := item.call("check", checker.Health)
+		if err != nil {
+			status.Health = "red"
+			status.HealthError = err.Error()
+		} else {
+			status.Health = "green"
 		}
-
 	}
-	log.Debug("all user plugin setup finished")
+	return status
+}
+
+// GetModuleStatus returns the status of all modules, in the start order
+func GetModuleStatus() []ModuleStatus {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 
-	log.Trace("start to start system modules")
-	for _, v := range m.system {
+	result := []ModuleStatus{}
+	seen := map[*ModuleItem]bool{}
+	for _, v := range m.order {
+		seen[v] = true
+		result = append(result, v.status())
+	}
+	for _, v := range append(append([]*ModuleItem{}, m.system...), m.user...) {
+		if !seen[v] {
+			result = append(result, v.status())
+		}
+	}
+	return result
+}
 
-		cfg := env.GetModuleConfig(v.Value.Name())
+// GetModuleStatusByName returns the status of the module
+func GetModuleStatusByName(name string) (*ModuleStatus, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 
-		log.Trace("module: ", v.Value.Name(), ", enabled: ", cfg.Enabled(true))
+	item := m.get(name)
+	if item == nil {
+		return nil, errors.Errorf("module [%v] not found", name)
+	}
+	status := item.status()
+	return &status, nil
+}
 
-		if cfg.Enabled(true) {
-			log.Trace("starting module: ", v.Value.Name())
-			err := v.Value.Start()
-			if err != nil {
-				panic(err)
+// dependents returns the modules depend on the item directly or indirectly, in the start order
+func (receiver *Modules) dependents(item *ModuleItem) []*ModuleItem {
+	affected := map[string]bool{item.name(): true}
+	result := []*ModuleItem{}
+	for _, v := range receiver.order {
+		if v == item {
+			continue
+		}
+		for _, dep := range v.dependencies() {
+			if affected[dep] {
+				affected[v.name()] = true
+				result = append(result, v)
+				break
 			}
-			log.Info("started module: ", v.Value.Name())
 		}
+	}
+	return result
+}
 
+// stopWithDependents stops the module and the running modules depend on it,
+// returns the stopped dependents in the start order
+func (receiver *Modules) stopWithDependents(item *ModuleItem) ([]*ModuleItem, error) {
+	stopped := []*ModuleItem{}
+	dependents := receiver.dependents(item)
+	for i := len(dependents) - 1; i >= 0; i-- {
+		v := dependents[i]
+		if v.state != StateRunning {
+			continue
+		}
+		if err := v.stop(); err != nil {
+			return stopped, err
+		}
+		stopped = append([]*ModuleItem{v}, stopped...)
+	}
+	if item.state == StateRunning {
+		if err := item.stop(); err != nil {
+			return stopped, err
+		}
 	}
-	log.Debug("all system module are started")
+	return stopped, nil
+}
 
-	log.Trace("start to start user plugins")
-	for _, v := range m.user {
+// startWithDependencies starts the dependencies which are not running, then the module
+func (receiver *Modules) startWithDependencies(item *ModuleItem, visiting map[string]bool) error {
+	if item.state == StateRunning {
+		return nil
+	}
+	if !item.enabled() || !item.sectionEnabled() {
+		item.setState(StateDisabled, nil)
+		return errors.Errorf("%v [%v] is disabled", item.kind(), item.Value.Name())
+	}
+	if visiting[item.name()] {
+		return errors.Errorf("circular module dependencies on [%v]", item.Value.Name())
+	}
+	visiting[item.name()] = true
 
-		cfg := env.GetPluginConfig(v.Value.Name())
+	for _, dep := range item.dependencies() {
+		v := receiver.get(dep)
+		if v == nil {
+			continue
+		}
+		if err := receiver.startWithDependencies(v, visiting); err != nil {
+			return errors.Errorf("failed to start dependency [%v] of [%v]: %v", dep, item.Value.Name(), err)
+		}
+	}
 
-		log.Trace("plugin: ", v.Value.Name(), ", enabled: ", cfg.Enabled(true))
+	if item.state == StateRegistered || item.state == StateDisabled {
+		if err := item.setup(); err != nil {
+			return err
+		}
+		receiver.addToOrder(item)
+	}
+	return item.start()
+}
 
-		if cfg.Enabled(true) {
-			log.Trace("starting plugin: ", v.Value.Name())
-			err := v.Value.Start()
-			if err != nil {
-				panic(err)
-			}
-			log.Info("started plugin: ", v.Value.Name())
+// addToOrder keeps the module which was not started on boot in the stop order
+func (receiver *Modules) addToOrder(item *ModuleItem) {
+	for _, v := range receiver.order {
+		if v == item {
+			return
 		}
+	}
+	receiver.order = append(receiver.order, item)
+}
 
+// StartModule starts a stopped module, dependencies are started first, disabled
+// modules are not started
+func StartModule(name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	item := m.get(name)
+	if item == nil {
+		return errors.Errorf("module [%v] not found", name)
 	}
-	log.Debug("all user plugin are started")
+	return m.startWithDependencies(item, map[string]bool{})
+}
 
-	log.Info("all modules are started")
+// StopModule stops a running module, modules depend on it are stopped first
+func StopModule(name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	item := m.get(name)
+	if item == nil {
+		return errors.Errorf("module [%v] not found", name)
+	}
+	_, err := m.stopWithDependents(item)
+	return err
 }
 
-func Stop() {
+// ReloadModule restarts the module with the new config, the config will replace
+// the section named after the module, nil config means reload with the current one,
+// modules depend on it are restarted as well, the module is kept stopped if disabled
+func ReloadModule(name string, cfg *config.Config) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	item := m.get(name)
+	if item == nil {
+		return errors.Errorf("module [%v] not found", name)
+	}
 
-	log.Trace("start to unload user")
-	for i := len(m.user) - 1; i >= 0; i-- {
-		v := m.user[i]
-		cfg := env.GetPluginConfig(v.Value.Name())
-		if cfg.Enabled(true) {
-			log.Debug("stopping plugin: ", v.Value.Name())
-			v.Value.Stop()
-			log.Debug("stopped plugin: ", v.Value.Name())
+	stopped, err := m.stopWithDependents(item)
+	if err != nil {
+		return err
+	}
+
+	if cfg != nil {
+		if err := env.UpdateConfigSection(item.Value.Name(), cfg); err != nil {
+			return err
 		}
 	}
-	log.Debug("all user module are unloaded")
 
-	log.Trace("start to stop system module")
-	for i := len(m.system) - 1; i >= 0; i-- {
-		v := m.system[i]
-		cfg := env.GetModuleConfig(v.Value.Name())
-		if cfg.Enabled(true) {
-			log.Debug("stopping module: ", v.Value.Name())
-			v.Value.Stop()
-			log.Debug("stopped module: ", v.Value.Name())
+	if !item.enabled() || !item.sectionEnabled() {
+		item.setState(StateDisabled, nil)
+		log.Infof("%v [%v] is disabled, keep it stopped", item.kind(), item.Value.Name())
+		for _, v := range stopped {
+			log.Warnf("%v [%v] depends on [%v], which is disabled, keep it stopped", v.kind(), v.Value.Name(), item.Value.Name())
 		}
+		return nil
+	}
+
+	log.Infof("reloading %v [%v]", item.kind(), item.Value.Name())
+	if err := item.setup(); err != nil {
+		return err
+	}
+	m.addToOrder(item)
+	if err := m.startWithDependencies(item, map[string]bool{}); err != nil {
+		return err
 	}
-	log.Debug("all system module are stopped")
 
-	log.Info("all modules are stopped")
+	for _, v := range stopped {
+		if err := v.start(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,251 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+package module
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+)
+
+// modules may be stopped concurrently on shutdown
+var eventsLock sync.Mutex
+
+type testModule struct {
+	name    string
+	deps    []string
+	events  *[]string
+	failing bool
+}
+
+func (module *testModule) Setup() {}
+
+func (module *testModule) Start() error {
+	if module.failing {
+		return errors.New("failed")
+	}
+	eventsLock.Lock()
+	*module.events = append(*module.events, "start:"+module.name)
+	eventsLock.Unlock()
+	return nil
+}
+
+func (module *testModule) Stop() error {
+	eventsLock.Lock()
+	*module.events = append(*module.events, "stop:"+module.name)
+	eventsLock.Unlock()
+	return nil
+}
+
+func (module *testModule) Name() string {
+	return module.name
+}
+
+func (module *testModule) Dependencies() []string {
+	return module.deps
+}
+
+func newTestModules(events *[]string, defs ...[]string) *Modules {
+	modules := &Modules{}
+	for i, def := range defs {
+		modules.system = append(modules.system, &ModuleItem{Value: &testModule{name: def[0], deps: def[1:], events: events}, Priority: i, state: StateRegistered})
+	}
+	return modules
+}
+
+func names(items []*ModuleItem) []string {
+	result := []string{}
+	for _, v := range items {
+		result = append(result, v.Value.Name())
+	}
+	return result
+}
+
+func TestSortByDependencies(t *testing.T) {
+	events := []string{}
+	modules := newTestModules(&events, []string{"metrics", "elastic", "queue"}, []string{"api"}, []string{"queue"}, []string{"elastic", "unknown"})
+	order, err := modules.resolveOrder()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"api", "queue", "elastic", "metrics"}, names(order))
+
+	modules = newTestModules(&events, []string{"a", "c"}, []string{"b", "a"}, []string{"c", "b"}, []string{"d"})
+	_, err = modules.resolveOrder()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "a -> c -> b -> a")
+}
+
+func TestStopAndStartModule(t *testing.T) {
+	events := []string{}
+	modules := newTestModules(&events, []string{"metrics", "elastic"}, []string{"elastic", "queue"}, []string{"queue"}, []string{"api"})
+	order, err := modules.resolveOrder()
+	assert.Nil(t, err)
+	modules.order = order
+	for _, v := range modules.order {
+		assert.Nil(t, v.setup())
+		assert.Nil(t, v.start())
+	}
+	assert.Equal(t, []string{"start:queue", "start:elastic", "start:metrics", "start:api"}, events)
+
+	//dependents are stopped first
+	events = events[:0]
+	stopped, err := modules.stopWithDependents(modules.get("queue"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"elastic", "metrics"}, names(stopped))
+	assert.Equal(t, []string{"stop:metrics", "stop:elastic", "stop:queue"}, events)
+	assert.Equal(t, StateStopped, modules.get("queue").state)
+	assert.Equal(t, StateRunning, modules.get("api").state)
+
+	//dependencies are started first
+	events = events[:0]
+	assert.Nil(t, modules.startWithDependencies(modules.get("metrics"), map[string]bool{}))
+	assert.Equal(t, []string{"start:queue", "start:elastic", "start:metrics"}, events)
+
+	//start failure is recorded
+	modules.get("api").Value.(*testModule).failing = true
+	_, err = modules.stopWithDependents(modules.get("api"))
+	assert.Nil(t, err)
+	assert.NotNil(t, modules.startWithDependencies(modules.get("api"), map[string]bool{}))
+	status := modules.get("api").status()
+	assert.Equal(t, StateFailed, status.State)
+	assert.Contains(t, status.Error, "failed")
+}
+
+func TestStartAndReloadDisabledModule(t *testing.T) {
+	events := []string{}
+	modules := newTestModules(&events, []string{"disabled_test"}, []string{"disabled_dependent", "disabled_test"})
+	order, err := modules.resolveOrder()
+	assert.Nil(t, err)
+	modules.order = order
+	for _, v := range modules.order {
+		assert.Nil(t, v.setup())
+		assert.Nil(t, v.start())
+	}
+	current := m
+	m = modules
+	defer func() { m = current }()
+
+	//disabled on reload, dependents are kept stopped
+	disabled, _ := config.NewConfigFrom(map[string]interface{}{"enabled": false})
+	assert.Nil(t, ReloadModule("disabled_test", disabled))
+	assert.Equal(t, StateDisabled, modules.get("disabled_test").state)
+	assert.Equal(t, StateStopped, modules.get("disabled_dependent").state)
+
+	events = events[:0]
+	err = StartModule("disabled_test")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "disabled")
+	assert.NotNil(t, StartModule("disabled_dependent"))
+	assert.Equal(t, 0, len(events))
+
+	enabled, _ := config.NewConfigFrom(map[string]interface{}{"enabled": true})
+	assert.Nil(t, ReloadModule("disabled_test", enabled))
+	assert.Nil(t, StartModule("disabled_dependent"))
+	assert.Equal(t, []string{"start:disabled_test", "start:disabled_dependent"}, events)
+}
+
+type phasedTestModule struct {
+	testModule
+	phase ShutdownPhase
+	delay time.Duration
+	hang  chan struct{}
+}
+
+func (module *phasedTestModule) ShutdownPhase() ShutdownPhase {
+	return module.phase
+}
+
+func (module *phasedTestModule) Stop() error {
+	time.Sleep(module.delay)
+	if module.hang != nil {
+		<-module.hang
+	}
+	return module.testModule.Stop()
+}
+
+func TestShutdownInPhases(t *testing.T) {
+	events := []string{}
+	modules := &Modules{}
+	add := func(name string, phase ShutdownPhase, delay time.Duration) {
+		item := &ModuleItem{Value: &phasedTestModule{testModule: testModule{name: name, events: &events}, phase: phase, delay: delay}, state: StateRunning}
+		modules.order = append(modules.order, item)
+	}
+	add("queue", PhaseStorage, 0)
+	add("elastic", PhaseDefault, 0)
+	add("pipeline", PhaseProcessing, 0)
+	add("api", PhaseIngress, 0)
+	add("web", PhaseIngress, 0)
+
+	report := modules.shutdown(func(phase ShutdownPhase) time.Duration { return time.Second })
+	assert.Equal(t, []string{"stop:web", "stop:api", "stop:pipeline", "stop:elastic", "stop:queue"}, events)
+	assert.Equal(t, 4, len(report.Phases))
+	assert.Equal(t, "ingress", report.Phases[0].Phase)
+	assert.Equal(t, 0, len(report.Unfinished))
+
+	//slow module is reported, and the next phase is not blocked
+	events = []string{}
+	modules.order = nil
+	add("queue", PhaseStorage, 0)
+	add("elastic", PhaseDefault, 0)
+	add("pipeline", PhaseProcessing, 100*time.Millisecond)
+	report = modules.shutdown(func(phase ShutdownPhase) time.Duration {
+		if phase == PhaseStorage {
+			return time.Second
+		}
+		return 50 * time.Millisecond
+	})
+	assert.Equal(t, []string{"pipeline"}, report.Unfinished)
+	assert.Equal(t, []string{"pipeline"}, report.Phases[0].Unfinished)
+	assert.Equal(t, []string{"elastic"}, report.Phases[1].Stopped)
+	//storage waits for the slow module
+	assert.Equal(t, []string{"queue"}, report.Phases[2].Stopped)
+	assert.Equal(t, []string{"stop:elastic", "stop:pipeline", "stop:queue"}, events)
+}
+
+func TestShutdownWithHangingModule(t *testing.T) {
+	events := []string{}
+	hang := make(chan struct{})
+	defer close(hang)
+
+	modules := &Modules{}
+	for _, v := range []*phasedTestModule{
+		{testModule: testModule{name: "queue", events: &events}, phase: PhaseStorage},
+		{testModule: testModule{name: "pipeline", events: &events}, phase: PhaseProcessing, hang: hang},
+		{testModule: testModule{name: "api", events: &events}, phase: PhaseIngress},
+	} {
+		modules.order = append(modules.order, &ModuleItem{Value: v, state: StateRunning})
+	}
+
+	//storage is kept open while the pipeline is still flushing
+	report := modules.shutdown(func(phase ShutdownPhase) time.Duration { return 50 * time.Millisecond })
+	assert.Equal(t, []string{"pipeline"}, report.Unfinished)
+	assert.Equal(t, 3, len(report.Phases))
+	assert.Equal(t, "storage", report.Phases[2].Phase)
+	assert.Equal(t, []string{"queue"}, report.Phases[2].Skipped)
+	assert.Equal(t, 0, len(report.Phases[2].Stopped))
+	assert.Equal(t, []string{"stop:api"}, events)
+	assert.Equal(t, StateRunning, modules.order[0].state)
+}
@@ -0,0 +1,223 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package module
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/global"
+)
+
+var shutdownPhases = []ShutdownPhase{PhaseIngress, PhaseProcessing, PhaseDefault, PhaseStorage}
+
+func (phase ShutdownPhase) String() string {
+	switch phase {
+	case PhaseIngress:
+		return "ingress"
+	case PhaseProcessing:
+		return "processing"
+	case PhaseStorage:
+		return "storage"
+	default:
+		return "default"
+	}
+}
+
+// GetShutdownTimeout returns the deadline of the shutdown phase
+func GetShutdownTimeout(phase ShutdownPhase) time.Duration {
+	cfg := global.Env().SystemConfig.Shutdown
+	switch phase {
+	case PhaseIngress:
+		return cfg.GetIngressTimeout()
+	case PhaseProcessing:
+		return cfg.GetDrainTimeout()
+	default:
+		return cfg.GetStopTimeout()
+	}
+}
+
+func (item *ModuleItem) shutdownPhase() ShutdownPhase {
+	if v, ok := item.Value.(ShutdownAwareModule); ok {
+		return v.ShutdownPhase()
+	}
+	return PhaseDefault
+}
+
+// ShutdownPhaseReport is the result of one shutdown phase
+type ShutdownPhaseReport struct {
+	Phase      string            `json:"phase"`
+	Timeout    string            `json:"timeout"`
+	Elapsed    string            `json:"elapsed"`
+	Stopped    []string          `json:"stopped,omitempty"`
+	Failed     map[string]string `json:"failed,omitempty"`
+	Unfinished []string          `json:"unfinished,omitempty"`
+	//storage modules are kept open when the earlier phases are not finished
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// ShutdownReport is the result of the whole shutdown, modules which didn't
+// finish within the deadline of their phase are listed in Unfinished
+type ShutdownReport struct {
+	Phases     []ShutdownPhaseReport `json:"phases"`
+	Unfinished []string              `json:"unfinished,omitempty"`
+}
+
+type stopResult struct {
+	item *ModuleItem
+	err  error
+}
+
+// stopPhase stops the modules one by one in the given order, gives up waiting
+// when the deadline is reached, the pending modules keep stopping in background,
+// the returned channel is closed when all the modules are stopped
+func stopPhase(phase ShutdownPhase, items []*ModuleItem, timeout time.Duration) (ShutdownPhaseReport, <-chan struct{}) {
+	report := ShutdownPhaseReport{Phase: phase.String(), Timeout: timeout.String()}
+	start := time.Now()
+
+	done := make(chan stopResult, len(items))
+	allDone := make(chan struct{})
+	go func() {
+		defer close(allDone)
+		for _, item := range items {
+			done <- stopResult{item: item, err: item.stop()}
+		}
+	}()
+
+	finished := map[*ModuleItem]bool{}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+WAIT:
+	for len(finished) < len(items) {
+		select {
+		case ret := <-done:
+			finished[ret.item] = true
+			if ret.err != nil {
+				if report.Failed == nil {
+					report.Failed = map[string]string{}
+				}
+				report.Failed[ret.item.Value.Name()] = ret.err.Error()
+				log.Error(ret.err)
+			} else {
+				report.Stopped = append(report.Stopped, ret.item.Value.Name())
+			}
+		case <-timer.C:
+			break WAIT
+		}
+	}
+
+	for _, item := range items {
+		if !finished[item] {
+			report.Unfinished = append(report.Unfinished, item.Value.Name())
+		}
+	}
+	report.Elapsed = time.Since(start).String()
+
+	if len(report.Unfinished) > 0 {
+		log.Warnf("shutdown phase [%v] timed out after %v, unfinished: %v", report.Phase, timeout, strings.Join(report.Unfinished, ","))
+	} else {
+		log.Debugf("shutdown phase [%v] finished in %v", report.Phase, report.Elapsed)
+	}
+	return report, allDone
+}
+
+// waitPending waits for the modules of the timed out phases to finish stopping
+func waitPending(pending []<-chan struct{}, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for _, v := range pending {
+		select {
+		case <-v:
+		case <-timer.C:
+			return false
+		}
+	}
+	return true
+}
+
+// shutdown stops the running modules phase by phase, ingress first and storage
+// last, modules within the same phase are stopped in the reverse start order.
+// if modules of the earlier phases are still stopping, storage waits for them
+// within its own deadline, and is kept open if they are still not finished
+func (receiver *Modules) shutdown(timeout func(phase ShutdownPhase) time.Duration) *ShutdownReport {
+	groups := map[ShutdownPhase][]*ModuleItem{}
+	for i := len(receiver.order) - 1; i >= 0; i-- {
+		v := receiver.order[i]
+		if v.state != StateRunning {
+			continue
+		}
+		phase := v.shutdownPhase()
+		groups[phase] = append(groups[phase], v)
+	}
+
+	report := &ShutdownReport{}
+	pending := []<-chan struct{}{}
+	for _, phase := range shutdownPhases {
+		items := groups[phase]
+		if len(items) == 0 {
+			continue
+		}
+
+		if phase == PhaseStorage && len(pending) > 0 {
+			log.Infof("waiting for unfinished modules [%v] before closing storage", strings.Join(report.Unfinished, ","))
+			start := time.Now()
+			if !waitPending(pending, timeout(phase)) {
+				ret := ShutdownPhaseReport{Phase: phase.String(), Timeout: timeout(phase).String(), Elapsed: time.Since(start).String()}
+				for _, v := range items {
+					ret.Skipped = append(ret.Skipped, v.Value.Name())
+				}
+				log.Warnf("modules [%v] are still stopping, skip closing storage: %v", strings.Join(report.Unfinished, ","), strings.Join(ret.Skipped, ","))
+				report.Phases = append(report.Phases, ret)
+				continue
+			}
+		}
+
+		log.Debugf("shutdown phase [%v], modules: %v", phase, len(items))
+		ret, done := stopPhase(phase, items, timeout(phase))
+		report.Phases = append(report.Phases, ret)
+		report.Unfinished = append(report.Unfinished, ret.Unfinished...)
+		if len(ret.Unfinished) > 0 {
+			pending = append(pending, done)
+		}
+	}
+	return report
+}
+
+// Shutdown stops all the running modules in phases with deadlines, and reports
+// the modules didn't finish in time
+func Shutdown() *ShutdownReport {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	log.Trace("start to stop modules")
+	report := m.shutdown(GetShutdownTimeout)
+	if len(report.Unfinished) > 0 {
+		log.Warnf("modules not stopped in time: %v", strings.Join(report.Unfinished, ","))
+	} else {
+		log.Info("all modules are stopped")
+	}
+	return report
+}
@@ -120,6 +120,12 @@ type Query struct {
 	TemplatedQuery *TemplatedQuery
 	WildcardIndex  bool
 	IndexName      string
+
+	Aggregations []*Aggregation
+	// SearchAfter is the cursor of last page, the query is also sorted by id to keep the cursor unique
+	SearchAfter   *[]interface{}
+	IncludeFields []string
+	ExcludeFields []string
 }
 
 type TemplatedQuery struct {
@@ -156,6 +162,7 @@ type Cond struct {
 	QueryType   QueryType
 	BoolType    BoolType
 	Value       interface{}
+	Conds       []*Cond
 }
 
 type BoolType string
@@ -290,6 +297,10 @@ type Result struct {
 	Total  int64
 	Raw    []byte
 	Result []interface{}
+
+	Aggregations map[string]AggregationResult
+	// Cursor points to the last document, pass it to Query.After to fetch next page
+	Cursor []interface{}
 }
 
 func Get(o interface{}) (bool, error) {
@@ -499,6 +510,11 @@ func getHandler() ORM {
 	return handler
 }
 
+// Registered returns true if an ORM handler has been registered
+func Registered() bool {
+	return handler != nil
+}
+
 var adapters map[string]ORM
 
 func Register(name string, h ORM) {
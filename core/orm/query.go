@@ -0,0 +1,191 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package orm
+
+const Filter BoolType = "filter"
+
+const Exists QueryType = "exists"
+
+// BoolGroup is a nested bool query, the conditions inside are combined by their own BoolType
+const BoolGroup QueryType = "bool"
+
+func TermEq(field string, value interface{}) *Cond {
+	c := Cond{}
+	c.Field = field
+	c.Value = value
+	c.SQLOperator = " = "
+	c.QueryType = Term
+	c.BoolType = Must
+	return &c
+}
+
+func PrefixOf(field string, value string) *Cond {
+	c := Cond{}
+	c.Field = field
+	c.Value = value
+	c.SQLOperator = " like "
+	c.QueryType = Prefix
+	c.BoolType = Must
+	return &c
+}
+
+func WildcardOf(field string, value string) *Cond {
+	c := Cond{}
+	c.Field = field
+	c.Value = value
+	c.SQLOperator = " like "
+	c.QueryType = Wildcard
+	c.BoolType = Must
+	return &c
+}
+
+func ExistsField(field string) *Cond {
+	c := Cond{}
+	c.Field = field
+	c.SQLOperator = " is not null "
+	c.QueryType = Exists
+	c.BoolType = Must
+	return &c
+}
+
+// Group nests the conditions into a bool query, eg: a AND (b OR c)
+//
+//	orm.And(orm.Eq("a", 1), orm.Group(orm.Or(orm.Eq("b", 2), orm.Eq("c", 3))...))
+func Group(conds ...*Cond) *Cond {
+	c := Cond{}
+	c.Conds = conds
+	c.QueryType = BoolGroup
+	c.BoolType = Must
+	return &c
+}
+
+// FilterBy appends the conditions as non-scoring filters
+func FilterBy(conds ...*Cond) []*Cond {
+	t := []*Cond{}
+	for _, c := range conds {
+		c.BoolType = Filter
+		t = append(t, c)
+	}
+	return t
+}
+
+type AggregationType string
+
+const (
+	TermsAggregation         AggregationType = "terms"
+	DateHistogramAggregation AggregationType = "date_histogram"
+	StatsAggregation         AggregationType = "stats"
+	AvgAggregation           AggregationType = "avg"
+	SumAggregation           AggregationType = "sum"
+	MinAggregation           AggregationType = "min"
+	MaxAggregation           AggregationType = "max"
+	CardinalityAggregation   AggregationType = "cardinality"
+	ValueCountAggregation    AggregationType = "value_count"
+)
+
+type Aggregation struct {
+	Name  string
+	Type  AggregationType
+	Field string
+
+	// Size is the max buckets of terms aggregation
+	Size int
+	// Interval of date histogram, calendar units like 1d/1w/1M or fixed intervals like 30m/12h
+	Interval    string
+	TimeZone    string
+	MinDocCount int
+
+	Aggregations []*Aggregation
+}
+
+func (agg *Aggregation) AddAggregation(sub ...*Aggregation) *Aggregation {
+	agg.Aggregations = append(agg.Aggregations, sub...)
+	return agg
+}
+
+func TermsAgg(name, field string, size int) *Aggregation {
+	return &Aggregation{Name: name, Type: TermsAggregation, Field: field, Size: size}
+}
+
+func DateHistogramAgg(name, field, interval string) *Aggregation {
+	return &Aggregation{Name: name, Type: DateHistogramAggregation, Field: field, Interval: interval}
+}
+
+func StatsAgg(name, field string) *Aggregation {
+	return &Aggregation{Name: name, Type: StatsAggregation, Field: field}
+}
+
+// MetricAgg creates a single value metric aggregation, eg: avg, sum, min, max, cardinality and value_count
+func MetricAgg(name string, aggType AggregationType, field string) *Aggregation {
+	return &Aggregation{Name: name, Type: aggType, Field: field}
+}
+
+type AggregationResult struct {
+	// Value of single value metric aggregation
+	Value *float64 `json:"value,omitempty"`
+	// Stats of stats aggregation
+	Stats   *StatsResult        `json:"stats,omitempty"`
+	Buckets []AggregationBucket `json:"buckets,omitempty"`
+}
+
+type StatsResult struct {
+	Count int64   `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+	Sum   float64 `json:"sum"`
+}
+
+type AggregationBucket struct {
+	Key          interface{}                  `json:"key"`
+	KeyAsString  string                       `json:"key_as_string,omitempty"`
+	DocCount     int64                        `json:"doc_count"`
+	Aggregations map[string]AggregationResult `json:"aggregations,omitempty"`
+}
+
+func (q *Query) AddAggregation(aggs ...*Aggregation) *Query {
+	q.Aggregations = append(q.Aggregations, aggs...)
+	return q
+}
+
+// After enables the cursor based deep pagination, pass nil for the first page,
+// and the Result.Cursor of previous page for the next pages
+func (q *Query) After(cursor []interface{}) *Query {
+	if cursor == nil {
+		cursor = []interface{}{}
+	}
+	q.SearchAfter = &cursor
+	return q
+}
+
+// Select limits the fields returned for each document
+func (q *Query) Select(fields ...string) *Query {
+	q.IncludeFields = append(q.IncludeFields, fields...)
+	return q
+}
+
+func (q *Query) Exclude(fields ...string) *Query {
+	q.ExcludeFields = append(q.ExcludeFields, fields...)
+	return q
+}
@@ -124,6 +124,18 @@ func (para *Parameters) CloneData() util.MapStr {
 	return para.Data.Clone()
 }
 
+// CopyData returns a shallow copy of the data, the values are not cloned, and
+// the arrays are kept as they are
+func (para *Parameters) CopyData() util.MapStr {
+	para.l.RLock()
+	defer para.l.RUnlock()
+	result := make(util.MapStr, len(para.Data))
+	for k, v := range para.Data {
+		result[k] = v
+	}
+	return result
+}
+
 func (para *Parameters) ResetParameters() {
 	para.l.Lock()
 	para.Data = util.MapStr{}
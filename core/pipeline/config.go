@@ -26,6 +26,7 @@ package pipeline
 import (
 	log "github.com/cihub/seelog"
 	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/util"
 )
 
@@ -43,9 +44,66 @@ type PipelineConfigV2 struct {
 	Processors []*config.Config       `config:"processor" json:"-"`
 	Labels     map[string]interface{} `config:"labels" json:"labels"`
 
+	// Schedule triggers the pipeline periodically by interval or crontab
+	Schedule *ScheduleConfig `config:"schedule" json:"schedule,omitempty"`
+	// HistorySize is the number of recent runs retained for this pipeline
+	HistorySize int `config:"history_size" json:"history_size,omitempty"`
+
 	Transient bool `config:"-" json:"transient"`
 }
 
+const (
+	// ConcurrencySkip drops the trigger if the previous run is not finished
+	ConcurrencySkip = "skip"
+	// ConcurrencyQueue runs the trigger after the previous run finished
+	ConcurrencyQueue = "queue"
+	// ConcurrencyReplace cancels the previous run and starts a new one
+	ConcurrencyReplace = "replace"
+)
+
+type ScheduleConfig struct {
+	Enabled           *bool  `config:"enabled" json:"enabled,omitempty"`
+	Interval          string `config:"interval" json:"interval,omitempty"`
+	Crontab           string `config:"crontab" json:"crontab,omitempty"`
+	ConcurrencyPolicy string `config:"concurrency_policy" json:"concurrency_policy,omitempty"`
+	// MaxQueuedRuns limits the pending triggers for the `queue` policy
+	MaxQueuedRuns int `config:"max_queued_runs" json:"max_queued_runs,omitempty"`
+}
+
+func (this *ScheduleConfig) IsEnabled() bool {
+	if this == nil || (this.Interval == "" && this.Crontab == "") {
+		return false
+	}
+	return this.Enabled == nil || *this.Enabled
+}
+
+func (this *ScheduleConfig) GetConcurrencyPolicy() string {
+	if this == nil {
+		return ConcurrencySkip
+	}
+	switch this.ConcurrencyPolicy {
+	case ConcurrencyQueue, ConcurrencyReplace:
+		return this.ConcurrencyPolicy
+	default:
+		return ConcurrencySkip
+	}
+}
+
+func (this *ScheduleConfig) Validate() error {
+	if this == nil {
+		return nil
+	}
+	if this.Interval != "" && this.Crontab != "" {
+		return errors.New("only one of interval and crontab can be configured")
+	}
+	switch this.ConcurrencyPolicy {
+	case "", ConcurrencySkip, ConcurrencyQueue, ConcurrencyReplace:
+	default:
+		return errors.Errorf("invalid concurrency_policy: %v", this.ConcurrencyPolicy)
+	}
+	return nil
+}
+
 func (this PipelineConfigV2) Equals(target PipelineConfigV2) bool {
 
 	if util.MustToJSON(this) == util.MustToJSON(target) {
@@ -57,6 +115,8 @@ func (this PipelineConfigV2) Equals(target PipelineConfigV2) bool {
 		this.KeepRunning != target.KeepRunning ||
 		this.RetryDelayInMs != target.RetryDelayInMs ||
 		this.Logging.Enabled != target.Logging.Enabled ||
+		this.HistorySize != target.HistorySize ||
+		util.MustToJSON(this.Schedule) != util.MustToJSON(target.Schedule) ||
 		!this.ProcessorsEquals(target) {
 		return false
 	}
@@ -94,6 +94,10 @@ type Context struct {
 	id    string
 	steps int64
 
+	// trace of the current run, empty when tracing is disabled
+	traceID string
+	spanID  string
+
 	cancelFunc   context.CancelFunc
 	isPaused     bool
 	pause        sync.WaitGroup
@@ -42,11 +42,13 @@ package pipeline
 import (
 	"runtime"
 	"strings"
+	"time"
 
 	log "github.com/cihub/seelog"
 	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/stats"
 )
 
 type ProcessorBase interface {
@@ -198,6 +200,9 @@ func (procs *Processors) Release() {
 	}
 }
 
+var processorDuration = stats.NewHistogramVec("pipeline_processor_duration_ms", "Time spent in the processor in milliseconds.", nil, "pipeline", "processor")
+var processorErrors = stats.NewCounterVec("pipeline_processor_errors_total", "Errors returned by the processor.", "pipeline", "processor")
+
 func (procs *Processors) Process(ctx *Context) error {
 
 	if !procs.SkipCatchError{
@@ -240,9 +245,14 @@ func (procs *Processors) Process(ctx *Context) error {
 		log.Trace("pipeline: ",ctx.Config.Name,", start processing:",ctx.processHistory,"->",p.Name())
 
 		ctx.AddFlowProcess(p.Name())
+		start := time.Now()
+		span := ctx.startChildSpan(p.Name())
 		err := p.Process(ctx)
+		processorDuration.With(ctx.Config.Name, p.Name()).Record(time.Since(start).Milliseconds())
+		ctx.FinishSpan(span, err)
 		//event, err = p.Filter(filterCfg,ctx)
 		if err != nil {
+			processorErrors.With(ctx.Config.Name, p.Name()).Inc()
 			log.Error("error on processing:", p.Name(), ",", err)
 			return err
 		}
@@ -258,6 +258,11 @@ func RegisterProcessorPlugin(name string, constructor ProcessorConstructor) {
 	}
 }
 
+// TryRegisterProcessorPlugin registers the processor, returns error instead of panic if the name was taken
+func TryRegisterProcessorPlugin(name string, constructor ProcessorConstructor) error {
+	return registry.RegisterProcessor(name, constructor)
+}
+
 func RegisterFilterPlugin(name string, constructor FilterConstructor) {
 		err := registry.RegisterFilter(name, constructor)
 	if err != nil {
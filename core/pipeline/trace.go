@@ -0,0 +1,143 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pipeline
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Span records one timed operation of a pipeline run, the run itself is the
+// root span and every processor invoked during the run is a child span
+type Span struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Name       string
+	Pipeline   string
+	Start      time.Time
+	End        time.Time
+	Error      string
+	Attributes map[string]interface{}
+}
+
+type SpanHandler func(span *Span)
+
+var spanHandlers = map[string]SpanHandler{}
+var spanHandlersLock = sync.RWMutex{}
+
+// RegisterSpanHandler adds a named receiver for finished spans, registering
+// the same name again replaces the previous handler
+func RegisterSpanHandler(name string, handler SpanHandler) {
+	spanHandlersLock.Lock()
+	defer spanHandlersLock.Unlock()
+	spanHandlers[name] = handler
+}
+
+func UnregisterSpanHandler(name string) {
+	spanHandlersLock.Lock()
+	defer spanHandlersLock.Unlock()
+	delete(spanHandlers, name)
+}
+
+// TracingEnabled returns true when at least one span handler is registered
+func TracingEnabled() bool {
+	spanHandlersLock.RLock()
+	defer spanHandlersLock.RUnlock()
+	return len(spanHandlers) > 0
+}
+
+func emitSpan(span *Span) {
+	spanHandlersLock.RLock()
+	defer spanHandlersLock.RUnlock()
+	for _, handler := range spanHandlers {
+		handler(span)
+	}
+}
+
+// StartTrace begins a new trace for the current run of the pipeline, it
+// returns nil when tracing is disabled
+func (ctx *Context) StartTrace() *Span {
+	if !TracingEnabled() {
+		ctx.traceID = ""
+		ctx.spanID = ""
+		return nil
+	}
+	ctx.traceID = newTraceID()
+	ctx.spanID = newSpanID()
+	return &Span{
+		TraceID:  ctx.traceID,
+		SpanID:   ctx.spanID,
+		Name:     ctx.Config.Name,
+		Pipeline: ctx.Config.Name,
+		Start:    time.Now(),
+	}
+}
+
+// FinishSpan closes the span and hands it over to the registered handlers
+func (ctx *Context) FinishSpan(span *Span, err error) {
+	if span == nil {
+		return
+	}
+	span.End = time.Now()
+	if err != nil {
+		span.Error = err.Error()
+	}
+	emitSpan(span)
+}
+
+// startChildSpan starts a span for the processor under the current trace
+func (ctx *Context) startChildSpan(name string) *Span {
+	if ctx.traceID == "" {
+		return nil
+	}
+	return &Span{
+		TraceID:  ctx.traceID,
+		SpanID:   newSpanID(),
+		ParentID: ctx.spanID,
+		Name:     name,
+		Pipeline: ctx.Config.Name,
+		Start:    time.Now(),
+	}
+}
+
+func (ctx *Context) GetTraceID() string {
+	return ctx.traceID
+}
+
+func newTraceID() string {
+	return randomHex(16)
+}
+
+func newSpanID() string {
+	return randomHex(8)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
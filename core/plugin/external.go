@@ -0,0 +1,562 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"google.golang.org/grpc"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/param"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/util"
+)
+
+// ExternalPluginsConfig configures the out-of-process plugins, each sub-directory
+// of the path with a manifest file is loaded as a plugin
+type ExternalPluginsConfig struct {
+	Enabled      bool   `config:"enabled"`
+	Path         string `config:"path"`
+	StartTimeout string `config:"start_timeout"`
+	CallTimeout  string `config:"call_timeout"`
+}
+
+func (cfg ExternalPluginsConfig) GetStartTimeout() time.Duration {
+	return util.GetDurationOrDefault(cfg.StartTimeout, 10*time.Second)
+}
+
+func (cfg ExternalPluginsConfig) GetCallTimeout() time.Duration {
+	return util.GetDurationOrDefault(cfg.CallTimeout, 30*time.Second)
+}
+
+// ExternalPlugin is a running plugin process
+type ExternalPlugin struct {
+	Manifest *Manifest
+
+	cmd         *exec.Cmd
+	conn        *grpc.ClientConn
+	callTimeout time.Duration
+	exited      chan struct{}
+	exitErr     error
+}
+
+var (
+	externalPlugins     = map[string]*ExternalPlugin{}
+	externalPluginsLock sync.Mutex
+	//processors registered into the pipeline registry, by the name of the plugin,
+	//the registry can't be cleaned, they are kept across the reloads
+	externalProcessors = map[string]string{}
+)
+
+func getExternalPlugin(name string) *ExternalPlugin {
+	externalPluginsLock.Lock()
+	defer externalPluginsLock.Unlock()
+	return externalPlugins[name]
+}
+
+// LoadExternalPlugins starts the plugins found in the path and registers their processors,
+// the plugins already running are kept, so it is safe to be called again on reload
+func LoadExternalPlugins(cfg ExternalPluginsConfig) error {
+	files, err := manifestFiles(cfg.Path)
+	if err != nil {
+		return err
+	}
+
+	errs := []string{}
+	for _, manifestFile := range files {
+		if err := loadExternalPlugin(manifestFile, cfg); err != nil {
+			log.Errorf("failed to load plugin %v: %v", manifestFile, err)
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// manifestFiles returns the manifest files of the sub-directories of the path
+func manifestFiles(path string) ([]string, error) {
+	dirs, err := ioutil.ReadDir(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	files := []string{}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		manifestFile := filepath.Join(path, dir.Name(), ManifestFile)
+		if util.FileExists(manifestFile) {
+			files = append(files, manifestFile)
+		}
+	}
+	return files, nil
+}
+
+// RegisterValidationProcessors registers the processors declared by the manifests found
+// in the path without starting the plugins, the processor configs are only checked
+// against the manifests, used to validate the config files
+func RegisterValidationProcessors(cfg ExternalPluginsConfig) error {
+	files, err := manifestFiles(cfg.Path)
+	if err != nil {
+		return err
+	}
+
+	errs := []string{}
+	for _, manifestFile := range files {
+		manifest, err := LoadManifest(manifestFile)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		for i := range manifest.Processors {
+			processor := &manifest.Processors[i]
+			err := pipeline.TryRegisterProcessorPlugin(processor.Name, func(c *config.Config) (pipeline.Processor, error) {
+				cfg := map[string]interface{}{}
+				if c != nil {
+					if err := c.Unpack(&cfg); err != nil {
+						return nil, err
+					}
+				}
+				if _, err := processor.ValidateConfig(cfg); err != nil {
+					return nil, err
+				}
+				return &validationProcessor{name: processor.Name}, nil
+			})
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("plugin [%v]: %v", manifest.Name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// validationProcessor stands for the processor of a plugin not started
+type validationProcessor struct {
+	name string
+}
+
+func (processor *validationProcessor) Name() string {
+	return processor.name
+}
+
+func (processor *validationProcessor) Process(ctx *pipeline.Context) error {
+	return errors.Errorf("processor [%v] is only registered for the validation", processor.name)
+}
+
+func loadExternalPlugin(manifestFile string, cfg ExternalPluginsConfig) error {
+	manifest, err := LoadManifest(manifestFile)
+	if err != nil {
+		return err
+	}
+
+	if p := getExternalPlugin(manifest.Name); p != nil {
+		if p.Manifest.Dir != manifest.Dir {
+			return errors.Errorf("plugin [%v] was already loaded from %v", manifest.Name, p.Manifest.Dir)
+		}
+		log.Debugf("plugin [%v] was already loaded, skip", manifest.Name)
+		return nil
+	}
+
+	p, err := StartExternalPlugin(manifest, cfg.GetStartTimeout(), cfg.GetCallTimeout())
+	if err != nil {
+		return err
+	}
+	if err := p.RegisterProcessors(); err != nil {
+		p.Stop(cfg.GetCallTimeout())
+		return err
+	}
+
+	externalPluginsLock.Lock()
+	externalPlugins[manifest.Name] = p
+	externalPluginsLock.Unlock()
+	go p.supervise(cfg)
+	log.Infof("plugin [%v] %v loaded, processors: %v", manifest.Name, manifest.Version, len(manifest.Processors))
+	return nil
+}
+
+// supervise restarts the plugin if the process exited while it is still in use,
+// the processors create their instances again on the new process
+func (p *ExternalPlugin) supervise(cfg ExternalPluginsConfig) {
+	<-p.exited
+	name := p.Manifest.Name
+	backoff := time.Second
+	for {
+		//stopped or replaced
+		if getExternalPlugin(name) != p {
+			return
+		}
+		log.Errorf("plugin [%v] exited unexpectedly: %v, restarting", name, p.exitErr)
+		restarted, err := StartExternalPlugin(p.Manifest, cfg.GetStartTimeout(), cfg.GetCallTimeout())
+		if err == nil {
+			externalPluginsLock.Lock()
+			current := externalPlugins[name] == p
+			if current {
+				externalPlugins[name] = restarted
+			}
+			externalPluginsLock.Unlock()
+			if !current {
+				restarted.Stop(cfg.GetCallTimeout())
+				return
+			}
+			log.Infof("plugin [%v] restarted", name)
+			go restarted.supervise(cfg)
+			return
+		}
+		log.Errorf("failed to restart plugin [%v], retry in %v: %v", name, backoff, err)
+		time.Sleep(backoff)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// StopExternalPlugins stops all the loaded plugins
+func StopExternalPlugins(timeout time.Duration) {
+	externalPluginsLock.Lock()
+	plugins := externalPlugins
+	externalPlugins = map[string]*ExternalPlugin{}
+	externalPluginsLock.Unlock()
+
+	for _, p := range plugins {
+		p.Stop(timeout)
+	}
+}
+
+// StartExternalPlugin starts the plugin process, waits for the handshake and checks
+// the plugin with the manifest
+func StartExternalPlugin(manifest *Manifest, startTimeout, callTimeout time.Duration) (*ExternalPlugin, error) {
+	cmd := exec.Command(manifest.GetCommand(), manifest.Args...)
+	cmd.Dir = manifest.Dir
+	cmd.Env = append(os.Environ(), EnvProtocolVersion+"="+strconv.Itoa(ProtocolVersion))
+	for k, v := range manifest.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	p := &ExternalPlugin{
+		Manifest:    manifest,
+		cmd:         cmd,
+		callTimeout: callTimeout,
+		exited:      make(chan struct{}),
+	}
+
+	handshake := make(chan string, 1)
+	reader := bufio.NewReader(stdout)
+	go func() {
+		line, _ := reader.ReadString('\n')
+		handshake <- line
+		p.pipeLogs(reader)
+	}()
+	go p.pipeLogs(stderr)
+
+	var addr string
+	select {
+	case line := <-handshake:
+		addr, err = parseHandshake(line)
+	case <-time.After(startTimeout):
+		err = errors.Errorf("timeout waiting for the handshake after %v", startTimeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+
+	go func() {
+		p.exitErr = cmd.Wait()
+		close(p.exited)
+		log.Debugf("plugin [%v] exited: %v", manifest.Name, p.exitErr)
+	}()
+
+	p.conn, err = grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		p.kill()
+		return nil, err
+	}
+
+	if err := p.describe(); err != nil {
+		p.kill()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *ExternalPlugin) pipeLogs(reader io.Reader) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		log.Infof("[plugin:%v] %v", p.Manifest.Name, scanner.Text())
+	}
+}
+
+// describe checks the plugin process matches the manifest
+func (p *ExternalPlugin) describe() error {
+	resp := &DescribeResponse{}
+	if err := p.call(context.Background(), "Describe", &DescribeRequest{ProtocolVersion: ProtocolVersion}, resp); err != nil {
+		return err
+	}
+	if resp.Name != p.Manifest.Name || resp.Version != p.Manifest.Version {
+		return errors.Errorf("plugin %v %v doesn't match the manifest: %v %v", resp.Name, resp.Version, p.Manifest.Name, p.Manifest.Version)
+	}
+	if resp.ProtocolVersion != ProtocolVersion {
+		return errors.Errorf("protocol version %v is not supported, expected: %v", resp.ProtocolVersion, ProtocolVersion)
+	}
+	for _, v := range p.Manifest.Processors {
+		if !util.StringInArray(resp.Processors, v.Name) {
+			return errors.Errorf("processor [%v] is not provided by plugin [%v]", v.Name, p.Manifest.Name)
+		}
+	}
+	return nil
+}
+
+func (p *ExternalPlugin) IsRunning() bool {
+	select {
+	case <-p.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *ExternalPlugin) call(parent context.Context, method string, req, resp interface{}) error {
+	if !p.IsRunning() {
+		return errors.Errorf("plugin [%v] exited: %v", p.Manifest.Name, p.exitErr)
+	}
+	ctx, cancel := context.WithTimeout(parent, p.callTimeout)
+	defer cancel()
+	return invoke(ctx, p.conn, method, req, resp)
+}
+
+// RegisterProcessors registers the processors of the plugin into the pipeline registry,
+// the processors are created on the running process of the plugin
+func (p *ExternalPlugin) RegisterProcessors() error {
+	name := p.Manifest.Name
+	externalPluginsLock.Lock()
+	defer externalPluginsLock.Unlock()
+	for _, v := range p.Manifest.Processors {
+		processor := v.Name
+		if plugin, ok := externalProcessors[processor]; ok {
+			if plugin != name {
+				return errors.Errorf("processor [%v] was already registered by plugin [%v]", processor, plugin)
+			}
+			continue
+		}
+		err := pipeline.TryRegisterProcessorPlugin(processor, func(c *config.Config) (pipeline.Processor, error) {
+			return newExternalProcessor(name, processor, c)
+		})
+		if err != nil {
+			return err
+		}
+		externalProcessors[processor] = name
+	}
+	return nil
+}
+
+// Stop asks the plugin to shutdown, the process is killed after the timeout
+func (p *ExternalPlugin) Stop(timeout time.Duration) {
+	if p.IsRunning() {
+		if err := p.call(context.Background(), "Shutdown", &Empty{}, &Empty{}); err != nil {
+			log.Debugf("failed to shutdown plugin [%v]: %v", p.Manifest.Name, err)
+		}
+		select {
+		case <-p.exited:
+		case <-time.After(timeout):
+			log.Warnf("plugin [%v] was not stopped in %v, kill it", p.Manifest.Name, timeout)
+		}
+	}
+	p.kill()
+}
+
+func (p *ExternalPlugin) kill() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	if p.IsRunning() {
+		p.cmd.Process.Kill()
+	}
+}
+
+type externalProcessor struct {
+	pluginName string
+	name       string
+	config     map[string]interface{}
+
+	lock       sync.Mutex
+	plugin     *ExternalPlugin
+	instanceID string
+}
+
+func newExternalProcessor(pluginName, name string, c *config.Config) (pipeline.Processor, error) {
+	p := getExternalPlugin(pluginName)
+	if p == nil {
+		return nil, errors.Errorf("plugin [%v] is not loaded", pluginName)
+	}
+	manifest, ok := p.Manifest.GetProcessor(name)
+	if !ok {
+		return nil, errors.Errorf("processor [%v] is not provided by plugin [%v]", name, pluginName)
+	}
+
+	cfg := map[string]interface{}{}
+	if c != nil {
+		if err := c.Unpack(&cfg); err != nil {
+			return nil, err
+		}
+	}
+	cfg, err := manifest.ValidateConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	processor := &externalProcessor{pluginName: pluginName, name: name, config: cfg}
+	if _, _, err := processor.instance(); err != nil {
+		return nil, err
+	}
+	return processor, nil
+}
+
+// instance returns the instance on the running process of the plugin, it is
+// created again after the plugin was restarted
+func (processor *externalProcessor) instance() (*ExternalPlugin, string, error) {
+	p := getExternalPlugin(processor.pluginName)
+	if p == nil {
+		return nil, "", errors.Errorf("plugin [%v] is not running", processor.pluginName)
+	}
+
+	processor.lock.Lock()
+	defer processor.lock.Unlock()
+	if processor.plugin == p {
+		return p, processor.instanceID, nil
+	}
+	resp := &NewProcessorResponse{}
+	if err := p.call(context.Background(), "NewProcessor", &NewProcessorRequest{Processor: processor.name, Config: processor.config}, resp); err != nil {
+		return nil, "", errors.Errorf("failed to create processor [%v] of plugin [%v]: %v", processor.name, processor.pluginName, err)
+	}
+	processor.plugin = p
+	processor.instanceID = resp.InstanceID
+	return p, resp.InstanceID, nil
+}
+
+func (processor *externalProcessor) Name() string {
+	return processor.name
+}
+
+func (processor *externalProcessor) Process(ctx *pipeline.Context) error {
+	var parent context.Context = context.Background()
+	if ctx.Context != nil {
+		parent = ctx.Context
+	}
+
+	p, instanceID, err := processor.instance()
+	if err != nil {
+		return err
+	}
+
+	//values are encoded as json on the way, only copy the top-level keys
+	parameters := ctx.CopyData()
+	resp := &ProcessResponse{}
+	err = p.call(parent, "Process", &ProcessRequest{InstanceID: instanceID, Parameters: parameters}, resp)
+	if err != nil {
+		return err
+	}
+	for k, v := range resp.Parameters {
+		value, changed, err := changedValue(parameters[k], v)
+		if err != nil {
+			return errors.Errorf("invalid parameter [%v] returned by plugin [%v]: %v", k, processor.pluginName, err)
+		}
+		if changed {
+			ctx.Set(param.ParaKey(k), value)
+		}
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if resp.Finished {
+		ctx.Finished()
+	}
+	return nil
+}
+
+// changedValue compares the value returned by the plugin with the one sent, the
+// changed value is decoded into the type of the original one, eg: []queue.Message
+func changedValue(original, returned interface{}) (interface{}, bool, error) {
+	if original == nil {
+		return returned, true, nil
+	}
+	data, err := json.Marshal(returned)
+	if err != nil {
+		return nil, false, err
+	}
+	if sent, err := json.Marshal(original); err == nil && bytes.Equal(sent, data) {
+		return nil, false, nil
+	}
+	value := reflect.New(reflect.TypeOf(original))
+	if err := json.Unmarshal(data, value.Interface()); err != nil {
+		return nil, false, err
+	}
+	return value.Elem().Interface(), true, nil
+}
+
+func (processor *externalProcessor) Close() error {
+	processor.lock.Lock()
+	p, instanceID := processor.plugin, processor.instanceID
+	processor.lock.Unlock()
+	if p == nil || !p.IsRunning() {
+		return nil
+	}
+	return p.call(context.Background(), "CloseProcessor", &CloseProcessorRequest{InstanceID: instanceID}, &Empty{})
+}
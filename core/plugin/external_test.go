@@ -0,0 +1,242 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+)
+
+type setFieldProcessor struct {
+	field string
+	value string
+}
+
+func (p *setFieldProcessor) Process(ctx context.Context, parameters util.MapStr) (*ProcessResponse, error) {
+	if v, ok := parameters["fail"]; ok && v == true {
+		return nil, errors.New("failed on purpose")
+	}
+	if v, ok := parameters["crash"]; ok && v == true {
+		os.Exit(2)
+	}
+	return &ProcessResponse{Parameters: map[string]interface{}{p.field: p.value}}, nil
+}
+
+// appendMessageProcessor returns all the parameters back, with a new message
+type appendMessageProcessor struct{}
+
+func (p *appendMessageProcessor) Process(ctx context.Context, parameters util.MapStr) (*ProcessResponse, error) {
+	messages, _ := parameters["messages"].([]interface{})
+	parameters["messages"] = append(messages, map[string]interface{}{"data": []byte("appended"), "size": 8})
+	return &ProcessResponse{Parameters: parameters}, nil
+}
+
+// TestMain runs the test binary as the plugin process if required
+func TestMain(m *testing.M) {
+	if os.Getenv("TEST_EXTERNAL_PLUGIN") == "1" {
+		err := Serve("test_plugin", "1.0.0", map[string]RemoteProcessorConstructor{
+			"test_set_field": func(cfg map[string]interface{}) (RemoteProcessor, error) {
+				return &setFieldProcessor{field: cfg["field"].(string), value: cfg["value"].(string)}, nil
+			},
+			"test_append_message": func(cfg map[string]interface{}) (RemoteProcessor, error) {
+				return &appendMessageProcessor{}, nil
+			},
+		})
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func writeManifest(t *testing.T, dir string, protocolVersion int) {
+	manifest := `name: test_plugin
+version: 1.0.0
+protocol_version: ` + util.IntToString(protocolVersion) + `
+command: ` + os.Args[0] + `
+env:
+  TEST_EXTERNAL_PLUGIN: "1"
+processors:
+  - name: test_set_field
+    config_schema:
+      field:
+        type: string
+        required: true
+      value:
+        type: string
+        default: ok
+  - name: test_append_message
+`
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, ManifestFile), []byte(manifest), 0644))
+}
+
+func TestExternalPlugin(t *testing.T) {
+	dir := t.TempDir()
+	writeManifest(t, filepath.Join(dir, "test_plugin"), ProtocolVersion)
+
+	cfg := ExternalPluginsConfig{Enabled: true, Path: dir}
+	assert.NoError(t, LoadExternalPlugins(cfg))
+	defer StopExternalPlugins(5 * time.Second)
+
+	processorCfg, err := config.NewConfigFrom(map[string]interface{}{
+		"test_set_field": map[string]interface{}{"field": "status"},
+	})
+	assert.NoError(t, err)
+	procs, err := pipeline.NewPipeline([]*config.Config{processorCfg})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(procs.List))
+
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{})
+	assert.NoError(t, procs.List[0].Process(ctx))
+	assert.Equal(t, "ok", ctx.GetStringOrDefault("status", ""))
+
+	ctx.Set("fail", true)
+	assert.EqualError(t, procs.List[0].Process(ctx), "failed on purpose")
+	assert.NoError(t, pipeline.Close(procs.List[0]))
+
+	//unknown and missing fields are rejected by the schema
+	processorCfg, _ = config.NewConfigFrom(map[string]interface{}{
+		"test_set_field": map[string]interface{}{"unknown": "x"},
+	})
+	_, err = pipeline.NewPipeline([]*config.Config{processorCfg})
+	assert.Error(t, err)
+
+	//loaded only once, the processors are kept registered
+	p := getExternalPlugin("test_plugin")
+	assert.NoError(t, LoadExternalPlugins(cfg))
+	assert.Equal(t, p, getExternalPlugin("test_plugin"))
+
+	//loaded again after being stopped, eg: the module was reloaded
+	StopExternalPlugins(5 * time.Second)
+	assert.False(t, p.IsRunning())
+	assert.NoError(t, LoadExternalPlugins(cfg))
+	assert.True(t, getExternalPlugin("test_plugin").IsRunning())
+}
+
+func TestExternalPluginParameters(t *testing.T) {
+	dir := t.TempDir()
+	writeManifest(t, filepath.Join(dir, "test_plugin"), ProtocolVersion)
+	assert.NoError(t, LoadExternalPlugins(ExternalPluginsConfig{Enabled: true, Path: dir}))
+	defer StopExternalPlugins(5 * time.Second)
+
+	processorCfg, _ := config.NewConfigFrom(map[string]interface{}{"test_append_message": map[string]interface{}{}})
+	procs, err := pipeline.NewPipeline([]*config.Config{processorCfg})
+	assert.NoError(t, err)
+
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{})
+	ctx.Set("count", 10)
+	ctx.Set("messages", []queue.Message{{Data: []byte("origin"), Size: 6}})
+	assert.NoError(t, procs.List[0].Process(ctx))
+
+	//unchanged parameters are not written back
+	assert.Equal(t, 10, ctx.Get("count"))
+	//messages are decoded into the original type
+	messages, ok := ctx.Get("messages").([]queue.Message)
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "origin", string(messages[0].Data))
+	assert.Equal(t, "appended", string(messages[1].Data))
+}
+
+func TestExternalPluginRestart(t *testing.T) {
+	dir := t.TempDir()
+	writeManifest(t, filepath.Join(dir, "test_plugin"), ProtocolVersion)
+	assert.NoError(t, LoadExternalPlugins(ExternalPluginsConfig{Enabled: true, Path: dir}))
+	defer StopExternalPlugins(5 * time.Second)
+
+	processorCfg, _ := config.NewConfigFrom(map[string]interface{}{"test_set_field": map[string]interface{}{"field": "status"}})
+	procs, err := pipeline.NewPipeline([]*config.Config{processorCfg})
+	assert.NoError(t, err)
+
+	p := getExternalPlugin("test_plugin")
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{})
+	ctx.Set("crash", true)
+	assert.Error(t, procs.List[0].Process(ctx))
+
+	//the crashed plugin is restarted, the processor is created again
+	for i := 0; i < 100 && getExternalPlugin("test_plugin") == p; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.NotEqual(t, p, getExternalPlugin("test_plugin"))
+	ctx = pipeline.AcquireContext(pipeline.PipelineConfigV2{})
+	assert.NoError(t, procs.List[0].Process(ctx))
+	assert.Equal(t, "ok", ctx.GetStringOrDefault("status", ""))
+}
+
+func TestManifestProtocolVersion(t *testing.T) {
+	dir := t.TempDir()
+	writeManifest(t, dir, ProtocolVersion+1)
+	_, err := LoadManifest(filepath.Join(dir, ManifestFile))
+	assert.Error(t, err)
+
+	_, err = parseHandshake("INFINI_PLUGIN|2|127.0.0.1:1234")
+	assert.Error(t, err)
+	addr, err := parseHandshake(formatHandshake("127.0.0.1:1234") + "\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:1234", addr)
+}
+
+func TestRegisterValidationProcessors(t *testing.T) {
+	dir := t.TempDir()
+	manifest := `name: test_validation_plugin
+version: 1.0.0
+protocol_version: ` + util.IntToString(ProtocolVersion) + `
+command: not_started
+processors:
+  - name: test_validation_field
+    config_schema:
+      field:
+        type: string
+        required: true
+`
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "test_validation_plugin"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "test_validation_plugin", ManifestFile), []byte(manifest), 0644))
+	assert.NoError(t, RegisterValidationProcessors(ExternalPluginsConfig{Enabled: true, Path: dir}))
+
+	processorCfg, _ := config.NewConfigFrom(map[string]interface{}{
+		"test_validation_field": map[string]interface{}{"field": "status"},
+	})
+	procs, err := pipeline.NewPipeline([]*config.Config{processorCfg})
+	assert.NoError(t, err)
+	assert.Error(t, procs.List[0].Process(pipeline.AcquireContext(pipeline.PipelineConfigV2{})))
+
+	//the configs are still checked against the manifest
+	processorCfg, _ = config.NewConfigFrom(map[string]interface{}{
+		"test_validation_field": map[string]interface{}{},
+	})
+	_, err = pipeline.NewPipeline([]*config.Config{processorCfg})
+	assert.Error(t, err)
+}
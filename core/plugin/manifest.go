@@ -0,0 +1,198 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package plugin
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+)
+
+// ManifestFile is the manifest filename in the directory of the external plugin
+const ManifestFile = "plugin.yml"
+
+// Manifest describes the external plugin, eg:
+//
+//	name: my_plugin
+//	version: 1.0.0
+//	protocol_version: 1
+//	command: ./my_plugin
+//	processors:
+//	  - name: my_processor
+//	    config_schema:
+//	      field:
+//	        type: string
+//	        required: true
+type Manifest struct {
+	Name            string              `config:"name" json:"name"`
+	Version         string              `config:"version" json:"version"`
+	Description     string              `config:"description" json:"description,omitempty"`
+	ProtocolVersion int                 `config:"protocol_version" json:"protocol_version"`
+	Command         string              `config:"command" json:"command,omitempty"`
+	Args            []string            `config:"args" json:"args,omitempty"`
+	Env             map[string]string   `config:"env" json:"env,omitempty"`
+	Processors      []ProcessorManifest `config:"processors" json:"processors"`
+
+	// Dir is the directory of the manifest file, the command is relative to it
+	Dir string `config:"-" json:"-"`
+}
+
+type ProcessorManifest struct {
+	Name         string                    `config:"name" json:"name"`
+	Description  string                    `config:"description" json:"description,omitempty"`
+	ConfigSchema map[string]SchemaProperty `config:"config_schema" json:"config_schema,omitempty"`
+}
+
+// SchemaProperty describes a config field of the processor, type is one of
+// string, number, bool, array, object, leave it empty to accept any value
+type SchemaProperty struct {
+	Type        string      `config:"type" json:"type,omitempty"`
+	Required    bool        `config:"required" json:"required,omitempty"`
+	Default     interface{} `config:"default" json:"default,omitempty"`
+	Description string      `config:"description" json:"description,omitempty"`
+}
+
+// LoadManifest loads the manifest from the file
+func LoadManifest(path string) (*Manifest, error) {
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err := cfg.Unpack(manifest); err != nil {
+		return nil, err
+	}
+	manifest.Dir = filepath.Dir(path)
+	if err := manifest.Validate(); err != nil {
+		return nil, errors.Errorf("invalid plugin manifest %v: %v", path, err)
+	}
+	return manifest, nil
+}
+
+func (m *Manifest) Validate() error {
+	if m.Name == "" {
+		return errors.Errorf("name is required")
+	}
+	if m.Version == "" {
+		return errors.Errorf("version is required")
+	}
+	if m.ProtocolVersion != ProtocolVersion {
+		return errors.Errorf("protocol version %v is not supported, expected: %v", m.ProtocolVersion, ProtocolVersion)
+	}
+	if m.Command == "" {
+		return errors.Errorf("command is required")
+	}
+	if len(m.Processors) == 0 {
+		return errors.Errorf("no processors declared")
+	}
+	names := map[string]bool{}
+	for _, p := range m.Processors {
+		if p.Name == "" {
+			return errors.Errorf("processor name is required")
+		}
+		if names[p.Name] {
+			return errors.Errorf("duplicated processor: %v", p.Name)
+		}
+		names[p.Name] = true
+	}
+	return nil
+}
+
+// GetCommand returns the command, relative path is resolved with the manifest dir
+func (m *Manifest) GetCommand() string {
+	if filepath.IsAbs(m.Command) || !strings.ContainsAny(m.Command, "/\\") {
+		return m.Command
+	}
+	return filepath.Join(m.Dir, m.Command)
+}
+
+func (m *Manifest) GetProcessor(name string) (*ProcessorManifest, bool) {
+	for i := range m.Processors {
+		if m.Processors[i].Name == name {
+			return &m.Processors[i], true
+		}
+	}
+	return nil, false
+}
+
+// ValidateConfig checks the config with the schema, unknown fields are rejected
+// and defaults are applied, no schema means any config is accepted
+func (p *ProcessorManifest) ValidateConfig(cfg map[string]interface{}) (map[string]interface{}, error) {
+	if cfg == nil {
+		cfg = map[string]interface{}{}
+	}
+	if len(p.ConfigSchema) == 0 {
+		return cfg, nil
+	}
+
+	errs := []string{}
+	for k := range cfg {
+		if _, ok := p.ConfigSchema[k]; !ok {
+			errs = append(errs, fmt.Sprintf("unknown field [%v]", k))
+		}
+	}
+	for k, prop := range p.ConfigSchema {
+		v, ok := cfg[k]
+		if !ok || v == nil {
+			if prop.Required {
+				errs = append(errs, fmt.Sprintf("field [%v] is required", k))
+			} else if prop.Default != nil {
+				cfg[k] = prop.Default
+			}
+			continue
+		}
+		if !matchSchemaType(prop.Type, v) {
+			errs = append(errs, fmt.Sprintf("field [%v] should be %v", k, prop.Type))
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, errors.Errorf("invalid config of processor [%v]: %v", p.Name, strings.Join(errs, ", "))
+	}
+	return cfg, nil
+}
+
+func matchSchemaType(t string, v interface{}) bool {
+	kind := reflect.TypeOf(v).Kind()
+	switch t {
+	case "":
+		return true
+	case "string":
+		return kind == reflect.String
+	case "bool":
+		return kind == reflect.Bool
+	case "number":
+		return kind >= reflect.Int && kind <= reflect.Float64
+	case "array":
+		return kind == reflect.Slice || kind == reflect.Array
+	case "object":
+		return kind == reflect.Map || kind == reflect.Struct
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"infini.sh/framework/core/errors"
+)
+
+// ProtocolVersion is the version of the protocol between the host and the external
+// plugins, increased on incompatible changes, the plugin must declare the same version
+// in the manifest and the handshake
+const ProtocolVersion = 1
+
+const (
+	// handshakePrefix starts the first line the plugin writes to stdout: `INFINI_PLUGIN|<protocol_version>|<address>`
+	handshakePrefix = "INFINI_PLUGIN"
+	// EnvProtocolVersion is passed to the plugin process with the protocol version of the host
+	EnvProtocolVersion = "INFINI_PLUGIN_PROTOCOL_VERSION"
+
+	serviceName = "infini.plugin.v1.Plugin"
+	codecName   = "infini-plugin-json"
+)
+
+func formatHandshake(addr string) string {
+	return fmt.Sprintf("%s|%d|%s", handshakePrefix, ProtocolVersion, addr)
+}
+
+// parseHandshake returns the address of the plugin, the protocol version must match
+func parseHandshake(line string) (string, error) {
+	parts := strings.Split(strings.TrimSpace(line), "|")
+	if len(parts) != 3 || parts[0] != handshakePrefix {
+		return "", errors.Errorf("invalid handshake: %v", line)
+	}
+	version, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", errors.Errorf("invalid handshake: %v", line)
+	}
+	if version != ProtocolVersion {
+		return "", errors.Errorf("protocol version %v is not supported, expected: %v", version, ProtocolVersion)
+	}
+	return parts[2], nil
+}
+
+type DescribeRequest struct {
+	ProtocolVersion int `json:"protocol_version"`
+}
+
+type DescribeResponse struct {
+	Name            string   `json:"name"`
+	Version         string   `json:"version"`
+	ProtocolVersion int      `json:"protocol_version"`
+	Processors      []string `json:"processors"`
+}
+
+type NewProcessorRequest struct {
+	Processor string                 `json:"processor"`
+	Config    map[string]interface{} `json:"config,omitempty"`
+}
+
+type NewProcessorResponse struct {
+	InstanceID string `json:"instance_id"`
+}
+
+// ProcessRequest carries the parameters of the pipeline context
+type ProcessRequest struct {
+	InstanceID string                 `json:"instance_id"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// ProcessResponse carries the parameters to update in the pipeline context, the
+// unchanged ones are skipped and the changed ones are decoded into the type of
+// the current value, Error fails the process, Finished stops the remaining processors
+type ProcessResponse struct {
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Finished   bool                   `json:"finished,omitempty"`
+}
+
+type CloseProcessorRequest struct {
+	InstanceID string `json:"instance_id"`
+}
+
+type Empty struct{}
+
+// PluginService is implemented by the plugin process, see Serve
+type PluginService interface {
+	Describe(ctx context.Context, req *DescribeRequest) (*DescribeResponse, error)
+	NewProcessor(ctx context.Context, req *NewProcessorRequest) (*NewProcessorResponse, error)
+	Process(ctx context.Context, req *ProcessRequest) (*ProcessResponse, error)
+	CloseProcessor(ctx context.Context, req *CloseProcessorRequest) (*Empty, error)
+	Shutdown(ctx context.Context, req *Empty) (*Empty, error)
+}
+
+// jsonCodec encodes the messages as json, so no generated protobuf code is needed
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return codecName
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+func unaryHandler(method string, newRequest func() interface{}, call func(srv PluginService, ctx context.Context, req interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newRequest()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(PluginService), ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/" + method}
+			return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(PluginService), ctx, req)
+			})
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*PluginService)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler("Describe", func() interface{} { return &DescribeRequest{} }, func(srv PluginService, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.Describe(ctx, req.(*DescribeRequest))
+		}),
+		unaryHandler("NewProcessor", func() interface{} { return &NewProcessorRequest{} }, func(srv PluginService, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.NewProcessor(ctx, req.(*NewProcessorRequest))
+		}),
+		unaryHandler("Process", func() interface{} { return &ProcessRequest{} }, func(srv PluginService, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.Process(ctx, req.(*ProcessRequest))
+		}),
+		unaryHandler("CloseProcessor", func() interface{} { return &CloseProcessorRequest{} }, func(srv PluginService, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.CloseProcessor(ctx, req.(*CloseProcessorRequest))
+		}),
+		unaryHandler("Shutdown", func() interface{} { return &Empty{} }, func(srv PluginService, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.Shutdown(ctx, req.(*Empty))
+		}),
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// invoke calls the method of the plugin with the json codec
+func invoke(ctx context.Context, conn *grpc.ClientConn, method string, req, resp interface{}) error {
+	return conn.Invoke(ctx, "/"+serviceName+"/"+method, req, resp, grpc.CallContentSubtype(codecName))
+}
@@ -0,0 +1,156 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/rpc"
+	"infini.sh/framework/core/util"
+)
+
+// RemoteProcessor is the processor runs in the plugin process, the parameters
+// are the copy of the pipeline context
+type RemoteProcessor interface {
+	Process(ctx context.Context, parameters util.MapStr) (*ProcessResponse, error)
+}
+
+// RemoteProcessorConstructor creates the processor with the config validated by the schema in the manifest
+type RemoteProcessorConstructor func(cfg map[string]interface{}) (RemoteProcessor, error)
+
+type pluginServer struct {
+	name       string
+	version    string
+	processors map[string]RemoteProcessorConstructor
+
+	lock      sync.RWMutex
+	instances map[string]RemoteProcessor
+	shutdown  func()
+}
+
+func (s *pluginServer) Describe(ctx context.Context, req *DescribeRequest) (*DescribeResponse, error) {
+	names := make([]string, 0, len(s.processors))
+	for k := range s.processors {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return &DescribeResponse{Name: s.name, Version: s.version, ProtocolVersion: ProtocolVersion, Processors: names}, nil
+}
+
+func (s *pluginServer) NewProcessor(ctx context.Context, req *NewProcessorRequest) (*NewProcessorResponse, error) {
+	constructor, ok := s.processors[req.Processor]
+	if !ok {
+		return nil, errors.Errorf("processor [%v] not found", req.Processor)
+	}
+	p, err := constructor(req.Config)
+	if err != nil {
+		return nil, err
+	}
+	id := util.GetUUID()
+	s.lock.Lock()
+	s.instances[id] = p
+	s.lock.Unlock()
+	return &NewProcessorResponse{InstanceID: id}, nil
+}
+
+func (s *pluginServer) getInstance(id string) (RemoteProcessor, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	p, ok := s.instances[id]
+	if !ok {
+		return nil, errors.Errorf("processor instance [%v] not found", id)
+	}
+	return p, nil
+}
+
+func (s *pluginServer) Process(ctx context.Context, req *ProcessRequest) (*ProcessResponse, error) {
+	p, err := s.getInstance(req.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.Process(ctx, req.Parameters)
+	if resp == nil {
+		resp = &ProcessResponse{}
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp, nil
+}
+
+func (s *pluginServer) CloseProcessor(ctx context.Context, req *CloseProcessorRequest) (*Empty, error) {
+	s.lock.Lock()
+	p, ok := s.instances[req.InstanceID]
+	delete(s.instances, req.InstanceID)
+	s.lock.Unlock()
+	if ok {
+		if closer, ok := p.(interface{ Close() error }); ok {
+			if err := closer.Close(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Empty{}, nil
+}
+
+func (s *pluginServer) Shutdown(ctx context.Context, req *Empty) (*Empty, error) {
+	go s.shutdown()
+	return &Empty{}, nil
+}
+
+// Serve runs in the main function of the plugin process, serves the processors
+// over grpc until the host shutdown the plugin, the stdout is reserved for the
+// handshake, logs should be written to stderr
+func Serve(name, version string, processors map[string]RemoteProcessorConstructor) error {
+	if v := os.Getenv(EnvProtocolVersion); v != "" && v != strconv.Itoa(ProtocolVersion) {
+		return errors.Errorf("protocol version %v of the host is not supported, expected: %v", v, ProtocolVersion)
+	}
+
+	rpc.Setup(&config.RPCConfig{})
+	server := rpc.GetRPCServer()
+
+	impl := &pluginServer{
+		name:       name,
+		version:    version,
+		processors: processors,
+		instances:  map[string]RemoteProcessor{},
+		shutdown:   server.GracefulStop,
+	}
+	server.RegisterService(&serviceDesc, impl)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, formatHandshake(listener.Addr().String()))
+	return server.Serve(listener)
+}
@@ -33,6 +33,7 @@ import (
 	log "github.com/cihub/seelog"
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/stats"
 	"infini.sh/framework/core/task"
 	"infini.sh/framework/core/util"
 	"sync"
@@ -153,6 +154,7 @@ func RemoveConfig(cfg *QueueConfig) bool {
 	}
 	idConfigs.Delete(cfg.ID)
 	configs.Delete(cfg.Name)
+	stats.DeleteSeries("queue", cfg.ID)
 	return true
 }
 
@@ -35,6 +35,10 @@ import (
 )
 
 
+var queuePushed = stats.NewCounterVec("queue_push_total", "Messages pushed to the queue.", "queue")
+var queuePushErrors = stats.NewCounterVec("queue_push_errors_total", "Failed pushes to the queue.", "queue")
+var queuePopped = stats.NewCounterVec("queue_pop_total", "Messages popped from the queue.", "queue")
+
 func Push(k *QueueConfig, v []byte) error {
 	var err error = nil
 	if k == nil || k.ID == "" {
@@ -45,9 +49,11 @@ func Push(k *QueueConfig, v []byte) error {
 		err = handler.Push(k.ID, v)
 		if err == nil {
 			stats.Increment("queue", k.ID, "push")
+			queuePushed.With(k.ID).Inc()
 			return nil
 		}
 		stats.Increment("queue", k.ID, "push_error")
+		queuePushErrors.With(k.ID).Inc()
 		return err
 	}
 	panic(errors.Errorf("handler for [%v] is not registered", k))
@@ -67,6 +73,7 @@ func Pop(k *QueueConfig) ([]byte, error) {
 		o, timeout := handler.Pop(k.ID, -1)
 		if !timeout {
 			stats.Increment("queue", k.ID, "pop")
+			queuePopped.With(k.ID).Inc()
 			return o, nil
 		}
 		if global.Env().IsDebug {
@@ -96,6 +103,7 @@ func PopTimeout(k *QueueConfig, timeoutInSeconds time.Duration) (data []byte, ti
 		o, timeout := handler.Pop(k.ID, timeoutInSeconds)
 		if !timeout {
 			stats.Increment("queue", k.ID, "pop")
+			queuePopped.With(k.ID).Inc()
 		}
 
 		if global.Env().IsDebug {
@@ -0,0 +1,219 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package stats
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// values are bucketed on a log scale with 8 sub buckets per power of two,
+// the relative error of the percentiles is about 5%
+const histogramSubBuckets = 8
+
+// DefaultHistogramBuckets are the upper bounds of the cumulative buckets, in milliseconds
+var DefaultHistogramBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000}
+
+type histogramSlot struct {
+	epoch   int64
+	count   int64
+	sum     int64
+	min     int64
+	max     int64
+	buckets map[int]int64
+}
+
+// Histogram keeps the percentiles of the recent values over a sliding window,
+// which is made of several slots, and the cumulative buckets since start
+type Histogram struct {
+	l        sync.Mutex
+	slotSize time.Duration
+	slots    []histogramSlot
+	bounds   []float64
+	counts   []int64
+	count    int64
+	sum      int64
+}
+
+type HistogramBucket struct {
+	UpperBound float64 `json:"le"`
+	Count      int64   `json:"count"`
+}
+
+type HistogramSnapshot struct {
+	//values in the sliding window
+	Count int64   `json:"count"`
+	Min   int64   `json:"min"`
+	Max   int64   `json:"max"`
+	Mean  float64 `json:"mean"`
+	P50   int64   `json:"p50"`
+	P90   int64   `json:"p90"`
+	P99   int64   `json:"p99"`
+
+	//values since start
+	TotalCount int64             `json:"total_count"`
+	TotalSum   int64             `json:"total_sum"`
+	Buckets    []HistogramBucket `json:"-"`
+}
+
+func NewHistogram(window time.Duration, slots int, bounds []float64) *Histogram {
+	if slots <= 0 {
+		slots = 1
+	}
+	if window < time.Duration(slots) {
+		window = time.Minute
+	}
+	if len(bounds) == 0 {
+		bounds = DefaultHistogramBuckets
+	}
+	return &Histogram{
+		slotSize: window / time.Duration(slots),
+		slots:    make([]histogramSlot, slots),
+		bounds:   bounds,
+		counts:   make([]int64, len(bounds)+1),
+	}
+}
+
+func getHistogramBucket(v int64) int {
+	if v <= 0 {
+		return 0
+	}
+	return int(math.Log2(float64(v))*histogramSubBuckets) + 1
+}
+
+// getHistogramBucketValue returns the geometric middle of the bucket
+func getHistogramBucketValue(i int) float64 {
+	if i <= 0 {
+		return 0
+	}
+	return math.Pow(2, (float64(i)-0.5)/histogramSubBuckets)
+}
+
+func (h *Histogram) Record(v int64) {
+	h.record(v, time.Now())
+}
+
+func (h *Histogram) record(v int64, now time.Time) {
+	epoch := now.UnixNano() / int64(h.slotSize)
+
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	slot := &h.slots[epoch%int64(len(h.slots))]
+	if slot.epoch != epoch || slot.buckets == nil {
+		*slot = histogramSlot{epoch: epoch, min: v, max: v, buckets: map[int]int64{}}
+	}
+	slot.count++
+	slot.sum += v
+	if v < slot.min {
+		slot.min = v
+	}
+	if v > slot.max {
+		slot.max = v
+	}
+	slot.buckets[getHistogramBucket(v)]++
+
+	h.count++
+	h.sum += v
+	i := sort.SearchFloat64s(h.bounds, float64(v))
+	h.counts[i]++
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	return h.snapshot(time.Now())
+}
+
+func (h *Histogram) snapshot(now time.Time) HistogramSnapshot {
+	epoch := now.UnixNano() / int64(h.slotSize)
+
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	snapshot := HistogramSnapshot{TotalCount: h.count, TotalSum: h.sum}
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		snapshot.Buckets = append(snapshot.Buckets, HistogramBucket{UpperBound: bound, Count: cumulative})
+	}
+	snapshot.Buckets = append(snapshot.Buckets, HistogramBucket{UpperBound: math.Inf(1), Count: h.count})
+
+	var sum int64
+	buckets := map[int]int64{}
+	for i := range h.slots {
+		slot := &h.slots[i]
+		if slot.buckets == nil || slot.epoch <= epoch-int64(len(h.slots)) || slot.epoch > epoch {
+			continue
+		}
+		if snapshot.Count == 0 || slot.min < snapshot.Min {
+			snapshot.Min = slot.min
+		}
+		if snapshot.Count == 0 || slot.max > snapshot.Max {
+			snapshot.Max = slot.max
+		}
+		snapshot.Count += slot.count
+		sum += slot.sum
+		for k, v := range slot.buckets {
+			buckets[k] += v
+		}
+	}
+	if snapshot.Count == 0 {
+		return snapshot
+	}
+	snapshot.Mean = float64(sum) / float64(snapshot.Count)
+
+	keys := make([]int, 0, len(buckets))
+	for k := range buckets {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	percentile := func(q float64) int64 {
+		rank := int64(math.Ceil(q * float64(snapshot.Count)))
+		var seen int64
+		for _, k := range keys {
+			seen += buckets[k]
+			if seen >= rank {
+				v := int64(math.Round(getHistogramBucketValue(k)))
+				if v < snapshot.Min {
+					return snapshot.Min
+				}
+				if v > snapshot.Max {
+					return snapshot.Max
+				}
+				return v
+			}
+		}
+		return snapshot.Max
+	}
+	snapshot.P50 = percentile(0.5)
+	snapshot.P90 = percentile(0.9)
+	snapshot.P99 = percentile(0.99)
+	return snapshot
+}
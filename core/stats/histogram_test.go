@@ -0,0 +1,70 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package stats
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(time.Minute, 6, nil)
+	now := time.Now()
+	for i := int64(1); i <= 1000; i++ {
+		h.record(i, now)
+	}
+
+	s := h.snapshot(now)
+	assert.Equal(t, int64(1000), s.Count)
+	assert.Equal(t, int64(1), s.Min)
+	assert.Equal(t, int64(1000), s.Max)
+	assert.Equal(t, 500.5, s.Mean)
+	assert.InDelta(t, 500, s.P50, 500*0.05)
+	assert.InDelta(t, 900, s.P90, 900*0.05)
+	assert.InDelta(t, 990, s.P99, 990*0.05)
+
+	//cumulative buckets
+	assert.Equal(t, HistogramBucket{UpperBound: 1, Count: 1}, s.Buckets[0])
+	assert.Equal(t, HistogramBucket{UpperBound: 100, Count: 100}, s.Buckets[6])
+	assert.Equal(t, HistogramBucket{UpperBound: math.Inf(1), Count: 1000}, s.Buckets[len(s.Buckets)-1])
+
+	//values out of the window are dropped from percentiles, but kept in buckets
+	h.record(5, now.Add(2*time.Minute))
+	s = h.snapshot(now.Add(2 * time.Minute))
+	assert.Equal(t, int64(1), s.Count)
+	assert.Equal(t, int64(5), s.P99)
+	assert.Equal(t, int64(1001), s.TotalCount)
+	assert.Equal(t, int64(500505), s.TotalSum)
+
+	s = h.snapshot(now.Add(5 * time.Minute))
+	assert.Equal(t, int64(0), s.Count)
+	assert.Equal(t, int64(0), s.P50)
+}
@@ -0,0 +1,132 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package stats
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeSample(buffer *bytes.Buffer, name string, labels []Label, extra *Label, value string) {
+	buffer.WriteString(name)
+	if len(labels) > 0 || extra != nil {
+		buffer.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			buffer.WriteString(l.Name)
+			buffer.WriteString(`="`)
+			buffer.WriteString(labelValueEscaper.Replace(l.Value))
+			buffer.WriteByte('"')
+		}
+		if extra != nil {
+			if len(labels) > 0 {
+				buffer.WriteByte(',')
+			}
+			buffer.WriteString(extra.Name)
+			buffer.WriteString(`="`)
+			buffer.WriteString(extra.Value)
+			buffer.WriteByte('"')
+		}
+		buffer.WriteByte('}')
+	}
+	buffer.WriteByte(' ')
+	buffer.WriteString(value)
+	buffer.WriteByte('\n')
+}
+
+// WritePrometheus writes the metric families in the prometheus text format,
+// or in the OpenMetrics format, where counter samples are suffixed by _total
+// and unknown metrics are untyped in the prometheus text format
+func WritePrometheus(w io.Writer, families []MetricFamily, constLabels []Label, openMetrics bool) error {
+	buffer := bytes.Buffer{}
+	for _, family := range families {
+		name := family.Name
+		sampleName := family.Name
+		typ := string(family.Type)
+		switch family.Type {
+		case CounterMetric:
+			if openMetrics {
+				name = strings.TrimSuffix(family.Name, "_total")
+				sampleName = name + "_total"
+			}
+		case UnknownMetric:
+			if !openMetrics {
+				typ = "untyped"
+			}
+		}
+
+		if family.Help != "" {
+			buffer.WriteString("# HELP " + name + " " + helpEscaper.Replace(family.Help) + "\n")
+		}
+		buffer.WriteString("# TYPE " + name + " " + typ + "\n")
+
+		for _, m := range family.Metrics {
+			labels := make([]Label, 0, len(constLabels)+len(m.Labels))
+			labels = append(labels, constLabels...)
+			labels = append(labels, m.Labels...)
+
+			if m.Histogram == nil {
+				writeSample(&buffer, sampleName, labels, nil, formatFloat(m.Value))
+				continue
+			}
+			for _, b := range m.Histogram.Buckets {
+				writeSample(&buffer, name+"_bucket", labels, &Label{Name: "le", Value: formatFloat(b.UpperBound)}, strconv.FormatInt(b.Count, 10))
+			}
+			writeSample(&buffer, name+"_sum", labels, nil, strconv.FormatInt(m.Histogram.TotalSum, 10))
+			writeSample(&buffer, name+"_count", labels, nil, strconv.FormatInt(m.Histogram.TotalCount, 10))
+		}
+	}
+	if openMetrics {
+		buffer.WriteString("# EOF\n")
+	}
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
@@ -0,0 +1,114 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package stats
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	pushed := NewCounterVec("test_queue_push_total", "Messages pushed to the queue.", "queue")
+	pushed.With("q1").Inc()
+	pushed.With("q1").Add(2)
+	pushed.With(`q"2`).Inc()
+	assert.Equal(t, pushed.vec, NewCounterVec("test_queue_push_total", "", "queue").vec)
+	assert.Panics(t, func() {
+		NewGaugeVec("test_queue_push_total", "", "queue")
+	})
+
+	depth := NewGaugeVec("test_queue_depth", "Messages in the queue.", "queue")
+	depth.With("q1").Set(10)
+	depth.With("q1").Dec()
+
+	latency := NewHistogramVec("test_bulk_latency_ms", "Latency of bulk requests.", []float64{10, 100}, "cluster")
+	latency.With("c1").Record(5)
+	latency.With("c1").Record(50)
+
+	var families []MetricFamily
+	for _, f := range GatherMetrics() {
+		if f.Name == "test_queue_push_total" || f.Name == "test_queue_depth" || f.Name == "test_bulk_latency_ms" {
+			families = append(families, f)
+		}
+	}
+	families = append(families, MetricFamily{Name: "legacy", Type: UnknownMetric, Metrics: []Metric{{Value: 1.5}}})
+	constLabels := []Label{{Name: "id", Value: "node"}}
+
+	buffer := bytes.Buffer{}
+	assert.NoError(t, WritePrometheus(&buffer, families, constLabels, false))
+	assert.Equal(t, `# HELP test_bulk_latency_ms Latency of bulk requests.
+# TYPE test_bulk_latency_ms histogram
+test_bulk_latency_ms_bucket{id="node",cluster="c1",le="10"} 1
+test_bulk_latency_ms_bucket{id="node",cluster="c1",le="100"} 2
+test_bulk_latency_ms_bucket{id="node",cluster="c1",le="+Inf"} 2
+test_bulk_latency_ms_sum{id="node",cluster="c1"} 55
+test_bulk_latency_ms_count{id="node",cluster="c1"} 2
+# HELP test_queue_depth Messages in the queue.
+# TYPE test_queue_depth gauge
+test_queue_depth{id="node",queue="q1"} 9
+# HELP test_queue_push_total Messages pushed to the queue.
+# TYPE test_queue_push_total counter
+test_queue_push_total{id="node",queue="q\"2"} 1
+test_queue_push_total{id="node",queue="q1"} 3
+# TYPE legacy untyped
+legacy{id="node"} 1.5
+`, buffer.String())
+
+	buffer.Reset()
+	assert.NoError(t, WritePrometheus(&buffer, families[2:], nil, true))
+	assert.Equal(t, `# HELP test_queue_push Messages pushed to the queue.
+# TYPE test_queue_push counter
+test_queue_push_total{queue="q\"2"} 1
+test_queue_push_total{queue="q1"} 3
+# TYPE legacy unknown
+legacy 1.5
+# EOF
+`, buffer.String())
+}
+
+func TestDeleteSeries(t *testing.T) {
+	runs := NewCounterVec("test_delete_runs_total", "", "pipeline", "state")
+	duration := NewHistogramVec("test_delete_duration_ms", "", nil, "pipeline")
+	runs.With("p1", "finished").Inc()
+	runs.With("p1", "failed").Inc()
+	runs.With("p2", "finished").Inc()
+	duration.With("p1").Record(10)
+
+	assert.True(t, runs.Delete("p2", "finished"))
+	assert.False(t, runs.Delete("p2", "finished"))
+
+	//all the series of the pipeline in all the metrics
+	assert.Equal(t, 3, DeleteSeries("pipeline", "p1"))
+	for _, f := range GatherMetrics() {
+		if f.Name == "test_delete_runs_total" || f.Name == "test_delete_duration_ms" {
+			assert.Equal(t, 0, len(f.Metrics))
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type MetricType string
+
+const (
+	CounterMetric   MetricType = "counter"
+	GaugeMetric     MetricType = "gauge"
+	HistogramMetric MetricType = "histogram"
+	UnknownMetric   MetricType = "unknown"
+)
+
+type Label struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Metric struct {
+	Labels    []Label            `json:"labels,omitempty"`
+	Value     float64            `json:"value"`
+	Histogram *HistogramSnapshot `json:"histogram,omitempty"`
+}
+
+type MetricFamily struct {
+	Name    string     `json:"name"`
+	Help    string     `json:"help,omitempty"`
+	Type    MetricType `json:"type"`
+	Metrics []Metric   `json:"metrics"`
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       int64
+	histogram   *Histogram
+}
+
+type metricVec struct {
+	name       string
+	help       string
+	typ        MetricType
+	labelNames []string
+	buckets    []float64
+
+	l      sync.RWMutex
+	series map[string]*metricSeries
+}
+
+// histograms in the registry share the same sliding window
+const registryHistogramWindow = time.Minute
+const registryHistogramSlots = 6
+
+var registry = map[string]*metricVec{}
+var registryLock = sync.RWMutex{}
+
+func registerMetric(name, help string, typ MetricType, buckets []float64, labelNames []string) *metricVec {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if v, ok := registry[name]; ok {
+		if v.typ != typ || strings.Join(v.labelNames, ",") != strings.Join(labelNames, ",") {
+			panic(fmt.Errorf("metric [%v] already registered as %v with labels %v", name, v.typ, v.labelNames))
+		}
+		return v
+	}
+	v := &metricVec{name: name, help: help, typ: typ, labelNames: labelNames, buckets: buckets, series: map[string]*metricSeries{}}
+	registry[name] = v
+	return v
+}
+
+func (v *metricVec) with(labelValues []string) *metricSeries {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Errorf("metric [%v] expects labels %v, got %v", v.name, v.labelNames, labelValues))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.l.RLock()
+	s, ok := v.series[key]
+	v.l.RUnlock()
+	if ok {
+		return s
+	}
+
+	v.l.Lock()
+	defer v.l.Unlock()
+	if s, ok = v.series[key]; ok {
+		return s
+	}
+	s = &metricSeries{labelValues: append([]string{}, labelValues...)}
+	if v.typ == HistogramMetric {
+		s.histogram = NewHistogram(registryHistogramWindow, registryHistogramSlots, v.buckets)
+	}
+	v.series[key] = s
+	return s
+}
+
+func (v *metricVec) delete(labelValues []string) bool {
+	key := strings.Join(labelValues, "\xff")
+	v.l.Lock()
+	defer v.l.Unlock()
+	_, ok := v.series[key]
+	delete(v.series, key)
+	return ok
+}
+
+// deleteMatching removes the series with the label set to the value
+func (v *metricVec) deleteMatching(labelName, value string) int {
+	idx := -1
+	for i, name := range v.labelNames {
+		if name == labelName {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return 0
+	}
+	v.l.Lock()
+	defer v.l.Unlock()
+	deleted := 0
+	for key, s := range v.series {
+		if s.labelValues[idx] == value {
+			delete(v.series, key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// DeleteSeries removes the series of all the metrics with the label set to the value,
+// eg: the series of a deleted pipeline, returns the number of series removed
+func DeleteSeries(labelName, value string) int {
+	registryLock.RLock()
+	vecs := make([]*metricVec, 0, len(registry))
+	for _, v := range registry {
+		vecs = append(vecs, v)
+	}
+	registryLock.RUnlock()
+
+	deleted := 0
+	for _, v := range vecs {
+		deleted += v.deleteMatching(labelName, value)
+	}
+	return deleted
+}
+
+type CounterVec struct{ vec *metricVec }
+type GaugeVec struct{ vec *metricVec }
+type HistogramVec struct{ vec *metricVec }
+
+type Counter struct{ s *metricSeries }
+type GaugeValue struct{ s *metricSeries }
+
+// NewCounterVec registers a counter, registering the same name again returns the existing one
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{vec: registerMetric(name, help, CounterMetric, nil, labelNames)}
+}
+
+// NewGaugeVec registers a gauge, registering the same name again returns the existing one
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{vec: registerMetric(name, help, GaugeMetric, nil, labelNames)}
+}
+
+// NewHistogramVec registers a histogram, DefaultHistogramBuckets is used if buckets is empty
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return &HistogramVec{vec: registerMetric(name, help, HistogramMetric, buckets, labelNames)}
+}
+
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return &Counter{s: c.vec.with(labelValues)}
+}
+
+// Delete removes the series with the label values, returns false if not exists
+func (c *CounterVec) Delete(labelValues ...string) bool {
+	return c.vec.delete(labelValues)
+}
+
+func (c *Counter) Inc() {
+	atomic.AddInt64(&c.s.value, 1)
+}
+
+func (c *Counter) Add(v int64) {
+	if v < 0 {
+		return
+	}
+	atomic.AddInt64(&c.s.value, v)
+}
+
+func (g *GaugeVec) With(labelValues ...string) *GaugeValue {
+	return &GaugeValue{s: g.vec.with(labelValues)}
+}
+
+// Delete removes the series with the label values, returns false if not exists
+func (g *GaugeVec) Delete(labelValues ...string) bool {
+	return g.vec.delete(labelValues)
+}
+
+func (g *GaugeValue) Set(v int64) {
+	atomic.StoreInt64(&g.s.value, v)
+}
+
+func (g *GaugeValue) Add(v int64) {
+	atomic.AddInt64(&g.s.value, v)
+}
+
+func (g *GaugeValue) Inc() {
+	g.Add(1)
+}
+
+func (g *GaugeValue) Dec() {
+	g.Add(-1)
+}
+
+func (h *HistogramVec) With(labelValues ...string) *Histogram {
+	return h.vec.with(labelValues).histogram
+}
+
+// Delete removes the series with the label values, returns false if not exists
+func (h *HistogramVec) Delete(labelValues ...string) bool {
+	return h.vec.delete(labelValues)
+}
+
+// GatherMetrics returns all the registered metrics, sorted by name and labels
+func GatherMetrics() []MetricFamily {
+	registryLock.RLock()
+	vecs := make([]*metricVec, 0, len(registry))
+	for _, v := range registry {
+		vecs = append(vecs, v)
+	}
+	registryLock.RUnlock()
+	sort.Slice(vecs, func(i, j int) bool {
+		return vecs[i].name < vecs[j].name
+	})
+
+	families := make([]MetricFamily, 0, len(vecs))
+	for _, v := range vecs {
+		family := MetricFamily{Name: v.name, Help: v.help, Type: v.typ}
+		v.l.RLock()
+		keys := make([]string, 0, len(v.series))
+		for k := range v.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := v.series[k]
+			m := Metric{}
+			for i, name := range v.labelNames {
+				m.Labels = append(m.Labels, Label{Name: name, Value: s.labelValues[i]})
+			}
+			if s.histogram != nil {
+				snapshot := s.histogram.Snapshot()
+				m.Histogram = &snapshot
+			} else {
+				m.Value = float64(atomic.LoadInt64(&s.value))
+			}
+			family.Metrics = append(family.Metrics, m)
+		}
+		v.l.RUnlock()
+		families = append(families, family)
+	}
+	return families
+}
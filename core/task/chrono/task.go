@@ -87,6 +87,13 @@ func WithStartTime(year int, month time.Month, day, hour, min, sec int) Option {
 	}
 }
 
+func WithTime(t time.Time) Option {
+	return func(task *SchedulerTask) error {
+		task.startTime = t.In(time.Local)
+		return nil
+	}
+}
+
 func WithLocation(location string) Option {
 	return func(task *SchedulerTask) error {
 		loadedLocation, err := time.LoadLocation(location)
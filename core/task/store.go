@@ -0,0 +1,324 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package task
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/locker"
+	"infini.sh/framework/core/task/chrono"
+	"infini.sh/framework/core/util"
+)
+
+const (
+	// MisfireSkip ignores the runs missed while the process was down
+	MisfireSkip = "skip"
+	// MisfireFireOnce runs the task once if any run was missed
+	MisfireFireOnce = "fire_once"
+	// MisfireFireAll runs the task as many times as it was missed, up to maxMisfireRuns
+	MisfireFireAll = "fire_all"
+)
+
+const scheduleBucket = "task_schedule"
+
+var scheduleIndexKey = []byte("_index")
+
+const maxMisfireRuns = 100
+
+const singletonLockBucket = "task_singleton"
+
+var defaultLockTimeout = time.Duration(60) * time.Second
+
+var handlers = sync.Map{}
+
+// RegisterTaskHandler registers a named task function, persistent tasks refer to it by `handler`
+// the task `params` are passed to the handler as context values
+func RegisterTaskHandler(name string, handler func(ctx context.Context)) {
+	handlers.Store(name, handler)
+}
+
+func GetTaskHandler(name string) (func(ctx context.Context), bool) {
+	v, ok := handlers.Load(name)
+	if !ok {
+		return nil, false
+	}
+	f, ok := v.(func(ctx context.Context))
+	return f, ok
+}
+
+var storeLock = sync.Mutex{}
+
+func loadScheduleIndex() ([]string, error) {
+	v, err := kv.GetValue(scheduleBucket, scheduleIndexKey)
+	if err != nil || len(v) == 0 {
+		return nil, err
+	}
+	ids := []string{}
+	err = util.FromJSONBytes(v, &ids)
+	return ids, err
+}
+
+func saveScheduleIndex(ids []string) error {
+	return kv.AddValue(scheduleBucket, scheduleIndexKey, util.MustToJSONBytes(ids))
+}
+
+// saveScheduleTask persists the task definition and its last run state
+func saveScheduleTask(task *ScheduleTask) error {
+	if !kv.Registered() {
+		return errors.New("kv store handler is not registered")
+	}
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	err := kv.AddValue(scheduleBucket, []byte(task.ID), util.MustToJSONBytes(task))
+	if err != nil {
+		return err
+	}
+
+	ids, err := loadScheduleIndex()
+	if err != nil {
+		return err
+	}
+	if util.StringInArray(ids, task.ID) {
+		return nil
+	}
+	return saveScheduleIndex(append(ids, task.ID))
+}
+
+func removeScheduleTask(id string) error {
+	if !kv.Registered() {
+		return nil
+	}
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	err := kv.DeleteKey(scheduleBucket, []byte(id))
+	if err != nil {
+		return err
+	}
+
+	ids, err := loadScheduleIndex()
+	if err != nil {
+		return err
+	}
+	newIDs := make([]string, 0, len(ids))
+	for _, v := range ids {
+		if v != id {
+			newIDs = append(newIDs, v)
+		}
+	}
+	return saveScheduleIndex(newIDs)
+}
+
+func loadScheduleTasks() ([]ScheduleTask, error) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	ids, err := loadScheduleIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]ScheduleTask, 0, len(ids))
+	for _, id := range ids {
+		v, err := kv.GetValue(scheduleBucket, []byte(id))
+		if err != nil {
+			return nil, err
+		}
+		if len(v) == 0 {
+			continue
+		}
+		task := ScheduleTask{}
+		err = util.FromJSONBytes(v, &task)
+		if err != nil {
+			log.Errorf("invalid persistent task [%v]: %v", id, err)
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// restoreScheduleTasks loads the persistent tasks, tasks registered by code keep their definitions and only restore the last run state
+func restoreScheduleTasks() {
+	if !kv.Registered() {
+		return
+	}
+
+	tasks, err := loadScheduleTasks()
+	if err != nil {
+		log.Error("failed to load persistent tasks: ", err)
+		return
+	}
+
+	for _, stored := range tasks {
+		v, ok := Tasks.Load(stored.ID)
+		if ok {
+			if item, ok := v.(*ScheduleTask); ok && item.Persistent && item.StartTime == nil {
+				item.StartTime = stored.StartTime
+				item.EndTime = stored.EndTime
+			}
+			continue
+		}
+
+		if stored.Handler == "" {
+			log.Debugf("persistent task [%v] has no handler, skip restoring", stored.ID)
+			continue
+		}
+		if _, ok := GetTaskHandler(stored.Handler); !ok {
+			log.Warnf("handler [%v] of persistent task [%v] is not registered, skip restoring", stored.Handler, stored.ID)
+			continue
+		}
+		stored.State = Pending
+		stored.Persistent = true
+		RegisterScheduleTask(stored)
+		log.Debugf("persistent task [%v][%v] restored", stored.ID, stored.Description)
+	}
+
+	// save tasks registered by code, so that the run state can be tracked
+	Tasks.Range(func(key, value any) bool {
+		item, ok := value.(*ScheduleTask)
+		if ok && item.Persistent {
+			if err := saveScheduleTask(item); err != nil {
+				log.Errorf("failed to save persistent task [%v]: %v", item.ID, err)
+			}
+		}
+		return true
+	})
+}
+
+// checkMisfire returns the number of runs missed since the last run,
+// and the time of next run for interval task to keep the period across restarts
+func (task *ScheduleTask) checkMisfire(now time.Time) (missed int, next time.Time) {
+	if task.StartTime == nil || task.StartTime.IsZero() {
+		return 0, next
+	}
+	last := *task.StartTime
+
+	switch task.Type {
+	case Interval:
+		interval := util.GetDurationOrDefault(task.Interval, defaultInterval)
+		if interval <= 0 {
+			return 0, next
+		}
+		slots := now.Sub(last) / interval
+		if slots < 0 {
+			slots = 0
+		}
+		next = last.Add((slots + 1) * interval)
+		missed = int(slots)
+	case Crontab:
+		expr, err := chrono.ParseCronExpression(task.Crontab)
+		if err != nil {
+			return 0, next
+		}
+		t := last
+		for missed < maxMisfireRuns {
+			t = expr.NextTime(t)
+			if t.IsZero() || t.After(now) {
+				break
+			}
+			missed++
+		}
+	}
+
+	if missed > maxMisfireRuns {
+		missed = maxMisfireRuns
+	}
+	return missed, next
+}
+
+// fireMisfiredRuns runs the task according to the misfire policy
+func (task *ScheduleTask) fireMisfiredRuns(missed int) {
+	runs := 0
+	switch task.MisfirePolicy {
+	case MisfireFireOnce:
+		if missed > 0 {
+			runs = 1
+		}
+	case MisfireFireAll:
+		runs = missed
+	}
+	if runs <= 0 {
+		return
+	}
+
+	log.Infof("task [%v][%v] missed %v runs, policy: %v, run %v times", task.ID, task.Description, missed, task.MisfirePolicy, runs)
+	f := task.Task
+	go func() {
+		for i := 0; i < runs; i++ {
+			if !started {
+				return
+			}
+			f(context.Background())
+		}
+	}()
+}
+
+// holdSingletonLock holds the lock of singleton task and keeps renewing it until released
+func holdSingletonLock(task *ScheduleTask) (release func(), ok bool) {
+	clientID := global.Env().SystemConfig.NodeConfig.ID
+	timeout := util.GetDurationOrDefault(task.LockTimeout, defaultLockTimeout)
+	ok, err := locker.Hold(singletonLockBucket, task.ID, clientID, timeout, true)
+	if err != nil || !ok {
+		return nil, false
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("failed to renew lock of task [%v]: %v", task.ID, r)
+			}
+		}()
+		ticker := time.NewTicker(timeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if ok, _ := locker.Hold(singletonLockBucket, task.ID, clientID, timeout, true); !ok {
+					log.Warnf("lost lock of task [%v]", task.ID)
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		if err := locker.Release(singletonLockBucket, task.ID, clientID); err != nil {
+			log.Debugf("failed to release lock of task [%v]: %v", task.ID, err)
+		}
+	}, true
+}
@@ -0,0 +1,229 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package task
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/kv"
+)
+
+type memoryKV struct {
+	lock sync.Mutex
+	data map[string][]byte
+}
+
+func (s *memoryKV) Open() error  { return nil }
+func (s *memoryKV) Close() error { return nil }
+func (s *memoryKV) GetValue(bucket string, key []byte) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.data[bucket+"/"+string(key)], nil
+}
+func (s *memoryKV) GetCompressedValue(bucket string, key []byte) ([]byte, error) {
+	return s.GetValue(bucket, key)
+}
+func (s *memoryKV) AddValueCompress(bucket string, key []byte, value []byte) error {
+	return s.AddValue(bucket, key, value)
+}
+func (s *memoryKV) AddValue(bucket string, key []byte, value []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data[bucket+"/"+string(key)] = value
+	return nil
+}
+func (s *memoryKV) ExistsKey(bucket string, key []byte) (bool, error) {
+	v, _ := s.GetValue(bucket, key)
+	return v != nil, nil
+}
+func (s *memoryKV) DeleteKey(bucket string, key []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.data, bucket+"/"+string(key))
+	return nil
+}
+
+var testKV = &memoryKV{data: map[string][]byte{}}
+var registerKVOnce = sync.Once{}
+
+// resetTestKV registers the memory kv store once and clears it
+func resetTestKV() {
+	registerKVOnce.Do(func() {
+		kv.Register("task_test", testKV)
+	})
+	testKV.lock.Lock()
+	testKV.data = map[string][]byte{}
+	testKV.lock.Unlock()
+}
+
+func TestCheckMisfire(t *testing.T) {
+	last := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+
+	task := ScheduleTask{Type: Interval, Interval: "10m", StartTime: &last}
+	missed, next := task.checkMisfire(last.Add(35 * time.Minute))
+	assert.Equal(t, 3, missed)
+	assert.Equal(t, last.Add(40*time.Minute), next)
+
+	missed, next = task.checkMisfire(last.Add(5 * time.Minute))
+	assert.Equal(t, 0, missed)
+	assert.Equal(t, last.Add(10*time.Minute), next)
+
+	task = ScheduleTask{Type: Crontab, Crontab: "0 0 * * * *", StartTime: &last}
+	missed, next = task.checkMisfire(last.Add(150 * time.Minute))
+	assert.Equal(t, 2, missed)
+	assert.True(t, next.IsZero())
+
+	task = ScheduleTask{Type: Interval, Interval: "1s", StartTime: &last}
+	missed, _ = task.checkMisfire(last.Add(time.Hour))
+	assert.Equal(t, maxMisfireRuns, missed)
+
+	task = ScheduleTask{Type: Interval, Interval: "1s"}
+	missed, next = task.checkMisfire(last)
+	assert.Equal(t, 0, missed)
+	assert.True(t, next.IsZero())
+}
+
+func TestPersistScheduleTask(t *testing.T) {
+	resetTestKV()
+
+	last := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	task := &ScheduleTask{ID: "persist_a", Type: Interval, Interval: "10m", Persistent: true, Handler: "persist", Params: map[string]interface{}{"index": "logs"}, StartTime: &last, Task: func(ctx context.Context) {}}
+	assert.NoError(t, saveScheduleTask(task))
+	assert.NoError(t, saveScheduleTask(&ScheduleTask{ID: "persist_b", Type: Crontab, Crontab: "0 0 * * * *", Persistent: true}))
+	//saved again with the new run state, not duplicated in the index
+	end := last.Add(time.Minute)
+	task.EndTime = &end
+	assert.NoError(t, saveScheduleTask(task))
+
+	tasks, err := loadScheduleTasks()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(tasks))
+	assert.Equal(t, "persist_a", tasks[0].ID)
+	assert.Equal(t, "persist", tasks[0].Handler)
+	assert.Equal(t, "logs", tasks[0].Params["index"])
+	assert.True(t, last.Equal(*tasks[0].StartTime))
+	assert.True(t, end.Equal(*tasks[0].EndTime))
+	assert.Nil(t, tasks[0].Task)
+
+	assert.NoError(t, removeScheduleTask("persist_a"))
+	tasks, err = loadScheduleTasks()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, "persist_b", tasks[0].ID)
+}
+
+func TestRestoreScheduleTasks(t *testing.T) {
+	resetTestKV()
+	defer func() {
+		for _, id := range []string{"restore_handler", "restore_unknown", "restore_code"} {
+			Tasks.Delete(id)
+		}
+	}()
+
+	var index atomic.Value
+	RegisterTaskHandler("restore_test", func(ctx context.Context) {
+		index.Store(ctx.Value("index"))
+	})
+
+	last := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	assert.NoError(t, saveScheduleTask(&ScheduleTask{ID: "restore_handler", Type: Interval, Interval: "1h", Persistent: true, Handler: "restore_test", Params: map[string]interface{}{"index": "logs"}, State: Running}))
+	assert.NoError(t, saveScheduleTask(&ScheduleTask{ID: "restore_unknown", Type: Interval, Interval: "1h", Persistent: true, Handler: "unknown"}))
+	assert.NoError(t, saveScheduleTask(&ScheduleTask{ID: "restore_code", Type: Interval, Interval: "1h", Persistent: true, StartTime: &last}))
+
+	//registered by code, keeps the definition and restores the last run
+	RegisterScheduleTask(ScheduleTask{ID: "restore_code", Interval: "30m", Persistent: true, Task: func(ctx context.Context) {}})
+	restoreScheduleTasks()
+
+	v, ok := Tasks.Load("restore_code")
+	assert.True(t, ok)
+	code := v.(*ScheduleTask)
+	assert.Equal(t, "30m", code.Interval)
+	assert.True(t, last.Equal(*code.StartTime))
+
+	v, ok = Tasks.Load("restore_handler")
+	assert.True(t, ok)
+	restored := v.(*ScheduleTask)
+	assert.Equal(t, Pending, restored.State)
+	assert.True(t, restored.Persistent)
+	restored.Task(context.Background())
+	assert.Equal(t, "logs", index.Load())
+
+	_, ok = Tasks.Load("restore_unknown")
+	assert.False(t, ok)
+
+	//the code registered task is saved with the new definition
+	tasks, err := loadScheduleTasks()
+	assert.NoError(t, err)
+	for _, task := range tasks {
+		if task.ID == "restore_code" {
+			assert.Equal(t, "30m", task.Interval)
+		}
+	}
+}
+
+func TestFireMisfiredRuns(t *testing.T) {
+	started = true
+	defer func() { started = false }()
+
+	for policy, expected := range map[string]int32{MisfireSkip: 0, MisfireFireOnce: 1, MisfireFireAll: 3} {
+		var runs int32
+		done := make(chan struct{}, 3)
+		task := &ScheduleTask{MisfirePolicy: policy, Task: func(ctx context.Context) {
+			atomic.AddInt32(&runs, 1)
+			done <- struct{}{}
+		}}
+		task.fireMisfiredRuns(3)
+		for i := int32(0); i < expected; i++ {
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("policy %v: timeout", policy)
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		assert.Equal(t, expected, atomic.LoadInt32(&runs), policy)
+	}
+}
+
+func TestTryRegisterScheduleTask(t *testing.T) {
+	id, err := TryRegisterScheduleTask(ScheduleTask{Interval: "10m", Handler: "try_register_not_found", Persistent: true})
+	assert.Error(t, err)
+	assert.Equal(t, "", id)
+
+	id, err = TryRegisterScheduleTask(ScheduleTask{Interval: "10m"})
+	assert.Error(t, err)
+	assert.Equal(t, "", id)
+
+	id, err = TryRegisterScheduleTask(ScheduleTask{ID: "try_register", Interval: "10m", Task: func(ctx context.Context) {}})
+	assert.NoError(t, err)
+	assert.Equal(t, "try_register", id)
+	_, ok := Tasks.Load(id)
+	assert.True(t, ok)
+	Tasks.Delete(id)
+}
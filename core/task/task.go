@@ -28,6 +28,7 @@ import (
 	log "github.com/cihub/seelog"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/kv"
 	"infini.sh/framework/core/task/chrono"
 	"infini.sh/framework/core/util"
 	"runtime"
@@ -132,7 +133,18 @@ type ScheduleTask struct {
 	EndTime     *time.Time `config:"end_time" json:"end_time,omitempty"`
 
 	// Ensures the task runs as a singleton, preventing duplicate executions when previous attempt is not finished.
+	// The task is also exclusive across multiple instances by holding a lock in the kv store.
 	Singleton bool `config:"singleton" json:"singleton,omitempty"`
+	// Lease of the singleton lock, renewed while the task is running
+	LockTimeout string `config:"lock_timeout" json:"lock_timeout,omitempty"`
+
+	// Persistent saves the task definition and last run state to the kv store, restores them after restart
+	Persistent bool `config:"persistent" json:"persistent,omitempty"`
+	// How to handle the runs missed while the process was down, one of skip, fire_once and fire_all
+	MisfirePolicy string `config:"misfire_policy" json:"misfire_policy,omitempty"`
+	// Name of the registered task handler, used when the task function can't be persisted
+	Handler string                 `config:"handler" json:"handler,omitempty"`
+	Params  map[string]interface{} `config:"params" json:"params,omitempty"`
 
 	Task     func(ctx context.Context) `config:"-" json:"-"`
 	taskItem chrono.ScheduledTask
@@ -146,11 +158,24 @@ const Interval = "interval"
 const Crontab = "crontab"
 const Transient = "transient"
 
+// RegisterScheduleTask registers the task, returns empty id if failed to register
 func RegisterScheduleTask(task ScheduleTask) (taskID string) {
+	taskID, err := TryRegisterScheduleTask(task)
+	if err != nil {
+		log.Error(err)
+	}
+	return taskID
+}
+
+// TryRegisterScheduleTask registers the task, the persistent task is saved before
+// registered, an error is returned if the handler is not found or failed to save
+func TryRegisterScheduleTask(task ScheduleTask) (string, error) {
 	if task.ID == "" {
 		task.ID = util.GetUUID()
 	}
-	task.CreateTime = time.Now()
+	if task.CreateTime.IsZero() {
+		task.CreateTime = time.Now()
+	}
 	task.State = Pending
 	if task.Type == "" && task.Interval != "" {
 		task.Type = Interval
@@ -162,6 +187,24 @@ func RegisterScheduleTask(task ScheduleTask) (taskID string) {
 		task.isTaskRunning=&atomic.Bool{}
 	}
 
+	if task.Task == nil && task.Handler != "" {
+		handler, ok := GetTaskHandler(task.Handler)
+		if !ok {
+			return "", errors.Errorf("task handler [%v] not found, task: %v", task.Handler, task.ID)
+		}
+		params := task.Params
+		task.Task = func(ctx context.Context) {
+			for k, v := range params {
+				ctx = context.WithValue(ctx, k, v)
+			}
+			handler(ctx)
+		}
+	}
+
+	if task.Task == nil {
+		return "", errors.Errorf("task function is not defined, task: %v", task.ID)
+	}
+
 	tempTask := task.Task
 	task.Task = func(ctx context.Context) {
 
@@ -190,6 +233,16 @@ func RegisterScheduleTask(task ScheduleTask) (taskID string) {
 				}
 				task.isTaskRunning.Store(false)
 			}()
+
+			//task should be exclusive across instances
+			if kv.Registered() {
+				release, ok := holdSingletonLock(&task)
+				if !ok {
+					log.Debugf("task [%v][%v] is running in other instance, skipping", task.ID, task.Description)
+					return
+				}
+				defer release()
+			}
 		}
 
 		t := time.Now()
@@ -201,6 +254,18 @@ func RegisterScheduleTask(task ScheduleTask) (taskID string) {
 		t = time.Now()
 		task.EndTime = &t
 		task.State = Finished
+
+		if task.Persistent && started {
+			if err := saveScheduleTask(&task); err != nil {
+				log.Errorf("failed to save state of task [%v]: %v", task.ID, err)
+			}
+		}
+	}
+
+	if started && task.Persistent {
+		if err := saveScheduleTask(&task); err != nil {
+			return "", errors.Errorf("failed to save persistent task [%v]: %v", task.ID, err)
+		}
 	}
 
 	_, ok := Tasks.Load(task.ID)
@@ -215,7 +280,7 @@ func RegisterScheduleTask(task ScheduleTask) (taskID string) {
 		runTask(&task)
 	}
 
-	return task.ID
+	return task.ID, nil
 }
 
 var quit = make(chan struct{})
@@ -224,6 +289,7 @@ var defaultInterval = time.Duration(10) * time.Second
 var started bool
 
 func RunTasks() {
+	restoreScheduleTasks()
 	started = true
 	Tasks.Range(func(key, value any) bool {
 		task, ok := value.(*ScheduleTask)
@@ -243,9 +309,18 @@ func runTask(task *ScheduleTask) {
 		log.Debug("scheduled task:", task.ID, ",", task.Type, ",", task.Interval, ",", task.Crontab, ",", task.Description)
 	}
 
+	var options []chrono.Option
+	if task.Persistent {
+		missed, next := task.checkMisfire(time.Now())
+		if !next.IsZero() {
+			options = append(options, chrono.WithTime(next))
+		}
+		task.fireMisfiredRuns(missed)
+	}
+
 	switch task.Type {
 	case Interval:
-		task1, err := taskScheduler.ScheduleAtFixedRate(task.Task, util.GetDurationOrDefault(task.Interval, defaultInterval))
+		task1, err := taskScheduler.ScheduleAtFixedRate(task.Task, util.GetDurationOrDefault(task.Interval, defaultInterval), options...)
 		if err != nil {
 			log.Error("failed to scheduled interval task:", task.Type, ",", task.Interval, ",", task.Description)
 		}
@@ -325,7 +400,14 @@ func StopAllTasks() {
 
 func DeleteTask(id string) {
 	StopTask(id)
-	Tasks.Delete(id)
+	v, ok := Tasks.LoadAndDelete(id)
+	if ok {
+		if item, ok := v.(*ScheduleTask); ok && item.Persistent {
+			if err := removeScheduleTask(id); err != nil {
+				log.Errorf("failed to remove persistent task [%v]: %v", id, err)
+			}
+		}
+	}
 }
 
 func StopTasks() {
@@ -0,0 +1,195 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package alerting
+
+import (
+	"context"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/api"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/event"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/task"
+	"infini.sh/framework/core/util"
+)
+
+type NotificationConfig struct {
+	//notify the resolution of the alerts as well
+	NotifyResolved bool `config:"notify_resolved"`
+	//processors to run with the alerts as messages, eg: smtp
+	Processors []*config.Config `config:"processor"`
+	//queue to push the alerts to, for a pipeline to consume
+	Queue string `config:"queue"`
+	//extra fields of the messages, eg: template, server_id and email for smtp
+	Message map[string]interface{} `config:"message"`
+}
+
+type Config struct {
+	Enabled           bool               `config:"enabled"`
+	Interval          string             `config:"interval"`
+	ResolvedRetention string             `config:"resolved_retention"`
+	Rules             []RuleConfig       `config:"rules"`
+	Notification      NotificationConfig `config:"notification"`
+}
+
+const listenerName = "alerting"
+
+type AlertingModule struct {
+	api.Handler
+	config     *Config
+	engine     *Engine
+	processors *pipeline.Processors
+	taskID     string
+}
+
+func (module *AlertingModule) Name() string {
+	return "alerting"
+}
+
+func (module *AlertingModule) Setup() {
+	module.config = &Config{
+		Interval:          "10s",
+		ResolvedRetention: "1h",
+	}
+	ok, err := env.ParseConfig("alerting", module.config)
+	if ok && err != nil {
+		panic(err)
+	}
+	if !ok || !module.config.Enabled {
+		return
+	}
+
+	var rules []*Rule
+	for _, cfg := range module.config.Rules {
+		rule, err := NewRule(cfg)
+		if err != nil {
+			panic(err)
+		}
+		rules = append(rules, rule)
+	}
+
+	if len(module.config.Notification.Processors) > 0 {
+		module.processors, err = pipeline.NewPipeline(module.config.Notification.Processors)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	retention := util.GetDurationOrDefault(module.config.ResolvedRetention, time.Hour)
+	module.engine = NewEngine(rules, module.notify, module.config.Notification.NotifyResolved, retention)
+
+	api.HandleAPIMethod(api.GET, "/alerting/rules", module.getRules)
+	api.HandleAPIMethod(api.GET, "/alerting/alerts", module.getAlerts)
+	api.HandleAPIMethod(api.GET, "/alerting/silences", module.getSilences)
+	api.HandleAPIMethod(api.POST, "/alerting/silences", module.createSilence)
+	api.HandleAPIMethod(api.DELETE, "/alerting/silences/:id", module.deleteSilence)
+}
+
+func (module *AlertingModule) Start() error {
+	if module.engine == nil {
+		return nil
+	}
+	event.RegisterListener(listenerName, module.engine.OnEvent)
+	module.taskID = task.RegisterScheduleTask(task.ScheduleTask{
+		Description: "evaluate alerting rules",
+		Type:        "interval",
+		Interval:    util.StringDefault(module.config.Interval, "10s"),
+		Task: func(ctx context.Context) {
+			module.engine.Evaluate(time.Now())
+		},
+	})
+	log.Infof("alerting started with %v rules", len(module.engine.rules))
+	return nil
+}
+
+func (module *AlertingModule) Stop() error {
+	if module.engine == nil {
+		return nil
+	}
+	event.UnregisterListener(listenerName)
+	if module.taskID != "" {
+		task.DeleteTask(module.taskID)
+	}
+	return nil
+}
+
+// notify sends the alert to the configured queue and processors, the
+// variables are flattened to strings for the templates of smtp
+func (module *AlertingModule) notify(alert Alert) {
+	variables := util.MapStr{
+		"alert_id":  alert.ID,
+		"rule_id":   alert.RuleID,
+		"rule_name": alert.RuleName,
+		"severity":  alert.Severity,
+		"state":     string(alert.State),
+		"message":   alert.Message,
+		"active_at": alert.ActiveAt.Format(time.RFC3339),
+	}
+	if alert.Value != nil {
+		variables["value"] = formatValue(*alert.Value)
+	}
+	for k, v := range alert.Labels {
+		variables["labels."+k] = v
+	}
+	if vars, ok := module.config.Notification.Message["variables"].(map[string]interface{}); ok {
+		for k, v := range vars {
+			if _, exists := variables[k]; !exists {
+				variables[k] = v
+			}
+		}
+	}
+
+	msg := util.MapStr{}
+	for k, v := range module.config.Notification.Message {
+		msg[k] = v
+	}
+	msg["alert"] = alert
+	msg["variables"] = variables
+	data := util.MustToJSONBytes(msg)
+
+	if module.config.Notification.Queue != "" {
+		if err := queue.Push(queue.GetOrInitConfig(module.config.Notification.Queue), data); err != nil {
+			log.Errorf("failed to push alert [%v] to queue: %v", alert.ID, err)
+		}
+	}
+
+	if module.processors != nil {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("failed to notify alert [%v]: %v", alert.ID, r)
+			}
+		}()
+		ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{Name: "alerting"})
+		defer pipeline.ReleaseContext(ctx)
+		ctx.Set("messages", []queue.Message{{Data: data, Size: len(data), Timestamp: time.Now().UnixNano()}})
+		if err := module.processors.Process(ctx); err != nil {
+			log.Errorf("failed to notify alert [%v]: %v", alert.ID, err)
+		}
+	}
+	log.Infof("alert [%v] %v: %v", alert.ID, alert.State, alert.Message)
+}
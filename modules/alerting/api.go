@@ -0,0 +1,84 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package alerting
+
+import (
+	"net/http"
+	"time"
+
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/util"
+)
+
+func (module *AlertingModule) getRules(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	module.WriteJSON(w, util.MapStr{"rules": module.engine.Rules()}, http.StatusOK)
+}
+
+func (module *AlertingModule) getAlerts(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	state := AlertState(module.GetParameter(req, "state"))
+	module.WriteJSON(w, util.MapStr{"alerts": module.engine.Alerts(state)}, http.StatusOK)
+}
+
+func (module *AlertingModule) getSilences(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	module.WriteJSON(w, util.MapStr{"silences": module.engine.Silences()}, http.StatusOK)
+}
+
+type silenceRequest struct {
+	Silence
+	//alternative to ends_at, eg: 2h
+	Duration string `json:"duration,omitempty"`
+}
+
+func (module *AlertingModule) createSilence(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	body := silenceRequest{}
+	if err := module.DecodeJSON(req, &body); err != nil {
+		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	silence := body.Silence
+	if body.Duration != "" {
+		d, err := time.ParseDuration(body.Duration)
+		if err != nil {
+			module.WriteError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if silence.StartsAt.IsZero() {
+			silence.StartsAt = time.Now()
+		}
+		silence.EndsAt = silence.StartsAt.Add(d)
+	}
+	if err := module.engine.AddSilence(&silence); err != nil {
+		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	module.WriteJSON(w, util.MapStr{"acknowledged": true, "id": silence.ID}, http.StatusOK)
+}
+
+func (module *AlertingModule) deleteSilence(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if !module.engine.DeleteSilence(ps.MustGetParameter("id")) {
+		module.WriteError(w, "silence not found", http.StatusNotFound)
+		return
+	}
+	module.WriteJSON(w, util.MapStr{"acknowledged": true}, http.StatusOK)
+}
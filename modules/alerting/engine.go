@@ -0,0 +1,434 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package alerting
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/conditions"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/event"
+	"infini.sh/framework/core/util"
+)
+
+type AlertState string
+
+const (
+	StatePending  AlertState = "pending"
+	StateFiring   AlertState = "firing"
+	StateResolved AlertState = "resolved"
+)
+
+type Alert struct {
+	ID       string            `json:"id"`
+	RuleID   string            `json:"rule_id"`
+	RuleName string            `json:"rule_name"`
+	Severity string            `json:"severity"`
+	State    AlertState        `json:"state"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Value    *float64          `json:"value,omitempty"`
+	Message  string            `json:"message"`
+	Silenced bool              `json:"silenced"`
+
+	ActiveAt      time.Time  `json:"active_at"`
+	FiredAt       *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt    *time.Time `json:"resolved_at,omitempty"`
+	LastEvaluated time.Time  `json:"last_evaluated"`
+}
+
+type Silence struct {
+	ID string `json:"id"`
+	//empty to match all the rules
+	RuleID   string            `json:"rule_id,omitempty"`
+	Matchers map[string]string `json:"matchers,omitempty"`
+	StartsAt time.Time         `json:"starts_at"`
+	EndsAt   time.Time         `json:"ends_at"`
+	Comment  string            `json:"comment,omitempty"`
+}
+
+func (s *Silence) active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+func (s *Silence) matches(alert *Alert) bool {
+	if s.RuleID != "" && s.RuleID != alert.RuleID {
+		return false
+	}
+	for k, v := range s.Matchers {
+		if alert.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// series tracks the latest events of one group of a rule
+type series struct {
+	labels    map[string]string
+	doc       util.MapStr
+	lastSeen  time.Time
+	value     float64
+	hasValue  bool
+	rate      float64
+	hasRate   bool
+	valueTime time.Time
+}
+
+type Notifier func(alert Alert)
+
+// Engine evaluates the rules against the latest events of each series and
+// tracks the state of the alerts, notifying on firing and on resolution
+type Engine struct {
+	lock     sync.RWMutex
+	rules    []*Rule
+	series   map[string]map[string]*series
+	alerts   map[string]*Alert
+	silences map[string]*Silence
+	notifier Notifier
+
+	notifyResolved    bool
+	resolvedRetention time.Duration
+	started           time.Time
+}
+
+func NewEngine(rules []*Rule, notifier Notifier, notifyResolved bool, resolvedRetention time.Duration) *Engine {
+	e := &Engine{
+		rules:             rules,
+		series:            map[string]map[string]*series{},
+		alerts:            map[string]*Alert{},
+		silences:          map[string]*Silence{},
+		notifier:          notifier,
+		notifyResolved:    notifyResolved,
+		resolvedRetention: resolvedRetention,
+		started:           time.Now(),
+	}
+	for _, rule := range rules {
+		e.series[rule.ID] = map[string]*series{}
+	}
+	return e
+}
+
+// OnEvent records the event into the series of the matching rules
+func (e *Engine) OnEvent(ev *event.Event) {
+	var matched []*Rule
+	for _, rule := range e.rules {
+		if !rule.Disabled && rule.matches(ev.Metadata.Category, ev.Metadata.Name) {
+			matched = append(matched, rule)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	//events carry typed structs, flatten them for the conditions
+	doc := util.MapStr{}
+	if err := util.FromJSONBytes(util.MustToJSONBytes(ev), &doc); err != nil {
+		log.Debugf("invalid event %v: %v", ev.String(), err)
+		return
+	}
+	now := ev.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, rule := range matched {
+		if rule.filter != nil && !rule.filter.Check(doc) {
+			continue
+		}
+		key, labels := rule.groupKey(doc)
+		s, ok := e.series[rule.ID][key]
+		if !ok {
+			s = &series{labels: labels}
+			e.series[rule.ID][key] = s
+		}
+		s.doc = doc
+		s.lastSeen = now
+
+		if rule.Type == RuleTypeRate {
+			raw, _ := doc.GetValue(rule.Field)
+			v, ok := toFloat(raw)
+			if !ok {
+				continue
+			}
+			if s.hasValue && now.After(s.valueTime) {
+				s.rate = (v - s.value) / now.Sub(s.valueTime).Seconds()
+				s.hasRate = true
+			}
+			s.value, s.hasValue, s.valueTime = v, true, now
+		}
+	}
+}
+
+func alertID(ruleID, key string) string {
+	if key == "" {
+		return ruleID
+	}
+	return ruleID + "|" + key
+}
+
+// Evaluate checks all the rules and moves the alerts through the states
+func (e *Engine) Evaluate(now time.Time) {
+	var notifications []Alert
+
+	e.lock.Lock()
+	for _, rule := range e.rules {
+		if rule.Disabled {
+			continue
+		}
+		active := e.evaluateRule(rule, now)
+		for key, value := range active {
+			id := alertID(rule.ID, key)
+			alert, ok := e.alerts[id]
+			if !ok || alert.State == StateResolved {
+				alert = &Alert{
+					ID:       id,
+					RuleID:   rule.ID,
+					RuleName: rule.Name,
+					Severity: rule.Severity,
+					State:    StatePending,
+					ActiveAt: now,
+				}
+				e.alerts[id] = alert
+			}
+			alert.Labels = e.alertLabels(rule, key)
+			alert.Value = value
+			alert.LastEvaluated = now
+			alert.Message = rule.renderMessage(e.messageVars(rule, key, alert))
+			alert.Silenced = e.isSilenced(alert, now)
+			if alert.State == StatePending && now.Sub(alert.ActiveAt) >= rule.forDur {
+				alert.State = StateFiring
+				firedAt := now
+				alert.FiredAt = &firedAt
+				if !alert.Silenced {
+					notifications = append(notifications, *alert)
+				}
+			}
+		}
+
+		//resolve the alerts not active anymore
+		for id, alert := range e.alerts {
+			if alert.RuleID != rule.ID || alert.State == StateResolved {
+				continue
+			}
+			key := strings.TrimPrefix(strings.TrimPrefix(id, rule.ID), "|")
+			if _, ok := active[key]; ok {
+				continue
+			}
+			if alert.State == StatePending {
+				delete(e.alerts, id)
+				continue
+			}
+			alert.State = StateResolved
+			resolvedAt := now
+			alert.ResolvedAt = &resolvedAt
+			alert.LastEvaluated = now
+			alert.Silenced = e.isSilenced(alert, now)
+			if e.notifyResolved && !alert.Silenced {
+				notifications = append(notifications, *alert)
+			}
+		}
+	}
+
+	//cleanup the history and the expired silences
+	for id, alert := range e.alerts {
+		if alert.State == StateResolved && alert.ResolvedAt != nil && now.Sub(*alert.ResolvedAt) > e.resolvedRetention {
+			delete(e.alerts, id)
+		}
+	}
+	for id, s := range e.silences {
+		if now.After(s.EndsAt) {
+			delete(e.silences, id)
+		}
+	}
+	e.lock.Unlock()
+
+	if e.notifier != nil {
+		for _, alert := range notifications {
+			e.notifier(alert)
+		}
+	}
+}
+
+// evaluateRule returns the keys of the active series and their value, the
+// stale series are dropped, so the alerts of them are resolved as no data
+func (e *Engine) evaluateRule(rule *Rule, now time.Time) map[string]*float64 {
+	active := map[string]*float64{}
+	groups := e.series[rule.ID]
+	for key, s := range groups {
+		staleAfter := rule.staleAfter
+		if rule.Type == RuleTypeAbsence {
+			staleAfter += rule.absentFor
+		}
+		if now.Sub(s.lastSeen) >= staleAfter {
+			delete(groups, key)
+		}
+	}
+	switch rule.Type {
+	case RuleTypeAbsence:
+		//nothing received at all since the start
+		if len(groups) == 0 && len(rule.GroupBy) == 0 && now.Sub(e.started) >= rule.absentFor {
+			active[""] = nil
+		}
+		for key, s := range groups {
+			if now.Sub(s.lastSeen) >= rule.absentFor {
+				silent := now.Sub(s.lastSeen).Seconds()
+				active[key] = &silent
+			}
+		}
+	case RuleTypeRate:
+		for key, s := range groups {
+			if !s.hasRate {
+				continue
+			}
+			ctx := &conditions.Context{}
+			ctx.AddContext(util.MapStr{"rate": s.rate, "value": s.value})
+			ctx.AddContext(s.doc)
+			if rule.condition.Check(ctx) {
+				rate := s.rate
+				active[key] = &rate
+			}
+		}
+	default:
+		for key, s := range groups {
+			if rule.condition.Check(s.doc) {
+				var value *float64
+				if rule.Field != "" {
+					raw, _ := s.doc.GetValue(rule.Field)
+					if v, ok := toFloat(raw); ok {
+						value = &v
+					}
+				}
+				active[key] = value
+			}
+		}
+	}
+	return active
+}
+
+func (e *Engine) alertLabels(rule *Rule, key string) map[string]string {
+	labels := map[string]string{}
+	for k, v := range rule.Labels {
+		labels[k] = v
+	}
+	if s, ok := e.series[rule.ID][key]; ok {
+		for k, v := range s.labels {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+func (e *Engine) messageVars(rule *Rule, key string, alert *Alert) util.MapStr {
+	labels := util.MapStr{}
+	for k, v := range alert.Labels {
+		labels[k] = v
+	}
+	vars := util.MapStr{
+		"rule":     util.MapStr{"id": rule.ID, "name": rule.Name},
+		"severity": rule.Severity,
+		"labels":   labels,
+	}
+	if alert.Value != nil {
+		vars["value"] = formatValue(*alert.Value)
+	}
+	if s, ok := e.series[rule.ID][key]; ok && s.doc != nil {
+		vars["event"] = s.doc
+	}
+	return vars
+}
+
+func (e *Engine) isSilenced(alert *Alert, now time.Time) bool {
+	for _, s := range e.silences {
+		if s.active(now) && s.matches(alert) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) Alerts(state AlertState) []Alert {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	result := []Alert{}
+	for _, alert := range e.alerts {
+		if state == "" || alert.State == state {
+			result = append(result, *alert)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+func (e *Engine) Rules() []RuleConfig {
+	result := make([]RuleConfig, 0, len(e.rules))
+	for _, rule := range e.rules {
+		result = append(result, rule.RuleConfig)
+	}
+	return result
+}
+
+func (e *Engine) AddSilence(s *Silence) error {
+	if s.StartsAt.IsZero() {
+		s.StartsAt = time.Now()
+	}
+	if s.EndsAt.IsZero() || !s.EndsAt.After(s.StartsAt) {
+		return errors.New("ends_at of the silence must be after starts_at")
+	}
+	if s.ID == "" {
+		s.ID = util.GetUUID()
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.silences[s.ID] = s
+	return nil
+}
+
+func (e *Engine) DeleteSilence(id string) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	_, ok := e.silences[id]
+	delete(e.silences, id)
+	return ok
+}
+
+func (e *Engine) Silences() []Silence {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	result := []Silence{}
+	for _, s := range e.silences {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartsAt.Before(result[j].StartsAt)
+	})
+	return result
+}
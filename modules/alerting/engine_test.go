@@ -0,0 +1,188 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/conditions"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/event"
+	"infini.sh/framework/core/util"
+)
+
+func newTestRule(t *testing.T, cfg map[string]interface{}) *Rule {
+	c, err := config.NewConfigFrom(cfg)
+	assert.NoError(t, err)
+	ruleCfg := RuleConfig{}
+	assert.NoError(t, c.Unpack(&ruleCfg))
+	rule, err := NewRule(ruleCfg)
+	assert.NoError(t, err)
+	return rule
+}
+
+func healthEvent(cluster, status string, ts time.Time) *event.Event {
+	return &event.Event{
+		Timestamp: ts,
+		Metadata: event.EventMetadata{
+			Category: "elasticsearch",
+			Name:     "cluster_health",
+			Labels:   util.MapStr{"cluster_id": cluster},
+		},
+		Fields: util.MapStr{"elasticsearch": util.MapStr{"cluster_health": util.MapStr{"status": status}}},
+	}
+}
+
+func TestThresholdRule(t *testing.T) {
+	rule := newTestRule(t, map[string]interface{}{
+		"id":        "cluster_red",
+		"metric":    "elasticsearch.cluster_health",
+		"group_by":  []string{"metadata.labels.cluster_id"},
+		"condition": map[string]interface{}{"equals": map[string]interface{}{"payload.elasticsearch.cluster_health.status": "red"}},
+		"for":       "1m",
+		"message":   "cluster $[[labels.cluster_id]] is $[[event.payload.elasticsearch.cluster_health.status]]",
+	})
+	var notified []Alert
+	engine := NewEngine([]*Rule{rule}, func(alert Alert) { notified = append(notified, alert) }, true, time.Hour)
+
+	now := time.Now()
+	engine.OnEvent(healthEvent("c1", "red", now))
+	engine.OnEvent(healthEvent("c2", "green", now))
+	engine.Evaluate(now)
+	alerts := engine.Alerts("")
+	assert.Equal(t, 1, len(alerts))
+	assert.Equal(t, StatePending, alerts[0].State)
+	assert.Equal(t, "c1", alerts[0].Labels["cluster_id"])
+	assert.Equal(t, "cluster c1 is red", alerts[0].Message)
+	assert.Equal(t, 0, len(notified))
+
+	engine.Evaluate(now.Add(time.Minute))
+	assert.Equal(t, StateFiring, engine.Alerts("")[0].State)
+	assert.Equal(t, 1, len(notified))
+
+	//stays firing without notifying again
+	engine.Evaluate(now.Add(2 * time.Minute))
+	assert.Equal(t, 1, len(notified))
+
+	engine.OnEvent(healthEvent("c1", "green", now.Add(3*time.Minute)))
+	engine.Evaluate(now.Add(3 * time.Minute))
+	assert.Equal(t, StateResolved, engine.Alerts("")[0].State)
+	assert.Equal(t, 2, len(notified))
+	assert.Equal(t, StateResolved, notified[1].State)
+
+	//forgotten after the retention
+	engine.Evaluate(now.Add(2 * time.Hour))
+	assert.Equal(t, 0, len(engine.Alerts("")))
+}
+
+func TestRateRule(t *testing.T) {
+	rule := newTestRule(t, map[string]interface{}{
+		"id":        "indexing_failures",
+		"type":      "rate",
+		"metric":    "elasticsearch.node_stats",
+		"field":     "payload.failed",
+		"condition": map[string]interface{}{"range": map[string]interface{}{"rate": map[string]interface{}{"gt": 10}}},
+	})
+	engine := NewEngine([]*Rule{rule}, nil, false, time.Hour)
+
+	now := time.Now()
+	send := func(v int, ts time.Time) {
+		engine.OnEvent(&event.Event{Timestamp: ts, Metadata: event.EventMetadata{Category: "elasticsearch", Name: "node_stats"}, Fields: util.MapStr{"failed": v}})
+	}
+	send(0, now)
+	engine.Evaluate(now)
+	assert.Equal(t, 0, len(engine.Alerts("")))
+
+	send(100, now.Add(5*time.Second))
+	engine.Evaluate(now.Add(5 * time.Second))
+	alerts := engine.Alerts(StateFiring)
+	assert.Equal(t, 1, len(alerts))
+	assert.Equal(t, 20.0, *alerts[0].Value)
+
+	send(110, now.Add(10*time.Second))
+	engine.Evaluate(now.Add(10 * time.Second))
+	assert.Equal(t, 1, len(engine.Alerts(StateResolved)))
+}
+
+func TestAbsenceRuleAndSilence(t *testing.T) {
+	rule := newTestRule(t, map[string]interface{}{
+		"id":         "no_health",
+		"type":       "absence",
+		"metric":     "elasticsearch.cluster_health",
+		"group_by":   []string{"metadata.labels.cluster_id"},
+		"absent_for": "5m",
+	})
+	var notified []Alert
+	engine := NewEngine([]*Rule{rule}, func(alert Alert) { notified = append(notified, alert) }, false, time.Hour)
+
+	now := time.Now()
+	engine.OnEvent(healthEvent("c1", "green", now))
+	engine.OnEvent(healthEvent("c2", "green", now))
+	assert.NoError(t, engine.AddSilence(&Silence{RuleID: "no_health", Matchers: map[string]string{"cluster_id": "c2"}, StartsAt: now, EndsAt: now.Add(time.Hour)}))
+
+	engine.Evaluate(now.Add(time.Minute))
+	assert.Equal(t, 0, len(engine.Alerts("")))
+
+	engine.Evaluate(now.Add(6 * time.Minute))
+	assert.Equal(t, 2, len(engine.Alerts(StateFiring)))
+	assert.Equal(t, 1, len(notified))
+	assert.Equal(t, "c1", notified[0].Labels["cluster_id"])
+}
+
+func TestStaleSeries(t *testing.T) {
+	rule := newTestRule(t, map[string]interface{}{
+		"id":          "cluster_red",
+		"metric":      "elasticsearch.cluster_health",
+		"group_by":    []string{"metadata.labels.cluster_id"},
+		"condition":   map[string]interface{}{"equals": map[string]interface{}{"payload.elasticsearch.cluster_health.status": "red"}},
+		"stale_after": "5m",
+	})
+	engine := NewEngine([]*Rule{rule}, nil, false, time.Hour)
+
+	now := time.Now()
+	engine.OnEvent(healthEvent("c1", "red", now))
+	engine.OnEvent(healthEvent("c2", "green", now))
+	engine.Evaluate(now)
+	assert.Equal(t, 1, len(engine.Alerts(StateFiring)))
+
+	//the deleted cluster stops reporting, treated as no data
+	engine.OnEvent(healthEvent("c2", "green", now.Add(4*time.Minute)))
+	engine.Evaluate(now.Add(5 * time.Minute))
+	assert.Equal(t, 1, len(engine.Alerts(StateResolved)))
+	assert.Equal(t, 1, len(engine.series[rule.ID]))
+
+	engine.Evaluate(now.Add(10 * time.Minute))
+	assert.Equal(t, 0, len(engine.series[rule.ID]))
+}
+
+func TestInvalidRule(t *testing.T) {
+	_, err := NewRule(RuleConfig{ID: "a", Metric: "invalid", Condition: &conditions.Config{}})
+	assert.Error(t, err)
+	_, err = NewRule(RuleConfig{ID: "a", Metric: "elasticsearch.node_stats", Type: "absence"})
+	assert.Error(t, err)
+	_, err = NewRule(RuleConfig{ID: "a", Metric: "elasticsearch.node_stats", Type: "absence", AbsentFor: "5m", StaleAfter: "0s"})
+	assert.Error(t, err)
+}
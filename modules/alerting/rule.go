@@ -0,0 +1,220 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package alerting
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasttemplate"
+	"infini.sh/framework/core/conditions"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+)
+
+const (
+	RuleTypeThreshold = "threshold"
+	RuleTypeRate      = "rate"
+	RuleTypeAbsence   = "absence"
+)
+
+const defaultStaleAfter = 10 * time.Minute
+
+type RuleConfig struct {
+	ID       string `config:"id" json:"id"`
+	Name     string `config:"name" json:"name,omitempty"`
+	Disabled bool   `config:"disabled" json:"disabled,omitempty"`
+	//threshold, rate or absence
+	Type string `config:"type" json:"type"`
+
+	//category and name of the events, eg: elasticsearch.cluster_health
+	Metric string `config:"metric" json:"metric"`
+	//only the events matching the filter are tracked
+	Filter *conditions.Config `config:"filter" json:"-"`
+	//paths of the event to split the events into series, eg: metadata.labels.cluster_id
+	GroupBy []string `config:"group_by" json:"group_by,omitempty"`
+
+	//numeric field to compute the per second rate from, for the rate rules
+	Field string `config:"field" json:"field,omitempty"`
+	//checked against the latest event of the series, the rate rules expose
+	//the computed `rate` and `value` fields as well
+	Condition *conditions.Config `config:"condition" json:"-"`
+
+	//how long the condition must hold before firing
+	For string `config:"for" json:"for,omitempty"`
+	//how long the series must stay silent to fire, for the absence rules
+	AbsentFor string `config:"absent_for" json:"absent_for,omitempty"`
+	//series without events for this long are treated as no data and dropped,
+	//the absence rules drop the series this long after they went absent
+	StaleAfter string `config:"stale_after" json:"stale_after,omitempty"`
+
+	Severity string            `config:"severity" json:"severity,omitempty"`
+	Message  string            `config:"message" json:"message,omitempty"`
+	Labels   map[string]string `config:"labels" json:"labels,omitempty"`
+}
+
+type Rule struct {
+	RuleConfig
+	category   string
+	name       string
+	filter     conditions.Condition
+	condition  conditions.Condition
+	forDur     time.Duration
+	absentFor  time.Duration
+	staleAfter time.Duration
+	message    *fasttemplate.Template
+}
+
+func NewRule(cfg RuleConfig) (*Rule, error) {
+	if cfg.ID == "" {
+		return nil, errors.New("id of the rule can't be empty")
+	}
+	rule := &Rule{RuleConfig: cfg}
+	if rule.Name == "" {
+		rule.Name = rule.ID
+	}
+	if rule.Severity == "" {
+		rule.Severity = "warning"
+	}
+	if rule.Type == "" {
+		rule.Type = RuleTypeThreshold
+	}
+
+	parts := strings.SplitN(cfg.Metric, ".", 2)
+	if len(parts) != 2 {
+		return nil, errors.Errorf("invalid metric of rule [%v]: %v, should be category.name", cfg.ID, cfg.Metric)
+	}
+	rule.category, rule.name = parts[0], parts[1]
+
+	var err error
+	if cfg.Filter != nil {
+		rule.filter, err = conditions.NewCondition(cfg.Filter)
+		if err != nil {
+			return nil, errors.Errorf("invalid filter of rule [%v]: %v", cfg.ID, err)
+		}
+	}
+
+	switch rule.Type {
+	case RuleTypeThreshold, RuleTypeRate:
+		if cfg.Condition == nil {
+			return nil, errors.Errorf("condition of rule [%v] can't be empty", cfg.ID)
+		}
+		rule.condition, err = conditions.NewCondition(cfg.Condition)
+		if err != nil {
+			return nil, errors.Errorf("invalid condition of rule [%v]: %v", cfg.ID, err)
+		}
+		if rule.Type == RuleTypeRate && cfg.Field == "" {
+			return nil, errors.Errorf("field of rate rule [%v] can't be empty", cfg.ID)
+		}
+	case RuleTypeAbsence:
+		rule.absentFor, err = time.ParseDuration(cfg.AbsentFor)
+		if err != nil || rule.absentFor <= 0 {
+			return nil, errors.Errorf("invalid absent_for of rule [%v]: %v", cfg.ID, cfg.AbsentFor)
+		}
+	default:
+		return nil, errors.Errorf("invalid type of rule [%v]: %v", cfg.ID, cfg.Type)
+	}
+
+	if cfg.For != "" {
+		rule.forDur, err = time.ParseDuration(cfg.For)
+		if err != nil {
+			return nil, errors.Errorf("invalid for of rule [%v]: %v", cfg.ID, cfg.For)
+		}
+	}
+
+	rule.staleAfter = defaultStaleAfter
+	if cfg.StaleAfter != "" {
+		rule.staleAfter, err = time.ParseDuration(cfg.StaleAfter)
+		if err != nil || rule.staleAfter <= 0 {
+			return nil, errors.Errorf("invalid stale_after of rule [%v]: %v", cfg.ID, cfg.StaleAfter)
+		}
+	}
+
+	if cfg.Message != "" {
+		rule.message, err = fasttemplate.NewTemplate(cfg.Message, "$[[", "]]")
+		if err != nil {
+			return nil, errors.Errorf("invalid message of rule [%v]: %v", cfg.ID, err)
+		}
+	}
+	return rule, nil
+}
+
+func (rule *Rule) matches(category, name string) bool {
+	return rule.category == category && rule.name == name
+}
+
+// groupKey returns the key and the labels of the series the document belongs to
+func (rule *Rule) groupKey(doc util.MapStr) (string, map[string]string) {
+	labels := map[string]string{}
+	keys := make([]string, 0, len(rule.GroupBy))
+	for _, path := range rule.GroupBy {
+		v, _ := doc.GetValue(path)
+		s := util.ToString(v)
+		name := path[strings.LastIndex(path, ".")+1:]
+		labels[name] = s
+		keys = append(keys, name+"="+s)
+	}
+	return strings.Join(keys, ","), labels
+}
+
+// renderMessage fills the message with the variables of the alert, eg:
+// $[[labels.cluster_id]], $[[value]] or $[[event.payload.elasticsearch.cluster_health.status]]
+func (rule *Rule) renderMessage(vars util.MapStr) string {
+	if rule.message == nil {
+		return rule.Name
+	}
+	return rule.message.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
+		v, err := vars.GetValue(tag)
+		if err != nil || v == nil {
+			return w.Write([]byte("N/A"))
+		}
+		return w.Write([]byte(util.ToString(v)))
+	})
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case string:
+		f, err := strconv.ParseFloat(x, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func formatValue(v float64) string {
+	return fmt.Sprintf("%g", v)
+}
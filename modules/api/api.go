@@ -46,6 +46,7 @@ import (
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/host"
 	"infini.sh/framework/core/model"
+	"infini.sh/framework/core/module"
 	"infini.sh/framework/core/util"
 	"net/http"
 	"sort"
@@ -175,8 +176,12 @@ func (module *APIModule) Start() error {
 	return nil
 }
 
+func (*APIModule) ShutdownPhase() module.ShutdownPhase {
+	return module.PhaseIngress
+}
+
 func (module *APIModule) Stop() error {
-	return nil
+	return api.StopAPI(global.Env().SystemConfig.Shutdown.GetIngressTimeout())
 }
 
 type APIModule struct {
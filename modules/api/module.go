@@ -0,0 +1,111 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+package api
+
+import (
+	"net/http"
+
+	"infini.sh/framework/core/api"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/module"
+)
+
+func init() {
+	api.HandleAPIMethod(api.GET, "/_modules", listModulesAPIHandler)
+	api.HandleAPIMethod(api.GET, "/_modules/:name", getModuleAPIHandler)
+	api.HandleAPIMethod(api.POST, "/_modules/:name/_start", startModuleAPIHandler)
+	api.HandleAPIMethod(api.POST, "/_modules/:name/_stop", stopModuleAPIHandler)
+	api.HandleAPIMethod(api.POST, "/_modules/:name/_reload", reloadModuleAPIHandler)
+}
+
+func listModulesAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	api.DefaultAPI.WriteJSON(w, module.GetModuleStatus(), http.StatusOK)
+}
+
+func getModuleAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	status, err := module.GetModuleStatusByName(ps.MustGetParameter("name"))
+	if err != nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	api.DefaultAPI.WriteJSON(w, status, http.StatusOK)
+}
+
+func startModuleAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	writeModuleActionResult(w, ps.MustGetParameter("name"), module.StartModule(ps.MustGetParameter("name")))
+}
+
+func stopModuleAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	name := ps.MustGetParameter("name")
+	if isServingModule(w, name) {
+		return
+	}
+	writeModuleActionResult(w, name, module.StopModule(name))
+}
+
+// isServingModule refuses to stop or reload the api module through its own api, the
+// server would shutdown while serving the request, writes the error if refused
+func isServingModule(w http.ResponseWriter, name string) bool {
+	if name != (&APIModule{}).Name() {
+		return false
+	}
+	api.DefaultAPI.WriteError(w, "module [api] serves this request, it can't be stopped or reloaded through the api", http.StatusBadRequest)
+	return true
+}
+
+// reloadModuleAPIHandler restarts the module, the request body is the new config of this module, in json or yaml
+func reloadModuleAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	name := ps.MustGetParameter("name")
+	if isServingModule(w, name) {
+		return
+	}
+	body, err := api.DefaultAPI.GetRawBody(req)
+	if err != nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var cfg *config.Config
+	if len(body) > 0 {
+		cfg, err = config.NewConfigWithYAML(body, "api")
+		if err != nil {
+			api.DefaultAPI.WriteError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	writeModuleActionResult(w, name, module.ReloadModule(name, cfg))
+}
+
+func writeModuleActionResult(w http.ResponseWriter, name string, err error) {
+	status, statusErr := module.GetModuleStatusByName(name)
+	if statusErr != nil {
+		api.DefaultAPI.WriteError(w, statusErr.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.DefaultAPI.WriteAckJSON(w, false, http.StatusInternalServerError, map[string]interface{}{"error": err.Error(), "status": status})
+		return
+	}
+	api.DefaultAPI.WriteAckJSON(w, true, http.StatusOK, map[string]interface{}{"status": status})
+}
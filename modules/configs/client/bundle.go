@@ -0,0 +1,302 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package client
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+	config2 "infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/model"
+	"infini.sh/framework/core/module"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/modules/configs/common"
+	"infini.sh/framework/modules/configs/config"
+)
+
+const bundleBucketName = "config_bundles"
+
+var knownGoodKey = []byte("last_known_good")
+
+func bundleVersion(obj *common.ConfigSyncResponse) string {
+	if obj.Bundle != nil {
+		return obj.Bundle.Version
+	}
+	return ""
+}
+
+func rejectedBundleKey(obj *common.ConfigSyncResponse) []byte {
+	return []byte("rejected_" + hex.EncodeToString(common.BundleDigest(obj)))
+}
+
+// checkBundle verifies the signature and the syntax of the configs before applying
+func checkBundle(obj *common.ConfigSyncResponse) error {
+	cfg := global.Env().SystemConfig.Configs.Signature
+	if cfg.Required || (obj.Bundle != nil && obj.Bundle.Signature != "") {
+		if err := common.VerifyBundle(obj, cfg.TrustedKeys); err != nil {
+			return err
+		}
+	}
+
+	if exists, _ := kv.ExistsKey(bundleBucketName, rejectedBundleKey(obj)); exists {
+		return errors.Errorf("config bundle [%v] was rolled back before", bundleVersion(obj))
+	}
+
+	for _, files := range []map[string]common.ConfigFile{obj.Configs.CreatedConfigs, obj.Configs.UpdatedConfigs} {
+		for name, v := range files {
+			//templates are rendered later
+			if filepath.Ext(name) == ".tpl" {
+				continue
+			}
+			if _, err := config2.NewConfigWithYAML([]byte(v.Content), name); err != nil {
+				return errors.Errorf("invalid config [%v]: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// touchedModules returns the running modules whose config sections are changed
+// by the response, both the current and the new content of the files are checked,
+// a module is matched by the top-level section named after the module
+func touchedModules(obj *common.ConfigSyncResponse) []string {
+	contents := []string{}
+	cfgDir := global.Env().GetConfigDir()
+	for _, files := range []map[string]common.ConfigFile{obj.Configs.CreatedConfigs, obj.Configs.UpdatedConfigs, obj.Configs.DeletedConfigs} {
+		for name, v := range files {
+			if v.Name != "" {
+				name = v.Name
+			}
+			contents = append(contents, v.Content)
+			if c, err := util.FileGetContent(filepath.Join(cfgDir, name)); err == nil {
+				contents = append(contents, string(c))
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	result := []string{}
+	for _, content := range contents {
+		if content == "" {
+			continue
+		}
+		cfg, err := config2.NewConfigWithYAML([]byte(content), "bundle")
+		if err != nil {
+			continue
+		}
+		for _, key := range cfg.GetFields() {
+			key = strings.ToLower(key)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			status, err := module.GetModuleStatusByName(key)
+			if err != nil {
+				continue
+			}
+			if status.State == module.StateRunning || status.State == module.StateFailed {
+				result = append(result, status.Name)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// checkModules waits for the config watcher to apply the changes, returns the
+// modules failed or reporting unhealthy after the delay
+func checkModules(modules []string, delay time.Duration) map[string]string {
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	failed := map[string]string{}
+	for _, name := range modules {
+		status, err := module.GetModuleStatusByName(name)
+		if err != nil {
+			failed[name] = err.Error()
+		} else if status.State == module.StateFailed {
+			failed[name] = status.Error
+		} else if status.HealthError != "" {
+			failed[name] = status.HealthError
+		}
+	}
+	return failed
+}
+
+// saveKnownGood keeps the managed configs which are running well, as the target to rollback
+func saveKnownGood(configs common.ConfigList) error {
+	return kv.AddValue(bundleBucketName, knownGoodKey, util.MustToJSONBytes(configs.Configs))
+}
+
+func getKnownGood() (map[string]common.ConfigFile, error) {
+	data, err := kv.GetValue(bundleBucketName, knownGoodKey)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	configs := map[string]common.ConfigFile{}
+	err = util.FromJSONBytes(data, &configs)
+	return configs, err
+}
+
+// rollback restores the managed configs to the last known-good version
+func rollback() error {
+	knownGood, err := getKnownGood()
+	if err != nil {
+		return err
+	}
+	if knownGood == nil {
+		return errors.New("no known-good configs to rollback")
+	}
+
+	current := config.GetConfigs(false, true)
+	for name := range current.Configs {
+		if _, ok := knownGood[name]; !ok {
+			if err := config.DeleteConfig(name); err != nil {
+				return err
+			}
+		}
+	}
+	for name, v := range knownGood {
+		if err := config.SaveConfigStr(name, v.Content); err != nil {
+			return err
+		}
+	}
+	//refresh now, the failed modules are restarted with the restored configs
+	return global.Env().RefreshConfig()
+}
+
+var healthChecking int32
+
+// isHealthChecking returns true if the last applied change is still being checked,
+// config sync should wait for the result before applying new changes
+func isHealthChecking() bool {
+	return atomic.LoadInt32(&healthChecking) == 1
+}
+
+// checkHealthInBackground checks the health after applying the changes without
+// blocking the config sync, the report is sent to manager for the bundles
+func checkHealthInBackground(obj common.ConfigSyncResponse, modules []string) {
+	if !atomic.CompareAndSwapInt32(&healthChecking, 0, 1) {
+		log.Warn("config health check is already running, skip")
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&healthChecking, 0)
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error("error on checking health after config changes, ", r)
+			}
+		}()
+		report := checkHealthAfterApply(&obj, modules)
+		if obj.Bundle != nil {
+			reportToManager(report)
+		}
+	}()
+}
+
+// checkHealthAfterApply checks the modules touched by the change, and rollbacks
+// to the last known-good configs if any of them failed or turns unhealthy
+func checkHealthAfterApply(obj *common.ConfigSyncResponse, modules []string) *common.ConfigApplyReport {
+	report := &common.ConfigApplyReport{
+		Client:        model.GetInstanceInfo(),
+		BundleVersion: bundleVersion(obj),
+		Status:        common.BundleApplied,
+	}
+	if obj.Bundle != nil {
+		report.RolloutID = obj.Bundle.RolloutID
+	}
+
+	cfg := global.Env().SystemConfig.Configs.Rollback
+	if !cfg.Enabled {
+		return report
+	}
+
+	var failed map[string]string
+	if len(modules) > 0 {
+		log.Infof("checking modules %v after config changes", modules)
+		failed = checkModules(modules, util.GetDurationOrDefault(cfg.HealthCheckDelay, 10*time.Second))
+		for name, v := range failed {
+			report.FailedModules = append(report.FailedModules, name)
+			log.Errorf("module [%v] is unhealthy after config changes: %v", name, v)
+		}
+		sort.Strings(report.FailedModules)
+	}
+
+	if len(report.FailedModules) == 0 {
+		if err := saveKnownGood(config.GetConfigs(true, true)); err != nil {
+			log.Error(err)
+		}
+		return report
+	}
+
+	log.Warnf("rollback config bundle [%v] to the last known-good configs", report.BundleVersion)
+	report.Status = common.BundleRolledBack
+	if err := kv.AddValue(bundleBucketName, rejectedBundleKey(obj), []byte(util.GetLowPrecisionCurrentTime().String())); err != nil {
+		log.Error(err)
+	}
+	if err := rollback(); err != nil {
+		log.Error("failed to rollback configs, ", err)
+		report.Error = err.Error()
+		return report
+	}
+	//the failed modules are not restarted by the config watcher
+	for name := range failed {
+		if status, err := module.GetModuleStatusByName(name); err != nil || status.State != module.StateFailed {
+			continue
+		}
+		if err := module.ReloadModule(name, nil); err != nil {
+			log.Errorf("module [%v] is still failed after rollback: %v", name, err)
+		}
+	}
+	return report
+}
+
+// reportToManager reports the result of the bundle, the manager proceeds the
+// rollout to other nodes after the canary nodes reported
+func reportToManager(report *common.ConfigApplyReport) {
+	req := util.Request{Method: util.Verb_POST}
+	req.ContentType = "application/json"
+	req.Path = common.REPORT_API
+	req.Body = util.MustToJSONBytes(report)
+	_, res, err := submitRequestToManager(&req)
+	if err != nil {
+		log.Error("failed to report config bundle to manager, ", err)
+		return
+	}
+	if res != nil && res.StatusCode != 200 {
+		log.Warnf("failed to report config bundle to manager, status: %v, %v", res.StatusCode, string(res.Body))
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/module"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/modules/configs/common"
+)
+
+type memoryKV struct {
+	lock sync.Mutex
+	data map[string][]byte
+}
+
+func (s *memoryKV) Open() error  { return nil }
+func (s *memoryKV) Close() error { return nil }
+func (s *memoryKV) GetValue(bucket string, key []byte) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.data[bucket+"/"+string(key)], nil
+}
+func (s *memoryKV) GetCompressedValue(bucket string, key []byte) ([]byte, error) {
+	return s.GetValue(bucket, key)
+}
+func (s *memoryKV) AddValueCompress(bucket string, key []byte, value []byte) error {
+	return s.AddValue(bucket, key, value)
+}
+func (s *memoryKV) AddValue(bucket string, key []byte, value []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data[bucket+"/"+string(key)] = value
+	return nil
+}
+func (s *memoryKV) ExistsKey(bucket string, key []byte) (bool, error) {
+	v, _ := s.GetValue(bucket, key)
+	return v != nil, nil
+}
+func (s *memoryKV) DeleteKey(bucket string, key []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.data, bucket+"/"+string(key))
+	return nil
+}
+
+// bundleTestModule fails to start when the config is not valid
+type bundleTestModule struct {
+	valid bool
+}
+
+func (m *bundleTestModule) Name() string {
+	return "bundle_test"
+}
+
+func (m *bundleTestModule) Setup() {
+	cfg := struct {
+		Valid bool `config:"valid"`
+	}{}
+	env.ParseConfig("bundle_test", &cfg)
+	m.valid = cfg.Valid
+}
+
+func (m *bundleTestModule) Start() error {
+	if !m.valid {
+		return errors.New("invalid config")
+	}
+	return nil
+}
+
+func (m *bundleTestModule) Stop() error {
+	return nil
+}
+
+func TestRollbackBadConfig(t *testing.T) {
+	dir := t.TempDir()
+	cfgDir := filepath.Join(dir, "config")
+	assert.NoError(t, os.MkdirAll(cfgDir, 0755))
+	mainFile := filepath.Join(dir, "app.yml")
+	assert.NoError(t, os.WriteFile(mainFile, []byte("path.configs: "+cfgDir+"\n"+
+		"configs.rollback.enabled: true\n"+
+		"configs.rollback.health_check_delay: 10ms\n"), 0644))
+	good := "bundle_test:\n  valid: true\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(cfgDir, "bundle_test.yml"), []byte(good), 0644))
+
+	e := env.EmptyEnv()
+	e.SystemConfig.PathConfig.Data = filepath.Join(dir, "data")
+	e.SetConfigFile(mainFile)
+	global.RegisterEnv(e)
+	assert.NoError(t, e.RefreshConfig())
+
+	kv.Register("bundle_test", &memoryKV{data: map[string][]byte{}})
+	module.RegisterUserPlugin(&bundleTestModule{})
+	assert.NoError(t, module.StartModule("bundle_test"))
+
+	//a change doesn't touch any module is kept
+	obj := common.ConfigSyncResponse{Changed: true}
+	obj.Configs.CreatedConfigs = map[string]common.ConfigFile{"other.yml": {Name: "other.yml", Content: "other:\n  key: value\n"}}
+	assert.Equal(t, []string{}, touchedModules(&obj))
+	report := checkHealthAfterApply(&obj, touchedModules(&obj))
+	assert.Equal(t, common.BundleApplied, report.Status)
+
+	//the module fails with the new config, rollback to the last known-good one
+	obj = common.ConfigSyncResponse{Changed: true, Bundle: &common.BundleInfo{Version: "v2"}}
+	obj.Configs.UpdatedConfigs = map[string]common.ConfigFile{"bundle_test.yml": {Name: "bundle_test.yml", Content: "bundle_test:\n  valid: false\n"}}
+	touched := touchedModules(&obj)
+	assert.Equal(t, []string{"bundle_test"}, touched)
+	_, err := util.FilePutContent(filepath.Join(cfgDir, "bundle_test.yml"), obj.Configs.UpdatedConfigs["bundle_test.yml"].Content)
+	assert.NoError(t, err)
+	//applied by the config watcher
+	assert.NoError(t, e.RefreshConfig())
+	assert.Error(t, module.ReloadModule("bundle_test", nil))
+
+	report = checkHealthAfterApply(&obj, touched)
+	assert.Equal(t, common.BundleRolledBack, report.Status)
+	assert.Equal(t, []string{"bundle_test"}, report.FailedModules)
+	assert.Equal(t, "", report.Error)
+
+	content, err := util.FileGetContent(filepath.Join(cfgDir, "bundle_test.yml"))
+	assert.NoError(t, err)
+	assert.Equal(t, good, string(content))
+	status, err := module.GetModuleStatusByName("bundle_test")
+	assert.NoError(t, err)
+	assert.Equal(t, module.StateRunning, status.State)
+
+	//the rolled back bundle is rejected next time
+	assert.Error(t, checkBundle(&obj))
+}
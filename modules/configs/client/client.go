@@ -141,6 +141,12 @@ func ListenConfigChanges() error {
 					log.Trace("fetch configs from manger")
 				}
 
+				//wait for the last applied changes to be checked
+				if isHealthChecking() {
+					log.Debug("config health check is running, skip config sync")
+					return
+				}
+
 				cfgs := config.GetConfigs(false, false)
 				req.Configs = cfgs
 				req.Hash = util.MD5digestString(util.MustToJSONBytes(cfgs))
@@ -174,6 +180,32 @@ func ListenConfigChanges() error {
 
 					if obj.Changed {
 
+						if err := checkBundle(&obj); err != nil {
+							log.Error("reject config bundle from manager, ", err)
+							if obj.Bundle != nil {
+								reportToManager(&common.ConfigApplyReport{
+									Client:        req.Client,
+									BundleVersion: obj.Bundle.Version,
+									RolloutID:     obj.Bundle.RolloutID,
+									Status:        common.BundleRejected,
+									Error:         err.Error(),
+								})
+							}
+							return
+						}
+
+						//keep the current configs as the rollback target if there is none
+						var touched []string
+						rollbackEnabled := obj.Bundle != nil && global.Env().SystemConfig.Configs.Rollback.Enabled
+						if rollbackEnabled {
+							touched = touchedModules(&obj)
+							if knownGood, err := getKnownGood(); err == nil && knownGood == nil {
+								if err := saveKnownGood(config.GetConfigs(true, true)); err != nil {
+									log.Error(err)
+								}
+							}
+						}
+
 						//update secrets //TODO client send salt to manager first, manager encrypt secrets with salt and send back
 						if obj.Secrets != nil {
 							for k, v := range obj.Secrets.Keystore {
@@ -239,6 +271,11 @@ func ListenConfigChanges() error {
 								}
 							}
 						}
+
+						//the changes are applied by the config watcher, only the bundles are checked and reported
+						if obj.Bundle != nil {
+							checkHealthInBackground(obj, touched)
+						}
 					}
 				}
 
@@ -0,0 +1,145 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package common
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+
+	"infini.sh/framework/core/errors"
+)
+
+func sha256Hex(str string) string {
+	sum := sha256.Sum256([]byte(str))
+	return hex.EncodeToString(sum[:])
+}
+
+func sortedKeys(m map[string]ConfigFile) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// BundleDigest returns the digest of the changes in the response, which is signed
+// by the manager, the content is hashed so the digest doesn't depend on the json encoding
+func BundleDigest(res *ConfigSyncResponse) []byte {
+	buf := strings.Builder{}
+	if res.Bundle != nil {
+		buf.WriteString(fmt.Sprintf("version\n%s\n", res.Bundle.Version))
+	}
+	for _, k := range sortedKeys(res.Configs.CreatedConfigs) {
+		v := res.Configs.CreatedConfigs[k]
+		buf.WriteString(fmt.Sprintf("created\n%s\n%s\n%s\n%d\n", k, v.Name, sha256Hex(v.Content), v.Version))
+	}
+	for _, k := range sortedKeys(res.Configs.UpdatedConfigs) {
+		v := res.Configs.UpdatedConfigs[k]
+		buf.WriteString(fmt.Sprintf("updated\n%s\n%s\n%s\n%d\n", k, v.Name, sha256Hex(v.Content), v.Version))
+	}
+	for _, k := range sortedKeys(res.Configs.DeletedConfigs) {
+		buf.WriteString(fmt.Sprintf("deleted\n%s\n%s\n", k, res.Configs.DeletedConfigs[k].Name))
+	}
+	if res.Secrets != nil {
+		keys := make([]string, 0, len(res.Secrets.Keystore))
+		for k := range res.Secrets.Keystore {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v := res.Secrets.Keystore[k]
+			buf.WriteString(fmt.Sprintf("secret\n%s\n%s\n%s\n", k, v.Type, sha256Hex(v.Value)))
+		}
+	}
+	sum := sha256.Sum256([]byte(buf.String()))
+	return sum[:]
+}
+
+// SignBundle signs the bundle with the private key of the manager
+func SignBundle(res *ConfigSyncResponse, key ed25519.PrivateKey) {
+	if res.Bundle == nil {
+		res.Bundle = &BundleInfo{}
+	}
+	res.Bundle.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, BundleDigest(res)))
+}
+
+// ParsePublicKey parses the ed25519 public key, in PEM or base64 encoded raw bytes
+func ParsePublicKey(str string) (ed25519.PublicKey, error) {
+	str = strings.TrimSpace(str)
+	if block, _ := pem.Decode([]byte(str)); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := key.(ed25519.PublicKey); ok {
+			return v, nil
+		}
+		return nil, errors.New("only ed25519 public key is supported")
+	}
+	bytes, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("invalid ed25519 public key size: %v", len(bytes))
+	}
+	return ed25519.PublicKey(bytes), nil
+}
+
+// VerifyBundle verifies the signature of the bundle against the trusted keys
+func VerifyBundle(res *ConfigSyncResponse, trustedKeys []string) error {
+	if res.Bundle == nil || res.Bundle.Signature == "" {
+		return errors.New("config bundle is not signed")
+	}
+	if len(trustedKeys) == 0 {
+		return errors.New("no trusted key to verify the config bundle")
+	}
+	signature, err := base64.StdEncoding.DecodeString(res.Bundle.Signature)
+	if err != nil {
+		return errors.Errorf("invalid signature of config bundle: %v", err)
+	}
+	digest := BundleDigest(res)
+	for _, v := range trustedKeys {
+		key, err := ParsePublicKey(v)
+		if err != nil {
+			return errors.Errorf("invalid trusted key: %v", err)
+		}
+		if ed25519.Verify(key, digest, signature) {
+			return nil
+		}
+	}
+	return errors.Errorf("signature of config bundle [%v] was not trusted", res.Bundle.Version)
+}
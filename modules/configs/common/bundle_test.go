@@ -0,0 +1,91 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package common
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBundle() *ConfigSyncResponse {
+	res := &ConfigSyncResponse{Changed: true, Bundle: &BundleInfo{Version: "v2", Canary: true}}
+	res.Configs.CreatedConfigs = map[string]ConfigFile{"a.yml": {Name: "a.yml", Content: "a: 1", Version: 2}}
+	res.Configs.UpdatedConfigs = map[string]ConfigFile{"b.yml": {Name: "b.yml", Content: "b: 1", Version: 2}}
+	res.Configs.DeletedConfigs = map[string]ConfigFile{"c.yml": {Name: "c.yml"}}
+	res.Secrets = &Secrets{Keystore: map[string]KeystoreValue{"pwd": {Type: "plaintext", Value: "changeme"}}}
+	return res
+}
+
+func TestSignAndVerifyBundle(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	trusted := base64.StdEncoding.EncodeToString(pub)
+
+	res := newTestBundle()
+	assert.NotNil(t, VerifyBundle(res, []string{trusted}))
+
+	SignBundle(res, priv)
+	assert.Nil(t, VerifyBundle(res, []string{base64.StdEncoding.EncodeToString(otherPub), trusted}))
+	assert.NotNil(t, VerifyBundle(res, []string{base64.StdEncoding.EncodeToString(otherPub)}))
+	assert.NotNil(t, VerifyBundle(res, nil))
+
+	//pem encoded key
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	assert.Nil(t, err)
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	assert.Nil(t, VerifyBundle(res, []string{pemKey}))
+
+	//tampered content, version and secrets
+	tampered := newTestBundle()
+	tampered.Bundle.Signature = res.Bundle.Signature
+	tampered.Configs.UpdatedConfigs["b.yml"] = ConfigFile{Name: "b.yml", Content: "b: 2", Version: 2}
+	assert.NotNil(t, VerifyBundle(tampered, []string{trusted}))
+
+	tampered = newTestBundle()
+	tampered.Bundle.Signature = res.Bundle.Signature
+	tampered.Bundle.Version = "v3"
+	assert.NotNil(t, VerifyBundle(tampered, []string{trusted}))
+
+	tampered = newTestBundle()
+	tampered.Bundle.Signature = res.Bundle.Signature
+	tampered.Secrets.Keystore["pwd"] = KeystoreValue{Type: "plaintext", Value: "other"}
+	assert.NotNil(t, VerifyBundle(tampered, []string{trusted}))
+
+	//rollout info is not part of the digest
+	same := newTestBundle()
+	same.Bundle.Signature = res.Bundle.Signature
+	same.Bundle.Canary = false
+	assert.Nil(t, VerifyBundle(same, []string{trusted}))
+}
@@ -31,6 +31,7 @@ import "infini.sh/framework/core/model"
 
 const REGISTER_API = "/instance/_register"
 const SYNC_API = "/configs/_sync"
+const REPORT_API = "/configs/_report"
 
 type ConfigFile struct {
 	Name     string `json:"name,omitempty"`
@@ -57,6 +58,20 @@ type ConfigUpdateRequest struct {
 	Configs map[string]string `json:"configs"`
 }
 
+// ConfigRenderRequest previews the template, either the content or the name of the
+// config file is required, the variables are merged with the environments under `env`
+type ConfigRenderRequest struct {
+	Name     string                 `json:"name,omitempty"`
+	Content  string                 `json:"content,omitempty"`
+	Variable map[string]interface{} `json:"variable,omitempty"`
+}
+
+type ConfigRenderResponse struct {
+	Content string `json:"content"`
+	Valid   bool   `json:"valid"`
+	Error   string `json:"error,omitempty"`
+}
+
 type ConfigSyncRequest struct {
 	ForceSync bool           `json:"force_sync"` //ignore hash check in server
 	Hash      string         `json:"hash"`
@@ -73,6 +88,32 @@ type ConfigSyncResponse struct {
 	} `json:"configs,omitempty"`
 
 	Secrets *Secrets `json:"secrets,omitempty"`
+
+	Bundle *BundleInfo `json:"bundle,omitempty"`
+}
+
+// BundleInfo describes the changes in the sync response as a versioned bundle
+type BundleInfo struct {
+	Version   string `json:"version"`
+	Signature string `json:"signature,omitempty"`  //base64 encoded ed25519 signature of the bundle digest
+	RolloutID string `json:"rollout_id,omitempty"` //the rollout this bundle belongs to
+	Canary    bool   `json:"canary,omitempty"`     //manager waits for the report before rolling out to other nodes
+}
+
+const (
+	BundleApplied    = "applied"
+	BundleRolledBack = "rolled_back"
+	BundleRejected   = "rejected"
+)
+
+// ConfigApplyReport is reported to the manager after the bundle was handled
+type ConfigApplyReport struct {
+	Client        model.Instance `json:"client"`
+	BundleVersion string         `json:"bundle_version"`
+	RolloutID     string         `json:"rollout_id,omitempty"`
+	Status        string         `json:"status"`
+	Error         string         `json:"error,omitempty"`
+	FailedModules []string       `json:"failed_modules,omitempty"`
 }
 
 type ResourceGroup struct {
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	log "github.com/cihub/seelog"
 	"infini.sh/framework/core/api"
+	"infini.sh/framework/core/config"
 	httprouter "infini.sh/framework/core/api/router"
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/errors"
@@ -28,6 +29,7 @@ func init() {
 	api.HandleAPIMethod(api.PUT, "/config/", saveConfigAction)
 	api.HandleAPIMethod(api.DELETE, "/config/", deleteConfigAction)
 	api.HandleAPIMethod(api.POST, "/config/_reload", reloadConfigAction)
+	api.HandleAPIMethod(api.POST, "/config/_render", renderConfigAction)
 	api.HandleAPIMethod(api.GET, "/config/runtime", getConfigAction)
 	api.HandleAPIMethod(api.GET, "/environments", getEnvAction)
 
@@ -322,6 +324,74 @@ func  saveConfigAction(w http.ResponseWriter, req *http.Request, params httprout
 	api.DefaultAPI.WriteAckOKJSON(w)
 }
 
+// RenderConfig renders the template in the config dir, the result is checked as yaml,
+// the files are included relative to the dir of the named file, as on loading
+func RenderConfig(request common.ConfigRenderRequest) (*common.ConfigRenderResponse, error) {
+	cfgDir, err := filepath.Abs(global.Env().GetConfigDir())
+	if err != nil {
+		return nil, err
+	}
+
+	baseDir := cfgDir
+	content := request.Content
+	if request.Name != "" {
+		name := path.Join(cfgDir, request.Name)
+		if err := validateFile(cfgDir, name); err != nil {
+			return nil, err
+		}
+		c, err := util.FileGetContent(name)
+		if err != nil {
+			return nil, err
+		}
+		content = string(c)
+		baseDir = filepath.Dir(name)
+	}
+	if content == "" {
+		return nil, errors.New("content or name is required")
+	}
+
+	variables := util.MapStr{}
+	if configFile := global.Env().GetConfigFile(); util.FileExists(configFile) {
+		envs, err := config.LoadEnvVariables(configFile)
+		if err != nil {
+			return nil, err
+		}
+		variables["env"] = envs
+	}
+	for k, v := range request.Variable {
+		variables[k] = v
+	}
+
+	result := &common.ConfigRenderResponse{}
+	result.Content, err = config.RenderTemplate(content, variables, baseDir)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	if _, err := config.NewConfigWithYAML([]byte(result.Content), "render"); err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	result.Valid = true
+	return result, nil
+}
+
+func renderConfigAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	reqBody := common.ConfigRenderRequest{}
+	err := api.DefaultAPI.DecodeJSON(req, &reqBody)
+	if err != nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := RenderConfig(reqBody)
+	if err != nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	api.DefaultAPI.WriteJSON(w, result, http.StatusOK)
+}
+
 func  reloadConfigAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	log.Infof("refresh config")
 	err := global.Env().RefreshConfig()
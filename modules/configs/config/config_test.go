@@ -7,6 +7,11 @@ package config
 import (
 	"fmt"
 	"github.com/magiconair/properties/assert"
+	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/modules/configs/common"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -41,3 +46,26 @@ func TestVersion(t *testing.T) {
 	ver=parseConfigVersion("what's the version, i think is 1234")
 	assert.Equal(t, ver, int64(-1))
 }
+
+func TestRenderConfigIncludeRelativeToFile(t *testing.T) {
+	dir := t.TempDir()
+	e := env.EmptyEnv()
+	e.SystemConfig.PathConfig.Config = dir
+	global.RegisterEnv(e)
+
+	os.MkdirAll(filepath.Join(dir, "pipelines"), 0755)
+	os.WriteFile(filepath.Join(dir, "pipelines", "main.yml"), []byte("$[[include \"output.yml\"]]\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "pipelines", "output.yml"), []byte("output: pipelines\n"), 0644)
+
+	//included relative to the named file, as on loading
+	result, err := RenderConfig(common.ConfigRenderRequest{Name: "pipelines/main.yml"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, result.Valid, true)
+	assert.Equal(t, result.Content, "output: pipelines\n")
+
+	//the content is rendered relative to the config dir
+	os.WriteFile(filepath.Join(dir, "output.yml"), []byte("output: root\n"), 0644)
+	result, err = RenderConfig(common.ConfigRenderRequest{Content: "$[[include \"output.yml\"]]\n"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, result.Content, "output: root\n")
+}
@@ -125,6 +125,14 @@ func (c *ESAPIV0) GetMajorVersion() int {
 
 const TypeName0 = "doc"
 
+func getRequestHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
 func (c *ESAPIV0) Request(ctx context.Context, method, url string, body []byte) (result *util.Result, err error) {
 
 	if global.Env().IsDebug {
@@ -190,12 +198,21 @@ func (c *ESAPIV0) Request(ctx context.Context, method, url string, body []byte)
 		}(req)
 	}
 
-	resp, err := util.ExecuteRequest(req)
-	if err != nil {
-		return resp, err
+	//fail fast if the breaker of this host is open
+	host := getRequestHost(url)
+	breaker := elastic.GetHostCircuitBreaker(host)
+	if breaker == nil {
+		return util.ExecuteRequest(req)
 	}
 
-	return resp, err
+	allowed := breaker.Execute(func() bool {
+		result, err = util.ExecuteRequest(req)
+		return err == nil && result != nil && result.StatusCode < 500
+	})
+	if !allowed {
+		return nil, errors.Errorf("circuit breaker of host [%v] is open", host)
+	}
+	return result, err
 }
 
 func (c *ESAPIV0) InitDefaultTemplate(templateName, indexPrefix string) {
@@ -482,19 +499,20 @@ func (c *ESAPIV0) Search(indexName string, query *elastic.SearchRequest) (*elast
 func (c *ESAPIV0) QueryDSL(ctx context.Context, indexName string, queryArgs *[]util.KV, queryDSL []byte) (*elastic.SearchResponse, error) {
 	indexName = util.UrlEncode(indexName)
 
-	url := c.GetEndpoint() + "/" + indexName + "/_search"
+	path := "/" + indexName + "/_search"
 
 	if queryArgs != nil && len(*queryArgs) > 0 {
 		str := strings.Builder{}
-		str.WriteString(url)
+		str.WriteString(path)
 		str.WriteString("?")
 		for _, v := range *queryArgs {
 			str.WriteString(v.Key)
 			str.WriteString("=")
 			str.WriteString(v.Value)
 		}
-		url = str.String()
+		path = str.String()
 	}
+	url := c.GetEndpoint() + path
 
 	esResp := &elastic.SearchResponse{}
 
@@ -502,7 +520,17 @@ func (c *ESAPIV0) QueryDSL(ctx context.Context, indexName string, queryArgs *[]u
 		log.Trace("search: ", url, ",", string(queryDSL))
 	}
 
-	resp, err := c.Request(ctx, util.Verb_POST, url, queryDSL)
+	var resp *util.Result
+	var err error
+	metadata := c.GetMetadata()
+	if hedger := elastic.GetHedgedRequester(metadata.Config.ID, metadata.Config.HedgedRequest); hedger != nil {
+		//send the search to another node if the first one is too slow
+		resp, err = hedger.Do(ctx, metadata.GetHedgedHosts(), func(ctx context.Context, host string) (*util.Result, error) {
+			return c.Request(ctx, util.Verb_POST, metadata.PrepareEndpoint(host)+path, queryDSL)
+		})
+	} else {
+		resp, err = c.Request(ctx, util.Verb_POST, url, queryDSL)
+	}
 	if resp != nil {
 		esResp.StatusCode = resp.StatusCode
 		esResp.RawResult = resp
@@ -573,6 +601,22 @@ func (c *ESAPIV0) GetNodesStats(nodeID, host string, level string) *elastic.Node
 		url = fmt.Sprintf("%s/_nodes/%v/stats%v", c.GetActivePreferredEndpoint(host), nodeID, suffix)
 	}
 
+	return c.getNodesStats(url)
+}
+
+func (c *ESAPIV0) GetNodesThreadPoolStats(pools ...string) *elastic.NodesStats {
+	paths := make([]string, 0, len(pools))
+	for _, pool := range pools {
+		paths = append(paths, "nodes.*.thread_pool."+pool)
+	}
+	url := fmt.Sprintf("%s/_nodes/stats/thread_pool", c.GetEndpoint())
+	if len(paths) > 0 {
+		url = url + "?filter_path=" + strings.Join(paths, ",")
+	}
+	return c.getNodesStats(url)
+}
+
+func (c *ESAPIV0) getNodesStats(url string) *elastic.NodesStats {
 	resp, err := c.Request(nil, util.Verb_GET, url, nil)
 
 	obj := &elastic.NodesStats{}
@@ -28,6 +28,7 @@
 package elastic
 
 import (
+	"fmt"
 	"infini.sh/framework/core/api"
 	httprouter "infini.sh/framework/core/api/router"
 	"infini.sh/framework/core/elastic"
@@ -38,6 +39,8 @@ import (
 func init() {
 	api.HandleAPIMethod(api.GET, "/elasticsearch/metadata", GetMetadata)
 	api.HandleAPIMethod(api.GET, "/elasticsearch/hosts", GetHosts)
+	api.HandleAPIMethod(api.GET, "/elasticsearch/orm/_schema/_migration", GetSchemaMigrations)
+	api.HandleAPIMethod(api.POST, "/elasticsearch/orm/_schema/:index/_migrate", MigrateSchema)
 }
 
 func GetMetadata(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
@@ -85,7 +88,7 @@ func GetHosts(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 
 		v, ok := value.(*elastic.NodeAvailable)
 		if ok {
-			result[k] = util.MapStr{
+			host := util.MapStr{
 				"host":            v.Host,
 				"available":       v.IsAvailable(),
 				"dead":            v.IsDead(),
@@ -93,10 +96,58 @@ func GetHosts(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 				"last_success":    v.LastSuccess(),
 				"failure_tickets": v.FailureTickets(),
 			}
+			if breaker := v.GetCircuitBreaker(); breaker != nil {
+				host["circuit_breaker"] = breaker.Stats()
+			}
+			result[k] = host
 		}
 		return true
 	})
 
 	api.DefaultAPI.WriteJSON(w, result, http.StatusOK)
 
-}
\ No newline at end of file
+}
+
+// GetSchemaMigrations returns the migration plans of all registered schemas, nothing will be changed
+func GetSchemaMigrations(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if ormHandler == nil {
+		api.DefaultAPI.WriteError(w, "elastic orm is not enabled", http.StatusNotFound)
+		return
+	}
+	plans, err := ormHandler.PlanAllSchemaMigrations()
+	if err != nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	api.DefaultAPI.WriteJSON(w, plans, http.StatusOK)
+}
+
+// MigrateSchema migrates the schema of the index, use dry_run=true to preview the plan
+func MigrateSchema(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if ormHandler == nil {
+		api.DefaultAPI.WriteError(w, "elastic orm is not enabled", http.StatusNotFound)
+		return
+	}
+	indexName := ps.MustGetParameter("index")
+	t, ok := schemas.Load(indexName)
+	if !ok {
+		api.DefaultAPI.WriteError(w, fmt.Sprintf("schema of index [%v] not found", indexName), http.StatusNotFound)
+		return
+	}
+
+	cfg := ormHandler.Config.SchemaMigration
+	cfg.DryRun = api.DefaultAPI.GetParameterOrDefault(req, "dry_run", "false") == "true"
+	if v := api.DefaultAPI.GetParameter(req, "allow_reindex"); v != "" {
+		cfg.AllowReindex = v == "true"
+	}
+	plan, err := ormHandler.MigrateSchema(t, cfg)
+	if err != nil && plan == nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusInternalServerError
+	}
+	api.DefaultAPI.WriteJSON(w, plan, status)
+}
@@ -52,6 +52,18 @@ type ORMConfig struct {
 
 	IndexTemplates  map[string]string `config:"index_templates"`  //template_name -> template_content
 	SearchTemplates map[string]string `config:"search_templates"` //template_name -> template_content
+
+	SchemaMigration SchemaMigrationConfig `config:"schema_migration"`
+}
+
+// SchemaMigrationConfig controls how existing indices are migrated when the
+// mapping derived from a registered schema changes
+type SchemaMigrationConfig struct {
+	Enabled        bool   `config:"enabled"`         //check and migrate existing indices on schema registration
+	DryRun         bool   `config:"dry_run"`         //only log the migration plan
+	AllowReindex   bool   `config:"allow_reindex"`   //reindex to a new index when the mapping change is incompatible
+	KeepOldIndex   bool   `config:"keep_old_index"`  //keep the previous index after the alias was swapped
+	ReindexTimeout string `config:"reindex_timeout"` //max time to wait for the reindex task, default 30m
 }
 
 type StoreConfig struct {
@@ -68,6 +68,7 @@ func (module *ElasticModule) clusterHealthCheck(clusterID string, force bool) {
 		client := elastic.GetClient(cfg.ID)
 		//check cluster health status
 		health, err := client.ClusterHealth(nil)
+		dispatchClusterAvailability(clusterID, health, err)
 		if err != nil || health == nil || health.StatusCode != 200 {
 			if health != nil && util.ContainStr(util.UnsafeBytesToString(health.RawResult.Body), "master_not_discovered_exception") {
 				metadata.ReportFailure(errors.New("master_not_discovered_exception"))
@@ -100,6 +101,36 @@ func (module *ElasticModule) clusterHealthCheck(clusterID string, force bool) {
 	}
 }
 
+// dispatchClusterAvailability exposes the result of the health check to the
+// event listeners, eg: alerting rules
+func dispatchClusterAvailability(clusterID string, health *elastic.ClusterHealth, err error) {
+	availability := util.MapStr{
+		"available": err == nil && health != nil && health.StatusCode == 200,
+	}
+	if health != nil {
+		availability["status"] = health.Status
+	}
+	if err != nil {
+		availability["error"] = err.Error()
+	}
+	event.Dispatch(&event.Event{
+		Timestamp: time.Now(),
+		Metadata: event.EventMetadata{
+			Category: "elasticsearch",
+			Name:     "cluster_availability",
+			Datatype: "snapshot",
+			Labels: util.MapStr{
+				"cluster_id": clusterID,
+			},
+		},
+		Fields: util.MapStr{
+			"elasticsearch": util.MapStr{
+				"cluster_availability": availability,
+			},
+		},
+	})
+}
+
 func updateClusterHealthStatus(clusterID string, healthStatus string) {
 
 	globalID := global.MustLookupString(elastic.GlobalSystemElasticsearchID)
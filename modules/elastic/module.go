@@ -41,6 +41,7 @@ import (
 	"infini.sh/framework/core/credential"
 	"infini.sh/framework/core/elastic"
 	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/event"
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/kv"
@@ -87,6 +88,40 @@ var (
 	}
 )
 
+func init() {
+	config.RegisterSchema("elasticsearch", func() interface{} {
+		return &[]elastic.ElasticsearchConfig{}
+	}, validateElasticConfig)
+	config.RegisterSchema("elastic", func() interface{} {
+		v := getDefaultConfig()
+		return &v
+	})
+}
+
+// validateElasticConfig checks the required settings of the enabled clusters
+func validateElasticConfig(cfg *config.Config) error {
+	configs := []elastic.ElasticsearchConfig{}
+	if err := cfg.Unpack(&configs); err != nil {
+		return err
+	}
+	for i, v := range configs {
+		if !v.Enabled {
+			continue
+		}
+		if v.Name == "" && v.ID == "" {
+			return errors.Errorf("elasticsearch [%v]: name or id is required", i)
+		}
+		if v.Endpoint == "" && len(v.Endpoints) == 0 {
+			name := v.Name
+			if name == "" {
+				name = v.ID
+			}
+			return errors.Errorf("elasticsearch [%v]: endpoint is required", name)
+		}
+	}
+	return nil
+}
+
 func getDefaultConfig() common.ModuleConfig {
 	return defaultConfig
 }
@@ -387,12 +422,14 @@ func InitSchema() {
 }
 
 var ormInited bool
+var ormHandler *ElasticORM
 
 func (module *ElasticModule) Start() error {
 
 	if moduleConfig.ORMConfig.Enabled {
 		client := elastic.GetClient(global.MustLookupString(elastic.GlobalSystemElasticsearchID))
 		handler := ElasticORM{Client: client, Config: moduleConfig.ORMConfig}
+		ormHandler = &handler
 		orm.Register("elastic", &handler)
 	}
 
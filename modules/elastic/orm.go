@@ -262,23 +262,30 @@ func getQuery(c1 *api.Cond) interface{} {
 		q := elastic.RangeQuery{}
 		q.Lte(c1.Field, c1.Value)
 		return q
+	case api.Term, api.Prefix, api.Wildcard:
+		return util.MapStr{
+			string(c1.QueryType): util.MapStr{
+				c1.Field: c1.Value,
+			},
+		}
+	case api.Exists:
+		return util.MapStr{
+			"exists": util.MapStr{
+				"field": c1.Field,
+			},
+		}
+	case api.BoolGroup:
+		return util.MapStr{
+			"bool": buildBoolQuery(c1.Conds),
+		}
 	}
-	panic(errors.Errorf("invalid query: %s", c1))
+	panic(errors.Errorf("invalid query: %v", c1))
 }
 
 func (handler *ElasticORM) Search(t interface{}, q *api.Query) (error, api.Result) {
 
 	var err error
 
-	request := elastic.SearchRequest{}
-
-	request.From = q.From
-	request.Size = q.Size
-
-	if q.CollapseField != "" {
-		request.Collapse = &elastic.Collapse{Field: q.CollapseField}
-	}
-
 	var searchResponse *elastic.SearchResponse
 	result := api.Result{}
 
@@ -295,39 +302,12 @@ func (handler *ElasticORM) Search(t interface{}, q *api.Query) (error, api.Resul
 	}else if q.TemplatedQuery!=nil{
 		searchResponse, err =handler.Client.SearchByTemplate(indexName,q.TemplatedQuery.TemplateID,q.TemplatedQuery.Parameters)
 	} else {
-
-		if q.Conds != nil && len(q.Conds) > 0 {
-			request.Query = &elastic.Query{}
-
-			boolQuery := elastic.BoolQuery{}
-
-			for _, c1 := range q.Conds {
-				q := getQuery(c1)
-				switch c1.BoolType {
-				case api.Must:
-					boolQuery.Must = append(boolQuery.Must, q)
-					break
-				case api.MustNot:
-					boolQuery.MustNot = append(boolQuery.MustNot, q)
-					break
-				case api.Should:
-					boolQuery.Should = append(boolQuery.Should, q)
-					break
-				}
-
-			}
-
-			request.Query.BoolQuery = &boolQuery
-
-		}
-
-		if q.Sort != nil && len(*q.Sort) > 0 {
-			for _, i := range *q.Sort {
-				request.AddSort(i.Field, string(i.SortType))
-			}
+		var request *elastic.SearchRequest
+		request, err = buildSearchRequest(q, getDSLFeatures(handler.Client.GetVersion()))
+		if err != nil {
+			return err, result
 		}
-
-		searchResponse, err = handler.Client.Search(indexName, &request)
+		searchResponse, err = handler.Client.SearchWithRawQueryDSL(indexName, []byte(request.ToJSONString()))
 	}
 
 	if err != nil {
@@ -338,12 +318,27 @@ func (handler *ElasticORM) Search(t interface{}, q *api.Query) (error, api.Resul
 
 	//TODO remove
 	for _, doc := range searchResponse.Hits.Hits {
+		if doc.Source == nil {
+			doc.Source = map[string]interface{}{}
+		}
 		if _, ok := doc.Source["id"]; !ok {
 			doc.Source["id"] = doc.ID
 		}
 		array = append(array, doc.Source)
 	}
 
+	if len(searchResponse.Hits.Hits) > 0 {
+		result.Cursor = searchResponse.Hits.Hits[len(searchResponse.Hits.Hits)-1].Sort
+	}
+
+	if len(q.Aggregations) > 0 && searchResponse.RawResult != nil {
+		aggs, err := getAggregationsFromResponse(searchResponse.RawResult.Body)
+		if err != nil {
+			return err, result
+		}
+		result.Aggregations = parseAggregations(q.Aggregations, aggs)
+	}
+
 	result.Result = array
 	result.Raw = searchResponse.RawResult.Body
 	result.Total = searchResponse.GetTotal() //TODO improve performance
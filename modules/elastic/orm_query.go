@@ -0,0 +1,268 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package elastic
+
+import (
+	"strings"
+
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/errors"
+	api "infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+// dslFeatures describes the query DSL differences between versions
+type dslFeatures struct {
+	// search_after was added in elasticsearch 5.0
+	searchAfter bool
+	// _source `includes`/`excludes` were named `include`/`exclude` before 5.0
+	sourceIncludes bool
+	// `calendar_interval`/`fixed_interval` replaced `interval` of date_histogram in 7.2
+	calendarInterval bool
+}
+
+func getDSLFeatures(v elastic.Version) dslFeatures {
+	if v.Distribution != "" && v.Distribution != elastic.Elasticsearch {
+		//easysearch and opensearch are compatible with elasticsearch 7.10
+		return dslFeatures{searchAfter: true, sourceIncludes: true, calendarInterval: true}
+	}
+
+	ver, err := util.ParseGeneric(v.Number)
+	if err != nil {
+		return dslFeatures{searchAfter: v.Major >= 5, sourceIncludes: v.Major >= 5, calendarInterval: v.Major >= 8}
+	}
+	return dslFeatures{
+		searchAfter:      ver.Major() >= 5,
+		sourceIncludes:   ver.Major() >= 5,
+		calendarInterval: ver.AtLeast(util.MustParseGeneric("7.2")),
+	}
+}
+
+func buildBoolQuery(conds []*api.Cond) *elastic.BoolQuery {
+	boolQuery := elastic.BoolQuery{}
+	for _, c1 := range conds {
+		q := getQuery(c1)
+		switch c1.BoolType {
+		case api.Must:
+			boolQuery.Must = append(boolQuery.Must, q)
+		case api.MustNot:
+			boolQuery.MustNot = append(boolQuery.MustNot, q)
+		case api.Should:
+			boolQuery.Should = append(boolQuery.Should, q)
+		case api.Filter:
+			boolQuery.Filter = append(boolQuery.Filter, q)
+		}
+	}
+	return &boolQuery
+}
+
+var calendarIntervals = []string{"1m", "1h", "1d", "1w", "1M", "1q", "1y",
+	"minute", "hour", "day", "week", "month", "quarter", "year"}
+
+func buildAggregations(aggs []*api.Aggregation, features dslFeatures) util.MapStr {
+	result := util.MapStr{}
+	for _, agg := range aggs {
+		body := util.MapStr{
+			"field": agg.Field,
+		}
+		switch agg.Type {
+		case api.TermsAggregation:
+			if agg.Size > 0 {
+				body["size"] = agg.Size
+			}
+			if agg.MinDocCount > 0 {
+				body["min_doc_count"] = agg.MinDocCount
+			}
+		case api.DateHistogramAggregation:
+			intervalKey := "interval"
+			if features.calendarInterval {
+				if util.StringInArray(calendarIntervals, agg.Interval) {
+					intervalKey = "calendar_interval"
+				} else {
+					intervalKey = "fixed_interval"
+				}
+			}
+			body[intervalKey] = agg.Interval
+			if agg.TimeZone != "" {
+				body["time_zone"] = agg.TimeZone
+			}
+			body["min_doc_count"] = agg.MinDocCount
+		}
+
+		item := util.MapStr{
+			string(agg.Type): body,
+		}
+		if len(agg.Aggregations) > 0 {
+			item["aggs"] = buildAggregations(agg.Aggregations, features)
+		}
+		result[agg.Name] = item
+	}
+	return result
+}
+
+func buildSource(q *api.Query, features dslFeatures) interface{} {
+	if len(q.IncludeFields) == 0 && len(q.ExcludeFields) == 0 {
+		return nil
+	}
+	includesKey, excludesKey := "includes", "excludes"
+	if !features.sourceIncludes {
+		includesKey, excludesKey = "include", "exclude"
+	}
+	source := util.MapStr{}
+	if len(q.IncludeFields) > 0 {
+		source[includesKey] = q.IncludeFields
+	}
+	if len(q.ExcludeFields) > 0 {
+		source[excludesKey] = q.ExcludeFields
+	}
+	return source
+}
+
+// buildSearchRequest translates the ORM query into the search request of specify version
+func buildSearchRequest(q *api.Query, features dslFeatures) (*elastic.SearchRequest, error) {
+	request := elastic.SearchRequest{}
+	request.From = q.From
+	request.Size = q.Size
+	if request.Size <= 0 {
+		if len(q.Aggregations) > 0 {
+			//only aggregations are needed
+			request.Size = 0
+		} else {
+			request.Size = 10
+		}
+	}
+
+	if q.CollapseField != "" {
+		request.Collapse = &elastic.Collapse{Field: q.CollapseField}
+	}
+
+	if len(q.Conds) > 0 {
+		request.Query = &elastic.Query{}
+		request.Query.BoolQuery = buildBoolQuery(q.Conds)
+	}
+
+	hasIDSort := false
+	if q.Sort != nil && len(*q.Sort) > 0 {
+		for _, i := range *q.Sort {
+			request.AddSort(i.Field, string(i.SortType))
+			if i.Field == "id" {
+				hasIDSort = true
+			}
+		}
+	}
+
+	if q.SearchAfter != nil {
+		if !features.searchAfter {
+			return nil, errors.New("search_after is not supported by this version")
+		}
+		//sort by id to break the tie, make sure the cursor is unique
+		if !hasIDSort {
+			request.AddSort("id", string(api.ASC))
+		}
+		request.From = 0
+		if len(*q.SearchAfter) > 0 {
+			request.Set("search_after", *q.SearchAfter)
+		}
+	}
+
+	request.Source = buildSource(q, features)
+
+	if len(q.Aggregations) > 0 {
+		request.Set("aggs", buildAggregations(q.Aggregations, features))
+	}
+
+	return &request, nil
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	f, err := util.ExtractFloat(v)
+	return f, err == nil
+}
+
+func toInt64(v interface{}) int64 {
+	if v == nil {
+		return 0
+	}
+	return util.GetInt64Value(v)
+}
+
+func parseAggregations(aggs []*api.Aggregation, raw map[string]interface{}) map[string]api.AggregationResult {
+	result := map[string]api.AggregationResult{}
+	for _, agg := range aggs {
+		v, ok := raw[agg.Name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		item := api.AggregationResult{}
+		switch agg.Type {
+		case api.TermsAggregation, api.DateHistogramAggregation:
+			buckets, _ := v["buckets"].([]interface{})
+			item.Buckets = make([]api.AggregationBucket, 0, len(buckets))
+			for _, b := range buckets {
+				bucketM, ok := b.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				bucket := api.AggregationBucket{
+					Key:      bucketM["key"],
+					DocCount: toInt64(bucketM["doc_count"]),
+				}
+				if keyStr, ok := bucketM["key_as_string"].(string); ok {
+					bucket.KeyAsString = keyStr
+				}
+				if len(agg.Aggregations) > 0 {
+					bucket.Aggregations = parseAggregations(agg.Aggregations, bucketM)
+				}
+				item.Buckets = append(item.Buckets, bucket)
+			}
+		case api.StatsAggregation:
+			stats := api.StatsResult{Count: toInt64(v["count"])}
+			stats.Min, _ = toFloat(v["min"])
+			stats.Max, _ = toFloat(v["max"])
+			stats.Avg, _ = toFloat(v["avg"])
+			stats.Sum, _ = toFloat(v["sum"])
+			item.Stats = &stats
+		default:
+			if f, ok := toFloat(v["value"]); ok {
+				item.Value = &f
+			}
+		}
+		result[agg.Name] = item
+	}
+	return result
+}
+
+func getAggregationsFromResponse(body []byte) (map[string]interface{}, error) {
+	if len(body) == 0 || !strings.Contains(string(body), "aggregations") {
+		return nil, nil
+	}
+	resp := struct {
+		Aggregations map[string]interface{} `json:"aggregations"`
+	}{}
+	err := util.FromJSONBytes(body, &resp)
+	return resp.Aggregations, err
+}
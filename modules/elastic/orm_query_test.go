@@ -0,0 +1,72 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package elastic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/elastic"
+	api "infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+func TestBuildSearchRequest(t *testing.T) {
+	q := api.Query{}
+	q.Conds = api.And(api.Eq("type", "a"), api.Group(api.Or(api.TermEq("b", 1), api.ExistsField("c"))...))
+	q.AddAggregation(api.DateHistogramAgg("by_day", "created", "1d").AddAggregation(api.StatsAgg("latency", "took")))
+	q.Select("id", "name").After(nil)
+
+	request, err := buildSearchRequest(&q, getDSLFeatures(elastic.Version{Number: "7.10.2", Major: 7}))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"_source":{"includes":["id","name"]},"aggs":{"by_day":{"aggs":{"latency":{"stats":{"field":"took"}}},"date_histogram":{"calendar_interval":"1d","field":"created","min_doc_count":0}}},"from":0,"query":{"bool":{"must":[{"match":{"type":"a"}},{"bool":{"should":[{"term":{"b":1}},{"exists":{"field":"c"}}]}}]}},"size":0,"sort":[{"id":{"order":"asc"}}]}`, request.ToJSONString())
+
+	request, err = buildSearchRequest(&q, getDSLFeatures(elastic.Version{Number: "6.8.0", Major: 6}))
+	assert.Nil(t, err)
+	assert.Contains(t, request.ToJSONString(), `"interval":"1d"`)
+
+	_, err = buildSearchRequest(&q, getDSLFeatures(elastic.Version{Number: "2.4.6", Major: 2}))
+	assert.NotNil(t, err)
+}
+
+func TestParseAggregations(t *testing.T) {
+	aggs := []*api.Aggregation{
+		api.TermsAgg("by_type", "type", 10).AddAggregation(api.MetricAgg("avg_took", api.AvgAggregation, "took")),
+		api.StatsAgg("latency", "took"),
+	}
+	raw := map[string]interface{}{}
+	util.MustFromJSONBytes([]byte(`{"by_type":{"buckets":[{"key":"a","doc_count":3,"avg_took":{"value":1.5}}]},"latency":{"count":3,"min":1,"max":2,"avg":1.5,"sum":4.5}}`), &raw)
+
+	result := parseAggregations(aggs, raw)
+	assert.Equal(t, 1, len(result["by_type"].Buckets))
+	assert.Equal(t, "a", result["by_type"].Buckets[0].Key)
+	assert.Equal(t, int64(3), result["by_type"].Buckets[0].DocCount)
+	assert.Equal(t, 1.5, *result["by_type"].Buckets[0].Aggregations["avg_took"].Value)
+	assert.Equal(t, int64(3), result["latency"].Stats.Count)
+	assert.Equal(t, 4.5, result["latency"].Stats.Sum)
+}
@@ -125,6 +125,8 @@ func (handler *ElasticORM) RegisterSchemaWithIndexName(t interface{},indexName s
 
 	log.Trace("indexName: ", indexName)
 
+	schemas.Store(indexName, t)
+
 	exist, err := handler.Client.IndexExists(indexName)
 	if err != nil {
 		return err
@@ -135,15 +137,15 @@ func (handler *ElasticORM) RegisterSchemaWithIndexName(t interface{},indexName s
 			return err
 		}
 
-		jsonFormat := `{ %s }`
-		mapping := getIndexMapping(t)
-
-		js := parseAnnotation(mapping)
-		json := fmt.Sprintf(jsonFormat, quoteJson(js))
+		properties, err := getSchemaProperties(t)
+		if err != nil {
+			return err
+		}
+		json := buildSchemaMapping(t, 1, getSchemaHash(properties))
 
-		log.Trace(indexName,", mapping: ", json)
+		log.Trace(indexName,", mapping: ", string(json))
 
-		data, err := handler.Client.UpdateMapping(indexName, "", []byte(json))
+		data, err := handler.Client.UpdateMapping(indexName, "", json)
 		if err != nil {
 			return err
 		}
@@ -153,6 +155,16 @@ func (handler *ElasticORM) RegisterSchemaWithIndexName(t interface{},indexName s
 		}else{
 			log.Debugf("schema %v successful initialized", indexName)
 		}
+	}else if handler.Config.SchemaMigration.Enabled{
+		plan, err := handler.MigrateSchema(t, handler.Config.SchemaMigration)
+		if plan != nil && plan.Action != SchemaActionNone {
+			log.Infof("schema migration plan of [%v]: %v", indexName, util.MustToJSON(plan))
+		}
+		if err == errReindexNotAllowed {
+			log.Errorf("schema of [%v] can't be migrated: %v", indexName, err)
+			return nil
+		}
+		return err
 	}
 	return err
 }
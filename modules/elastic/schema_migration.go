@@ -0,0 +1,494 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package elastic
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/modules/elastic/common"
+)
+
+const (
+	SchemaActionNone          = "none"
+	SchemaActionCreate        = "create"
+	SchemaActionUpdateMapping = "update_mapping"
+	SchemaActionReindex       = "reindex"
+)
+
+// version and fingerprint of the schema are tracked in the _meta of the index mapping
+const schemaVersionKey = "schema_version"
+const schemaHashKey = "schema_hash"
+
+const defaultReindexTimeout = 30 * time.Minute
+
+// mapping parameters which can't be changed on an existing field
+var immutableMappingParams = []string{"type", "analyzer", "normalizer", "index", "doc_values", "store", "format"}
+
+var errReindexNotAllowed = errors.New("incompatible mapping changes require reindex, but reindex is not allowed")
+
+// registered schemas, index name -> schema object
+var schemas = sync.Map{}
+
+type MappingConflict struct {
+	Field    string      `json:"field"`
+	Property string      `json:"property"`
+	Current  interface{} `json:"current"`
+	Target   interface{} `json:"target"`
+}
+
+type SchemaMigrationPlan struct {
+	IndexName      string            `json:"index_name"`
+	PhysicalIndex  string            `json:"physical_index,omitempty"`
+	TargetIndex    string            `json:"target_index,omitempty"`
+	CurrentVersion int               `json:"current_version"`
+	TargetVersion  int               `json:"target_version"`
+	CurrentHash    string            `json:"current_hash,omitempty"`
+	TargetHash     string            `json:"target_hash"`
+	Action         string            `json:"action"`
+	AddedFields    []string          `json:"added_fields,omitempty"`
+	RemovedFields  []string          `json:"removed_fields,omitempty"`
+	Conflicts      []MappingConflict `json:"conflicts,omitempty"`
+	Steps          []string          `json:"steps,omitempty"`
+	DryRun         bool              `json:"dry_run"`
+	Executed       bool              `json:"executed"`
+	Error          string            `json:"error,omitempty"`
+}
+
+func getSchemaProperties(t interface{}) (map[string]interface{}, error) {
+	js := fmt.Sprintf(`{ %s }`, quoteJson(parseAnnotation(getIndexMapping(t))))
+	mapping := map[string]interface{}{}
+	err := util.FromJSONBytes([]byte(js), &mapping)
+	if err != nil {
+		return nil, errors.Errorf("invalid mapping: %v, %v", js, err)
+	}
+	properties, _ := mapping["properties"].(map[string]interface{})
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+	return properties, nil
+}
+
+func getSchemaHash(properties map[string]interface{}) string {
+	return util.MD5digestString(util.MustToJSONBytes(properties))
+}
+
+// buildSchemaMapping keeps the raw mapping generated from the tags and stamps the schema version into _meta
+func buildSchemaMapping(t interface{}, version int, hash string) []byte {
+	meta := util.MustToJSON(util.MapStr{schemaVersionKey: version, schemaHashKey: hash})
+	return []byte(fmt.Sprintf(`{ "_meta":%s, %s }`, meta, quoteJson(parseAnnotation(getIndexMapping(t)))))
+}
+
+// extractTypeMapping unwraps the mapping type used before elasticsearch 7.0
+func extractTypeMapping(mappings map[string]interface{}) map[string]interface{} {
+	if _, ok := mappings["properties"]; ok {
+		return mappings
+	}
+	if _, ok := mappings["_meta"]; ok {
+		return mappings
+	}
+	for k, v := range mappings {
+		if k == "_default_" {
+			continue
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			return m
+		}
+	}
+	return mappings
+}
+
+func getFieldType(field map[string]interface{}) string {
+	if v, ok := field["type"]; ok {
+		return fmt.Sprintf("%v", v)
+	}
+	return "object"
+}
+
+// diffMappingProperties walks through the target properties, new fields are
+// compatible, changes to immutable parameters of existing fields are conflicts
+func diffMappingProperties(current, target map[string]interface{}, prefix string, plan *SchemaMigrationPlan) {
+	for name, v := range target {
+		path := prefix + name
+		targetField, _ := v.(map[string]interface{})
+		currentField, ok := current[name].(map[string]interface{})
+		if !ok {
+			plan.AddedFields = append(plan.AddedFields, path)
+			continue
+		}
+		if targetField == nil {
+			continue
+		}
+
+		if getFieldType(currentField) != getFieldType(targetField) {
+			plan.Conflicts = append(plan.Conflicts, MappingConflict{Field: path, Property: "type",
+				Current: getFieldType(currentField), Target: getFieldType(targetField)})
+		} else {
+			for _, param := range immutableMappingParams[1:] {
+				tv, ok := targetField[param]
+				if !ok {
+					continue
+				}
+				cv, ok := currentField[param]
+				if ok && fmt.Sprintf("%v", cv) == fmt.Sprintf("%v", tv) {
+					continue
+				}
+				plan.Conflicts = append(plan.Conflicts, MappingConflict{Field: path, Property: param, Current: cv, Target: tv})
+			}
+		}
+
+		for _, key := range []string{"properties", "fields"} {
+			targetChildren, _ := targetField[key].(map[string]interface{})
+			if targetChildren == nil {
+				continue
+			}
+			currentChildren, _ := currentField[key].(map[string]interface{})
+			if currentChildren == nil {
+				currentChildren = map[string]interface{}{}
+			}
+			diffMappingProperties(currentChildren, targetChildren, path+".", plan)
+		}
+	}
+
+	for name, v := range current {
+		if _, ok := target[name]; !ok {
+			plan.RemovedFields = append(plan.RemovedFields, prefix+name)
+			continue
+		}
+		currentField, _ := v.(map[string]interface{})
+		targetField, _ := target[name].(map[string]interface{})
+		if currentField == nil || targetField == nil {
+			continue
+		}
+		for _, key := range []string{"properties", "fields"} {
+			currentChildren, _ := currentField[key].(map[string]interface{})
+			if currentChildren == nil {
+				continue
+			}
+			if _, ok := targetField[key].(map[string]interface{}); !ok {
+				for child := range currentChildren {
+					plan.RemovedFields = append(plan.RemovedFields, prefix+name+"."+child)
+				}
+			}
+		}
+	}
+}
+
+// getLiveMapping returns the concrete index behind the name and its mapping
+func (handler *ElasticORM) getLiveMapping(indexName string) (string, map[string]interface{}, error) {
+	_, count, result, err := handler.Client.GetMapping(false, indexName)
+	if err != nil {
+		return "", nil, err
+	}
+	if count != 1 || result == nil {
+		return "", nil, errors.Errorf("index [%v] resolved to %v indices", indexName, count)
+	}
+	for physical, v := range *result {
+		idx, _ := v.(map[string]interface{})
+		mappings, _ := idx["mappings"].(map[string]interface{})
+		if mappings == nil {
+			mappings = map[string]interface{}{}
+		}
+		return physical, extractTypeMapping(mappings), nil
+	}
+	return "", nil, errors.Errorf("mapping of index [%v] not found", indexName)
+}
+
+// PlanSchemaMigration compares the mapping derived from the schema with the
+// live mapping of the index, and works out what needs to be done
+func (handler *ElasticORM) PlanSchemaMigration(t interface{}) (*SchemaMigrationPlan, error) {
+	indexName := handler.GetIndexName(t)
+	properties, err := getSchemaProperties(t)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &SchemaMigrationPlan{IndexName: indexName, TargetHash: getSchemaHash(properties), DryRun: true}
+
+	exist, err := handler.Client.IndexExists(indexName)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		plan.Action = SchemaActionCreate
+		plan.TargetVersion = 1
+		plan.TargetIndex = indexName
+		plan.Steps = []string{fmt.Sprintf("create index [%v] with schema version 1", indexName)}
+		return plan, nil
+	}
+
+	physical, mapping, err := handler.getLiveMapping(indexName)
+	if err != nil {
+		return nil, err
+	}
+	plan.PhysicalIndex = physical
+
+	if meta, ok := mapping["_meta"].(map[string]interface{}); ok {
+		if v, ok := meta[schemaVersionKey]; ok {
+			ver, _ := util.ExtractInt(v)
+			plan.CurrentVersion = int(ver)
+		}
+		plan.CurrentHash, _ = meta[schemaHashKey].(string)
+	}
+
+	if plan.CurrentHash == plan.TargetHash {
+		plan.Action = SchemaActionNone
+		plan.TargetVersion = plan.CurrentVersion
+		return plan, nil
+	}
+	plan.TargetVersion = plan.CurrentVersion + 1
+
+	current, _ := mapping["properties"].(map[string]interface{})
+	if current == nil {
+		current = map[string]interface{}{}
+	}
+	diffMappingProperties(current, properties, "", plan)
+	sort.Strings(plan.AddedFields)
+	sort.Strings(plan.RemovedFields)
+	sort.Slice(plan.Conflicts, func(i, j int) bool {
+		if plan.Conflicts[i].Field == plan.Conflicts[j].Field {
+			return plan.Conflicts[i].Property < plan.Conflicts[j].Property
+		}
+		return plan.Conflicts[i].Field < plan.Conflicts[j].Field
+	})
+
+	if len(plan.Conflicts) == 0 {
+		plan.Action = SchemaActionUpdateMapping
+		plan.TargetIndex = physical
+		plan.Steps = []string{fmt.Sprintf("update mapping of index [%v] to schema version %v", physical, plan.TargetVersion)}
+		return plan, nil
+	}
+
+	plan.Action = SchemaActionReindex
+	plan.TargetIndex = fmt.Sprintf("%s-v%d", indexName, plan.TargetVersion)
+	plan.Steps = []string{
+		fmt.Sprintf("create index [%v] with schema version %v", plan.TargetIndex, plan.TargetVersion),
+		fmt.Sprintf("reindex documents from [%v] to [%v]", physical, plan.TargetIndex),
+		fmt.Sprintf("point alias [%v] to [%v]", indexName, plan.TargetIndex),
+	}
+	if physical == indexName {
+		plan.Steps = append(plan.Steps, fmt.Sprintf("remove index [%v]", physical))
+	} else if !handler.Config.SchemaMigration.KeepOldIndex {
+		plan.Steps = append(plan.Steps, fmt.Sprintf("delete index [%v]", physical))
+	}
+	return plan, nil
+}
+
+// MigrateSchema plans and applies the schema migration, nothing is changed in dry-run mode,
+// writes to the index should be paused while the documents are being reindexed
+func (handler *ElasticORM) MigrateSchema(t interface{}, cfg common.SchemaMigrationConfig) (*SchemaMigrationPlan, error) {
+	plan, err := handler.PlanSchemaMigration(t)
+	if err != nil {
+		return nil, err
+	}
+	plan.DryRun = cfg.DryRun
+	if plan.Action == SchemaActionNone || cfg.DryRun {
+		return plan, nil
+	}
+
+	if plan.Action == SchemaActionReindex && !cfg.AllowReindex {
+		plan.Error = errReindexNotAllowed.Error()
+		return plan, errReindexNotAllowed
+	}
+
+	mapping := buildSchemaMapping(t, plan.TargetVersion, plan.TargetHash)
+	switch plan.Action {
+	case SchemaActionCreate:
+		err = handler.Client.CreateIndex(plan.TargetIndex, nil)
+		if err == nil {
+			err = handler.putSchemaMapping(plan.TargetIndex, mapping)
+		}
+	case SchemaActionUpdateMapping:
+		err = handler.putSchemaMapping(plan.TargetIndex, mapping)
+	case SchemaActionReindex:
+		err = handler.reindexAndSwap(plan, mapping, cfg)
+	}
+	if err != nil {
+		plan.Error = err.Error()
+		return plan, err
+	}
+	plan.Executed = true
+	log.Infof("schema of [%v] migrated to version %v, action: %v", plan.IndexName, plan.TargetVersion, plan.Action)
+	return plan, nil
+}
+
+func (handler *ElasticORM) putSchemaMapping(indexName string, mapping []byte) error {
+	log.Trace(indexName, ", mapping: ", string(mapping))
+	data, err := handler.Client.UpdateMapping(indexName, "", mapping)
+	if err != nil {
+		return err
+	}
+	x, _, _, _ := jsonparser.Get(data, "error")
+	if x != nil {
+		return errors.Errorf("error on update mapping: %v, %v", indexName, string(x))
+	}
+	return nil
+}
+
+func (handler *ElasticORM) reindexAndSwap(plan *SchemaMigrationPlan, mapping []byte, cfg common.SchemaMigrationConfig) error {
+	exist, err := handler.Client.IndexExists(plan.TargetIndex)
+	if err != nil {
+		return err
+	}
+	if exist {
+		return errors.Errorf("target index [%v] already exists", plan.TargetIndex)
+	}
+
+	err = handler.Client.CreateIndex(plan.TargetIndex, nil)
+	if err != nil {
+		return err
+	}
+	err = handler.putSchemaMapping(plan.TargetIndex, mapping)
+	if err != nil {
+		return err
+	}
+
+	body := util.MustToJSONBytes(util.MapStr{
+		"source": util.MapStr{"index": plan.PhysicalIndex},
+		"dest":   util.MapStr{"index": plan.TargetIndex},
+	})
+	res, err := handler.Client.Reindex(body)
+	if err != nil {
+		return err
+	}
+	if res.Task == "" {
+		return errors.Errorf("failed to start reindex from [%v] to [%v]", plan.PhysicalIndex, plan.TargetIndex)
+	}
+	log.Infof("reindex from [%v] to [%v] started, task: %v", plan.PhysicalIndex, plan.TargetIndex, res.Task)
+
+	timeout := defaultReindexTimeout
+	if cfg.ReindexTimeout != "" {
+		timeout, err = time.ParseDuration(cfg.ReindexTimeout)
+		if err != nil {
+			return err
+		}
+	}
+	err = handler.waitForReindexTask(res.Task, timeout)
+	if err != nil {
+		return err
+	}
+
+	err = handler.Client.Refresh(plan.TargetIndex)
+	if err != nil {
+		return err
+	}
+	sourceCount, err := handler.Client.Count(context.Background(), plan.PhysicalIndex, nil)
+	if err != nil {
+		return err
+	}
+	targetCount, err := handler.Client.Count(context.Background(), plan.TargetIndex, nil)
+	if err != nil {
+		return err
+	}
+	if targetCount.Count < sourceCount.Count {
+		return errors.Errorf("reindex incomplete, [%v] has %v docs, [%v] has %v docs",
+			plan.PhysicalIndex, sourceCount.Count, plan.TargetIndex, targetCount.Count)
+	}
+
+	var actions []util.MapStr
+	if plan.PhysicalIndex == plan.IndexName {
+		//the alias takes over the name of the concrete index
+		actions = []util.MapStr{
+			{"add": util.MapStr{"index": plan.TargetIndex, "alias": plan.IndexName}},
+			{"remove_index": util.MapStr{"index": plan.PhysicalIndex}},
+		}
+	} else {
+		actions = []util.MapStr{
+			{"remove": util.MapStr{"index": plan.PhysicalIndex, "alias": plan.IndexName}},
+			{"add": util.MapStr{"index": plan.TargetIndex, "alias": plan.IndexName}},
+		}
+	}
+	err = handler.Client.Alias(util.MustToJSONBytes(util.MapStr{"actions": actions}))
+	if err != nil {
+		return err
+	}
+
+	if plan.PhysicalIndex != plan.IndexName && !cfg.KeepOldIndex {
+		return handler.Client.DeleteIndex(plan.PhysicalIndex)
+	}
+	return nil
+}
+
+func (handler *ElasticORM) waitForReindexTask(taskID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if global.ShuttingDown() {
+			return errors.Errorf("reindex task [%v] interrupted by shutdown", taskID)
+		}
+
+		res, err := handler.Client.SearchTasksByIds([]string{taskID})
+		if err == nil && res != nil && len(res.Hits.Hits) > 0 {
+			task := util.MapStr(res.Hits.Hits[0].Source)
+			if completed, ok := task["completed"].(bool); ok && completed {
+				if e, ok := task["error"]; ok && e != nil {
+					return errors.Errorf("reindex task [%v] failed: %v", taskID, util.MustToJSON(e))
+				}
+				failures, _ := task.GetValue("response.failures")
+				if v, ok := failures.([]interface{}); ok && len(v) > 0 {
+					return errors.Errorf("reindex task [%v] has %v failures: %v", taskID, len(v), util.MustToJSON(v[0]))
+				}
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return errors.Errorf("reindex task [%v] not completed in %v", taskID, timeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// PlanAllSchemaMigrations works out the migrations of all registered schemas
+func (handler *ElasticORM) PlanAllSchemaMigrations() ([]*SchemaMigrationPlan, error) {
+	var plans []*SchemaMigrationPlan
+	var err error
+	schemas.Range(func(key, value interface{}) bool {
+		var plan *SchemaMigrationPlan
+		plan, err = handler.PlanSchemaMigration(value)
+		if err != nil {
+			return false
+		}
+		plans = append(plans, plan)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].IndexName < plans[j].IndexName
+	})
+	return plans, nil
+}
@@ -0,0 +1,114 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package elastic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/util"
+)
+
+type schemaV1 struct {
+	Name  string `json:"name" elastic_mapping:"name: { type: keyword }"`
+	Count int    `json:"count" elastic_mapping:"count: { type: integer }"`
+}
+
+type schemaV2 struct {
+	Name  string `json:"name" elastic_mapping:"name: { type: keyword }"`
+	Count int    `json:"count" elastic_mapping:"count: { type: integer }"`
+	Tags  string `json:"tags" elastic_mapping:"tags: { type: keyword }"`
+}
+
+type schemaV3 struct {
+	Name  string `json:"name" elastic_mapping:"name: { type: text, analyzer: standard }"`
+	Count int    `json:"count" elastic_mapping:"count: { type: long }"`
+}
+
+func TestGetSchemaProperties(t *testing.T) {
+	v1, err := getSchemaProperties(schemaV1{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"type": "keyword"}, v1["name"])
+
+	v2, err := getSchemaProperties(schemaV2{})
+	assert.NoError(t, err)
+	assert.NotEqual(t, getSchemaHash(v1), getSchemaHash(v2))
+
+	again, _ := getSchemaProperties(schemaV1{})
+	assert.Equal(t, getSchemaHash(v1), getSchemaHash(again))
+
+	mapping := map[string]interface{}{}
+	assert.NoError(t, util.FromJSONBytes(buildSchemaMapping(schemaV1{}, 2, getSchemaHash(v1)), &mapping))
+	meta := mapping["_meta"].(map[string]interface{})
+	assert.Equal(t, float64(2), meta[schemaVersionKey])
+	assert.Equal(t, getSchemaHash(v1), meta[schemaHashKey])
+	assert.Equal(t, v1, mapping["properties"])
+}
+
+func TestDiffMappingProperties(t *testing.T) {
+	//live mapping as returned by elasticsearch
+	current := map[string]interface{}{
+		"name":  map[string]interface{}{"type": "keyword"},
+		"count": map[string]interface{}{"type": "integer"},
+		"old":   map[string]interface{}{"type": "keyword"},
+		"user": map[string]interface{}{"properties": map[string]interface{}{
+			"id": map[string]interface{}{"type": "keyword"},
+		}},
+	}
+
+	v2, _ := getSchemaProperties(schemaV2{})
+	v2["user"] = map[string]interface{}{"properties": map[string]interface{}{
+		"id":   map[string]interface{}{"type": "keyword"},
+		"name": map[string]interface{}{"type": "keyword"},
+	}}
+	plan := &SchemaMigrationPlan{}
+	diffMappingProperties(current, v2, "", plan)
+	assert.ElementsMatch(t, []string{"tags", "user.name"}, plan.AddedFields)
+	assert.Equal(t, []string{"old"}, plan.RemovedFields)
+	assert.Empty(t, plan.Conflicts)
+
+	v3, _ := getSchemaProperties(schemaV3{})
+	plan = &SchemaMigrationPlan{}
+	diffMappingProperties(current, v3, "", plan)
+	assert.ElementsMatch(t, []MappingConflict{
+		{Field: "name", Property: "type", Current: "keyword", Target: "text"},
+		{Field: "count", Property: "type", Current: "integer", Target: "long"},
+	}, plan.Conflicts)
+
+	//same type but a different analyzer
+	current["name"] = map[string]interface{}{"type": "text", "analyzer": "whitespace"}
+	plan = &SchemaMigrationPlan{}
+	diffMappingProperties(current, v3, "", plan)
+	assert.Contains(t, plan.Conflicts, MappingConflict{Field: "name", Property: "analyzer", Current: "whitespace", Target: "standard"})
+}
+
+func TestExtractTypeMapping(t *testing.T) {
+	typed := map[string]interface{}{
+		"doc": map[string]interface{}{"_meta": map[string]interface{}{schemaVersionKey: 1}},
+	}
+	assert.Contains(t, extractTypeMapping(typed), "_meta")
+
+	typeless := map[string]interface{}{"properties": map[string]interface{}{}}
+	assert.Equal(t, typeless, extractTypeMapping(typeless))
+}
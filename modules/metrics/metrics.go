@@ -72,6 +72,11 @@ func (module *MetricsModule) Name() string {
 	return "metrics"
 }
 
+// Dependencies metrics are collected from elasticsearch and pushed to queue
+func (module *MetricsModule) Dependencies() []string {
+	return []string{"elasticsearch", "queue"}
+}
+
 func (module *MetricsModule) loadConfig(cfg *MetricConfig) {
 
 	meta := module.buildAgentMeta()
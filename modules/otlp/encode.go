@@ -0,0 +1,351 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package otlp
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"infini.sh/framework/core/event"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/stats"
+)
+
+// The structs below follow the protobuf JSON mapping of the OTLP/HTTP
+// payloads, 64 bit integers are encoded as strings and ids as hex strings
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string     `json:"stringValue,omitempty"`
+	IntValue    *string     `json:"intValue,omitempty"`
+	DoubleValue *float64    `json:"doubleValue,omitempty"`
+	BoolValue   *bool       `json:"boolValue,omitempty"`
+	ArrayValue  *arrayValue `json:"arrayValue,omitempty"`
+}
+
+type arrayValue struct {
+	Values []anyValue `json:"values"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type metricsRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type metric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Unit        string     `json:"unit,omitempty"`
+	Sum         *sum       `json:"sum,omitempty"`
+	Gauge       *gauge     `json:"gauge,omitempty"`
+	Histogram   *histogram `json:"histogram,omitempty"`
+}
+
+const aggregationTemporalityCumulative = 2
+
+type sum struct {
+	DataPoints             []numberDataPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type gauge struct {
+	DataPoints []numberDataPoint `json:"dataPoints"`
+}
+
+type histogram struct {
+	DataPoints             []histogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type numberDataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	AsDouble          float64    `json:"asDouble"`
+}
+
+type histogramDataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	Count             string     `json:"count"`
+	Sum               float64    `json:"sum"`
+	BucketCounts      []string   `json:"bucketCounts"`
+	ExplicitBounds    []float64  `json:"explicitBounds"`
+	Min               *float64   `json:"min,omitempty"`
+	Max               *float64   `json:"max,omitempty"`
+}
+
+type tracesRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+const (
+	spanKindInternal  = 1
+	statusCodeOk      = 1
+	statusCodeError   = 2
+	instrumentation   = "infini.sh/framework"
+	pipelineAttribute = "pipeline.name"
+)
+
+type span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            spanStatus `json:"status"`
+}
+
+type spanStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func stringAttr(key, value string) keyValue {
+	return keyValue{Key: key, Value: anyValue{StringValue: &value}}
+}
+
+func toAnyValue(v interface{}) anyValue {
+	switch x := v.(type) {
+	case string:
+		return anyValue{StringValue: &x}
+	case bool:
+		return anyValue{BoolValue: &x}
+	case int:
+		s := strconv.Itoa(x)
+		return anyValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		return anyValue{IntValue: &s}
+	case float64:
+		return anyValue{DoubleValue: &x}
+	case []string:
+		values := make([]anyValue, 0, len(x))
+		for _, s := range x {
+			values = append(values, toAnyValue(s))
+		}
+		return anyValue{ArrayValue: &arrayValue{Values: values}}
+	default:
+		s := fmt.Sprint(x)
+		return anyValue{StringValue: &s}
+	}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// buildResource converts the agent metadata to the OTLP resource attributes,
+// following the semantic conventions where there is one
+func buildResource(serviceName, serviceVersion string, meta *event.AgentMeta, extra map[string]string) resource {
+	attrs := []keyValue{stringAttr("service.name", serviceName)}
+	if serviceVersion != "" {
+		attrs = append(attrs, stringAttr("service.version", serviceVersion))
+	}
+	if meta != nil {
+		if meta.AgentID != "" {
+			attrs = append(attrs, stringAttr("service.instance.id", meta.AgentID))
+		}
+		if meta.HostID != "" {
+			attrs = append(attrs, stringAttr("host.id", meta.HostID))
+		}
+		if meta.Hostname != "" {
+			attrs = append(attrs, stringAttr("host.name", meta.Hostname))
+		}
+		if len(meta.IP) > 0 {
+			attrs = append(attrs, keyValue{Key: "host.ip", Value: toAnyValue(meta.IP)})
+		} else if meta.MajorIP != "" {
+			attrs = append(attrs, keyValue{Key: "host.ip", Value: toAnyValue([]string{meta.MajorIP})})
+		}
+		if len(meta.Tags) > 0 {
+			attrs = append(attrs, keyValue{Key: "agent.tags", Value: toAnyValue(meta.Tags)})
+		}
+		attrs = appendSortedAttrs(attrs, "agent.labels.", meta.Labels)
+	}
+	return resource{Attributes: appendSortedAttrs(attrs, "", extra)}
+}
+
+func appendSortedAttrs(attrs []keyValue, prefix string, m map[string]string) []keyValue {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		attrs = append(attrs, stringAttr(prefix+k, m[k]))
+	}
+	return attrs
+}
+
+func labelAttrs(labels []stats.Label) []keyValue {
+	if len(labels) == 0 {
+		return nil
+	}
+	attrs := make([]keyValue, 0, len(labels))
+	for _, l := range labels {
+		attrs = append(attrs, stringAttr(l.Name, l.Value))
+	}
+	return attrs
+}
+
+// convertFamilies maps the families of the stats registry to OTLP metrics,
+// counters become cumulative monotonic sums and histograms carry the
+// cumulative buckets since start, converted to per bucket counts
+func convertFamilies(families []stats.MetricFamily, start, now time.Time) []metric {
+	startNano, nowNano := unixNano(start), unixNano(now)
+	var metrics []metric
+	for _, f := range families {
+		if len(f.Metrics) == 0 {
+			continue
+		}
+		m := metric{Name: f.Name, Description: f.Help}
+		switch f.Type {
+		case stats.CounterMetric:
+			m.Sum = &sum{AggregationTemporality: aggregationTemporalityCumulative, IsMonotonic: true}
+			for _, v := range f.Metrics {
+				m.Sum.DataPoints = append(m.Sum.DataPoints, numberDataPoint{Attributes: labelAttrs(v.Labels), StartTimeUnixNano: startNano, TimeUnixNano: nowNano, AsDouble: v.Value})
+			}
+		case stats.HistogramMetric:
+			m.Histogram = &histogram{AggregationTemporality: aggregationTemporalityCumulative}
+			for _, v := range f.Metrics {
+				if v.Histogram == nil {
+					continue
+				}
+				point := convertHistogram(v.Histogram)
+				point.Attributes = labelAttrs(v.Labels)
+				point.StartTimeUnixNano = startNano
+				point.TimeUnixNano = nowNano
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, point)
+			}
+			if len(m.Histogram.DataPoints) == 0 {
+				continue
+			}
+		default:
+			m.Gauge = &gauge{}
+			for _, v := range f.Metrics {
+				m.Gauge.DataPoints = append(m.Gauge.DataPoints, numberDataPoint{Attributes: labelAttrs(v.Labels), TimeUnixNano: nowNano, AsDouble: v.Value})
+			}
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func convertHistogram(h *stats.HistogramSnapshot) histogramDataPoint {
+	point := histogramDataPoint{
+		Count: strconv.FormatInt(h.TotalCount, 10),
+		Sum:   float64(h.TotalSum),
+	}
+	var previous int64
+	for _, b := range h.Buckets {
+		if !math.IsInf(b.UpperBound, 1) {
+			point.ExplicitBounds = append(point.ExplicitBounds, b.UpperBound)
+		}
+		point.BucketCounts = append(point.BucketCounts, strconv.FormatInt(b.Count-previous, 10))
+		previous = b.Count
+	}
+	//the overflow bucket is always present in OTLP
+	if len(point.BucketCounts) == len(point.ExplicitBounds) {
+		point.BucketCounts = append(point.BucketCounts, strconv.FormatInt(h.TotalCount-previous, 10))
+	}
+	if h.Count > 0 {
+		min, max := float64(h.Min), float64(h.Max)
+		point.Min, point.Max = &min, &max
+	}
+	return point
+}
+
+func gaugeMetric(name, unit string, value float64, now time.Time) metric {
+	return metric{Name: name, Unit: unit, Gauge: &gauge{DataPoints: []numberDataPoint{{TimeUnixNano: unixNano(now), AsDouble: value}}}}
+}
+
+func convertSpans(spans []*pipeline.Span) []span {
+	result := make([]span, 0, len(spans))
+	for _, s := range spans {
+		out := span{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentID,
+			Name:              s.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: unixNano(s.Start),
+			EndTimeUnixNano:   unixNano(s.End),
+			Attributes:        []keyValue{stringAttr(pipelineAttribute, s.Pipeline)},
+			Status:            spanStatus{Code: statusCodeOk},
+		}
+		if s.Error != "" {
+			out.Status = spanStatus{Code: statusCodeError, Message: s.Error}
+		}
+		keys := make([]string, 0, len(s.Attributes))
+		for k := range s.Attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			out.Attributes = append(out.Attributes, keyValue{Key: k, Value: toAnyValue(s.Attributes[k])})
+		}
+		result = append(result, out)
+	}
+	return result
+}
@@ -0,0 +1,263 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+const (
+	metricsPath = "/v1/metrics"
+	tracesPath  = "/v1/traces"
+)
+
+// retryableError marks failures worth retrying, the collector may ask for a
+// specific delay with the Retry-After header
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+type client struct {
+	endpoint    string
+	headers     map[string]string
+	compression bool
+	retry       RetryConfig
+	httpClient  *http.Client
+	done        chan struct{}
+}
+
+func newClient(cfg *Config) *client {
+	return &client{
+		endpoint:    strings.TrimRight(cfg.Endpoint, "/"),
+		headers:     cfg.Headers,
+		compression: cfg.Compression == "gzip",
+		retry:       cfg.Retry,
+		httpClient:  &http.Client{Timeout: util.GetDurationOrDefault(cfg.Timeout, 10*time.Second)},
+		done:        make(chan struct{}),
+	}
+}
+
+// export sends the payload to the collector, retrying with exponential
+// backoff on network errors and on the status codes defined as retryable
+// by the OTLP specification
+func (c *client) export(path string, payload interface{}) error {
+	body := util.MustToJSONBytes(payload)
+	if c.compression {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	backoff := util.GetDurationOrDefault(c.retry.InitialBackoff, time.Second)
+	maxBackoff := util.GetDurationOrDefault(c.retry.MaxBackoff, 30*time.Second)
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = c.send(path, body)
+		if err == nil {
+			return nil
+		}
+		retryable, ok := err.(*retryableError)
+		if !ok || !c.retry.Enabled || attempt >= c.retry.MaxRetries {
+			break
+		}
+		delay := backoff
+		if retryable.retryAfter > 0 {
+			delay = retryable.retryAfter
+		}
+		log.Debugf("failed to export to [%v%v], retry in %v: %v", c.endpoint, path, delay, err)
+		stats.Increment("otlp", "retries")
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			//shutting down, give up the pending retries
+			return err
+		}
+		backoff = backoff * 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	return err
+}
+
+func (c *client) close() {
+	close(c.done)
+}
+
+func (c *client) send(path string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, c.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.compression {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return &retryableError{err: err}
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = errors.Errorf("collector responded with status %v: %s", resp.StatusCode, respBody)
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		retryAfter := time.Duration(0)
+		if v := resp.Header.Get("Retry-After"); v != "" {
+			if seconds, e := strconv.Atoi(v); e == nil {
+				retryAfter = time.Duration(seconds) * time.Second
+			}
+		}
+		return &retryableError{err: err, retryAfter: retryAfter}
+	}
+	return err
+}
+
+// spanBatcher buffers the finished spans and exports them once the batch is
+// full or the flush interval elapsed, spans are dropped when the queue is full
+type spanBatcher struct {
+	client        *client
+	resource      func() resource
+	queue         chan *pipeline.Span
+	batchSize     int
+	flushInterval time.Duration
+	quit          chan struct{}
+	wg            sync.WaitGroup
+}
+
+func newSpanBatcher(c *client, cfg *TracesConfig, res func() resource) *spanBatcher {
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = 2048
+	}
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = 512
+	}
+	return &spanBatcher{
+		client:        c,
+		resource:      res,
+		queue:         make(chan *pipeline.Span, queueSize),
+		batchSize:     batchSize,
+		flushInterval: util.GetDurationOrDefault(cfg.FlushInterval, 5*time.Second),
+		quit:          make(chan struct{}),
+	}
+}
+
+func (b *spanBatcher) onSpan(span *pipeline.Span) {
+	select {
+	case b.queue <- span:
+	default:
+		stats.Increment("otlp", "spans.dropped")
+	}
+}
+
+func (b *spanBatcher) start() {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		ticker := time.NewTicker(b.flushInterval)
+		defer ticker.Stop()
+		batch := make([]*pipeline.Span, 0, b.batchSize)
+		for {
+			select {
+			case span := <-b.queue:
+				batch = append(batch, span)
+				if len(batch) >= b.batchSize {
+					batch = b.flush(batch)
+				}
+			case <-ticker.C:
+				batch = b.flush(batch)
+			case <-b.quit:
+				for {
+					select {
+					case span := <-b.queue:
+						batch = append(batch, span)
+						if len(batch) >= b.batchSize {
+							batch = b.flush(batch)
+						}
+					default:
+						b.flush(batch)
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+func (b *spanBatcher) flush(batch []*pipeline.Span) []*pipeline.Span {
+	if len(batch) == 0 {
+		return batch
+	}
+	req := tracesRequest{ResourceSpans: []resourceSpans{{
+		Resource:   b.resource(),
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: instrumentation}, Spans: convertSpans(batch)}},
+	}}}
+	if err := b.client.export(tracesPath, req); err != nil {
+		log.Errorf("failed to export %v spans: %v", len(batch), err)
+		stats.IncrementBy("otlp", "spans.failed", int64(len(batch)))
+	} else {
+		stats.IncrementBy("otlp", "spans.exported", int64(len(batch)))
+	}
+	return batch[:0]
+}
+
+func (b *spanBatcher) stop() {
+	close(b.quit)
+	b.wg.Wait()
+}
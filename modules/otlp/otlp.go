@@ -0,0 +1,260 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package otlp
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/event"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/host"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/task"
+	"infini.sh/framework/core/util"
+)
+
+type Config struct {
+	Enabled bool `config:"enabled"`
+	//base url of the collector, eg: http://localhost:4318
+	Endpoint    string            `config:"endpoint"`
+	Headers     map[string]string `config:"headers"`
+	Compression string            `config:"compression"`
+	Timeout     string            `config:"timeout"`
+
+	ServiceName        string            `config:"service_name"`
+	ResourceAttributes map[string]string `config:"resource_attributes"`
+
+	Retry   RetryConfig   `config:"retry"`
+	Metrics MetricsConfig `config:"metrics"`
+	Traces  TracesConfig  `config:"traces"`
+}
+
+type RetryConfig struct {
+	Enabled        bool   `config:"enabled"`
+	MaxRetries     int    `config:"max_retries"`
+	InitialBackoff string `config:"initial_backoff"`
+	MaxBackoff     string `config:"max_backoff"`
+}
+
+type MetricsConfig struct {
+	Enabled  bool   `config:"enabled"`
+	Interval string `config:"interval"`
+	//metrics of the labeled stats registry
+	Registry bool `config:"registry"`
+	//cpu, memory and disk usage of the host
+	Host bool `config:"host"`
+	//runtime metrics of the current process
+	Process bool `config:"process"`
+}
+
+type TracesConfig struct {
+	Enabled       bool   `config:"enabled"`
+	BatchSize     int    `config:"batch_size"`
+	QueueSize     int    `config:"queue_size"`
+	FlushInterval string `config:"flush_interval"`
+}
+
+const spanHandlerName = "otlp"
+
+type OTLPModule struct {
+	config  *Config
+	client  *client
+	batcher *spanBatcher
+	taskID  string
+}
+
+func (module *OTLPModule) Name() string {
+	return "otlp"
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Endpoint: "http://localhost:4318",
+		Timeout:  "10s",
+		Retry: RetryConfig{
+			Enabled:        true,
+			MaxRetries:     3,
+			InitialBackoff: "1s",
+			MaxBackoff:     "30s",
+		},
+		Metrics: MetricsConfig{
+			Enabled:  true,
+			Interval: "30s",
+			Registry: true,
+			Host:     true,
+			Process:  true,
+		},
+		Traces: TracesConfig{
+			Enabled:       true,
+			BatchSize:     512,
+			QueueSize:     2048,
+			FlushInterval: "5s",
+		},
+	}
+}
+
+func (module *OTLPModule) Setup() {
+	module.config = defaultConfig()
+	ok, err := env.ParseConfig("otlp", module.config)
+	if ok && err != nil {
+		panic(err)
+	}
+}
+
+func (module *OTLPModule) Start() error {
+	if module.config == nil || !module.config.Enabled {
+		return nil
+	}
+
+	module.client = newClient(module.config)
+
+	if module.config.Traces.Enabled {
+		module.batcher = newSpanBatcher(module.client, &module.config.Traces, module.resource)
+		module.batcher.start()
+		pipeline.RegisterSpanHandler(spanHandlerName, module.batcher.onSpan)
+	}
+
+	if module.config.Metrics.Enabled {
+		module.taskID = task.RegisterScheduleTask(task.ScheduleTask{
+			Description: "export metrics via otlp",
+			Type:        "interval",
+			Interval:    util.StringDefault(module.config.Metrics.Interval, "30s"),
+			Task: func(ctx context.Context) {
+				module.exportMetrics()
+			},
+		})
+	}
+
+	log.Infof("otlp exporter started, endpoint: %v", module.config.Endpoint)
+	return nil
+}
+
+func (module *OTLPModule) Stop() error {
+	if module.config == nil || !module.config.Enabled || module.client == nil {
+		return nil
+	}
+	if module.taskID != "" {
+		task.DeleteTask(module.taskID)
+	}
+	pipeline.UnregisterSpanHandler(spanHandlerName)
+	//abort the pending retries, the remaining spans are flushed once
+	module.client.close()
+	if module.batcher != nil {
+		module.batcher.stop()
+	}
+	return nil
+}
+
+func (module *OTLPModule) resource() resource {
+	meta := *event.GetMeta()
+	if meta.Hostname == "" {
+		meta.Hostname = util.GetHostName()
+	}
+	if meta.AgentID == "" {
+		meta.AgentID = global.Env().SystemConfig.NodeConfig.ID
+	}
+	serviceName := util.StringDefault(module.config.ServiceName, global.Env().GetAppLowercaseName())
+	return buildResource(serviceName, global.Env().GetVersion(), &meta, module.config.ResourceAttributes)
+}
+
+func (module *OTLPModule) collectMetrics(now time.Time) []metric {
+	var metrics []metric
+	if module.config.Metrics.Registry {
+		metrics = append(metrics, convertFamilies(stats.GatherMetrics(), env.GetStartTime(), now)...)
+	}
+	if module.config.Metrics.Host {
+		metrics = append(metrics, hostMetrics(now)...)
+	}
+	if module.config.Metrics.Process {
+		metrics = append(metrics, processMetrics(now)...)
+	}
+	return metrics
+}
+
+func (module *OTLPModule) exportMetrics() {
+	metrics := module.collectMetrics(time.Now())
+	if len(metrics) == 0 {
+		return
+	}
+	req := metricsRequest{ResourceMetrics: []resourceMetrics{{
+		Resource:     module.resource(),
+		ScopeMetrics: []scopeMetrics{{Scope: scope{Name: instrumentation}, Metrics: metrics}},
+	}}}
+	if err := module.client.export(metricsPath, req); err != nil {
+		log.Errorf("failed to export metrics: %v", err)
+		stats.Increment("otlp", "metrics.failed")
+		return
+	}
+	stats.Increment("otlp", "metrics.exported")
+}
+
+func hostMetrics(now time.Time) []metric {
+	metrics := []metric{gaugeMetric("system.cpu.utilization", "%", host.GetCPUUsageInfo(), now)}
+	if memory, _, err := host.GetMemoryUsage(); err == nil {
+		metrics = append(metrics,
+			gaugeMetric("system.memory.usage", "By", float64(memory.Used), now),
+			gaugeMetric("system.memory.limit", "By", float64(memory.Total), now),
+			gaugeMetric("system.memory.utilization", "%", memory.UsedPercent, now))
+	} else {
+		log.Debug(err)
+	}
+	if disk, err := host.GetDiskUsage(); err == nil {
+		metrics = append(metrics,
+			gaugeMetric("system.filesystem.usage", "By", float64(disk.Used), now),
+			gaugeMetric("system.filesystem.limit", "By", float64(disk.Total), now),
+			gaugeMetric("system.filesystem.utilization", "%", disk.UsedPercent, now))
+	} else {
+		log.Debug(err)
+	}
+	return metrics
+}
+
+// processMetrics exports the system section of the stats module
+func processMetrics(now time.Time) []metric {
+	all, err := stats.StatsMap()
+	if err != nil {
+		return nil
+	}
+	system, ok := all["system"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	keys := make([]string, 0, len(system))
+	for k := range system {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var metrics []metric
+	for _, k := range keys {
+		if v, ok := system[k].(float64); ok {
+			metrics = append(metrics, gaugeMetric("process."+k, "", v, now))
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,156 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package otlp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/event"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/stats"
+)
+
+// collector is a local stand-in of an OTLP/HTTP receiver
+type collector struct {
+	lock     sync.Mutex
+	failures int
+	status   int
+	requests map[string][][]byte
+}
+
+func newCollector() (*collector, *httptest.Server) {
+	c := &collector{requests: map[string][][]byte{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if c.failures > 0 {
+			c.failures--
+			w.WriteHeader(c.status)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		c.requests[r.URL.Path] = append(c.requests[r.URL.Path], body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	return c, server
+}
+
+func (c *collector) received(path string) [][]byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.requests[path]
+}
+
+func testConfig(endpoint string) *Config {
+	cfg := defaultConfig()
+	cfg.Endpoint = endpoint
+	cfg.Retry.InitialBackoff = "10ms"
+	cfg.Retry.MaxBackoff = "20ms"
+	return cfg
+}
+
+func TestExportRetry(t *testing.T) {
+	c, server := newCollector()
+	defer server.Close()
+	client := newClient(testConfig(server.URL))
+
+	c.failures, c.status = 2, http.StatusServiceUnavailable
+	assert.NoError(t, client.export(metricsPath, metricsRequest{}))
+	assert.Equal(t, 1, len(c.received(metricsPath)))
+
+	c.failures, c.status = 5, http.StatusServiceUnavailable
+	assert.Error(t, client.export(metricsPath, metricsRequest{}))
+	assert.Equal(t, 1, c.failures)
+
+	//bad request is not retryable
+	c.failures, c.status = 2, http.StatusBadRequest
+	assert.Error(t, client.export(metricsPath, metricsRequest{}))
+	assert.Equal(t, 1, c.failures)
+}
+
+func TestSpanBatcher(t *testing.T) {
+	c, server := newCollector()
+	defer server.Close()
+	cfg := testConfig(server.URL)
+	cfg.Traces.BatchSize = 2
+	cfg.Traces.FlushInterval = "1h"
+
+	meta := &event.AgentMeta{AgentID: "agent-1", Hostname: "node-1", IP: []string{"10.0.0.1"}, Labels: map[string]string{"region": "eu"}}
+	batcher := newSpanBatcher(newClient(cfg), &cfg.Traces, func() resource {
+		return buildResource("gateway", "1.0.0", meta, nil)
+	})
+	batcher.start()
+
+	now := time.Now()
+	batcher.onSpan(&pipeline.Span{TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "b7ad6b7169203331", Name: "pipe", Pipeline: "pipe", Start: now, End: now})
+	batcher.onSpan(&pipeline.Span{TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "00f067aa0ba902b7", ParentID: "b7ad6b7169203331", Name: "echo", Pipeline: "pipe", Start: now, End: now, Error: "failed"})
+	batcher.onSpan(&pipeline.Span{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "53995c3f42cd8ad8", Name: "other", Pipeline: "other", Start: now, End: now})
+	batcher.stop()
+
+	requests := c.received(tracesPath)
+	assert.Equal(t, 2, len(requests))
+
+	req := tracesRequest{}
+	assert.NoError(t, json.Unmarshal(requests[0], &req))
+	attrs := map[string]anyValue{}
+	for _, kv := range req.ResourceSpans[0].Resource.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	assert.Equal(t, "gateway", *attrs["service.name"].StringValue)
+	assert.Equal(t, "agent-1", *attrs["service.instance.id"].StringValue)
+	assert.Equal(t, "node-1", *attrs["host.name"].StringValue)
+	assert.Equal(t, "eu", *attrs["agent.labels.region"].StringValue)
+
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	assert.Equal(t, 2, len(spans))
+	assert.Equal(t, "b7ad6b7169203331", spans[1].ParentSpanID)
+	assert.Equal(t, statusCodeError, spans[1].Status.Code)
+}
+
+func TestConvertFamilies(t *testing.T) {
+	families := []stats.MetricFamily{
+		{Name: "requests_total", Type: stats.CounterMetric, Metrics: []stats.Metric{{Labels: []stats.Label{{Name: "code", Value: "200"}}, Value: 3}}},
+		{Name: "latency_ms", Type: stats.HistogramMetric, Metrics: []stats.Metric{{Histogram: &stats.HistogramSnapshot{
+			Count: 4, Min: 1, Max: 20, TotalCount: 4, TotalSum: 26,
+			Buckets: []stats.HistogramBucket{{UpperBound: 1, Count: 1}, {UpperBound: 10, Count: 3}, {UpperBound: math.Inf(1), Count: 4}},
+		}}}},
+	}
+	metrics := convertFamilies(families, time.Now(), time.Now())
+	assert.Equal(t, 2, len(metrics))
+	assert.True(t, metrics[0].Sum.IsMonotonic)
+	assert.Equal(t, 3.0, metrics[0].Sum.DataPoints[0].AsDouble)
+
+	point := metrics[1].Histogram.DataPoints[0]
+	assert.Equal(t, []float64{1, 10}, point.ExplicitBounds)
+	assert.Equal(t, []string{"1", "2", "1"}, point.BucketCounts)
+	assert.Equal(t, "4", point.Count)
+}
@@ -30,6 +30,7 @@ import (
 	httprouter "infini.sh/framework/core/api/router"
 	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/stats"
 	"infini.sh/framework/core/util"
 	"infini.sh/framework/lib/go-ucfg"
 )
@@ -88,6 +89,9 @@ func (module *PipeModule) getPipelineStatus(id string, config string, processor
 		EndTime:    c1.GetEndTime(),
 		Context:    c1.CloneData(),
 	}
+	if runs := module.loadRuns(id); runs != nil {
+		ret.LastRun = runs.lastRun()
+	}
 	if config != "false" {
 		v1, ok := module.configs.Load(id)
 		if !ok {
@@ -155,6 +159,8 @@ func (module *PipeModule) deletePipelineHandler(w http.ResponseWriter, req *http
 	_, exists := module.contexts.Load(id)
 	if exists {
 		module.deleteTask(id)
+		module.runs.Delete(id)
+		stats.DeleteSeries("pipeline", id)
 		module.WriteAckOKJSON(w)
 	} else {
 		module.WriteAckJSON(w, false, 404, util.MapStr{
@@ -165,7 +171,7 @@ func (module *PipeModule) deletePipelineHandler(w http.ResponseWriter, req *http
 
 func (module *PipeModule) startTaskHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	id := ps.ByName("id")
-	exists := module.startTask(id)
+	exists := module.startTask(id, TriggerManual)
 	if exists {
 		module.WriteAckOKJSON(w)
 	} else {
@@ -186,3 +192,25 @@ func (module *PipeModule) stopTaskHandler(w http.ResponseWriter, req *http.Reque
 		})
 	}
 }
+
+func (module *PipeModule) getPipelineHistoryHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	c, ok := module.contexts.Load(id)
+	if !ok {
+		module.WriteError(w, "pipeline not found", http.StatusNotFound)
+		return
+	}
+	c1, ok := c.(*pipeline.Context)
+	if !ok {
+		module.WriteError(w, "pipeline not found", http.StatusNotFound)
+		return
+	}
+	ret := PipelineHistory{
+		Schedule: c1.Config.Schedule,
+		Runs:     []RunRecord{},
+	}
+	if runs := module.loadRuns(id); runs != nil {
+		ret.Pending, ret.Runs = runs.history()
+	}
+	module.WriteJSON(w, ret, 200)
+}
@@ -38,4 +38,29 @@ type PipelineStatus struct {
 	Context    util.MapStr                `json:"context"`
 	Config     *pipeline.PipelineConfigV2 `json:"config"`
 	Processors []map[string]interface{}   `json:"processor"`
+	LastRun    *RunRecord                 `json:"last_run,omitempty"`
+}
+
+const (
+	TriggerAuto     = "auto"
+	TriggerManual   = "manual"
+	TriggerSchedule = "schedule"
+)
+
+// RunSkipped marks a scheduled trigger dropped by the `skip` concurrency policy
+const RunSkipped pipeline.RunningState = "SKIPPED"
+
+type RunRecord struct {
+	ID        string                `json:"id"`
+	Trigger   string                `json:"trigger"`
+	State     pipeline.RunningState `json:"state"`
+	StartTime time.Time             `json:"start_time"`
+	EndTime   *time.Time            `json:"end_time,omitempty"`
+	Error     string                `json:"error,omitempty"`
+}
+
+type PipelineHistory struct {
+	Schedule *pipeline.ScheduleConfig `json:"schedule,omitempty"`
+	Pending  int                      `json:"pending"`
+	Runs     []RunRecord              `json:"runs"`
 }
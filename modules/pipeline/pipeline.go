@@ -28,8 +28,10 @@ import (
 	"fmt"
 	"github.com/fsnotify/fsnotify"
 	"infini.sh/framework/core/locker"
+	"infini.sh/framework/core/module"
 	"infini.sh/framework/core/task"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -41,10 +43,15 @@ import (
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/plugin"
 	"infini.sh/framework/core/rate"
+	"infini.sh/framework/core/stats"
 	"infini.sh/framework/core/util"
 )
 
+var pipelineRunsTotal = stats.NewCounterVec("pipeline_runs_total", "Finished runs of the pipeline, by state.", "pipeline", "state")
+var pipelineRunDuration = stats.NewHistogramVec("pipeline_run_duration_ms", "Duration of the pipeline runs in milliseconds.", nil, "pipeline")
+
 type PipeModule struct {
 	api.Handler
 	closed atomic.Bool
@@ -52,6 +59,7 @@ type PipeModule struct {
 	pipelines sync.Map
 	configs   sync.Map
 	contexts  sync.Map
+	runs      sync.Map
 }
 
 func (module *PipeModule) Name() string {
@@ -62,6 +70,52 @@ var moduleCfg = struct {
 	PipelineEnabledByDefault bool `config:"pipeline_enabled_by_default"`
 }{PipelineEnabledByDefault: true}
 
+func init() {
+	pipeline.RegisterProcessorPlugin("dag", pipeline.NewDAGProcessor)
+	pipeline.RegisterProcessorPlugin("echo", NewEchoProcessor)
+
+	config.RegisterSchema("pipeline", func() interface{} {
+		return &[]pipeline.PipelineConfigV2{}
+	}, validatePipelineConfig)
+	config.RegisterSchema("external_plugins", func() interface{} {
+		return &plugin.ExternalPluginsConfig{}
+	})
+}
+
+// validatePipelineConfig creates the processors of each pipeline without starting them
+func validatePipelineConfig(cfg *config.Config) error {
+	pipelines := []pipeline.PipelineConfigV2{}
+	if err := cfg.Unpack(&pipelines); err != nil {
+		return err
+	}
+	errs := []string{}
+	for _, v := range pipelines {
+		if err := validateProcessors(v.Processors); err != nil {
+			errs = append(errs, fmt.Sprintf("pipeline [%v]: %v", v.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func validateProcessors(cfgs []*config.Config) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("failed to create processor: %v", r)
+		}
+	}()
+	procs, err := pipeline.NewPipeline(cfgs)
+	if err != nil {
+		return err
+	}
+	for _, p := range procs.List {
+		pipeline.Close(p)
+	}
+	return nil
+}
+
 func (module *PipeModule) Setup() {
 	if global.Env().IsDebug {
 		log.Debug("pipeline framework config: ", moduleCfg)
@@ -72,12 +126,22 @@ func (module *PipeModule) Setup() {
 		panic(err)
 	}
 
+	//out-of-process plugins, register the processors before the pipelines are created
+	pluginsCfg := plugin.ExternalPluginsConfig{Path: global.Env().GetPluginDir()}
+	ok, err = env.ParseConfig("external_plugins", &pluginsCfg)
+	if ok && err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
+		panic(err)
+	}
+	if pluginsCfg.Enabled {
+		if err := plugin.LoadExternalPlugins(pluginsCfg); err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
+			panic(err)
+		}
+	}
+
 	module.pipelines = sync.Map{}
 	module.contexts = sync.Map{}
 	module.configs = sync.Map{}
-
-	pipeline.RegisterProcessorPlugin("dag", pipeline.NewDAGProcessor)
-	pipeline.RegisterProcessorPlugin("echo", NewEchoProcessor)
+	module.runs = sync.Map{}
 
 	api.HandleAPIMethod(api.GET, "/pipeline/tasks/", module.getPipelinesHandler)
 	api.HandleAPIMethod(api.POST, "/pipeline/tasks/_search", module.searchPipelinesHandler)
@@ -86,10 +150,11 @@ func (module *PipeModule) Setup() {
 	api.HandleAPIMethod(api.DELETE, "/pipeline/task/:id", module.deletePipelineHandler)
 	api.HandleAPIMethod(api.POST, "/pipeline/task/:id/_start", module.startTaskHandler)
 	api.HandleAPIMethod(api.POST, "/pipeline/task/:id/_stop", module.stopTaskHandler)
+	api.HandleAPIMethod(api.GET, "/pipeline/task/:id/_history", module.getPipelineHistoryHandler)
 
 }
 
-func (module *PipeModule) startTask(taskID string) (exists bool) {
+func (module *PipeModule) startTask(taskID string, trigger string) (exists bool) {
 	if module.closed.Load() {
 		return false
 	}
@@ -111,6 +176,7 @@ func (module *PipeModule) startTask(taskID string) (exists bool) {
 	}
 	// Resume pipeline loop
 	if v1.IsPause() {
+		module.getRuns(v1.Config).setNextTrigger(trigger)
 		// Mark pipeline status as starting
 		v1.Starting()
 		v1.Resume()
@@ -150,6 +216,7 @@ func (module *PipeModule) stopTask(taskID string) (exists bool) {
 
 // deleteTask will clean all in-memory states and release the pipeline context
 func (module *PipeModule) deleteTask(taskID string) {
+	module.unregisterSchedule(taskID)
 	module.pipelines.Delete(taskID)
 	module.configs.Delete(taskID)
 	module.releaseContext(taskID)
@@ -299,6 +366,9 @@ func (module *PipeModule) Start() error {
 			for _, taskID := range needStopAndClean {
 				log.Infof("removing pipeline [%s]", taskID)
 				module.deleteTask(taskID)
+				if _, ok := newPipelines[taskID]; !ok {
+					stats.DeleteSeries("pipeline", taskID)
+				}
 			}
 		}
 
@@ -314,11 +384,16 @@ func (module *PipeModule) Start() error {
 	return nil
 }
 
+func (*PipeModule) ShutdownPhase() module.ShutdownPhase {
+	return module.PhaseProcessing
+}
+
 func (module *PipeModule) Stop() error {
 	if module.closed.Load() {
 		return nil
 	}
 	module.closed.Store(true)
+	defer plugin.StopExternalPlugins(global.Env().SystemConfig.Shutdown.GetStopTimeout())
 
 	total := util.GetSyncMapSize(&module.contexts)
 	if total <= 0 {
@@ -333,11 +408,12 @@ func (module *PipeModule) Stop() error {
 		if !ok {
 			return false
 		}
+		module.unregisterSchedule(taskID)
 		taskIDs = append(taskIDs, taskID)
 		return true
 	})
 
-	module.stopAndWaitForRelease(taskIDs, time.Minute*5)
+	module.stopAndWaitForRelease(taskIDs, global.Env().SystemConfig.Shutdown.GetDrainTimeout())
 
 	log.Info("finished shut down pipelines")
 	return nil
@@ -403,6 +479,10 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 		return nil
 	}
 
+	if err := v.Schedule.Validate(); err != nil {
+		return err
+	}
+
 	creatingLocker.Lock()
 	defer creatingLocker.Unlock()
 
@@ -437,6 +517,8 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 		ctx := pipeline.AcquireContext(v)
 		module.pipelines.Store(v.Name, processor)
 		module.contexts.Store(v.Name, ctx)
+		runs := module.getRuns(v)
+		module.registerSchedule(v)
 
 		defer func() {
 			if !global.Env().IsDebug {
@@ -460,7 +542,10 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 
 		if !cfg.AutoStart {
 			// Mark pipeline as exited, don't run automatically
-			ctx.Exit()
+			// scheduled pipeline keeps paused and waits for the next trigger
+			if !cfg.Schedule.IsEnabled() {
+				ctx.Exit()
+			}
 		} else {
 			ctx.Starting()
 		}
@@ -519,17 +604,31 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 				started = true
 				ctx.Started()
 				ctx.ResetContext()
+				runs.begin(TriggerAuto)
 
+				start := time.Now()
+				span := ctx.StartTrace()
 				err = processor.Process(ctx)
+				pipelineRunDuration.With(cfg.Name).Record(time.Since(start).Milliseconds())
+				ctx.FinishSpan(span, err)
 
 				if err != nil {
 					log.Errorf("error on pipeline:%v, %v", cfg.Name, err)
 					ctx.Failed(err)
+					runs.end(pipeline.FAILED, err)
+					pipelineRunsTotal.With(cfg.Name, string(pipeline.FAILED)).Inc()
 				} else {
 					if global.Env().IsDebug {
 						log.Debugf("pipeline [%v] end running", cfg.Name)
 					}
 					ctx.Finished()
+					if ctx.IsCanceled() {
+						runs.end(pipeline.STOPPED, nil)
+						pipelineRunsTotal.With(cfg.Name, string(pipeline.STOPPED)).Inc()
+					} else {
+						runs.end(pipeline.FINISHED, nil)
+						pipelineRunsTotal.With(cfg.Name, string(pipeline.FINISHED)).Inc()
+					}
 				}
 				started = false
 			case pipeline.STARTED, pipeline.STOPPING:
@@ -551,6 +650,9 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 
 					// restart after delay.
 					ctx.Starting()
+				} else if runs.finish() {
+					// queued or replaced by scheduled trigger
+					ctx.Starting()
 				} else {
 					ctx.Stopped()
 					ctx.Pause()
@@ -0,0 +1,289 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/task"
+	"infini.sh/framework/core/util"
+)
+
+const defaultHistorySize = 10
+
+// pipelineRuns tracks the run history and pending triggers of one pipeline
+type pipelineRuns struct {
+	lock        sync.Mutex
+	size        int
+	pending     int
+	running     bool
+	nextTrigger string
+	current     *RunRecord
+	runs        []RunRecord
+}
+
+func (r *pipelineRuns) setNextTrigger(trigger string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.nextTrigger = trigger
+}
+
+// enqueue holds a trigger until the running round finished, returns false if the queue is full
+func (r *pipelineRuns) enqueue(max int) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if max > 0 && r.pending >= max {
+		return false
+	}
+	r.pending++
+	return true
+}
+
+// replace keeps exactly one pending trigger to run after the current round get canceled
+func (r *pipelineRuns) replace() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.pending = 1
+}
+
+// finish takes a pending trigger to start the next round, or marks the runs
+// as idle, so the triggers after that start the pipeline again
+func (r *pipelineRuns) finish() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.pending <= 0 {
+		r.running = false
+		return false
+	}
+	r.pending--
+	r.nextTrigger = TriggerSchedule
+	return true
+}
+
+func (r *pipelineRuns) isRunning() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.running
+}
+
+func (r *pipelineRuns) begin(defaultTrigger string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	trigger := r.nextTrigger
+	if trigger == "" {
+		trigger = defaultTrigger
+	}
+	r.nextTrigger = ""
+	r.running = true
+	r.current = &RunRecord{
+		ID:        util.GetUUID(),
+		Trigger:   trigger,
+		State:     pipeline.STARTED,
+		StartTime: time.Now(),
+	}
+}
+
+func (r *pipelineRuns) end(state pipeline.RunningState, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.current == nil {
+		return
+	}
+	t := time.Now()
+	r.current.EndTime = &t
+	r.current.State = state
+	if err != nil {
+		r.current.Error = err.Error()
+	}
+	r.append(*r.current)
+	r.current = nil
+}
+
+func (r *pipelineRuns) skip(trigger string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	t := time.Now()
+	r.append(RunRecord{
+		ID:        util.GetUUID(),
+		Trigger:   trigger,
+		State:     RunSkipped,
+		StartTime: t,
+		EndTime:   &t,
+	})
+}
+
+// append must be called after holding lock
+func (r *pipelineRuns) append(record RunRecord) {
+	r.runs = append(r.runs, record)
+	if len(r.runs) > r.size {
+		r.runs = r.runs[len(r.runs)-r.size:]
+	}
+}
+
+func (r *pipelineRuns) lastRun() *RunRecord {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.current != nil {
+		record := *r.current
+		return &record
+	}
+	if len(r.runs) > 0 {
+		record := r.runs[len(r.runs)-1]
+		return &record
+	}
+	return nil
+}
+
+// history returns the runs in reverse order, the latest first
+func (r *pipelineRuns) history() (int, []RunRecord) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	runs := make([]RunRecord, 0, len(r.runs)+1)
+	if r.current != nil {
+		runs = append(runs, *r.current)
+	}
+	for i := len(r.runs) - 1; i >= 0; i-- {
+		runs = append(runs, r.runs[i])
+	}
+	return r.pending, runs
+}
+
+func (module *PipeModule) getRuns(cfg pipeline.PipelineConfigV2) *pipelineRuns {
+	size := cfg.HistorySize
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+	v, _ := module.runs.LoadOrStore(cfg.Name, &pipelineRuns{size: size})
+	runs := v.(*pipelineRuns)
+	runs.lock.Lock()
+	runs.size = size
+	runs.lock.Unlock()
+	return runs
+}
+
+func (module *PipeModule) loadRuns(taskID string) *pipelineRuns {
+	v, ok := module.runs.Load(taskID)
+	if !ok {
+		return nil
+	}
+	runs, ok := v.(*pipelineRuns)
+	if !ok {
+		return nil
+	}
+	return runs
+}
+
+func scheduleTaskID(taskID string) string {
+	return "pipeline_schedule:" + taskID
+}
+
+// registerSchedule registers a scheduled task to trigger the pipeline periodically
+func (module *PipeModule) registerSchedule(cfg pipeline.PipelineConfigV2) {
+	if !cfg.Schedule.IsEnabled() {
+		return
+	}
+
+	taskID := cfg.Name
+	scheduleTask := task.ScheduleTask{
+		ID:          scheduleTaskID(taskID),
+		Group:       "pipeline",
+		Description: "schedule pipeline: " + taskID,
+		Interval:    cfg.Schedule.Interval,
+		Crontab:     cfg.Schedule.Crontab,
+		Task: func(ctx context.Context) {
+			module.triggerTask(taskID, TriggerSchedule)
+		},
+	}
+	log.Debugf("register schedule for pipeline [%v], interval: %v, crontab: %v", taskID, cfg.Schedule.Interval, cfg.Schedule.Crontab)
+	task.RegisterScheduleTask(scheduleTask)
+}
+
+func (module *PipeModule) unregisterSchedule(taskID string) {
+	task.DeleteTask(scheduleTaskID(taskID))
+}
+
+// triggerTask starts the pipeline for a scheduled trigger, applies the concurrency policy if the previous round is still running
+func (module *PipeModule) triggerTask(taskID string, trigger string) {
+	if module.closed.Load() {
+		return
+	}
+
+	v, ok := module.contexts.Load(taskID)
+	if !ok {
+		return
+	}
+	ctx, ok := v.(*pipeline.Context)
+	if !ok {
+		return
+	}
+	runs := module.getRuns(ctx.Config)
+
+	// manually stopped pipeline should not be triggered until started again
+	if ctx.IsExit() {
+		log.Debugf("pipeline [%v] was stopped, skip %v trigger", taskID, trigger)
+		runs.skip(trigger)
+		return
+	}
+
+	// the previous round is over, the loop is going to pause
+	if !runs.isRunning() {
+		waitForPause(ctx, time.Second)
+	}
+	if ctx.IsPause() {
+		module.startTask(taskID, trigger)
+		return
+	}
+
+	policy := ctx.Config.Schedule.GetConcurrencyPolicy()
+	switch policy {
+	case pipeline.ConcurrencyQueue:
+		if !runs.enqueue(ctx.Config.Schedule.MaxQueuedRuns) {
+			log.Debugf("pipeline [%v] has too many queued runs, skip %v trigger", taskID, trigger)
+			runs.skip(trigger)
+		}
+	case pipeline.ConcurrencyReplace:
+		log.Debugf("pipeline [%v] is still running, cancel and start again", taskID)
+		runs.replace()
+		ctx.CancelTask()
+	default:
+		log.Debugf("pipeline [%v] is still running, skip %v trigger", taskID, trigger)
+		runs.skip(trigger)
+	}
+}
+
+func waitForPause(ctx *pipeline.Context, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for !ctx.IsPause() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/pipeline"
+)
+
+// testGate blocks the runs of a pipeline until released
+type testGate struct {
+	runs    int32
+	release chan struct{}
+}
+
+var testGates = sync.Map{}
+
+type gateProcessor struct {
+	gate *testGate
+}
+
+func (processor *gateProcessor) Name() string {
+	return "schedule_test"
+}
+
+func (processor *gateProcessor) Process(ctx *pipeline.Context) error {
+	atomic.AddInt32(&processor.gate.runs, 1)
+	for {
+		select {
+		case <-processor.gate.release:
+			return nil
+		case <-ctx.Context.Done():
+			return nil
+		}
+	}
+}
+
+func init() {
+	pipeline.RegisterProcessorPlugin("schedule_test", func(c *config.Config) (pipeline.Processor, error) {
+		cfg := struct {
+			Gate string `config:"gate"`
+		}{}
+		if err := c.Unpack(&cfg); err != nil {
+			return nil, err
+		}
+		v, ok := testGates.Load(cfg.Gate)
+		if !ok {
+			return nil, fmt.Errorf("gate [%v] not found", cfg.Gate)
+		}
+		return &gateProcessor{gate: v.(*testGate)}, nil
+	})
+}
+
+func newScheduledPipeline(t *testing.T, module *PipeModule, name, policy string) *testGate {
+	gate := &testGate{release: make(chan struct{})}
+	testGates.Store(name, gate)
+
+	processor, err := config.NewConfigFrom(map[string]interface{}{"schedule_test": map[string]interface{}{"gate": name}})
+	assert.NoError(t, err)
+	assert.NoError(t, module.createPipeline(pipeline.PipelineConfigV2{
+		Name:       name,
+		Processors: []*config.Config{processor},
+		Schedule:   &pipeline.ScheduleConfig{Interval: "1h", ConcurrencyPolicy: policy},
+	}, false))
+
+	waitFor(t, func() bool {
+		v, ok := module.contexts.Load(name)
+		return ok && v.(*pipeline.Context).IsPause()
+	})
+	t.Cleanup(func() {
+		module.stopTask(name)
+		module.deleteTask(name)
+	})
+	return gate
+}
+
+func waitFor(t *testing.T, check func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func historyStates(module *PipeModule, name string) (int, []pipeline.RunningState) {
+	pending, runs := module.loadRuns(name).history()
+	states := []pipeline.RunningState{}
+	for i := len(runs) - 1; i >= 0; i-- {
+		states = append(states, runs[i].State)
+	}
+	return pending, states
+}
+
+func TestScheduleSkip(t *testing.T) {
+	module := &PipeModule{}
+	gate := newScheduledPipeline(t, module, "schedule_skip", pipeline.ConcurrencySkip)
+
+	module.triggerTask("schedule_skip", TriggerSchedule)
+	waitFor(t, func() bool { return atomic.LoadInt32(&gate.runs) == 1 })
+	module.triggerTask("schedule_skip", TriggerSchedule)
+	_, states := historyStates(module, "schedule_skip")
+	assert.Equal(t, []pipeline.RunningState{RunSkipped, pipeline.STARTED}, states)
+
+	//triggered again after the round finished
+	gate.release <- struct{}{}
+	waitFor(t, func() bool { return !module.loadRuns("schedule_skip").isRunning() })
+	module.triggerTask("schedule_skip", TriggerSchedule)
+	waitFor(t, func() bool { return atomic.LoadInt32(&gate.runs) == 2 })
+	_, states = historyStates(module, "schedule_skip")
+	assert.Equal(t, []pipeline.RunningState{RunSkipped, pipeline.FINISHED, pipeline.STARTED}, states)
+	gate.release <- struct{}{}
+}
+
+func TestScheduleTriggerBeforePause(t *testing.T) {
+	module := &PipeModule{}
+	cfg := pipeline.PipelineConfigV2{Name: "schedule_finished", Schedule: &pipeline.ScheduleConfig{Interval: "1h"}}
+	ctx := pipeline.AcquireContext(cfg)
+	module.contexts.Store(cfg.Name, ctx)
+	runs := module.getRuns(cfg)
+
+	//the round finished, but the loop is not paused yet
+	runs.begin(TriggerSchedule)
+	runs.end(pipeline.FINISHED, nil)
+	ctx.Finished()
+	assert.False(t, runs.finish())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ctx.Pause()
+	}()
+
+	module.triggerTask(cfg.Name, TriggerSchedule)
+	assert.Equal(t, pipeline.STARTING, ctx.GetRunningState())
+	assert.False(t, ctx.IsPause())
+	_, states := historyStates(module, cfg.Name)
+	assert.Equal(t, []pipeline.RunningState{pipeline.FINISHED}, states)
+}
+
+func TestScheduleQueue(t *testing.T) {
+	module := &PipeModule{}
+	gate := newScheduledPipeline(t, module, "schedule_queue", pipeline.ConcurrencyQueue)
+
+	module.triggerTask("schedule_queue", TriggerSchedule)
+	waitFor(t, func() bool { return atomic.LoadInt32(&gate.runs) == 1 })
+	module.triggerTask("schedule_queue", TriggerSchedule)
+	module.triggerTask("schedule_queue", TriggerSchedule)
+	pending, _ := historyStates(module, "schedule_queue")
+	assert.Equal(t, 2, pending)
+
+	//queued runs start one by one
+	for i := 2; i <= 3; i++ {
+		gate.release <- struct{}{}
+		waitFor(t, func() bool { return atomic.LoadInt32(&gate.runs) == int32(i) })
+	}
+	gate.release <- struct{}{}
+	waitFor(t, func() bool { return !module.loadRuns("schedule_queue").isRunning() })
+	pending, states := historyStates(module, "schedule_queue")
+	assert.Equal(t, 0, pending)
+	assert.Equal(t, []pipeline.RunningState{pipeline.FINISHED, pipeline.FINISHED, pipeline.FINISHED}, states)
+}
+
+func TestScheduleReplace(t *testing.T) {
+	module := &PipeModule{}
+	gate := newScheduledPipeline(t, module, "schedule_replace", pipeline.ConcurrencyReplace)
+
+	module.triggerTask("schedule_replace", TriggerSchedule)
+	waitFor(t, func() bool { return atomic.LoadInt32(&gate.runs) == 1 })
+	module.triggerTask("schedule_replace", TriggerSchedule)
+	waitFor(t, func() bool { return atomic.LoadInt32(&gate.runs) == 2 })
+	_, states := historyStates(module, "schedule_replace")
+	assert.Equal(t, []pipeline.RunningState{pipeline.STOPPED, pipeline.STARTED}, states)
+	gate.release <- struct{}{}
+}
+
+func TestSchedulePauseAndResume(t *testing.T) {
+	module := &PipeModule{}
+	gate := newScheduledPipeline(t, module, "schedule_pause", pipeline.ConcurrencySkip)
+
+	//stopped manually, the triggers are skipped until started again
+	module.stopTask("schedule_pause")
+	module.triggerTask("schedule_pause", TriggerSchedule)
+	_, states := historyStates(module, "schedule_pause")
+	assert.Equal(t, []pipeline.RunningState{RunSkipped}, states)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&gate.runs))
+
+	assert.True(t, module.startTask("schedule_pause", TriggerManual))
+	waitFor(t, func() bool { return atomic.LoadInt32(&gate.runs) == 1 })
+	gate.release <- struct{}{}
+	waitFor(t, func() bool { return !module.loadRuns("schedule_pause").isRunning() })
+
+	module.triggerTask("schedule_pause", TriggerSchedule)
+	waitFor(t, func() bool { return atomic.LoadInt32(&gate.runs) == 2 })
+	_, runs := module.loadRuns("schedule_pause").history()
+	assert.Equal(t, TriggerSchedule, runs[0].Trigger)
+	assert.Equal(t, TriggerManual, runs[1].Trigger)
+	gate.release <- struct{}{}
+}
@@ -30,6 +30,7 @@ import (
 	"fmt"
 	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/module"
 	"infini.sh/framework/modules/queue/common"
 	"os"
 	"path"
@@ -607,6 +608,10 @@ func (module *DiskQueue) onReadComplete(evt Event, lastFilePrepared int64) int64
 	return -1
 }
 
+func (*DiskQueue) ShutdownPhase() module.ShutdownPhase {
+	return module.PhaseStorage
+}
+
 func (module *DiskQueue) Stop() error {
 
 	if module.cfg != nil && module.cfg == nil {
@@ -32,6 +32,7 @@ import (
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/module"
 	"infini.sh/framework/core/queue"
 	"infini.sh/framework/core/stats"
 	"infini.sh/framework/core/util"
@@ -73,6 +74,10 @@ func (this *MemoryQueue) Start() error {
 	return nil
 }
 
+func (*MemoryQueue) ShutdownPhase() module.ShutdownPhase {
+	return module.PhaseStorage
+}
+
 func (this *MemoryQueue) Stop() error {
 	return nil
 }
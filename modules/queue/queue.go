@@ -28,6 +28,7 @@
 package queue
 
 import (
+	"infini.sh/framework/core/module"
 	"infini.sh/framework/modules/queue/common"
 )
 
@@ -40,6 +41,10 @@ func (this *Module) Start() error {
 	common.InitQueueMetadata()
 	return nil
 }
+func (*Module) ShutdownPhase() module.ShutdownPhase {
+	return module.PhaseStorage
+}
+
 func (this *Module) Stop() error {
 	common.PersistQueueMetadata()
 	return nil
@@ -35,6 +35,7 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/module"
 	"infini.sh/framework/core/queue"
 )
 
@@ -174,6 +175,10 @@ func (module *RedisModule) Start() error {
 	return nil
 }
 
+func (*RedisModule) ShutdownPhase() module.ShutdownPhase {
+	return module.PhaseStorage
+}
+
 func (module *RedisModule) Stop() error {
 	if !module.config.Enabled {
 		return nil
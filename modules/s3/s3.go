@@ -34,6 +34,7 @@ import (
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/credential"
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
@@ -90,9 +91,21 @@ func NewS3Uploader(cfg *config.S3Config)(*S3Uploader,error)  {
 
 	var err error
 	uploader:=&S3Uploader{S3Config: cfg}
+	accessKey, accessSecret, token := cfg.AccessKey, cfg.AccessSecret, cfg.Token
+	if cfg.CredentialID != "" {
+		cred, err := credential.Loader(cfg.CredentialID)
+		if err != nil {
+			return nil, err
+		}
+		auth, err := cred.DecodeAWSAccessKey()
+		if err != nil {
+			return nil, err
+		}
+		accessKey, accessSecret, token = auth.AccessKeyID, auth.SecretAccessKey.Get(), auth.SessionToken.Get()
+	}
 	uploader.minioClient, err = minio.New(uploader.S3Config.Endpoint, &minio.Options{
 		Transport:transport,
-		Creds:  credentials.NewStaticV4(uploader.S3Config.AccessKey, uploader.S3Config.AccessSecret, uploader.S3Config.Token),
+		Creds:  credentials.NewStaticV4(accessKey, accessSecret, token),
 		Secure: uploader.S3Config.SSL,
 
 	})
@@ -28,23 +28,21 @@
 package stats
 
 import (
-	"fmt"
 	"net/http"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 
+	log "github.com/cihub/seelog"
 	"github.com/segmentio/encoding/json"
 	httprouter "infini.sh/framework/core/api/router"
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/stats"
 	"infini.sh/framework/core/util"
-	"infini.sh/framework/lib/bytebufferpool"
 )
 
-var space = []byte(" ")
-var newline = []byte("\n")
 var statsLock = sync.RWMutex{}
 
 // StatsAction return stats information
@@ -79,7 +77,8 @@ func (handler SimpleStatsModule) StatsAction(w http.ResponseWriter, req *http.Re
 	handler.WriteHeader(w, 200)
 }
 
-// StatsAction return stats information
+// PrometheusStatsAction return stats information in the prometheus text format,
+// or in the OpenMetrics format if requested by the Accept header
 func (handler SimpleStatsModule) PrometheusStatsAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 
 	var err error
@@ -89,25 +88,88 @@ func (handler SimpleStatsModule) PrometheusStatsAction(w http.ResponseWriter, re
 		return
 	}
 
-	kv := util.Flatten(metrics, false)
-	buffer := bytebufferpool.Get("stats")
-	defer bytebufferpool.Put("stats", buffer)
-	for k, v := range kv {
-		buffer.Write(util.UnsafeStringToBytes(util.PrometheusMetricReplacer.Replace(k)))
-		buffer.Write(util.UnsafeStringToBytes(fmt.Sprintf("{type=\"%v\", ip=\"%v\", name=\"%v\", id=\"%v\"}",
-			global.Env().GetAppLowercaseName(),
-			global.Env().SystemConfig.NodeConfig.IP,
-			global.Env().SystemConfig.NodeConfig.Name,
-			global.Env().SystemConfig.NodeConfig.ID,
-		)))
-		buffer.Write(space)
-		buffer.Write(util.UnsafeStringToBytes(util.ToString(v)))
-		buffer.Write(newline)
+	//timings are exported as histograms below
+	delete(metrics, "timing")
+
+	constLabels := []stats.Label{
+		{Name: "type", Value: global.Env().GetAppLowercaseName()},
+		{Name: "ip", Value: global.Env().SystemConfig.NodeConfig.IP},
+		{Name: "name", Value: global.Env().SystemConfig.NodeConfig.Name},
+		{Name: "id", Value: global.Env().SystemConfig.NodeConfig.ID},
 	}
-	handler.WriteTextHeader(w)
-	handler.Write(w, buffer.Bytes())
 
+	families := handler.data.legacyMetricFamilies(metrics)
+	families = append(families, handler.data.timingMetricFamilies()...)
+	families = append(families, stats.GatherMetrics()...)
+
+	openMetrics := strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", stats.OpenMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", stats.PrometheusContentType)
+	}
 	handler.WriteHeader(w, 200)
+	err = stats.WritePrometheus(w, families, constLabels, openMetrics)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// legacyMetricFamilies flattens the stats map, the values of the stats category are counters
+// unless they were set as gauges or decremented, others are all gauges
+func (s *Stats) legacyMetricFamilies(metrics util.MapStr) []stats.MetricFamily {
+	kv := util.Flatten(metrics, false)
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	families := make([]stats.MetricFamily, 0, len(keys))
+	for _, k := range keys {
+		v, ok := kv[k]
+		if !ok {
+			continue
+		}
+		value, err := util.ExtractFloat(v)
+		if err != nil {
+			continue
+		}
+		typ := stats.GaugeMetric
+		if strings.HasPrefix(k, "stats.") && s != nil && !s.isGauge(strings.TrimPrefix(k, "stats.")) {
+			typ = stats.CounterMetric
+		}
+		families = append(families, stats.MetricFamily{
+			Name:    util.PrometheusMetricReplacer.Replace(k),
+			Type:    typ,
+			Metrics: []stats.Metric{{Value: value}},
+		})
+	}
+	return families
+}
+
+// timingMetricFamilies exports the timings as histograms, and the percentiles of the sliding window as gauges
+func (s *Stats) timingMetricFamilies() []stats.MetricFamily {
+	if s == nil {
+		return nil
+	}
+	var families []stats.MetricFamily
+	for category, keys := range s.Histograms() {
+		for key, h := range keys {
+			name := util.PrometheusMetricReplacer.Replace("timing." + category + "." + key)
+			snapshot := h
+			families = append(families, stats.MetricFamily{Name: name, Type: stats.HistogramMetric,
+				Metrics: []stats.Metric{{Histogram: &snapshot}}})
+			for suffix, v := range map[string]int64{"_p50": h.P50, "_p90": h.P90, "_p99": h.P99, "_max": h.Max} {
+				families = append(families, stats.MetricFamily{Name: name + suffix, Type: stats.GaugeMetric,
+					Metrics: []stats.Metric{{Value: float64(v)}}})
+			}
+		}
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].Name < families[j].Name
+	})
+	return families
 }
 
 func (handler SimpleStatsModule) GoroutinesAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
@@ -62,6 +62,10 @@ type SimpleStatsConfig struct {
 	IncludeStorageStatsInAPI bool `config:"include_storage_stats_in_api"`
 	BufferSize               int  `config:"buffer_size"`
 	FlushIntervalInMs        int  `config:"flush_interval_ms"`
+
+	//sliding window of the timing percentiles
+	HistogramWindowInSeconds int       `config:"histogram_window_in_seconds"`
+	HistogramBuckets         []float64 `config:"histogram_buckets"`
 }
 
 func (module *SimpleStatsModule) Setup() {
@@ -73,6 +77,7 @@ func (module *SimpleStatsModule) Setup() {
 		BufferSize:               1000,
 		IncludeStorageStatsInAPI: true,
 		FlushIntervalInMs:        1000,
+		HistogramWindowInSeconds: 60,
 	}
 	env.ParseConfig("stats", module.config)
 
@@ -215,6 +220,25 @@ type Stats struct {
 	raw       bool
 	q         *queue.EsQueue
 	cfg       *SimpleStatsConfig
+
+	hl         sync.RWMutex
+	histograms map[string]map[string]*stats.Histogram //not persisted
+	gauges     map[string]bool                        //keys set as gauges, not persisted
+}
+
+func (s *Stats) markGauge(category, key string) {
+	s.l.Lock()
+	if s.gauges == nil {
+		s.gauges = map[string]bool{}
+	}
+	s.gauges[category+"."+key] = true
+	s.l.Unlock()
+}
+
+func (s *Stats) isGauge(key string) bool {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	return s.gauges[key]
 }
 
 func (s *Stats) initData(category, key string) {
@@ -255,6 +279,7 @@ func (s *Stats) IncrementBy(category, key string, value int64) {
 
 func (s *Stats) Absolute(category, key string, value int64) {
 	s.initData(category, key)
+	s.markGauge(category, key)
 	s.l.Lock()
 	(*s.Data)[category][key] = value
 	s.l.Unlock()
@@ -270,6 +295,8 @@ func (s *Stats) DecrementBy(category, key string, value int64) {
 		return
 	}
 
+	s.markGauge(category, key)
+
 	if s.raw {
 		s.initData(category, key)
 		s.l.Lock()
@@ -281,8 +308,61 @@ func (s *Stats) DecrementBy(category, key string, value int64) {
 	runtime.Gosched()
 }
 
+// number of slots in the sliding window of histograms
+const histogramSlots = 6
+
+func (s *Stats) getHistogram(category, key string) *stats.Histogram {
+	s.hl.RLock()
+	h, ok := s.histograms[category][key]
+	s.hl.RUnlock()
+	if ok {
+		return h
+	}
+
+	s.hl.Lock()
+	defer s.hl.Unlock()
+	if s.histograms == nil {
+		s.histograms = map[string]map[string]*stats.Histogram{}
+	}
+	if _, ok := s.histograms[category]; !ok {
+		s.histograms[category] = map[string]*stats.Histogram{}
+	}
+	h, ok = s.histograms[category][key]
+	if !ok {
+		window := time.Minute
+		var buckets []float64
+		if s.cfg != nil {
+			if s.cfg.HistogramWindowInSeconds > 0 {
+				window = time.Duration(s.cfg.HistogramWindowInSeconds) * time.Second
+			}
+			buckets = s.cfg.HistogramBuckets
+		}
+		h = stats.NewHistogram(window, histogramSlots, buckets)
+		s.histograms[category][key] = h
+	}
+	return h
+}
+
 func (s *Stats) Timing(category, key string, v int64) {
+	if s.closed {
+		return
+	}
+	s.getHistogram(category, key).Record(v)
+}
 
+// Histograms returns the snapshots of all the timings
+func (s *Stats) Histograms() map[string]map[string]stats.HistogramSnapshot {
+	s.hl.RLock()
+	defer s.hl.RUnlock()
+	result := make(map[string]map[string]stats.HistogramSnapshot, len(s.histograms))
+	for category, keys := range s.histograms {
+		m := make(map[string]stats.HistogramSnapshot, len(keys))
+		for key, h := range keys {
+			m[key] = h.Snapshot()
+		}
+		result[category] = m
+	}
+	return result
 }
 
 func (s *Stats) GetTimestamp(category, key string) (time.Time, error) {
@@ -304,6 +384,7 @@ func (s *Stats) RecordTimestamp(category, key string, v time.Time) {
 
 func (s *Stats) Gauge(category, key string, v int64) {
 	s.initData(category, key)
+	s.markGauge(category, key)
 	s.l.Lock()
 	(*s.Data)[category][key] = v
 	s.l.Unlock()
@@ -329,6 +410,10 @@ func (s *Stats) StatsAll() string {
 
 	result["pool"] = bytebufferpool.BuffStats()
 
+	if timing := s.Histograms(); len(timing) > 0 {
+		result["timing"] = timing
+	}
+
 	//update system metrics
 	checkPid := os.Getpid()
 	p, _ := process.NewProcess(int32(checkPid))
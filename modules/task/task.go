@@ -65,6 +65,7 @@ func (module *TaskModule) Setup() {
 	})
 
 	api.HandleAPIMethod(api.GET, "/tasks/", module.GetTaskList)
+	api.HandleAPIMethod(api.POST, "/tasks/", module.CreateTask)
 	api.HandleAPIMethod(api.POST, "/task/:id/_start", module.StartTask)
 	api.HandleAPIMethod(api.POST, "/task/:id/_stop", module.StopTask)
 	api.HandleAPIMethod(api.DELETE, "/task/:id", module.DeleteTask)
@@ -89,6 +90,39 @@ func (module *TaskModule) GetTaskList(w http.ResponseWriter, req *http.Request,
 	module.WriteJSON(w, r, 200)
 }
 
+// CreateTask registers a persistent schedule task, which refers to a registered task handler
+func (module *TaskModule) CreateTask(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	obj := task.ScheduleTask{}
+	err := module.DecodeJSON(req, &obj)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if obj.Handler == "" {
+		module.WriteError(w, "task handler is required", http.StatusBadRequest)
+		return
+	}
+	if _, ok := task.GetTaskHandler(obj.Handler); !ok {
+		module.WriteError(w, "task handler not found: "+obj.Handler, http.StatusBadRequest)
+		return
+	}
+	if obj.Interval == "" && obj.Crontab == "" {
+		module.WriteError(w, "interval or crontab is required", http.StatusBadRequest)
+		return
+	}
+
+	obj.Persistent = true
+	obj.Type = ""
+	id, err := task.TryRegisterScheduleTask(obj)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	module.WriteAckJSON(w, true, 200, util.MapStr{
+		"_id": id,
+	})
+}
+
 func (module *TaskModule) StartTask(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	task.StartTask(ps.ByName("id"))
 	module.WriteAckOKJSON(w)
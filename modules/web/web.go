@@ -34,6 +34,7 @@ import (
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/logging/logger"
 	_ "net/http/pprof"
+	"infini.sh/framework/core/module"
 )
 
 type WebModule struct {
@@ -65,6 +66,10 @@ func (module *WebModule) Start() error {
 	return nil
 }
 
+func (*WebModule) ShutdownPhase() module.ShutdownPhase {
+	return module.PhaseIngress
+}
+
 func (module *WebModule) Stop() error {
 	if global.Env().SystemConfig.WebAppConfig.Enabled {
 		uis.StopWeb(global.Env().SystemConfig.WebAppConfig)
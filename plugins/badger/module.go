@@ -34,6 +34,7 @@ import (
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/kv"
 	"infini.sh/framework/core/module"
+	"infini.sh/framework/core/orm"
 	"path"
 )
 
@@ -56,6 +57,8 @@ type Config struct {
 	ValueLogGCEnabled           bool    `config:"value_log_gc_enabled"`
 	ValueLogDiscardRatio        float64 `config:"value_log_gc_discard_ratio"`
 	ValueLogGCIntervalInSeconds int     `config:"value_log_gc_interval_in_seconds"`
+
+	ORM ORMConfig `config:"orm"`
 }
 
 type Module struct {
@@ -95,6 +98,9 @@ func (module *Module) Setup() {
 	if module.cfg.Enabled {
 		filter.Register("badger", module)
 		kv.Register("badger", module)
+		if module.cfg.ORM.Enabled {
+			orm.Register("badger", &ORM{module: module, Config: module.cfg.ORM})
+		}
 	}
 
 }
@@ -112,6 +118,10 @@ func (module *Module) Start() error {
 	return nil
 }
 
+func (*Module) ShutdownPhase() module.ShutdownPhase {
+	return module.PhaseStorage
+}
+
 func (module *Module) Stop() error {
 
 	if module.cfg == nil {
@@ -0,0 +1,380 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package badger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+var ErrNotFound = errors.New("record not found")
+
+const ormBucket = "orm"
+
+type ORMConfig struct {
+	Enabled     bool   `config:"enabled"`
+	IndexPrefix string `config:"index_prefix"`
+}
+
+// ORM stores objects as JSON documents in badger, it is meant for small
+// deployments and tests, queries are evaluated by scanning the whole index
+type ORM struct {
+	module     *Module
+	Config     ORMConfig
+	indexNames sync.Map
+}
+
+type ormDocument struct {
+	index  string
+	id     string
+	source util.MapStr
+}
+
+func getIndexID(o interface{}) string {
+	return util.GetFieldValueByTagName(o, "elastic_meta", "_id")
+}
+
+func getIndexPrefix(indexName string) string {
+	if strings.HasSuffix(indexName, "*") {
+		return ormBucket + ":" + strings.TrimSuffix(indexName, "*")
+	}
+	return ormBucket + ":" + indexName + ","
+}
+
+func getDocumentKey(indexName, id string) []byte {
+	return []byte(getIndexPrefix(indexName) + id)
+}
+
+func (handler *ORM) RegisterSchemaWithIndexName(t interface{}, indexName string) error {
+	if indexName == "" {
+		return nil
+	}
+	pkg, name := util.GetTypeAndPackageName(t, true)
+	handler.indexNames.Store(fmt.Sprintf("%s-%s", pkg, name), indexName)
+	return nil
+}
+
+func (handler *ORM) GetIndexName(o interface{}) string {
+	pkg, name := util.GetTypeAndPackageName(o, true)
+	if v, ok := handler.indexNames.Load(fmt.Sprintf("%s-%s", pkg, name)); ok {
+		name = v.(string)
+	}
+	return handler.Config.IndexPrefix + name
+}
+
+func (handler *ORM) GetWildcardIndexName(o interface{}) string {
+	return fmt.Sprintf("%v*", handler.GetIndexName(o))
+}
+
+func (handler *ORM) Save(ctx *orm.Context, o interface{}) error {
+	id := getIndexID(o)
+	if id == "" {
+		return fmt.Errorf("id was not found in object: %v", o)
+	}
+	data, err := util.ToJSONBytes(o)
+	if err != nil {
+		return err
+	}
+	indexName := handler.GetIndexName(o)
+	stats.Increment("badger", ormBucket+"::save")
+	return handler.module.mustGetBucket(ormBucket).Update(func(txn *badger.Txn) error {
+		return txn.Set(getDocumentKey(indexName, id), data)
+	})
+}
+
+// Update merges the new data into the old data
+func (handler *ORM) Update(ctx *orm.Context, o interface{}) error {
+	id := getIndexID(o)
+	if id == "" {
+		return fmt.Errorf("id was not found in object: %v", o)
+	}
+	data, err := util.ToJSONBytes(o)
+	if err != nil {
+		return err
+	}
+	doc := util.MapStr{}
+	err = util.FromJSONBytes(data, &doc)
+	if err != nil {
+		return err
+	}
+
+	key := getDocumentKey(handler.GetIndexName(o), id)
+	stats.Increment("badger", ormBucket+"::update")
+	return handler.module.mustGetBucket(ormBucket).Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		old := util.MapStr{}
+		err = item.Value(func(val []byte) error {
+			return util.FromJSONBytes(val, &old)
+		})
+		if err != nil {
+			return err
+		}
+		old.DeepUpdate(doc)
+		data, err := util.ToJSONBytes(old)
+		if err != nil {
+			return err
+		}
+		return txn.Set(key, data)
+	})
+}
+
+func (handler *ORM) Delete(ctx *orm.Context, o interface{}) error {
+	id := getIndexID(o)
+	if id == "" {
+		return fmt.Errorf("id was not found in object: %v", o)
+	}
+	stats.Increment("badger", ormBucket+"::delete")
+	return handler.module.mustGetBucket(ormBucket).Update(func(txn *badger.Txn) error {
+		return txn.Delete(getDocumentKey(handler.GetIndexName(o), id))
+	})
+}
+
+func (handler *ORM) Get(o interface{}) (bool, error) {
+	id := getIndexID(o)
+	if id == "" {
+		return false, fmt.Errorf("id was not found in object: %v", o)
+	}
+
+	stats.Increment("badger", ormBucket+"::get")
+	var data []byte
+	err := handler.module.mustGetBucket(ormBucket).View(func(txn *badger.Txn) error {
+		item, err := txn.Get(getDocumentKey(handler.GetIndexName(o), id))
+		if err != nil {
+			return err
+		}
+		data, err = item.ValueCopy(nil)
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return false, ErrNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	err = util.FromJSONBytes(data, o)
+	return true, err
+}
+
+func (handler *ORM) GetBy(field string, value interface{}, t interface{}) (error, orm.Result) {
+	query := orm.Query{}
+	query.Conds = orm.And(orm.Eq(field, value))
+	return handler.Search(t, &query)
+}
+
+func (handler *ORM) Search(t interface{}, q *orm.Query) (error, orm.Result) {
+	result := orm.Result{}
+	if len(q.RawQuery) > 0 || q.TemplatedQuery != nil {
+		return errors.New("raw query and templated query are not supported by badger orm"), result
+	}
+	if len(q.Aggregations) > 0 {
+		return errors.New("aggregations are not supported by badger orm"), result
+	}
+
+	var indexName = q.IndexName
+	if indexName == "" {
+		indexName = handler.GetIndexName(t)
+		if q.WildcardIndex {
+			indexName = handler.GetWildcardIndexName(t)
+		}
+	}
+
+	docs, err := handler.scan(indexName, q.Conds)
+	if err != nil {
+		return err, result
+	}
+	var sorts []orm.Sort
+	if q.Sort != nil {
+		sorts = *q.Sort
+	}
+	sortDocuments(docs, sorts)
+	if q.CollapseField != "" {
+		docs = collapseDocuments(docs, q.CollapseField)
+	}
+	result.Total = int64(len(docs))
+
+	if q.SearchAfter != nil && len(*q.SearchAfter) > 0 {
+		docs = searchAfter(docs, sorts, *q.SearchAfter)
+	} else if q.From > 0 {
+		if q.From >= len(docs) {
+			docs = nil
+		} else {
+			docs = docs[q.From:]
+		}
+	}
+	size := q.Size
+	if size <= 0 {
+		size = 10
+	}
+	if len(docs) > size {
+		docs = docs[:size]
+	}
+
+	var array []interface{}
+	hits := []util.MapStr{}
+	for _, doc := range docs {
+		source := projectDocument(doc.source, q.IncludeFields, q.ExcludeFields)
+		array = append(array, map[string]interface{}(source))
+		hits = append(hits, util.MapStr{
+			"_index":  doc.index,
+			"_id":     doc.id,
+			"_source": source,
+		})
+	}
+	if len(docs) > 0 {
+		result.Cursor = getSortValues(docs[len(docs)-1], sorts)
+	}
+
+	result.Result = array
+	result.Raw = util.MustToJSONBytes(util.MapStr{
+		"hits": util.MapStr{
+			"total": util.MapStr{"value": result.Total, "relation": "eq"},
+			"hits":  hits,
+		},
+	})
+	return nil, result
+}
+
+func (handler *ORM) Count(o interface{}, query interface{}) (int64, error) {
+	conds, err := getConds(query)
+	if err != nil {
+		return 0, err
+	}
+	docs, err := handler.scan(handler.GetIndexName(o), conds)
+	return int64(len(docs)), err
+}
+
+// GroupBy counts documents by the value of groupField, optionally filtered by haveQuery=haveValue
+func (handler *ORM) GroupBy(o interface{}, selectField, groupField string, haveQuery string, haveValue interface{}) (error, map[string]interface{}) {
+	var conds []*orm.Cond
+	if haveQuery != "" {
+		conds = orm.And(orm.Eq(haveQuery, haveValue))
+	}
+	docs, err := handler.scan(handler.GetIndexName(o), conds)
+	if err != nil {
+		return err, nil
+	}
+	result := map[string]interface{}{}
+	for _, doc := range docs {
+		v, _ := doc.source.GetValue(groupField)
+		for _, value := range toSlice(v) {
+			k := fmt.Sprintf("%v", value)
+			count, _ := result[k].(int64)
+			result[k] = count + 1
+		}
+	}
+	return nil, result
+}
+
+func (handler *ORM) DeleteBy(o interface{}, query interface{}) error {
+	conds, err := getConds(query)
+	if err != nil {
+		return err
+	}
+	docs, err := handler.scan(handler.GetIndexName(o), conds)
+	if err != nil {
+		return err
+	}
+
+	stats.Increment("badger", ormBucket+"::delete_by")
+	batch := handler.module.mustGetBucket(ormBucket).NewWriteBatch()
+	defer batch.Cancel()
+	for _, doc := range docs {
+		err = batch.Delete(getDocumentKey(doc.index, doc.id))
+		if err != nil {
+			return err
+		}
+	}
+	return batch.Flush()
+}
+
+func (handler *ORM) UpdateBy(o interface{}, query interface{}) error {
+	return errors.New("update by query is not supported by badger orm")
+}
+
+// scan walks through all the documents of the index and returns the matched ones
+func (handler *ORM) scan(indexName string, conds []*orm.Cond) ([]*ormDocument, error) {
+	var docs []*ormDocument
+	stats.Increment("badger", ormBucket+"::scan")
+	err := handler.module.mustGetBucket(ormBucket).View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(getIndexPrefix(indexName))
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(opts.Prefix); it.ValidForPrefix(opts.Prefix); it.Next() {
+			item := it.Item()
+			key := strings.TrimPrefix(string(item.Key()), ormBucket+":")
+			i := strings.Index(key, ",")
+			if i < 0 {
+				continue
+			}
+			doc := &ormDocument{index: key[:i], id: key[i+1:], source: util.MapStr{}}
+			err := item.Value(func(val []byte) error {
+				return util.FromJSONBytes(val, &doc.source)
+			})
+			if err != nil {
+				return err
+			}
+			if _, ok := doc.source["id"]; !ok {
+				doc.source["id"] = doc.id
+			}
+			if matchConds(doc.source, conds) {
+				docs = append(docs, doc)
+			}
+		}
+		return nil
+	})
+	return docs, err
+}
+
+// getConds accepts a query, a single condition or a list of conditions, raw query dsl is not supported
+func getConds(query interface{}) ([]*orm.Cond, error) {
+	switch v := query.(type) {
+	case nil:
+		return nil, nil
+	case *orm.Query:
+		return v.Conds, nil
+	case []*orm.Cond:
+		return v, nil
+	case *orm.Cond:
+		return []*orm.Cond{v}, nil
+	}
+	return nil, fmt.Errorf("invalid query type: %T, only conditions are supported by badger orm", query)
+}
@@ -0,0 +1,302 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package badger
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+// matchConds follows the bool query of elasticsearch, should conditions are
+// only required when there is no must or filter condition
+func matchConds(doc util.MapStr, conds []*orm.Cond) bool {
+	var hasRequired, hasShould, shouldMatched bool
+	for _, c := range conds {
+		matched := matchCond(doc, c)
+		switch c.BoolType {
+		case orm.Must, orm.Filter:
+			hasRequired = true
+			if !matched {
+				return false
+			}
+		case orm.MustNot:
+			if matched {
+				return false
+			}
+		case orm.Should:
+			hasShould = true
+			if matched {
+				shouldMatched = true
+			}
+		}
+	}
+	if hasShould && !hasRequired {
+		return shouldMatched
+	}
+	return true
+}
+
+func matchCond(doc util.MapStr, c *orm.Cond) bool {
+	if c.QueryType == orm.BoolGroup {
+		return matchConds(doc, c.Conds)
+	}
+	v, _ := doc.GetValue(c.Field)
+	values := toSlice(v)
+	if c.QueryType == orm.Exists {
+		return len(values) > 0
+	}
+	for _, value := range values {
+		if matchValue(value, c) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchValue(v interface{}, c *orm.Cond) bool {
+	switch c.QueryType {
+	case orm.Match, orm.Term:
+		return equals(v, c.Value)
+	case orm.Terms, orm.StringTerms:
+		for _, item := range toSlice(c.Value) {
+			if equals(v, item) {
+				return true
+			}
+		}
+		return false
+	case orm.Prefix:
+		return strings.HasPrefix(fmt.Sprintf("%v", v), fmt.Sprintf("%v", c.Value))
+	case orm.Wildcard:
+		pattern := regexp.QuoteMeta(fmt.Sprintf("%v", c.Value))
+		pattern = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(pattern)
+		return matchRegexp(pattern, v)
+	case orm.Regexp:
+		return matchRegexp(fmt.Sprintf("%v", c.Value), v)
+	case orm.RangeGt, orm.RangeGte, orm.RangeLt, orm.RangeLte:
+		r, ok := compareValues(v, c.Value)
+		if !ok {
+			return false
+		}
+		switch c.QueryType {
+		case orm.RangeGt:
+			return r > 0
+		case orm.RangeGte:
+			return r >= 0
+		case orm.RangeLt:
+			return r < 0
+		default:
+			return r <= 0
+		}
+	}
+	return false
+}
+
+// patterns are anchored like the regexp query of elasticsearch
+func matchRegexp(pattern string, v interface{}) bool {
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(fmt.Sprintf("%v", v))
+}
+
+func equals(a, b interface{}) bool {
+	r, ok := compareValues(a, b)
+	return ok && r == 0
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch v.(type) {
+	case float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		f, err := util.ExtractFloat(v)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case *time.Time:
+		if t != nil {
+			return *t, true
+		}
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		return parsed, err == nil
+	}
+	return time.Time{}, false
+}
+
+// compareValues compares numbers and times by value, and others by their string form
+func compareValues(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if fa, ok := toNumber(a); ok {
+		if fb, ok := toNumber(b); ok {
+			switch {
+			case fa < fb:
+				return -1, true
+			case fa > fb:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	_, isStringA := a.(string)
+	_, isStringB := b.(string)
+	if !isStringA || !isStringB {
+		if ta, ok := toTime(a); ok {
+			if tb, ok := toTime(b); ok {
+				switch {
+				case ta.Before(tb):
+					return -1, true
+				case ta.After(tb):
+					return 1, true
+				}
+				return 0, true
+			}
+		}
+	}
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b)), true
+}
+
+// toSlice returns the elements of array values, or the value itself
+func toSlice(v interface{}) []interface{} {
+	if v == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []interface{}{v}
+	}
+	var result []interface{}
+	for i := 0; i < rv.Len(); i++ {
+		result = append(result, rv.Index(i).Interface())
+	}
+	return result
+}
+
+// getSortValues returns the values of the sort fields, followed by the id as a tiebreaker
+func getSortValues(doc *ormDocument, sorts []orm.Sort) []interface{} {
+	values := make([]interface{}, 0, len(sorts)+1)
+	for _, s := range sorts {
+		v, _ := doc.source.GetValue(s.Field)
+		values = append(values, v)
+	}
+	return append(values, doc.id)
+}
+
+// compareSortValues compares two lists of sort values, missing values are always sorted last
+func compareSortValues(a, b []interface{}, sorts []orm.Sort) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == nil || b[i] == nil {
+			if a[i] == nil && b[i] == nil {
+				continue
+			}
+			if a[i] == nil {
+				return 1
+			}
+			return -1
+		}
+		r, _ := compareValues(a[i], b[i])
+		if r == 0 {
+			continue
+		}
+		if i < len(sorts) && sorts[i].SortType == orm.DESC {
+			return -r
+		}
+		return r
+	}
+	return 0
+}
+
+func sortDocuments(docs []*ormDocument, sorts []orm.Sort) {
+	values := make(map[*ormDocument][]interface{}, len(docs))
+	for _, doc := range docs {
+		values[doc] = getSortValues(doc, sorts)
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		return compareSortValues(values[docs[i]], values[docs[j]], sorts) < 0
+	})
+}
+
+func searchAfter(docs []*ormDocument, sorts []orm.Sort, cursor []interface{}) []*ormDocument {
+	for i, doc := range docs {
+		if compareSortValues(getSortValues(doc, sorts), cursor, sorts) > 0 {
+			return docs[i:]
+		}
+	}
+	return nil
+}
+
+// collapseDocuments keeps the first document of each value of the field
+func collapseDocuments(docs []*ormDocument, field string) []*ormDocument {
+	seen := map[string]bool{}
+	var result []*ormDocument
+	for _, doc := range docs {
+		v, _ := doc.source.GetValue(field)
+		k := fmt.Sprintf("%v", v)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		result = append(result, doc)
+	}
+	return result
+}
+
+func projectDocument(source util.MapStr, includes, excludes []string) util.MapStr {
+	if len(includes) == 0 && len(excludes) == 0 {
+		return source
+	}
+	result := source
+	if len(includes) > 0 {
+		result = util.MapStr{"id": source["id"]}
+		for _, field := range includes {
+			if v, err := source.GetValue(field); err == nil {
+				result.Put(field, v)
+			}
+		}
+	} else {
+		result = source.Clone()
+	}
+	for _, field := range excludes {
+		result.Delete(field)
+	}
+	return result
+}
@@ -0,0 +1,149 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package badger
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+type ormTestObject struct {
+	orm.ORMObjectBase
+	Name  string   `json:"name,omitempty"`
+	Count int      `json:"count,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+func newTestORM(t *testing.T) *ORM {
+	dir := path.Join(os.TempDir(), "badger_orm_"+util.PickRandomName())
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	//open the db directly, the opened buckets are shared by the package
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	m := &Module{cfg: &Config{Path: dir, SingleBucketMode: true}, bucket: db}
+	return &ORM{module: m}
+}
+
+func TestORM(t *testing.T) {
+	handler := newTestORM(t)
+	handler.RegisterSchemaWithIndexName(ormTestObject{}, "test-object")
+	assert.Equal(t, "test-object", handler.GetIndexName(&ormTestObject{}))
+
+	now := time.Now()
+	for i, name := range []string{"alpha", "beta", "gamma", "delta"} {
+		o := &ormTestObject{Name: name, Count: i, Tags: []string{"all", name[:1]}}
+		o.ID = name
+		created := now.Add(time.Duration(i) * time.Minute)
+		o.Created = &created
+		assert.NoError(t, handler.Save(nil, o))
+	}
+
+	o := &ormTestObject{}
+	o.ID = "beta"
+	exists, err := handler.Get(o)
+	assert.True(t, exists)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, o.Count)
+
+	o = &ormTestObject{}
+	o.ID = "none"
+	exists, err = handler.Get(o)
+	assert.False(t, exists)
+	assert.Equal(t, ErrNotFound, err)
+
+	count, err := handler.Count(&ormTestObject{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+
+	count, err = handler.Count(&ormTestObject{}, []*orm.Cond{orm.Ge("count", 1), orm.NotEq("name", "delta")})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	count, err = handler.Count(&ormTestObject{}, orm.Or(orm.Eq("tags", "a"), orm.PrefixOf("name", "ga")))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	count, err = handler.Count(&ormTestObject{}, orm.Gt("created", now.Add(90*time.Second)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	count, err = handler.Count(&ormTestObject{}, []byte(`{}`))
+	assert.Error(t, err)
+
+	q := &orm.Query{Size: 2}
+	q.Conds = orm.And(orm.WildcardOf("name", "*a"))
+	q.AddSort("count", orm.DESC)
+	err, result := handler.Search(&ormTestObject{}, q)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), result.Total)
+	assert.Equal(t, "delta", result.Result[0].(map[string]interface{})["name"])
+	assert.Equal(t, "gamma", result.Result[1].(map[string]interface{})["name"])
+
+	q.After(result.Cursor)
+	err, result = handler.Search(&ormTestObject{}, q)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(result.Result))
+	assert.Equal(t, "beta", result.Result[0].(map[string]interface{})["name"])
+	assert.Equal(t, "alpha", result.Result[1].(map[string]interface{})["name"])
+
+	q = &orm.Query{Size: 10}
+	q.Conds = orm.And(orm.Eq("name", "alpha"))
+	q.Select("name")
+	err, result = handler.Search(&ormTestObject{}, q)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": "alpha", "name": "alpha"}, result.Result[0])
+
+	update := &ormTestObject{Count: 100}
+	update.ID = "alpha"
+	assert.NoError(t, handler.Update(nil, update))
+	o = &ormTestObject{}
+	o.ID = "alpha"
+	handler.Get(o)
+	assert.Equal(t, 100, o.Count)
+	assert.Equal(t, "alpha", o.Name)
+
+	assert.NoError(t, handler.DeleteBy(&ormTestObject{}, orm.Lt("count", 3)))
+	count, _ = handler.Count(&ormTestObject{}, nil)
+	assert.Equal(t, int64(2), count)
+
+	assert.NoError(t, handler.Delete(nil, update))
+	count, _ = handler.Count(&ormTestObject{}, nil)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,313 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package dedup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/filter"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/param"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+const (
+	ActionDrop  = "drop"
+	ActionTag   = "tag"
+	ActionRoute = "route"
+)
+
+// staleSlots is how many windows before the previous one are dropped on the
+// first check, the buckets left by the last run of the processor
+const staleSlots = 1024
+
+type Config struct {
+	MessageField param.ParaKey `config:"message_field"`
+
+	//name of the registered filter, use the default filter if empty
+	Filter string `config:"filter"`
+	Bucket string `config:"bucket"`
+
+	//json paths of the message body to build the key, hash the whole body if empty
+	Fields []string `config:"fields"`
+	//pass through the messages missing any of the fields, messages missing all
+	//of them always pass through
+	IgnoreMissingFields bool `config:"ignore_missing_fields"`
+
+	//keys are only compared within the window, eg: 1h
+	Window string `config:"window"`
+
+	//drop, tag or route the duplicates
+	Action string `config:"action"`
+	//field set to true on the duplicated json messages, for the tag action
+	TagField string `config:"tag_field"`
+
+	OutputQueue struct {
+		Name   string                 `config:"name"`
+		Labels map[string]interface{} `config:"label" json:"label,omitempty"`
+	} `config:"output_queue"`
+}
+
+type DedupProcessor struct {
+	config            Config
+	filter            filter.Filter
+	window            time.Duration
+	tagPath           []string
+	outputQueueConfig *queue.QueueConfig
+	producer          queue.ProducerAPI
+
+	slotLock sync.Mutex
+	lastSlot int64
+}
+
+var messagesTotal = stats.NewCounterVec("dedup_messages_total", "Messages checked by the dedup processor, by result.", "bucket", "result")
+
+func init() {
+	pipeline.RegisterProcessorPlugin("dedup", New)
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	cfg := Config{
+		MessageField: "messages",
+		Bucket:       "dedup",
+		Action:       ActionDrop,
+		TagField:     "_duplicate",
+	}
+
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to unpack the configuration of dedup processor: %s", err)
+	}
+
+	processor := &DedupProcessor{config: cfg}
+
+	var err error
+	processor.filter, err = filter.GetFilter(cfg.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Window != "" {
+		processor.window, err = time.ParseDuration(cfg.Window)
+		if err != nil || processor.window <= 0 {
+			return nil, errors.Errorf("invalid window of dedup processor: %v", cfg.Window)
+		}
+		//the buckets of the past windows would never be dropped
+		if _, ok := processor.filter.(filter.BucketDeleter); !ok {
+			return nil, errors.New("window of dedup processor requires a filter able to delete buckets, eg: bloom or cuckoo")
+		}
+	}
+
+	switch cfg.Action {
+	case ActionDrop:
+	case ActionTag:
+		processor.tagPath = strings.Split(cfg.TagField, ".")
+	case ActionRoute:
+		if cfg.OutputQueue.Name == "" {
+			return nil, errors.New("name of output_queue can't be nil")
+		}
+		labels := util.MapStr{}
+		labels["type"] = "dedup"
+		for k, v := range cfg.OutputQueue.Labels {
+			labels[k] = v
+		}
+		processor.outputQueueConfig = queue.AdvancedGetOrInitConfig("", cfg.OutputQueue.Name, labels)
+		processor.producer, err = queue.AcquireProducer(processor.outputQueueConfig)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("invalid action of dedup processor: %v", cfg.Action)
+	}
+
+	return processor, nil
+}
+
+func (processor *DedupProcessor) Name() string {
+	return "dedup"
+}
+
+func (processor *DedupProcessor) Release() error {
+	if processor.producer != nil {
+		return processor.producer.Close()
+	}
+	return nil
+}
+
+// key returns the digest of the configured fields or of the whole body, nil
+// means the message can't be checked and passes through
+func (processor *DedupProcessor) key(data []byte) []byte {
+	if len(processor.config.Fields) == 0 {
+		digest := util.MD5digestBytes(data)
+		return digest[:]
+	}
+	buf := make([]byte, 0, 128)
+	found := 0
+	for _, field := range processor.config.Fields {
+		v, t, _, err := jsonparser.Get(data, strings.Split(field, ".")...)
+		if err != nil || t == jsonparser.NotExist {
+			if processor.config.IgnoreMissingFields {
+				continue
+			}
+			return nil
+		}
+		found++
+		buf = append(buf, field...)
+		buf = append(buf, '=')
+		buf = append(buf, v...)
+		buf = append(buf, 0)
+	}
+	//all the messages without any of the fields would share the same key
+	if found == 0 {
+		return nil
+	}
+	digest := util.MD5digestBytes(buf)
+	return digest[:]
+}
+
+func (processor *DedupProcessor) slotBucket(slot int64) string {
+	return processor.config.Bucket + "-" + strconv.FormatInt(slot, 10)
+}
+
+// buckets returns the current bucket and the previous one, with a window the
+// keys are compared to the keys of the current and the previous window
+func (processor *DedupProcessor) buckets(now time.Time) (string, string) {
+	if processor.window <= 0 {
+		return processor.config.Bucket, ""
+	}
+	slot := now.UnixNano() / int64(processor.window)
+	processor.expireBuckets(slot)
+	return processor.slotBucket(slot), processor.slotBucket(slot - 1)
+}
+
+// expireBuckets drops the buckets older than the previous window once the
+// window moves forward, the first call drops the ones left by the last run
+func (processor *DedupProcessor) expireBuckets(slot int64) {
+	deleter, ok := processor.filter.(filter.BucketDeleter)
+	if !ok {
+		return
+	}
+	processor.slotLock.Lock()
+	last := processor.lastSlot
+	if slot <= last {
+		processor.slotLock.Unlock()
+		return
+	}
+	processor.lastSlot = slot
+	processor.slotLock.Unlock()
+
+	//the last window and the one before were in use
+	from, to := last-1, last
+	if last == 0 {
+		from, to = slot-1-staleSlots, slot-2
+	}
+	for i := from; i <= to && i < slot-1; i++ {
+		bucket := processor.slotBucket(i)
+		if err := deleter.DeleteBucket(bucket); err != nil {
+			log.Errorf("failed to delete expired dedup bucket [%v]: %v", bucket, err)
+		} else {
+			log.Debugf("expired dedup bucket [%v] deleted", bucket)
+		}
+	}
+}
+
+func (processor *DedupProcessor) isDuplicate(key []byte, now time.Time) (bool, error) {
+	current, previous := processor.buckets(now)
+	if previous != "" && processor.filter.Exists(previous, key) {
+		return true, nil
+	}
+	return processor.filter.CheckThenAdd(current, key)
+}
+
+func (processor *DedupProcessor) Process(ctx *pipeline.Context) error {
+	obj := ctx.Get(processor.config.MessageField)
+	if obj == nil {
+		return nil
+	}
+	messages, ok := obj.([]queue.Message)
+	if !ok || len(messages) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	unique := make([]queue.Message, 0, len(messages))
+	var duplicates []queue.ProduceRequest
+	for _, message := range messages {
+		key := processor.key(message.Data)
+		if key == nil {
+			messagesTotal.With(processor.config.Bucket, "skipped").Inc()
+			unique = append(unique, message)
+			continue
+		}
+		duplicated, err := processor.isDuplicate(key, now)
+		if err != nil {
+			return err
+		}
+		if !duplicated {
+			messagesTotal.With(processor.config.Bucket, "unique").Inc()
+			unique = append(unique, message)
+			continue
+		}
+
+		messagesTotal.With(processor.config.Bucket, "duplicate").Inc()
+		if global.Env().IsDebug {
+			log.Debugf("duplicated message in bucket [%v], offset: %v", processor.config.Bucket, message.Offset.String())
+		}
+		switch processor.config.Action {
+		case ActionTag:
+			if data, err := jsonparser.Set(message.Data, []byte("true"), processor.tagPath...); err == nil {
+				message.Data = data
+				message.Size = len(data)
+			} else {
+				log.Debugf("failed to tag duplicated message, offset: %v, %v", message.Offset.String(), err)
+			}
+			unique = append(unique, message)
+		case ActionRoute:
+			duplicates = append(duplicates, queue.ProduceRequest{Topic: processor.outputQueueConfig.ID, Data: message.Data})
+		}
+	}
+
+	if len(duplicates) > 0 {
+		if _, err := processor.producer.Produce(&duplicates); err != nil {
+			return errors.Errorf("failed to push duplicated messages to queue [%v]: %v", processor.outputQueueConfig.Name, err)
+		}
+	}
+
+	ctx.Set(processor.config.MessageField, unique)
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package dedup
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/filter"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+)
+
+type memoryFilter struct {
+	lock    sync.Mutex
+	keys    map[string]bool
+	deleted []string
+}
+
+func (f *memoryFilter) Open() error  { return nil }
+func (f *memoryFilter) Close() error { return nil }
+
+func (f *memoryFilter) Exists(bucket string, key []byte) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.keys[bucket+"/"+string(key)]
+}
+
+func (f *memoryFilter) Add(bucket string, key []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.keys[bucket+"/"+string(key)] = true
+	return nil
+}
+
+func (f *memoryFilter) Delete(bucket string, key []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.keys, bucket+"/"+string(key))
+	return nil
+}
+
+func (f *memoryFilter) DeleteBucket(bucket string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for k := range f.keys {
+		if strings.HasPrefix(k, bucket+"/") {
+			delete(f.keys, k)
+		}
+	}
+	f.deleted = append(f.deleted, bucket)
+	return nil
+}
+
+func (f *memoryFilter) CheckThenAdd(bucket string, key []byte) (bool, error) {
+	ok := f.Exists(bucket, key)
+	return ok, f.Add(bucket, key)
+}
+
+var memory = &memoryFilter{keys: map[string]bool{}}
+
+func init() {
+	filter.Register("dedup_test", memory)
+	//hides DeleteBucket of the memory filter
+	filter.RegisterNamed("dedup_test_no_delete", struct{ filter.Filter }{memory})
+}
+
+func newProcessor(t *testing.T, cfg map[string]interface{}) *DedupProcessor {
+	cfg["filter"] = "dedup_test"
+	c, err := config.NewConfigFrom(cfg)
+	assert.NoError(t, err)
+	p, err := New(c)
+	assert.NoError(t, err)
+	return p.(*DedupProcessor)
+}
+
+func process(t *testing.T, p pipeline.Processor, bodies ...string) []queue.Message {
+	messages := []queue.Message{}
+	for _, body := range bodies {
+		messages = append(messages, queue.Message{Data: []byte(body), Size: len(body)})
+	}
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{Name: "test"})
+	ctx.Set("messages", messages)
+	assert.NoError(t, p.Process(ctx))
+	return ctx.Get("messages").([]queue.Message)
+}
+
+func TestDedupByFields(t *testing.T) {
+	p := newProcessor(t, map[string]interface{}{"bucket": "fields", "fields": []string{"id", "doc.version"}})
+	out := process(t, p,
+		`{"id":1,"doc":{"version":1},"v":"a"}`,
+		`{"id":1,"doc":{"version":1},"v":"b"}`,
+		`{"id":1,"doc":{"version":2}}`,
+		`{"other":1}`,
+		`{"other":1}`)
+	assert.Equal(t, 4, len(out))
+
+	//duplicates across batches
+	out = process(t, p, `{"id":1,"doc":{"version":2}}`)
+	assert.Equal(t, 0, len(out))
+}
+
+func TestDedupMissingFields(t *testing.T) {
+	p := newProcessor(t, map[string]interface{}{"bucket": "missing", "fields": []string{"id", "version"}, "ignore_missing_fields": true})
+	out := process(t, p,
+		`{"id":1}`,
+		`{"id":1}`,
+		`{"other":1}`,
+		`{"other":2}`,
+		`{"other":2}`)
+	//messages missing all the fields are not checked
+	assert.Equal(t, 4, len(out))
+	assert.Equal(t, `{"other":1}`, string(out[1].Data))
+}
+
+func TestDedupTag(t *testing.T) {
+	p := newProcessor(t, map[string]interface{}{"bucket": "tag", "action": "tag"})
+	out := process(t, p, `{"a":1}`, `{"a":1}`)
+	assert.Equal(t, 2, len(out))
+	assert.Equal(t, `{"a":1}`, string(out[0].Data))
+	assert.Equal(t, `{"a":1,"_duplicate":true}`, string(out[1].Data))
+}
+
+func TestDedupWindow(t *testing.T) {
+	p := newProcessor(t, map[string]interface{}{"bucket": "window", "window": "1h"})
+	now := time.Now()
+	key := p.key([]byte("body"))
+	ok, _ := p.isDuplicate(key, now)
+	assert.False(t, ok)
+	ok, _ = p.isDuplicate(key, now.Add(time.Hour))
+	assert.True(t, ok)
+	ok, _ = p.isDuplicate(key, now.Add(3*time.Hour))
+	assert.False(t, ok)
+}
+
+func TestDedupWindowExpiredBuckets(t *testing.T) {
+	p := newProcessor(t, map[string]interface{}{"bucket": "expired", "window": "1h"})
+	now := time.Now()
+	slot := now.UnixNano() / int64(time.Hour)
+	key := p.key([]byte("body"))
+
+	memory.lock.Lock()
+	memory.deleted = nil
+	memory.lock.Unlock()
+
+	//the buckets left by the last run are dropped on the first check
+	p.isDuplicate(key, now)
+	assert.Equal(t, staleSlots, len(memory.deleted))
+	assert.Equal(t, p.slotBucket(slot-1-staleSlots), memory.deleted[0])
+	assert.Equal(t, p.slotBucket(slot-2), memory.deleted[staleSlots-1])
+	memory.deleted = nil
+
+	//buckets older than the previous window are dropped
+	p.isDuplicate(key, now.Add(time.Hour))
+	assert.Equal(t, []string{p.slotBucket(slot - 1)}, memory.deleted)
+	p.isDuplicate(key, now.Add(2*time.Hour))
+	assert.Equal(t, []string{p.slotBucket(slot - 1), p.slotBucket(slot)}, memory.deleted)
+
+	p.isDuplicate(key, now.Add(5*time.Hour))
+	assert.Equal(t, []string{p.slotBucket(slot - 1), p.slotBucket(slot), p.slotBucket(slot + 1), p.slotBucket(slot + 2)}, memory.deleted)
+	assert.False(t, memory.Exists(p.slotBucket(slot+2), key))
+}
+
+func TestDedupWindowRequiresBucketDeleter(t *testing.T) {
+	c, err := config.NewConfigFrom(map[string]interface{}{"filter": "dedup_test_no_delete", "window": "1h"})
+	assert.NoError(t, err)
+	_, err = New(c)
+	assert.Error(t, err)
+
+	c, err = config.NewConfigFrom(map[string]interface{}{"filter": "dedup_test_no_delete"})
+	assert.NoError(t, err)
+	_, err = New(c)
+	assert.NoError(t, err)
+}
@@ -115,6 +115,9 @@ type Config struct {
 	RetryDelayIntervalInMs int      `config:"retry_delay_interval"`
 }
 
+var bulkLatency = stats.NewHistogramVec("elasticsearch_bulk_latency_ms", "Latency of bulk requests in milliseconds.", nil, "cluster")
+var bulkDocs = stats.NewCounterVec("elasticsearch_bulk_docs_total", "Documents submitted by bulk requests, by status code.", "queue", "cluster", "status")
+
 func init() {
 	pipeline.RegisterProcessorPlugin("bulk_indexing", New)
 }
@@ -916,7 +919,12 @@ READ_DOCS:
 				msgSize := mainBuf.GetMessageSize()
 				msgCount := mainBuf.GetMessageCount()
 
-				if (bulkSizeInByte > 0 && msgSize > (bulkSizeInByte)) || (processor.config.BulkConfig.BulkMaxDocsCount > 0 && msgCount > processor.config.BulkConfig.BulkMaxDocsCount) {
+				maxBulkSize, maxBulkDocs := bulkSizeInByte, processor.config.BulkConfig.BulkMaxDocsCount
+				if processor.config.BulkConfig.Adaptive.Enabled {
+					maxBulkSize, maxBulkDocs = bulkProcessor.GetBulkSizeInBytes(), bulkProcessor.GetBulkMaxDocsCount()
+				}
+
+				if (maxBulkSize > 0 && msgSize > maxBulkSize) || (maxBulkDocs > 0 && msgCount > maxBulkDocs) {
 					if global.Env().IsDebug {
 						log.Debugf("slice_worker, consuming [%v], slice_id:%v, hit buffer limit, size:%v, count:%v, submit now", qConfig.Name, sliceID, msgSize, msgCount)
 					}
@@ -1075,13 +1083,14 @@ func (processor *BulkIndexingProcessor) submitBulkRequest(ctx *pipeline.Context,
 
 		start := time.Now()
 		continueRequest, statsMap, bulkResult, err := bulkProcessor.Bulk(ctx.Context, tag, meta, host, mainBuf)
-		if global.Env().IsDebug {
-			stats.Timing("elasticsearch."+esClusterID+".bulk", "elapsed_ms", time.Since(start).Milliseconds())
-		}
+		elapsed := time.Since(start).Milliseconds()
+		stats.Timing("elasticsearch."+esClusterID+".bulk", "elapsed_ms", elapsed)
+		bulkLatency.With(esClusterID).Record(elapsed)
 
 		total := 0
 		for k, v := range statsMap {
 			stats.IncrementBy("queue", qConfig.ID+".docs_status_code."+util.ToString(k), int64(v))
+			bulkDocs.With(qConfig.ID, esClusterID, util.ToString(k)).Add(int64(v))
 			total += v
 		}
 
@@ -40,101 +40,360 @@ limitations under the License.
 package impl
 
 import (
+	"path"
+	"sync"
+	"time"
+
 	log "github.com/cihub/seelog"
-	. "github.com/zeebo/sbloom"
-	"hash/fnv"
+	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/filter"
 	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/stats"
 	"infini.sh/framework/core/util"
-	"io/ioutil"
-	"os"
-	"path"
-	"sync"
 )
 
+type BucketConfig struct {
+	//initial number of items per generation, the filter grows beyond it
+	Capacity          uint64  `config:"capacity"`
+	FalsePositiveRate float64 `config:"false_positive_rate"`
+	GrowthFactor      float64 `config:"growth_factor"`
+	//keys are forgotten after the window, empty to keep them forever, the window
+	//is split into generations, keys are kept for at least the window and at most
+	//one more generation, as one extra generation is kept for the oldest keys
+	Window      string `config:"window"`
+	Generations int    `config:"generations"`
+}
+
+type Config struct {
+	Enabled          bool   `config:"enabled"`
+	Path             string `config:"path"`
+	SnapshotInterval string `config:"snapshot_interval"`
+
+	BucketConfig `config:",inline"`
+
+	//per bucket overrides of the default settings
+	Buckets map[string]BucketConfig `config:"buckets"`
+}
+
+var errDeleteNotSupported = errors.New("bloom filter does not support deletion")
+
 type BloomFilter struct {
-	PersistFileName string
-	filter          *Filter
-	ProbItems       int
+	cfg   *Config
+	store *filter.BucketStore
 }
 
-var filters map[string]BloomFilter
+func (module *BloomFilter) Name() string {
+	return "filter_bloom"
+}
 
-func (filter *BloomFilter) Open() error {
+func (module *BloomFilter) Setup() {
+	module.cfg = &Config{
+		Enabled:          false,
+		SnapshotInterval: "5m",
+		BucketConfig: BucketConfig{
+			Capacity:          1000000,
+			FalsePositiveRate: 0.001,
+			GrowthFactor:      2,
+			Generations:       4,
+		},
+	}
+	ok, err := env.ParseConfig("filter_bloom", module.cfg)
+	if ok && err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
+		panic(err)
+	}
+	if module.cfg.Path == "" {
+		module.cfg.Path = path.Join(global.Env().GetDataDir(), "filters", "bloom")
+	}
+	module.setupStore()
 
-	//loading or initializing bloom filter
-	if util.FileExists(filter.PersistFileName) {
-		log.Debug("found bloomFilter,start reload,", filter.PersistFileName)
-		n, err := ioutil.ReadFile(filter.PersistFileName)
-		if err != nil {
-			log.Error("bloomFilter:", filter.PersistFileName, err)
+	//only used by name, eg: `filter: bloom`, never replaces the default filter
+	if module.cfg.Enabled {
+		filter.RegisterNamed("bloom", module)
+		stats.RegisterStats("bloom_filter", func() interface{} {
+			return module.Stats()
+		})
+	}
+}
+
+func (module *BloomFilter) setupStore() {
+	interval := util.GetDurationOrDefault(module.cfg.SnapshotInterval, 5*time.Minute)
+	module.store = filter.NewBucketStore("bloom filter", module.cfg.Path, ".bloom", interval, func(bucket string) filter.SnapshotBucket {
+		return newBucketFilter(bucket, module.bucketConfig(bucket))
+	})
+}
+
+func (module *BloomFilter) Start() error {
+	if module.cfg == nil || !module.cfg.Enabled {
+		return nil
+	}
+	return module.Open()
+}
+
+func (module *BloomFilter) Stop() error {
+	if module.cfg == nil || !module.cfg.Enabled {
+		return nil
+	}
+	return module.Close()
+}
+
+func (module *BloomFilter) Open() error {
+	return module.store.Open()
+}
+
+func (module *BloomFilter) Close() error {
+	return module.store.Close()
+}
+
+// Snapshot persists the buckets changed since the last snapshot
+func (module *BloomFilter) Snapshot() error {
+	return module.store.Snapshot()
+}
+
+func (module *BloomFilter) bucketConfig(bucket string) BucketConfig {
+	cfg := module.cfg.BucketConfig
+	if v, ok := module.cfg.Buckets[bucket]; ok {
+		if v.Capacity > 0 {
+			cfg.Capacity = v.Capacity
 		}
-		filter.filter = new(Filter)
-		if err := filter.filter.GobDecode(n); err != nil {
-			log.Error("bloomFilter:", filter.PersistFileName, err)
+		if v.FalsePositiveRate > 0 {
+			cfg.FalsePositiveRate = v.FalsePositiveRate
+		}
+		if v.GrowthFactor > 0 {
+			cfg.GrowthFactor = v.GrowthFactor
+		}
+		if v.Window != "" {
+			cfg.Window = v.Window
+		}
+		if v.Generations > 0 {
+			cfg.Generations = v.Generations
 		}
-		log.Info("bloomFilter successfully reloaded:", filter.PersistFileName)
-	} else {
-
-		probItems := 1000000 //config.GetIntConfig("BloomFilter", "ItemSize", 100000)
-		log.Debug("initializing bloom-filter", filter.PersistFileName, ",virual size is,", probItems)
-		filter.filter = NewFilter(fnv.New64(), probItems)
-		log.Info("bloomFilter successfully initialized:", filter.PersistFileName)
 	}
+	return cfg
+}
 
+func (module *BloomFilter) getBucket(bucket string) *bucketFilter {
+	return module.store.Get(bucket).(*bucketFilter)
+}
+
+func (module *BloomFilter) Exists(bucket string, key []byte) bool {
+	return module.getBucket(bucket).exists(key)
+}
+
+func (module *BloomFilter) Add(bucket string, key []byte) error {
+	module.getBucket(bucket).checkThenAdd(key)
 	return nil
 }
 
-func (filter *BloomFilter) Close() error {
+func (module *BloomFilter) Delete(bucket string, key []byte) error {
+	return errDeleteNotSupported
+}
+
+func (module *BloomFilter) CheckThenAdd(bucket string, key []byte) (bool, error) {
+	return module.getBucket(bucket).checkThenAdd(key), nil
+}
 
-	log.Debug("bloomFilter start persist,file:", filter.PersistFileName)
+// DeleteBucket drops the bucket and its snapshot
+func (module *BloomFilter) DeleteBucket(bucket string) error {
+	return module.store.Delete(bucket)
+}
 
-	//save bloom-filter
-	m, err := filter.filter.GobEncode()
-	if err != nil {
-		panic(err)
+type BucketStats struct {
+	Items                      uint64  `json:"items"`
+	Capacity                   uint64  `json:"capacity"`
+	Layers                     int     `json:"layers"`
+	Generations                int     `json:"generations"`
+	FillRatio                  float64 `json:"fill_ratio"`
+	EstimatedFalsePositiveRate float64 `json:"estimated_false_positive_rate"`
+}
+
+func (module *BloomFilter) Stats() map[string]BucketStats {
+	result := map[string]BucketStats{}
+	module.store.Range(func(bucket string, b filter.SnapshotBucket) {
+		result[bucket] = b.(*bucketFilter).stats()
+	})
+	return result
+}
+
+type generation struct {
+	start  time.Time
+	filter *ScalableBloom
+}
+
+// bucketFilter keeps a ring of generations when a window is configured, keys
+// are added to the latest generation and looked up in all of them, the
+// oldest generation is dropped on rotation
+type bucketFilter struct {
+	lock        sync.RWMutex
+	name        string
+	cfg         BucketConfig
+	window      time.Duration
+	generations []*generation
+	dirty       bool
+}
+
+func newBucketFilter(name string, cfg BucketConfig) *bucketFilter {
+	if cfg.Generations <= 0 {
+		cfg.Generations = 1
 	}
-	err = ioutil.WriteFile(filter.PersistFileName, m, 0600)
-	if err != nil {
-		panic(err)
+	b := &bucketFilter{name: name, cfg: cfg}
+	if cfg.Window != "" {
+		b.window = util.GetDurationOrDefault(cfg.Window, 0)
 	}
-	log.Info("bloomFilter safety persisted.")
+	b.reset()
+	return b
+}
 
-	return nil
+func (b *bucketFilter) newGeneration(start time.Time) *generation {
+	return &generation{start: start, filter: NewScalableBloom(b.cfg.Capacity, b.cfg.FalsePositiveRate, b.cfg.GrowthFactor)}
 }
 
-func (filter *BloomFilter) Exists(bucket string, key []byte) bool {
-	return filter.filter.Lookup(key)
+func (b *bucketFilter) reset() {
+	b.generations = []*generation{b.newGeneration(time.Now())}
+	b.dirty = true
 }
 
-func (filter *BloomFilter) Add(bucket string, key []byte) error {
-	filter.filter.Add(key)
-	return nil
+// rotate must be called with the write lock held
+func (b *bucketFilter) rotate(now time.Time) {
+	if b.window <= 0 {
+		return
+	}
+	slot := b.window / time.Duration(b.cfg.Generations)
+	current := b.generations[len(b.generations)-1]
+	if now.Sub(current.start) < slot {
+		return
+	}
+	//a generation ends when the next one starts, drop it once its last keys are
+	//out of the window
+	kept := b.generations[:0]
+	for i, g := range b.generations {
+		end := now
+		if i+1 < len(b.generations) {
+			end = b.generations[i+1].start
+		}
+		if now.Sub(end) < b.window {
+			kept = append(kept, g)
+		}
+	}
+	kept = append(kept, b.newGeneration(now))
+	if len(kept) > b.cfg.Generations+1 {
+		kept = kept[len(kept)-b.cfg.Generations-1:]
+	}
+	b.generations = kept
+	b.dirty = true
+	log.Debugf("bloom filter [%v] rotated, %v generations", b.name, len(b.generations))
+}
+
+func (b *bucketFilter) expired(now time.Time) bool {
+	if b.window <= 0 {
+		return false
+	}
+	current := b.generations[len(b.generations)-1]
+	return now.Sub(current.start) >= b.window/time.Duration(b.cfg.Generations)
 }
 
-func (filter *BloomFilter) Delete(bucket string, key []byte) error {
+func (b *bucketFilter) exists(key []byte) bool {
+	now := time.Now()
+	b.lock.RLock()
+	if b.expired(now) {
+		b.lock.RUnlock()
+		b.lock.Lock()
+		b.rotate(now)
+		b.lock.Unlock()
+		b.lock.RLock()
+	}
+	defer b.lock.RUnlock()
+	for i := len(b.generations) - 1; i >= 0; i-- {
+		if b.generations[i].filter.Test(key) {
+			return true
+		}
+	}
+	return false
+}
 
-	return nil
+func (b *bucketFilter) checkThenAdd(key []byte) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.rotate(time.Now())
+	last := len(b.generations) - 1
+	for i := 0; i < last; i++ {
+		if b.generations[i].filter.Test(key) {
+			return true
+		}
+	}
+	if b.generations[last].filter.TestAndAdd(key) {
+		return true
+	}
+	b.dirty = true
+	return false
 }
 
-var l sync.RWMutex
+func (b *bucketFilter) stats() BucketStats {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	s := BucketStats{Generations: len(b.generations)}
+	p := 1.0
+	for _, g := range b.generations {
+		s.Items += g.filter.Count()
+		s.Capacity += g.filter.Capacity()
+		s.Layers += g.filter.Layers()
+		p *= 1 - g.filter.EstimatedFalsePositiveRate()
+	}
+	s.EstimatedFalsePositiveRate = 1 - p
+	s.FillRatio = b.generations[len(b.generations)-1].filter.FillRatio()
+	return s
+}
 
-func (filter *BloomFilter) CheckThenAdd(bucket string, key []byte) (b bool, err error) {
-	f := filters[bucket]
-	l.Lock()
-	defer l.Unlock()
-	b = f.Exists(bucket, key)
-	if !b {
-		err = f.Add(bucket, key)
+type generationSnapshot struct {
+	Start  time.Time
+	Filter []byte
+}
+
+// Snapshot returns the generations if changed since the last snapshot
+func (b *bucketFilter) Snapshot() (interface{}, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.dirty {
+		return nil, nil
+	}
+	snapshot := make([]generationSnapshot, 0, len(b.generations))
+	for _, g := range b.generations {
+		data, err := g.filter.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		snapshot = append(snapshot, generationSnapshot{Start: g.start, Filter: data})
 	}
-	return b, err
+	b.dirty = false
+	return snapshot, nil
+}
+
+func (b *bucketFilter) MarkDirty() {
+	b.lock.Lock()
+	b.dirty = true
+	b.lock.Unlock()
 }
 
-func initBloomFilter(key string) {
-	//f := impl.PersistFilter{FilterBucket: string(key)}
-	dir := path.Join(global.Env().GetDataDir(), "filters")
-	os.MkdirAll(dir, 0755)
-	file := path.Join(dir, string(key))
-	f := BloomFilter{PersistFileName: file}
-	f.Open()
-	filters[key] = f
+func (b *bucketFilter) Restore(decode func(v interface{}) error) error {
+	snapshot := []generationSnapshot{}
+	if err := decode(&snapshot); err != nil {
+		return err
+	}
+	if len(snapshot) == 0 {
+		return errors.New("empty snapshot")
+	}
+	generations := make([]*generation, 0, len(snapshot))
+	for _, s := range snapshot {
+		f := &ScalableBloom{}
+		if err := f.UnmarshalBinary(s.Filter); err != nil {
+			return err
+		}
+		generations = append(generations, &generation{start: s.Start, filter: f})
+	}
+	b.lock.Lock()
+	b.generations = generations
+	b.dirty = false
+	b.rotate(time.Now())
+	b.lock.Unlock()
+	return nil
 }
@@ -0,0 +1,136 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package impl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScalableBloom(t *testing.T) {
+	f := NewScalableBloom(1000, 0.01, 2)
+	collisions := 0
+	for i := 0; i < 10000; i++ {
+		if f.TestAndAdd([]byte(fmt.Sprintf("key-%d", i))) {
+			collisions++
+		}
+	}
+	assert.True(t, f.Layers() > 1)
+	assert.True(t, collisions < 100, "collisions: %v", collisions)
+	assert.Equal(t, uint64(10000-collisions), f.Count())
+	for i := 0; i < 10000; i++ {
+		assert.True(t, f.Test([]byte(fmt.Sprintf("key-%d", i))))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if f.Test([]byte(fmt.Sprintf("other-%d", i))) {
+			falsePositives++
+		}
+	}
+	assert.True(t, falsePositives < 100, "false positives: %v", falsePositives)
+	assert.True(t, f.EstimatedFalsePositiveRate() < 0.01)
+
+	data, err := f.MarshalBinary()
+	assert.NoError(t, err)
+	restored := &ScalableBloom{}
+	assert.NoError(t, restored.UnmarshalBinary(data))
+	assert.Equal(t, f.Count(), restored.Count())
+	assert.True(t, restored.Test([]byte("key-42")))
+}
+
+func newTestFilter(dir string) *BloomFilter {
+	f := &BloomFilter{cfg: &Config{
+		Enabled:          true,
+		Path:             dir,
+		SnapshotInterval: "1h",
+		BucketConfig:     BucketConfig{Capacity: 100, FalsePositiveRate: 0.001, GrowthFactor: 2, Generations: 2},
+		Buckets:          map[string]BucketConfig{"window": {Window: "1h"}},
+	}}
+	f.setupStore()
+	return f
+}
+
+func TestBloomFilterBuckets(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bloom")
+	defer os.RemoveAll(dir)
+
+	f := newTestFilter(dir)
+	assert.NoError(t, f.Open())
+	ok, err := f.CheckThenAdd("a", []byte("key"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, _ = f.CheckThenAdd("a", []byte("key"))
+	assert.True(t, ok)
+	assert.False(t, f.Exists("b", []byte("key")))
+	assert.Error(t, f.Delete("a", []byte("key")))
+	assert.NoError(t, f.Close())
+	assert.FileExists(t, path.Join(dir, "a.bloom"))
+
+	f = newTestFilter(dir)
+	assert.NoError(t, f.Open())
+	assert.True(t, f.Exists("a", []byte("key")))
+	assert.Equal(t, uint64(1), f.Stats()["a"].Items)
+
+	//deleted bucket is dropped with its snapshot
+	assert.NoError(t, f.DeleteBucket("a"))
+	assert.NoFileExists(t, path.Join(dir, "a.bloom"))
+	assert.False(t, f.Exists("a", []byte("key")))
+	assert.NoError(t, f.Close())
+}
+
+func TestBloomFilterRotation(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bloom")
+	defer os.RemoveAll(dir)
+
+	f := newTestFilter(dir)
+	ok, _ := f.CheckThenAdd("window", []byte("key"))
+	assert.False(t, ok)
+
+	b := f.getBucket("window")
+	//still within the window after one rotation
+	b.generations[0].start = time.Now().Add(-40 * time.Minute)
+	assert.True(t, f.Exists("window", []byte("key")))
+	assert.Equal(t, 2, len(b.generations))
+
+	//kept for the whole window, the generation ended 40 minutes ago
+	b.generations[0].start = time.Now().Add(-70 * time.Minute)
+	b.generations[1].start = time.Now().Add(-40 * time.Minute)
+	assert.True(t, f.Exists("window", []byte("key")))
+	assert.Equal(t, 3, len(b.generations))
+
+	//forgotten once the window elapsed
+	b.generations = b.generations[:2]
+	b.generations[0].start = time.Now().Add(-2 * time.Hour)
+	b.generations[1].start = time.Now().Add(-61 * time.Minute)
+	assert.False(t, f.Exists("window", []byte("key")))
+	ok, _ = f.CheckThenAdd("window", []byte("key"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,256 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package impl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/bits"
+
+	"infini.sh/framework/core/errors"
+)
+
+// bloomLayer is a plain bloom filter sized for a fixed capacity, the bit
+// positions are derived from one 64 bit hash by double hashing
+type bloomLayer struct {
+	bits     []uint64
+	m        uint64
+	k        uint32
+	capacity uint64
+	count    uint64
+}
+
+func newBloomLayer(capacity uint64, fpRate float64) *bloomLayer {
+	if capacity == 0 {
+		capacity = 1
+	}
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint32(math.Ceil(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomLayer{
+		bits:     make([]uint64, (m+63)/64),
+		m:        m,
+		k:        k,
+		capacity: capacity,
+	}
+}
+
+func (l *bloomLayer) add(h1, h2 uint64) {
+	for i := uint64(0); i < uint64(l.k); i++ {
+		idx := (h1 + i*h2) % l.m
+		l.bits[idx>>6] |= 1 << (idx & 63)
+	}
+	l.count++
+}
+
+func (l *bloomLayer) test(h1, h2 uint64) bool {
+	for i := uint64(0); i < uint64(l.k); i++ {
+		idx := (h1 + i*h2) % l.m
+		if l.bits[idx>>6]&(1<<(idx&63)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *bloomLayer) fillRatio() float64 {
+	var set int
+	for _, w := range l.bits {
+		set += bits.OnesCount64(w)
+	}
+	return float64(set) / float64(l.m)
+}
+
+// ScalableBloom is a scalable bloom filter, once the active layer reaches its
+// capacity a larger layer with a tighter false positive rate is appended, so
+// the compound false positive rate stays below the configured one
+type ScalableBloom struct {
+	capacity   uint64
+	fpRate     float64
+	growth     float64
+	tightening float64
+	count      uint64
+	layers     []*bloomLayer
+}
+
+const defaultTighteningRatio = 0.8
+
+func NewScalableBloom(capacity uint64, fpRate, growth float64) *ScalableBloom {
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.001
+	}
+	if growth < 1 {
+		growth = 2
+	}
+	f := &ScalableBloom{
+		capacity:   capacity,
+		fpRate:     fpRate,
+		growth:     growth,
+		tightening: defaultTighteningRatio,
+	}
+	f.grow()
+	return f
+}
+
+func (f *ScalableBloom) grow() {
+	n := len(f.layers)
+	capacity := uint64(float64(f.capacity) * math.Pow(f.growth, float64(n)))
+	//the series of layer rates sums up to the configured rate
+	fpRate := f.fpRate * (1 - f.tightening) * math.Pow(f.tightening, float64(n))
+	f.layers = append(f.layers, newBloomLayer(capacity, fpRate))
+}
+
+func hashKey(key []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write(key)
+	h1 := h.Sum64()
+	//splitmix64 finalizer for the second hash, forced odd to cover all positions
+	h2 := h1 + 0x9e3779b97f4a7c15
+	h2 = (h2 ^ (h2 >> 30)) * 0xbf58476d1ce4e5b9
+	h2 = (h2 ^ (h2 >> 27)) * 0x94d049bb133111eb
+	h2 = h2 ^ (h2 >> 31)
+	return h1, h2 | 1
+}
+
+func (f *ScalableBloom) Test(key []byte) bool {
+	h1, h2 := hashKey(key)
+	return f.test(h1, h2)
+}
+
+func (f *ScalableBloom) test(h1, h2 uint64) bool {
+	for i := len(f.layers) - 1; i >= 0; i-- {
+		if f.layers[i].test(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestAndAdd adds the key and returns whether it was (probably) present before
+func (f *ScalableBloom) TestAndAdd(key []byte) bool {
+	h1, h2 := hashKey(key)
+	if f.test(h1, h2) {
+		return true
+	}
+	active := f.layers[len(f.layers)-1]
+	if active.count >= active.capacity {
+		f.grow()
+		active = f.layers[len(f.layers)-1]
+	}
+	active.add(h1, h2)
+	f.count++
+	return false
+}
+
+func (f *ScalableBloom) Count() uint64 {
+	return f.count
+}
+
+func (f *ScalableBloom) Layers() int {
+	return len(f.layers)
+}
+
+// Capacity returns the number of items the current layers are sized for
+func (f *ScalableBloom) Capacity() uint64 {
+	var c uint64
+	for _, l := range f.layers {
+		c += l.capacity
+	}
+	return c
+}
+
+// FillRatio returns the ratio of bits set in the active layer
+func (f *ScalableBloom) FillRatio() float64 {
+	return f.layers[len(f.layers)-1].fillRatio()
+}
+
+// EstimatedFalsePositiveRate is computed from the actual fill of the layers
+func (f *ScalableBloom) EstimatedFalsePositiveRate() float64 {
+	p := 1.0
+	for _, l := range f.layers {
+		p *= 1 - math.Pow(l.fillRatio(), float64(l.k))
+	}
+	return 1 - p
+}
+
+const scalableBloomMagic = uint32(0x53424631) //SBF1
+
+func (f *ScalableBloom) MarshalBinary() ([]byte, error) {
+	buf := bytes.Buffer{}
+	header := []interface{}{scalableBloomMagic, f.capacity, f.fpRate, f.growth, f.tightening, f.count, uint32(len(f.layers))}
+	for _, v := range header {
+		if err := binary.Write(&buf, binary.LittleEndian, v); err != nil {
+			return nil, err
+		}
+	}
+	for _, l := range f.layers {
+		for _, v := range []interface{}{l.m, l.k, l.capacity, l.count, l.bits} {
+			if err := binary.Write(&buf, binary.LittleEndian, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (f *ScalableBloom) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	var magic, layers uint32
+	for _, v := range []interface{}{&magic, &f.capacity, &f.fpRate, &f.growth, &f.tightening, &f.count, &layers} {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	if magic != scalableBloomMagic {
+		return errors.New("invalid bloom filter snapshot")
+	}
+	f.layers = make([]*bloomLayer, 0, layers)
+	for i := uint32(0); i < layers; i++ {
+		l := &bloomLayer{}
+		for _, v := range []interface{}{&l.m, &l.k, &l.capacity, &l.count} {
+			if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+				return err
+			}
+		}
+		if l.m == 0 || l.k == 0 {
+			return errors.New("invalid bloom filter layer")
+		}
+		l.bits = make([]uint64, (l.m+63)/64)
+		if err := binary.Read(r, binary.LittleEndian, l.bits); err != nil {
+			return err
+		}
+		f.layers = append(f.layers, l)
+	}
+	if len(f.layers) == 0 {
+		return errors.New("invalid bloom filter snapshot")
+	}
+	return nil
+}
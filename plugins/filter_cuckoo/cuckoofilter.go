@@ -24,43 +24,330 @@
 package impl
 
 import (
-	f "github.com/seiflotfy/cuckoofilter"
+	"math/bits"
+	"path"
 	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	f "github.com/seiflotfy/cuckoofilter"
+	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/errors"
+	filter2 "infini.sh/framework/core/filter"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
 )
 
+type BucketConfig struct {
+	//initial number of items of the bucket, rounded up to a power of two
+	Capacity uint `config:"capacity"`
+	//capacity of the next filter compared to the previous one
+	GrowthFactor uint `config:"growth_factor"`
+	//grow once the active filter is filled to this ratio
+	LoadFactor float64 `config:"load_factor"`
+}
+
+type Config struct {
+	Enabled          bool   `config:"enabled"`
+	Path             string `config:"path"`
+	SnapshotInterval string `config:"snapshot_interval"`
+
+	BucketConfig `config:",inline"`
+
+	//per bucket overrides of the default settings
+	Buckets map[string]BucketConfig `config:"buckets"`
+}
+
+// CuckooFilterImpl keeps one growable cuckoo filter per bucket, unlike the
+// bloom filter the keys can be deleted
 type CuckooFilterImpl struct {
-	l  sync.Mutex
-	cf *f.CuckooFilter
+	cfg   *Config
+	store *filter2.BucketStore
+}
+
+func (filter *CuckooFilterImpl) Name() string {
+	return "filter_cuckoo"
+}
+
+func (filter *CuckooFilterImpl) Setup() {
+	filter.cfg = &Config{
+		Enabled:          false,
+		SnapshotInterval: "5m",
+		BucketConfig: BucketConfig{
+			Capacity:     1000000,
+			GrowthFactor: 2,
+			LoadFactor:   0.9,
+		},
+	}
+	ok, err := env.ParseConfig("filter_cuckoo", filter.cfg)
+	if ok && err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
+		panic(err)
+	}
+	if filter.cfg.Path == "" {
+		filter.cfg.Path = path.Join(global.Env().GetDataDir(), "filters", "cuckoo")
+	}
+	filter.setupStore()
+
+	//only used by name, eg: `filter: cuckoo`, never replaces the default filter
+	if filter.cfg.Enabled {
+		filter2.RegisterNamed("cuckoo", filter)
+		stats.RegisterStats("cuckoo_filter", func() interface{} {
+			return filter.Stats()
+		})
+	}
+}
+
+func (filter *CuckooFilterImpl) setupStore() {
+	interval := util.GetDurationOrDefault(filter.cfg.SnapshotInterval, 5*time.Minute)
+	filter.store = filter2.NewBucketStore("cuckoo filter", filter.cfg.Path, ".cuckoo", interval, func(bucket string) filter2.SnapshotBucket {
+		return newCuckooBucket(bucket, filter.bucketConfig(bucket))
+	})
+}
+
+func (filter *CuckooFilterImpl) Start() error {
+	if filter.cfg == nil || !filter.cfg.Enabled {
+		return nil
+	}
+	return filter.Open()
+}
+
+func (filter *CuckooFilterImpl) Stop() error {
+	if filter.cfg == nil || !filter.cfg.Enabled {
+		return nil
+	}
+	return filter.Close()
 }
 
 func (filter *CuckooFilterImpl) Open() error {
+	return filter.store.Open()
+}
+
+func (filter *CuckooFilterImpl) Close() error {
+	return filter.store.Close()
+}
+
+// Snapshot persists the buckets changed since the last snapshot
+func (filter *CuckooFilterImpl) Snapshot() error {
+	return filter.store.Snapshot()
+}
 
-	filter.cf = f.NewCuckooFilter(10000000)
+func (filter *CuckooFilterImpl) bucketConfig(bucket string) BucketConfig {
+	cfg := filter.cfg.BucketConfig
+	if v, ok := filter.cfg.Buckets[bucket]; ok {
+		if v.Capacity > 0 {
+			cfg.Capacity = v.Capacity
+		}
+		if v.GrowthFactor > 0 {
+			cfg.GrowthFactor = v.GrowthFactor
+		}
+		if v.LoadFactor > 0 {
+			cfg.LoadFactor = v.LoadFactor
+		}
+	}
+	if cfg.GrowthFactor < 1 {
+		cfg.GrowthFactor = 2
+	}
+	if cfg.LoadFactor <= 0 || cfg.LoadFactor > 1 {
+		cfg.LoadFactor = 0.9
+	}
+	return cfg
+}
+
+func (filter *CuckooFilterImpl) getBucket(bucket string) *cuckooBucket {
+	return filter.store.Get(bucket).(*cuckooBucket)
+}
+
+func (filter *CuckooFilterImpl) Exists(bucket string, key []byte) bool {
+	return filter.getBucket(bucket).exists(key)
+}
+
+func (filter *CuckooFilterImpl) Add(bucket string, key []byte) error {
+	filter.getBucket(bucket).add(key)
 	return nil
 }
 
-func (filter *CuckooFilterImpl) Close() error {
-	filter.l.Lock()
-	defer filter.l.Unlock()
+func (filter *CuckooFilterImpl) Delete(bucket string, key []byte) error {
+	filter.getBucket(bucket).delete(key)
 	return nil
 }
 
-func (filter *CuckooFilterImpl) Exists(key []byte) bool {
-	filter.l.Lock()
-	defer filter.l.Unlock()
-	return filter.cf.Lookup(key)
+func (filter *CuckooFilterImpl) CheckThenAdd(bucket string, key []byte) (bool, error) {
+	return filter.getBucket(bucket).checkThenAdd(key), nil
 }
 
-func (filter *CuckooFilterImpl) Add(key []byte) error {
-	filter.l.Lock()
-	defer filter.l.Unlock()
-	filter.cf.Insert(key)
-	return nil
+// DeleteBucket drops the bucket and its snapshot
+func (filter *CuckooFilterImpl) DeleteBucket(bucket string) error {
+	return filter.store.Delete(bucket)
+}
+
+type BucketStats struct {
+	Items    uint    `json:"items"`
+	Capacity uint    `json:"capacity"`
+	Filters  int     `json:"filters"`
+	Load     float64 `json:"load"`
+}
+
+func (filter *CuckooFilterImpl) Stats() map[string]BucketStats {
+	result := map[string]BucketStats{}
+	filter.store.Range(func(bucket string, b filter2.SnapshotBucket) {
+		result[bucket] = b.(*cuckooBucket).stats()
+	})
+	return result
+}
+
+// cuckooBucket grows by appending a larger filter once the active one is
+// loaded, lookups and deletions go through all of them
+type cuckooBucket struct {
+	lock       sync.Mutex
+	name       string
+	cfg        BucketConfig
+	filters    []*f.Filter
+	capacities []uint
+	dirty      bool
+}
+
+func newCuckooBucket(name string, cfg BucketConfig) *cuckooBucket {
+	b := &cuckooBucket{name: name, cfg: cfg}
+	b.reset()
+	return b
+}
+
+// slots returns the number of fingerprints a filter created with the capacity holds
+func slots(capacity uint) uint {
+	if capacity <= 4 {
+		return 4
+	}
+	return 1 << uint(bits.Len(capacity-1))
+}
+
+func (b *cuckooBucket) reset() {
+	b.filters = nil
+	b.capacities = nil
+	b.grow(b.cfg.Capacity)
+}
+
+func (b *cuckooBucket) grow(capacity uint) {
+	b.filters = append(b.filters, f.NewFilter(capacity))
+	b.capacities = append(b.capacities, slots(capacity))
+	b.dirty = true
+	if len(b.filters) > 1 {
+		log.Debugf("cuckoo filter [%v] grown to %v filters", b.name, len(b.filters))
+	}
+}
+
+func (b *cuckooBucket) lookup(key []byte) bool {
+	for i := len(b.filters) - 1; i >= 0; i-- {
+		if b.filters[i].Lookup(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *cuckooBucket) insert(key []byte) {
+	last := len(b.filters) - 1
+	active := b.filters[last]
+	if float64(active.Count()) >= float64(b.capacities[last])*b.cfg.LoadFactor || !active.Insert(key) {
+		b.grow(b.capacities[last] * b.cfg.GrowthFactor)
+		b.filters[len(b.filters)-1].Insert(key)
+	}
+	b.dirty = true
+}
+
+func (b *cuckooBucket) exists(key []byte) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.lookup(key)
+}
+
+func (b *cuckooBucket) add(key []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.insert(key)
+}
+
+func (b *cuckooBucket) checkThenAdd(key []byte) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.lookup(key) {
+		return true
+	}
+	b.insert(key)
+	return false
+}
+
+func (b *cuckooBucket) delete(key []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for i := len(b.filters) - 1; i >= 0; i-- {
+		if b.filters[i].Delete(key) {
+			b.dirty = true
+			return
+		}
+	}
+}
+
+func (b *cuckooBucket) stats() BucketStats {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	s := BucketStats{Filters: len(b.filters)}
+	for i, cf := range b.filters {
+		s.Items += cf.Count()
+		s.Capacity += b.capacities[i]
+	}
+	if s.Capacity > 0 {
+		s.Load = float64(s.Items) / float64(s.Capacity)
+	}
+	return s
+}
+
+type bucketSnapshot struct {
+	Capacities []uint
+	Filters    [][]byte
+}
+
+// Snapshot returns the filters if changed since the last snapshot
+func (b *cuckooBucket) Snapshot() (interface{}, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.dirty {
+		return nil, nil
+	}
+	snapshot := &bucketSnapshot{Capacities: append([]uint{}, b.capacities...)}
+	for _, cf := range b.filters {
+		snapshot.Filters = append(snapshot.Filters, cf.Encode())
+	}
+	b.dirty = false
+	return snapshot, nil
+}
+
+func (b *cuckooBucket) MarkDirty() {
+	b.lock.Lock()
+	b.dirty = true
+	b.lock.Unlock()
 }
 
-func (filter *CuckooFilterImpl) Delete(key []byte) error {
-	filter.l.Lock()
-	defer filter.l.Unlock()
-	filter.cf.Delete(key)
+func (b *cuckooBucket) Restore(decode func(v interface{}) error) error {
+	snapshot := bucketSnapshot{}
+	if err := decode(&snapshot); err != nil {
+		return err
+	}
+	if len(snapshot.Filters) == 0 || len(snapshot.Filters) != len(snapshot.Capacities) {
+		return errors.New("invalid cuckoo filter snapshot")
+	}
+	filters := make([]*f.Filter, 0, len(snapshot.Filters))
+	for _, data := range snapshot.Filters {
+		cf, err := f.Decode(data)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, cf)
+	}
+	b.lock.Lock()
+	b.filters = filters
+	b.capacities = snapshot.Capacities
+	b.dirty = false
+	b.lock.Unlock()
 	return nil
 }
@@ -0,0 +1,84 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package impl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFilter(dir string) *CuckooFilterImpl {
+	filter := &CuckooFilterImpl{cfg: &Config{
+		Enabled:          true,
+		Path:             dir,
+		SnapshotInterval: "1h",
+		BucketConfig:     BucketConfig{Capacity: 1024, GrowthFactor: 2, LoadFactor: 0.9},
+		Buckets:          map[string]BucketConfig{"small": {Capacity: 64}},
+	}}
+	filter.setupStore()
+	return filter
+}
+
+func TestCuckooFilter(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cuckoo")
+	defer os.RemoveAll(dir)
+
+	filter := newTestFilter(dir)
+	assert.NoError(t, filter.Open())
+
+	ok, err := filter.CheckThenAdd("a", []byte("key"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, _ = filter.CheckThenAdd("a", []byte("key"))
+	assert.True(t, ok)
+	assert.False(t, filter.Exists("b", []byte("key")))
+
+	assert.NoError(t, filter.Delete("a", []byte("key")))
+	assert.False(t, filter.Exists("a", []byte("key")))
+
+	//grows beyond the configured capacity
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, filter.Add("small", []byte(fmt.Sprintf("key-%d", i))))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.True(t, filter.Exists("small", []byte(fmt.Sprintf("key-%d", i))))
+	}
+	s := filter.Stats()["small"]
+	assert.True(t, s.Filters > 1)
+	assert.True(t, s.Capacity >= 1000)
+
+	assert.NoError(t, filter.Close())
+	assert.FileExists(t, path.Join(dir, "small.cuckoo"))
+
+	filter = newTestFilter(dir)
+	assert.NoError(t, filter.Open())
+	assert.True(t, filter.Exists("small", []byte("key-42")))
+	assert.Equal(t, s.Filters, filter.Stats()["small"].Filters)
+	assert.NoError(t, filter.Close())
+}
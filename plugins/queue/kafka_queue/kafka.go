@@ -283,6 +283,10 @@ func (this *KafkaQueue) Start() error {
 	return nil
 }
 
+func (*KafkaQueue) ShutdownPhase() module.ShutdownPhase {
+	return module.PhaseStorage
+}
+
 func (this *KafkaQueue) Stop() error {
 	if this.cfg == nil {
 		return nil
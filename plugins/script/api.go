@@ -0,0 +1,170 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package script
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"infini.sh/framework/core/api"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/param"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+)
+
+// APIConfig configures the api of the script plugin, the test api runs the
+// submitted scripts on the node, so it is disabled by default
+type APIConfig struct {
+	TestAPI struct {
+		Enabled bool `config:"enabled"`
+	} `config:"test_api"`
+}
+
+func init() {
+	config.RegisterSchema("script", func() interface{} {
+		return &APIConfig{}
+	})
+	//the config is loaded before the init callbacks
+	global.RegisterInitCallback(registerAPI)
+}
+
+func registerAPI() {
+	cfg := APIConfig{}
+	ok, err := env.ParseConfig("script", &cfg)
+	if ok && err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
+		panic(err)
+	}
+	if cfg.TestAPI.Enabled {
+		api.HandleAPIMethod(api.POST, "/pipeline/script/_test", testScriptAction)
+	}
+}
+
+// TestRequest runs the script against the sample messages, json strings are
+// passed as raw text messages, others as json messages
+type TestRequest struct {
+	Source     string                 `json:"source"`
+	Function   string                 `json:"function,omitempty"`
+	Timeout    string                 `json:"timeout,omitempty"`
+	MaxSteps   uint64                 `json:"max_steps,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Messages   []json.RawMessage      `json:"messages,omitempty"`
+}
+
+type TestResponse struct {
+	Messages   []json.RawMessage      `json:"messages"`
+	Dropped    int                    `json:"dropped"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// the limits of the scripts submitted to the test api
+const (
+	maxTestTimeout  = time.Second
+	maxTestMaxSteps = 10000000
+)
+
+// RunScriptTest runs the script with the sample input in a new pipeline context,
+// the timeout and max steps are capped by maxTestTimeout and maxTestMaxSteps
+func RunScriptTest(req TestRequest) (*TestResponse, error) {
+	timeout := util.GetDurationOrDefault(req.Timeout, 100*time.Millisecond)
+	if timeout <= 0 || timeout > maxTestTimeout {
+		timeout = maxTestTimeout
+	}
+	maxSteps := req.MaxSteps
+	if maxSteps == 0 || maxSteps > maxTestMaxSteps {
+		maxSteps = maxTestMaxSteps
+	}
+
+	processor, err := NewScriptProcessor(Config{
+		MessageField: "messages",
+		Source:       req.Source,
+		Function:     req.Function,
+		Timeout:      timeout.String(),
+		MaxSteps:     maxSteps,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{})
+	for k, v := range req.Parameters {
+		ctx.Set(param.ParaKey(k), v)
+	}
+
+	messages := make([]queue.Message, 0, len(req.Messages))
+	for _, v := range req.Messages {
+		data := []byte(v)
+		var str string
+		if json.Unmarshal(v, &str) == nil {
+			data = []byte(str)
+		}
+		messages = append(messages, queue.Message{Data: data, Size: len(data)})
+	}
+	if len(messages) > 0 {
+		ctx.Set(processor.config.MessageField, messages)
+	}
+
+	resp := &TestResponse{Messages: []json.RawMessage{}}
+	if err := processor.Process(ctx); err != nil {
+		resp.Error = err.Error()
+		return resp, nil
+	}
+
+	if output, ok := ctx.Get(processor.config.MessageField).([]queue.Message); ok {
+		for _, message := range output {
+			if json.Valid(message.Data) {
+				resp.Messages = append(resp.Messages, message.Data)
+				continue
+			}
+			str, _ := json.Marshal(string(message.Data))
+			resp.Messages = append(resp.Messages, str)
+		}
+		resp.Dropped = len(messages) - len(output)
+	}
+
+	resp.Parameters = ctx.CloneData()
+	delete(resp.Parameters, string(processor.config.MessageField))
+	return resp, nil
+}
+
+func testScriptAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	reqBody := TestRequest{}
+	if err := api.DefaultAPI.DecodeJSON(req, &reqBody); err != nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := RunScriptTest(reqBody)
+	if err != nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	api.DefaultAPI.WriteJSON(w, resp, http.StatusOK)
+}
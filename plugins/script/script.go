@@ -0,0 +1,417 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package script runs the Starlark script for each message, the script is
+// compiled once and defines the function:
+//
+//	def process(ctx, msg):
+//	    msg["total"] = msg["price"] * msg["count"]
+//	    if ctx.get("env") == "test":
+//	        ctx.set("tested", True)
+//
+// msg is the json message decoded as dict or list, or string if not json, None
+// if there are no messages, the return value decides the output:
+//
+//	None    keep the message, with the changes made to msg if it is dict or list
+//	False   drop the message
+//	others  replace the message, dict and list are encoded as json
+//
+// ctx reads and writes the parameters of the pipeline context with the methods
+// get(key, default=None) and set(key, value), the `json` module is predeclared.
+// get returns a copy of the parameter, values other than the primitives, lists
+// and maps are converted through their json form, so use set to save changes.
+package script
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/param"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+)
+
+type Config struct {
+	MessageField param.ParaKey `config:"message_field"`
+
+	//inline script, or the path of the script file
+	Source string `config:"source"`
+	File   string `config:"file"`
+	//the function to call for each message
+	Function string `config:"function"`
+
+	//the max duration of each invocation
+	Timeout string `config:"timeout"`
+	//the max execution steps of each invocation, 0 means unlimited
+	MaxSteps uint64 `config:"max_steps"`
+}
+
+type ScriptProcessor struct {
+	config  Config
+	timeout time.Duration
+	fn      starlark.Callable
+}
+
+func init() {
+	pipeline.RegisterProcessorPlugin("script", New)
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	cfg := Config{
+		MessageField: "messages",
+		Function:     "process",
+		Timeout:      "100ms",
+	}
+
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to unpack the configuration of script processor: %s", err)
+	}
+
+	return NewScriptProcessor(cfg)
+}
+
+// NewScriptProcessor compiles the script, the function must be defined
+func NewScriptProcessor(cfg Config) (*ScriptProcessor, error) {
+	filename := "script"
+	var src interface{} = cfg.Source
+	if cfg.File != "" {
+		filename = cfg.File
+		content, err := util.FileGetContent(cfg.File)
+		if err != nil {
+			return nil, errors.Errorf("failed to load script %v: %v", cfg.File, err)
+		}
+		src = content
+	} else if cfg.Source == "" {
+		return nil, errors.New("source or file of script processor is required")
+	}
+	if cfg.Function == "" {
+		cfg.Function = "process"
+	}
+
+	predeclared := starlark.StringDict{"json": starlarkjson.Module}
+	_, program, err := starlark.SourceProgram(filename, src, predeclared.Has)
+	if err != nil {
+		return nil, errors.Errorf("failed to compile script: %v", err)
+	}
+
+	thread := &starlark.Thread{Name: "script_init"}
+	if cfg.MaxSteps > 0 {
+		thread.SetMaxExecutionSteps(cfg.MaxSteps)
+	}
+	globals, err := program.Init(thread, predeclared)
+	if err != nil {
+		return nil, errors.Errorf("failed to init script: %v", err)
+	}
+	globals.Freeze()
+
+	fn, ok := globals[cfg.Function].(starlark.Callable)
+	if !ok {
+		return nil, errors.Errorf("function [%v] is not defined in script", cfg.Function)
+	}
+
+	return &ScriptProcessor{
+		config:  cfg,
+		timeout: util.GetDurationOrDefault(cfg.Timeout, 100*time.Millisecond),
+		fn:      fn,
+	}, nil
+}
+
+func (processor *ScriptProcessor) Name() string {
+	return "script"
+}
+
+func (processor *ScriptProcessor) Process(ctx *pipeline.Context) error {
+	obj := ctx.Get(processor.config.MessageField)
+	messages, ok := obj.([]queue.Message)
+	if !ok {
+		//no messages, the script could still work with the parameters
+		_, _, err := processor.call(ctx, nil)
+		return err
+	}
+
+	result := make([]queue.Message, 0, len(messages))
+	for _, message := range messages {
+		data, keep, err := processor.call(ctx, message.Data)
+		if err != nil {
+			return errors.Errorf("failed to process message with script, offset: %v, %v", message.Offset.String(), err)
+		}
+		if !keep {
+			continue
+		}
+		message.Data = data
+		message.Size = len(data)
+		result = append(result, message)
+	}
+	ctx.Set(processor.config.MessageField, result)
+	return nil
+}
+
+// call runs the function with the message, returns the output message and
+// whether to keep it
+func (processor *ScriptProcessor) call(ctx *pipeline.Context, data []byte) ([]byte, bool, error) {
+	thread := &starlark.Thread{Name: "script"}
+	if processor.config.MaxSteps > 0 {
+		thread.SetMaxExecutionSteps(processor.config.MaxSteps)
+	}
+	timer := time.AfterFunc(processor.timeout, func() {
+		thread.Cancel(fmt.Sprintf("timeout after %v", processor.timeout))
+	})
+	defer timer.Stop()
+
+	var msg starlark.Value = starlark.None
+	if data != nil {
+		var err error
+		msg, err = decodeMessage(data)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	ret, err := starlark.Call(thread, processor.fn, starlark.Tuple{&contextValue{ctx: ctx}, msg}, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch v := ret.(type) {
+	case starlark.NoneType:
+		//only dict and list can be changed in place
+		switch msg.(type) {
+		case *starlark.Dict, *starlark.List:
+			return encodeMessage(msg)
+		}
+		return data, true, nil
+	case starlark.Bool:
+		if !v {
+			return nil, false, nil
+		}
+		return data, true, nil
+	default:
+		return encodeMessage(ret)
+	}
+}
+
+func decodeMessage(data []byte) (starlark.Value, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var obj interface{}
+	if err := decoder.Decode(&obj); err != nil {
+		//not json
+		return starlark.String(data), nil
+	}
+	return toStarlark(obj)
+}
+
+func encodeMessage(v starlark.Value) ([]byte, bool, error) {
+	if str, ok := v.(starlark.String); ok {
+		return []byte(string(str)), true, nil
+	}
+	obj, err := fromStarlark(v)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// contextValue exposes the parameters of the pipeline context to the script
+type contextValue struct {
+	ctx *pipeline.Context
+}
+
+func (c *contextValue) String() string        { return "ctx" }
+func (c *contextValue) Type() string          { return "ctx" }
+func (c *contextValue) Freeze()               {}
+func (c *contextValue) Truth() starlark.Bool  { return starlark.True }
+func (c *contextValue) Hash() (uint32, error) { return 0, errors.New("unhashable type: ctx") }
+func (c *contextValue) AttrNames() []string   { return []string{"get", "set"} }
+
+func (c *contextValue) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "get":
+		return starlark.NewBuiltin("get", c.get).BindReceiver(c), nil
+	case "set":
+		return starlark.NewBuiltin("set", c.set).BindReceiver(c), nil
+	}
+	return nil, nil
+}
+
+func (c *contextValue) get(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	var defaultValue starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "default?", &defaultValue); err != nil {
+		return nil, err
+	}
+	v := c.ctx.Get(param.ParaKey(key))
+	if v == nil {
+		return defaultValue, nil
+	}
+	return toStarlark(v)
+}
+
+func (c *contextValue) set(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	var value starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "value", &value); err != nil {
+		return nil, err
+	}
+	v, err := fromStarlark(value)
+	if err != nil {
+		return nil, err
+	}
+	c.ctx.Set(param.ParaKey(key), v)
+	return starlark.None, nil
+}
+
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch x := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(x), nil
+	case string:
+		return starlark.String(x), nil
+	case []byte:
+		return starlark.String(x), nil
+	case int:
+		return starlark.MakeInt(x), nil
+	case int32:
+		return starlark.MakeInt64(int64(x)), nil
+	case int64:
+		return starlark.MakeInt64(x), nil
+	case uint:
+		return starlark.MakeUint(x), nil
+	case uint32:
+		return starlark.MakeUint64(uint64(x)), nil
+	case uint64:
+		return starlark.MakeUint64(x), nil
+	case float32:
+		return starlark.Float(x), nil
+	case float64:
+		return starlark.Float(x), nil
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return starlark.MakeInt64(i), nil
+		}
+		f, err := x.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return starlark.Float(f), nil
+	case []string:
+		list := make([]starlark.Value, 0, len(x))
+		for _, item := range x {
+			list = append(list, starlark.String(item))
+		}
+		return starlark.NewList(list), nil
+	case []interface{}:
+		list := make([]starlark.Value, 0, len(x))
+		for _, item := range x {
+			value, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return starlark.NewList(list), nil
+	case util.MapStr:
+		return toStarlark(map[string]interface{}(x))
+	case map[string]interface{}:
+		dict := starlark.NewDict(len(x))
+		for k, item := range x {
+			value, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(starlark.String(k), value); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	}
+
+	//other values, eg: structs, are passed as the decoded json
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Errorf("unsupported type: %T", v)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var obj interface{}
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, err
+	}
+	return toStarlark(obj)
+}
+
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch x := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(x), nil
+	case starlark.String:
+		return string(x), nil
+	case starlark.Int:
+		if i, ok := x.Int64(); ok {
+			return i, nil
+		}
+		return json.Number(x.String()), nil
+	case starlark.Float:
+		return float64(x), nil
+	case starlark.Indexable:
+		list := make([]interface{}, 0, x.Len())
+		for i := 0; i < x.Len(); i++ {
+			item, err := fromStarlark(x.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	case *starlark.Dict:
+		obj := make(map[string]interface{}, x.Len())
+		for _, item := range x.Items() {
+			k, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, errors.Errorf("dict key should be string, got: %v", item[0].Type())
+			}
+			value, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			obj[string(k)] = value
+		}
+		return obj, nil
+	}
+	return nil, errors.Errorf("unsupported type: %v", v.Type())
+}
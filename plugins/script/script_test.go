@@ -0,0 +1,142 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package script
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+)
+
+const testSource = `
+def process(ctx, msg):
+    if msg == None:
+        ctx.set("visited", True)
+        return
+    if type(msg) == "string":
+        return msg.upper()
+    if msg.get("skip"):
+        return False
+    msg["total"] = msg["price"] * msg["count"]
+    msg["env"] = ctx.get("env", "prod")
+    ctx.set("count", ctx.get("count", 0) + 1)
+`
+
+func TestScriptProcessor(t *testing.T) {
+	processor, err := NewScriptProcessor(Config{MessageField: "messages", Source: testSource})
+	assert.NoError(t, err)
+
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{})
+	ctx.Set("env", "test")
+	ctx.Set("messages", []queue.Message{
+		{Data: []byte(`{"price":2,"count":3}`)},
+		{Data: []byte(`{"skip":true}`)},
+		{Data: []byte(`plain text`)},
+	})
+	assert.NoError(t, processor.Process(ctx))
+
+	messages := ctx.Get("messages").([]queue.Message)
+	assert.Equal(t, 2, len(messages))
+	obj := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(messages[0].Data, &obj))
+	assert.Equal(t, float64(6), obj["total"])
+	assert.Equal(t, "test", obj["env"])
+	assert.Equal(t, "PLAIN TEXT", string(messages[1].Data))
+	assert.Equal(t, int64(1), ctx.Get("count"))
+
+	//no messages
+	ctx = pipeline.AcquireContext(pipeline.PipelineConfigV2{})
+	assert.NoError(t, processor.Process(ctx))
+	assert.Equal(t, true, ctx.Get("visited"))
+}
+
+func TestScriptErrors(t *testing.T) {
+	_, err := NewScriptProcessor(Config{Source: "def process(ctx, msg)"})
+	assert.Error(t, err)
+
+	_, err = NewScriptProcessor(Config{Source: "def other(ctx, msg):\n    pass\n"})
+	assert.Error(t, err)
+
+	processor, err := NewScriptProcessor(Config{MessageField: "messages", Timeout: "50ms", Source: "def process(ctx, msg):\n    for i in range(1000000000):\n        pass\n"})
+	assert.NoError(t, err)
+	err = processor.Process(pipeline.AcquireContext(pipeline.PipelineConfigV2{}))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "timeout")
+
+	processor, err = NewScriptProcessor(Config{MessageField: "messages", MaxSteps: 100, Source: "def process(ctx, msg):\n    for i in range(1000):\n        pass\n"})
+	assert.NoError(t, err)
+	assert.Error(t, processor.Process(pipeline.AcquireContext(pipeline.PipelineConfigV2{})))
+}
+
+func TestScriptKeepMessage(t *testing.T) {
+	processor, err := NewScriptProcessor(Config{MessageField: "messages", Source: "def process(ctx, msg):\n    ctx.set(\"node_name\", ctx.get(\"node\")[\"name\"])\n"})
+	assert.NoError(t, err)
+
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{})
+	ctx.Set("node", struct {
+		Name string `json:"name"`
+	}{Name: "node-1"})
+	ctx.Set("messages", []queue.Message{{Data: []byte(`"quoted"`)}, {Data: []byte(`42`)}, {Data: []byte(`plain text`)}})
+	assert.NoError(t, processor.Process(ctx))
+
+	messages := ctx.Get("messages").([]queue.Message)
+	assert.Equal(t, `"quoted"`, string(messages[0].Data))
+	assert.Equal(t, `42`, string(messages[1].Data))
+	assert.Equal(t, `plain text`, string(messages[2].Data))
+	assert.Equal(t, "node-1", ctx.Get("node_name"))
+}
+
+func TestRunScriptTest(t *testing.T) {
+	resp, err := RunScriptTest(TestRequest{
+		Source:     testSource,
+		Parameters: map[string]interface{}{"env": "dev"},
+		Messages: []json.RawMessage{
+			json.RawMessage(`{"price":1.5,"count":2}`),
+			json.RawMessage(`{"skip":true}`),
+			json.RawMessage(`"hello"`),
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "", resp.Error)
+	assert.Equal(t, 1, resp.Dropped)
+	assert.Equal(t, 2, len(resp.Messages))
+	assert.JSONEq(t, `{"price":1.5,"count":2,"total":3.0,"env":"dev"}`, string(resp.Messages[0]))
+	assert.Equal(t, `"HELLO"`, string(resp.Messages[1]))
+	assert.Equal(t, int64(1), resp.Parameters["count"])
+
+	resp, err = RunScriptTest(TestRequest{Source: "def process(ctx, msg):\n    fail('bad input')\n", Messages: []json.RawMessage{json.RawMessage(`{}`)}})
+	assert.NoError(t, err)
+	assert.Contains(t, resp.Error, "bad input")
+
+	//the limits of the request are capped
+	start := time.Now()
+	resp, err = RunScriptTest(TestRequest{Source: "def process(ctx, msg):\n    for i in range(1000000000):\n        pass\n", Timeout: "1h"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", resp.Error)
+	assert.True(t, time.Since(start) <= maxTestTimeout+time.Second)
+}
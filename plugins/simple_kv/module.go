@@ -32,6 +32,7 @@ import (
 	"infini.sh/framework/core/filter"
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/module"
 	"infini.sh/framework/core/util"
 	"os"
 	"path"
@@ -90,6 +91,10 @@ func (module *SimpleKV) Start() error {
 	return nil
 }
 
+func (*SimpleKV) ShutdownPhase() module.ShutdownPhase {
+	return module.PhaseStorage
+}
+
 func (module *SimpleKV) Stop() error {
 
 	if module.cfg == nil {
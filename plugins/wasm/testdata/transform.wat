@@ -0,0 +1,21 @@
+;; source of transform.wasm, drops the messages with the `drop` field, tags the
+;; others and sets the `count` parameter, `spin` never returns
+(module
+  (import "infini" "field_get" (func $field_get (param i32 i32 i32 i32) (result i32)))
+  (import "infini" "field_set" (func $field_set (param i32 i32 i32 i32) (result i32)))
+  (import "infini" "param_set" (func $param_set (param i32 i32 i32 i32) (result i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 0) "drop")
+  (data (i32.const 8) "tagged")
+  (data (i32.const 16) "true")
+  (data (i32.const 24) "count")
+  (data (i32.const 32) "42")
+  (func (export "process") (result i32)
+    (if (i32.ge_s (call $field_get (i32.const 0) (i32.const 4) (i32.const 64) (i32.const 0)) (i32.const 0))
+      (then (return (i32.const 1))))
+    (drop (call $field_set (i32.const 8) (i32.const 6) (i32.const 16) (i32.const 4)))
+    (drop (call $param_set (i32.const 24) (i32.const 5) (i32.const 32) (i32.const 2)))
+    (i32.const 0))
+  (func (export "spin") (result i32)
+    (loop $forever (br $forever))
+    (i32.const 0)))
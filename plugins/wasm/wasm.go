@@ -0,0 +1,504 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package wasm runs the user-defined transforms compiled to WebAssembly, the
+// module is built as a reactor (eg: TinyGo `-buildmode=c-shared`, Rust `cdylib`)
+// and exports `process() i32`, which is called once per message:
+//
+//	0 keep the message, 1 drop the message, others fail the process
+//
+// the host functions are imported from the `infini` module, strings and buffers are
+// passed as (ptr, len) of the guest memory, the functions copying data into the guest
+// return the size of the data, or -1 if not found, nothing is copied if the buffer
+// is smaller than the size, call again with a larger buffer:
+//
+//	message_read(buf, cap) i32                read the body of the current message
+//	message_write(ptr, len) i32               replace the body of the current message
+//	field_get(path, path_len, buf, cap) i32   read the field of the json message, eg: `user.name`
+//	field_set(path, path_len, val, val_len) i32  set the field with the raw json value
+//	param_get(key, key_len, buf, cap) i32     read the parameter of the pipeline context
+//	param_set(key, key_len, val, val_len) i32 set the parameter of the pipeline context with the json value
+//	log(level, ptr, len)                      0 debug, 1 info, 2 warn, 3 error
+//
+// the value of param_set keeps its json type, eg: `42` is set as a number and
+// `"42"` as a string, values which are not valid json are set as strings, the
+// stdout and stderr of the module are written to the log
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	log "github.com/cihub/seelog"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/param"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+)
+
+const (
+	StatusKeep = 0
+	StatusDrop = 1
+)
+
+const hostModuleName = "infini"
+
+// wasmPageSize is the size of the WebAssembly memory page, 64KiB, at most 4GiB memory
+const (
+	wasmPageSize   = 64 * 1024
+	maxMemoryPages = 65536
+)
+
+type Config struct {
+	MessageField param.ParaKey `config:"message_field"`
+
+	//path of the .wasm file
+	Module string `config:"module"`
+	//the exported function to call for each message
+	Function string `config:"function"`
+
+	//the max memory of the module instance, eg: 16mb
+	MaxMemory string `config:"max_memory"`
+	//the max duration of each invocation
+	Timeout string `config:"timeout"`
+
+	//reload the module if the file was changed, checked every reload_interval,
+	//disabled by default, the module may be replaced while it is being written
+	HotReload      bool   `config:"hot_reload"`
+	ReloadInterval string `config:"reload_interval"`
+}
+
+type WasmProcessor struct {
+	config         Config
+	timeout        time.Duration
+	reloadInterval time.Duration
+	memoryPages    uint32
+
+	lock      sync.Mutex
+	runtime   wazero.Runtime
+	compiled  wazero.CompiledModule
+	instance  api.Module
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func init() {
+	pipeline.RegisterProcessorPlugin("wasm", New)
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	cfg := Config{
+		MessageField:   "messages",
+		Function:       "process",
+		MaxMemory:      "16mb",
+		Timeout:        "1s",
+		ReloadInterval: "5s",
+	}
+
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to unpack the configuration of wasm processor: %s", err)
+	}
+
+	if cfg.Module == "" {
+		return nil, errors.New("module of wasm processor can't be nil")
+	}
+
+	maxMemory, err := util.ConvertBytesFromString(cfg.MaxMemory)
+	if err != nil || maxMemory < wasmPageSize || maxMemory > maxMemoryPages*wasmPageSize {
+		return nil, errors.Errorf("invalid max_memory of wasm processor: %v", cfg.MaxMemory)
+	}
+
+	processor := &WasmProcessor{
+		config:         cfg,
+		timeout:        util.GetDurationOrDefault(cfg.Timeout, time.Second),
+		reloadInterval: util.GetDurationOrDefault(cfg.ReloadInterval, 5*time.Second),
+		memoryPages:    uint32(maxMemory / wasmPageSize),
+	}
+
+	if err := processor.load(); err != nil {
+		return nil, err
+	}
+	return processor, nil
+}
+
+func (processor *WasmProcessor) Name() string {
+	return "wasm"
+}
+
+// load compiles and instantiates the module file with a new runtime, the previous
+// runtime is closed after the new module was ready
+func (processor *WasmProcessor) load() error {
+	stat, err := os.Stat(processor.config.Module)
+	if err != nil {
+		return errors.Errorf("failed to load wasm module %v: %v", processor.config.Module, err)
+	}
+	code, err := util.FileGetContent(processor.config.Module)
+	if err != nil {
+		return errors.Errorf("failed to load wasm module %v: %v", processor.config.Module, err)
+	}
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(processor.memoryPages).
+		WithCloseOnContextDone(true))
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return err
+	}
+	if err := instantiateHostModule(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return err
+	}
+
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		runtime.Close(ctx)
+		return errors.Errorf("failed to compile wasm module %v: %v", processor.config.Module, err)
+	}
+	if _, ok := compiled.ExportedFunctions()[processor.config.Function]; !ok {
+		runtime.Close(ctx)
+		return errors.Errorf("function [%v] is not exported by wasm module %v", processor.config.Function, processor.config.Module)
+	}
+
+	instance, err := instantiate(ctx, runtime, compiled)
+	if err != nil {
+		runtime.Close(ctx)
+		return errors.Errorf("failed to instantiate wasm module %v: %v", processor.config.Module, err)
+	}
+
+	old := processor.runtime
+	processor.runtime = runtime
+	processor.compiled = compiled
+	processor.instance = instance
+	processor.modTime = stat.ModTime()
+	processor.lastCheck = time.Now()
+	if old != nil {
+		old.Close(ctx)
+	}
+	return nil
+}
+
+func instantiate(ctx context.Context, runtime wazero.Runtime, compiled wazero.CompiledModule) (api.Module, error) {
+	//anonymous module, so the module could be instantiated again after closed
+	cfg := wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStdout(&logWriter{level: 1}).
+		WithStderr(&logWriter{level: 3})
+	return runtime.InstantiateModule(ctx, compiled, cfg)
+}
+
+// logWriter writes the output of the module to the log, line by line
+type logWriter struct {
+	level uint32
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		logMessage(w.level, string(line))
+	}
+	return len(p), nil
+}
+
+// checkReload reloads the module if the file was changed, the current module is
+// kept if failed to reload
+func (processor *WasmProcessor) checkReload() {
+	if !processor.config.HotReload || time.Since(processor.lastCheck) < processor.reloadInterval {
+		return
+	}
+	processor.lastCheck = time.Now()
+	stat, err := os.Stat(processor.config.Module)
+	if err != nil || stat.ModTime().Equal(processor.modTime) {
+		return
+	}
+	if err := processor.load(); err != nil {
+		log.Errorf("failed to reload wasm module %v: %v", processor.config.Module, err)
+		return
+	}
+	log.Infof("wasm module %v reloaded", processor.config.Module)
+}
+
+func (processor *WasmProcessor) Process(ctx *pipeline.Context) error {
+	processor.lock.Lock()
+	defer processor.lock.Unlock()
+
+	processor.checkReload()
+
+	obj := ctx.Get(processor.config.MessageField)
+	messages, ok := obj.([]queue.Message)
+	if !ok {
+		//no messages, the module could still work with the parameters
+		_, err := processor.invoke(&invocation{ctx: ctx})
+		return err
+	}
+
+	result := make([]queue.Message, 0, len(messages))
+	for _, message := range messages {
+		inv := &invocation{ctx: ctx, message: &message}
+		status, err := processor.invoke(inv)
+		if err != nil {
+			return errors.Errorf("failed to process message with wasm module %v, offset: %v, %v", processor.config.Module, message.Offset.String(), err)
+		}
+		if status == StatusDrop {
+			continue
+		}
+		result = append(result, message)
+	}
+	ctx.Set(processor.config.MessageField, result)
+	return nil
+}
+
+// invoke calls the function with the time limit, the instance is closed on timeout
+// or trap, and instantiated again for the next invocation
+func (processor *WasmProcessor) invoke(inv *invocation) (int32, error) {
+	if processor.instance == nil {
+		instance, err := instantiate(context.Background(), processor.runtime, processor.compiled)
+		if err != nil {
+			return 0, err
+		}
+		processor.instance = instance
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), invocationKey{}, inv), processor.timeout)
+	defer cancel()
+
+	results, err := processor.instance.ExportedFunction(processor.config.Function).Call(ctx)
+	if err != nil {
+		processor.instance.Close(context.Background())
+		processor.instance = nil
+		return 0, err
+	}
+
+	var status int32
+	if len(results) > 0 {
+		status = int32(results[0])
+	}
+	if status != StatusKeep && status != StatusDrop {
+		return status, errors.Errorf("wasm function [%v] returned error status: %v", processor.config.Function, status)
+	}
+	return status, nil
+}
+
+func (processor *WasmProcessor) Close() error {
+	processor.lock.Lock()
+	defer processor.lock.Unlock()
+	if processor.runtime != nil {
+		return processor.runtime.Close(context.Background())
+	}
+	return nil
+}
+
+type invocationKey struct{}
+
+// invocation is the state of the current call, accessed by the host functions
+type invocation struct {
+	ctx     *pipeline.Context
+	message *queue.Message
+}
+
+func getInvocation(ctx context.Context) *invocation {
+	inv, _ := ctx.Value(invocationKey{}).(*invocation)
+	return inv
+}
+
+func readString(m api.Module, ptr, size uint32) (string, bool) {
+	buf, ok := m.Memory().Read(ptr, size)
+	if !ok {
+		return "", false
+	}
+	return string(buf), true
+}
+
+// writeResult copies data into the guest buffer if the buffer is large enough, returns the size of data
+func writeResult(m api.Module, data []byte, ptr, capacity uint32) int32 {
+	if uint32(len(data)) <= capacity {
+		if !m.Memory().Write(ptr, data) {
+			return -1
+		}
+	}
+	return int32(len(data))
+}
+
+func instantiateHostModule(ctx context.Context, runtime wazero.Runtime) error {
+	_, err := runtime.NewHostModuleBuilder(hostModuleName).
+		NewFunctionBuilder().WithFunc(hostMessageRead).Export("message_read").
+		NewFunctionBuilder().WithFunc(hostMessageWrite).Export("message_write").
+		NewFunctionBuilder().WithFunc(hostFieldGet).Export("field_get").
+		NewFunctionBuilder().WithFunc(hostFieldSet).Export("field_set").
+		NewFunctionBuilder().WithFunc(hostParamGet).Export("param_get").
+		NewFunctionBuilder().WithFunc(hostParamSet).Export("param_set").
+		NewFunctionBuilder().WithFunc(hostLog).Export("log").
+		Instantiate(ctx)
+	return err
+}
+
+func hostMessageRead(ctx context.Context, m api.Module, ptr, capacity uint32) int32 {
+	inv := getInvocation(ctx)
+	if inv == nil || inv.message == nil {
+		return -1
+	}
+	return writeResult(m, inv.message.Data, ptr, capacity)
+}
+
+func hostMessageWrite(ctx context.Context, m api.Module, ptr, size uint32) int32 {
+	inv := getInvocation(ctx)
+	if inv == nil || inv.message == nil {
+		return -1
+	}
+	buf, ok := m.Memory().Read(ptr, size)
+	if !ok {
+		return -1
+	}
+	//the guest memory is reused, copy it
+	inv.message.Data = append([]byte(nil), buf...)
+	inv.message.Size = len(inv.message.Data)
+	return 0
+}
+
+func hostFieldGet(ctx context.Context, m api.Module, pathPtr, pathSize, ptr, capacity uint32) int32 {
+	inv := getInvocation(ctx)
+	if inv == nil || inv.message == nil {
+		return -1
+	}
+	path, ok := readString(m, pathPtr, pathSize)
+	if !ok {
+		return -1
+	}
+	value, _, _, err := jsonparser.Get(inv.message.Data, strings.Split(path, ".")...)
+	if err != nil {
+		return -1
+	}
+	return writeResult(m, value, ptr, capacity)
+}
+
+func hostFieldSet(ctx context.Context, m api.Module, pathPtr, pathSize, valuePtr, valueSize uint32) int32 {
+	inv := getInvocation(ctx)
+	if inv == nil || inv.message == nil {
+		return -1
+	}
+	path, ok := readString(m, pathPtr, pathSize)
+	if !ok {
+		return -1
+	}
+	value, ok := m.Memory().Read(valuePtr, valueSize)
+	if !ok {
+		return -1
+	}
+	data, err := jsonparser.Set(inv.message.Data, append([]byte(nil), value...), strings.Split(path, ".")...)
+	if err != nil {
+		return -1
+	}
+	inv.message.Data = data
+	inv.message.Size = len(data)
+	return 0
+}
+
+func hostParamGet(ctx context.Context, m api.Module, keyPtr, keySize, ptr, capacity uint32) int32 {
+	inv := getInvocation(ctx)
+	if inv == nil {
+		return -1
+	}
+	key, ok := readString(m, keyPtr, keySize)
+	if !ok {
+		return -1
+	}
+	v := inv.ctx.Get(param.ParaKey(key))
+	if v == nil {
+		return -1
+	}
+	var data []byte
+	switch x := v.(type) {
+	case string:
+		data = []byte(x)
+	case []byte:
+		data = x
+	default:
+		data = util.MustToJSONBytes(x)
+	}
+	return writeResult(m, data, ptr, capacity)
+}
+
+func hostParamSet(ctx context.Context, m api.Module, keyPtr, keySize, valuePtr, valueSize uint32) int32 {
+	inv := getInvocation(ctx)
+	if inv == nil {
+		return -1
+	}
+	key, ok := readString(m, keyPtr, keySize)
+	if !ok {
+		return -1
+	}
+	value, ok := m.Memory().Read(valuePtr, valueSize)
+	if !ok {
+		return -1
+	}
+	inv.ctx.Set(param.ParaKey(key), decodeParamValue(value))
+	return 0
+}
+
+// decodeParamValue keeps the json type of the value, integers are int64, the
+// values not in json are taken as strings
+func decodeParamValue(data []byte) interface{} {
+	if v, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+		return v
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return string(data)
+	}
+	return v
+}
+
+func hostLog(ctx context.Context, m api.Module, level, ptr, size uint32) {
+	msg, ok := readString(m, ptr, size)
+	if !ok {
+		return
+	}
+	logMessage(level, msg)
+}
+
+func logMessage(level uint32, msg string) {
+	switch level {
+	case 0:
+		log.Debug("[wasm] ", msg)
+	case 1:
+		log.Info("[wasm] ", msg)
+	case 2:
+		log.Warn("[wasm] ", msg)
+	default:
+		log.Error("[wasm] ", msg)
+	}
+}
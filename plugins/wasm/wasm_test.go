@@ -0,0 +1,86 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package wasm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+)
+
+// testdata/transform.wasm is compiled from testdata/transform.wat
+func newProcessor(t *testing.T, cfg map[string]interface{}) *WasmProcessor {
+	cfg["module"] = "testdata/transform.wasm"
+	c, err := config.NewConfigFrom(cfg)
+	assert.NoError(t, err)
+	p, err := New(c)
+	assert.NoError(t, err)
+	return p.(*WasmProcessor)
+}
+
+func TestWasmProcessor(t *testing.T) {
+	p := newProcessor(t, map[string]interface{}{})
+	defer p.Close()
+	assert.False(t, p.config.HotReload)
+
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{Name: "test"})
+	ctx.Set("messages", []queue.Message{
+		{Data: []byte(`{"id":1}`)},
+		{Data: []byte(`{"id":2,"drop":true}`)},
+	})
+	assert.NoError(t, p.Process(ctx))
+
+	messages := ctx.Get("messages").([]queue.Message)
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, `{"id":1,"tagged":true}`, string(messages[0].Data))
+	assert.Equal(t, len(messages[0].Data), messages[0].Size)
+	//the type of the parameter is kept
+	assert.Equal(t, int64(42), ctx.Get("count"))
+}
+
+func TestWasmProcessorTimeout(t *testing.T) {
+	p := newProcessor(t, map[string]interface{}{"function": "spin", "timeout": "50ms"})
+	defer p.Close()
+
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{Name: "test"})
+	assert.Error(t, p.Process(ctx))
+	//instantiated again after the timeout
+	assert.Error(t, p.Process(ctx))
+
+	c, _ := config.NewConfigFrom(map[string]interface{}{"module": "testdata/transform.wasm", "function": "unknown"})
+	_, err := New(c)
+	assert.Error(t, err)
+}
+
+func TestDecodeParamValue(t *testing.T) {
+	assert.Equal(t, int64(42), decodeParamValue([]byte(`42`)))
+	assert.Equal(t, 1.5, decodeParamValue([]byte(`1.5`)))
+	assert.Equal(t, "42", decodeParamValue([]byte(`"42"`)))
+	assert.Equal(t, true, decodeParamValue([]byte(`true`)))
+	assert.Equal(t, map[string]interface{}{"a": "b"}, decodeParamValue([]byte(`{"a":"b"}`)))
+	assert.Equal(t, "plain text", decodeParamValue([]byte(`plain text`)))
+}